@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Environment variables that systemd passes activated sockets in
+	listenPIDEnv     = "LISTEN_PID"
+	listenFDsEnv     = "LISTEN_FDS"
+	listenFDNamesEnv = "LISTEN_FDNAMES"
+	// The first file descriptor that systemd passes sockets as, after stdin, stdout and stderr
+	listenFDsStart = 3
+)
+
+// activatedFD is a file descriptor of a socket passed by systemd, with its FileDescriptorName
+type activatedFD struct {
+	fd   int
+	name string
+}
+
+// activatedFDs returns the file descriptors of the sockets that systemd passed to the process with the given pid,
+// looking up the environment variables it passes them in with getenv
+func activatedFDs(getenv func(string) string, pid int) ([]activatedFD, error) {
+	if getenv(listenPIDEnv) == "" {
+		return nil, fmt.Errorf("%s isn't set, so no sockets were passed by systemd", listenPIDEnv)
+	}
+	if p, err := strconv.Atoi(getenv(listenPIDEnv)); err != nil || p != pid {
+		return nil, fmt.Errorf("%s is %q, so the sockets were passed to another process", listenPIDEnv, getenv(listenPIDEnv))
+	}
+	n, err := strconv.Atoi(getenv(listenFDsEnv))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("%s is %q, expected the number of sockets passed by systemd", listenFDsEnv, getenv(listenFDsEnv))
+	}
+
+	// Sockets are named after their unit unless FileDescriptorName is set, which systemd leaves out when it can't
+	// pass the names
+	var names []string
+	if v := getenv(listenFDNamesEnv); v != "" {
+		names = strings.Split(v, ":")
+	}
+	fds := make([]activatedFD, n)
+	for i := range fds {
+		fds[i].fd = listenFDsStart + i
+		if i < len(names) {
+			fds[i].name = names[i]
+		}
+	}
+	return fds, nil
+}
+
+// pickActivatedFD returns the file descriptor with the given name, or the only file descriptor if name is empty
+func pickActivatedFD(fds []activatedFD, name string) (activatedFD, error) {
+	if name == "" {
+		if len(fds) != 1 {
+			return activatedFD{}, fmt.Errorf("systemd passed %d sockets, name the one to listen on as in systemd://<FileDescriptorName>", len(fds))
+		}
+		return fds[0], nil
+	}
+	for _, fd := range fds {
+		if fd.name == name {
+			return fd, nil
+		}
+	}
+	return activatedFD{}, fmt.Errorf("systemd didn't pass a socket named %q", name)
+}
+
+// activatedListener returns a listener for the socket with the given FileDescriptorName that systemd passed through
+// socket activation, or the only socket passed if name is empty.
+// The environment variables describing the sockets are removed, so that commands don't inherit them, and the
+// sockets that aren't listened on are closed.
+func activatedListener(name string) (net.Listener, error) {
+	fds, err := activatedFDs(os.Getenv, os.Getpid())
+	for _, v := range []string{listenPIDEnv, listenFDsEnv, listenFDNamesEnv} {
+		_ = os.Unsetenv(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	picked, err := pickActivatedFD(fds, name)
+	for _, fd := range fds {
+		if err != nil || fd.fd != picked.fd {
+			_ = os.NewFile(uintptr(fd.fd), fd.name).Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The listener has its own copy of the file descriptor, which commands don't inherit
+	f := os.NewFile(uintptr(picked.fd), picked.name)
+	defer func() {
+		_ = f.Close()
+	}()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("Can't listen on socket %d passed by systemd: %w", picked.fd, err)
+	}
+	return ln, nil
+}
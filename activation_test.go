@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestActivatedFDs(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want []activatedFD
+		err  bool
+	}{
+		{name: "not_activated", env: map[string]string{}, err: true},
+		{name: "other_process", env: map[string]string{listenPIDEnv: "1", listenFDsEnv: "1"}, err: true},
+		{name: "no_sockets", env: map[string]string{listenPIDEnv: "42", listenFDsEnv: "0"}, err: true},
+		{
+			name: "unnamed",
+			env:  map[string]string{listenPIDEnv: "42", listenFDsEnv: "1"},
+			want: []activatedFD{{fd: 3}},
+		},
+		{
+			name: "named",
+			env:  map[string]string{listenPIDEnv: "42", listenFDsEnv: "2", listenFDNamesEnv: "http:admin"},
+			want: []activatedFD{{fd: 3, name: "http"}, {fd: 4, name: "admin"}},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := activatedFDs(func(name string) string { return tc.env[name] }, 42)
+			if ok := err == nil; ok == tc.err || !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Wrong result; got %v, %v, want %v, error %v", got, err, tc.want, tc.err)
+			}
+		})
+	}
+}
+
+func TestPickActivatedFD(t *testing.T) {
+	t.Parallel()
+	one := []activatedFD{{fd: 3, name: "am-executor.socket"}}
+	two := []activatedFD{{fd: 3, name: "http"}, {fd: 4, name: "admin"}}
+
+	if got, err := pickActivatedFD(one, ""); err != nil || got.fd != 3 {
+		t.Errorf("Expected the only socket; got %v, %v", got, err)
+	}
+	if got, err := pickActivatedFD(two, "admin"); err != nil || got.fd != 4 {
+		t.Errorf("Expected the socket named admin; got %v, %v", got, err)
+	}
+	if _, err := pickActivatedFD(two, ""); err == nil {
+		t.Error("Expected an error picking an unnamed socket out of several")
+	}
+	if _, err := pickActivatedFD(two, "metrics"); err == nil {
+		t.Error("Expected an error picking a socket that wasn't passed")
+	}
+}
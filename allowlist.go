@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPAllowlist decides which client addresses can send webhook requests
+type IPAllowlist struct {
+	// Networks that clients must be in; all clients are allowed if this is empty
+	allowed []*net.IPNet
+	// Networks of proxies whose X-Forwarded-For header is believed
+	trusted []*net.IPNet
+}
+
+// NewIPAllowlist returns an allowlist of the given CIDR ranges, such as "10.0.0.0/8".
+// Requests that come through the given trusted proxies are judged by the client address the proxies forwarded.
+// Plain IP addresses are accepted too, as a range of their own.
+func NewIPAllowlist(allowed, trustedProxies []string) (*IPAllowlist, error) {
+	var err error
+	a := &IPAllowlist{}
+	if a.allowed, err = parseCIDRs(allowed); err != nil {
+		return nil, fmt.Errorf("Invalid allowed_cidrs specified: %w", err)
+	}
+	if a.trusted, err = parseCIDRs(trustedProxies); err != nil {
+		return nil, fmt.Errorf("Invalid trusted_proxies specified: %w", err)
+	}
+	return a, nil
+}
+
+// parseCIDRs parses CIDR ranges and plain IP addresses
+func parseCIDRs(ranges []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ranges))
+	for _, r := range ranges {
+		if !strings.Contains(r, "/") {
+			ip := net.ParseIP(r)
+			if ip == nil {
+				return nil, fmt.Errorf("%q isn't a CIDR range or IP address", r)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a CIDR range or IP address", r)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// contains returns true if the IP is in one of the networks
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent a request.
+// When the request comes from a trusted proxy, X-Forwarded-For is followed back from the nearest hop,
+// until an address that isn't a trusted proxy is found, since addresses further along can be set by the client.
+// Returns nil if the address can't be determined.
+func (a *IPAllowlist) ClientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(a.trusted, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// The chain can't be followed past an address we don't understand
+			return nil
+		}
+		ip = hop
+		if !contains(a.trusted, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// Allows returns true if the request's client is allowed to send requests
+func (a *IPAllowlist) Allows(req *http.Request) bool {
+	if a == nil || len(a.allowed) == 0 {
+		return true
+	}
+	ip := a.ClientIP(req)
+	return ip != nil && contains(a.allowed, ip)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewIPAllowlist(t *testing.T) {
+	cases := []struct {
+		name    string
+		allowed []string
+		trusted []string
+		err     bool
+	}{
+		{name: "empty"},
+		{name: "cidrs", allowed: []string{"10.0.0.0/8", "2001:db8::/32"}, trusted: []string{"192.0.2.1"}},
+		{name: "ips", allowed: []string{"192.0.2.7", "::1"}},
+		{name: "bad_allowed", allowed: []string{"10.0.0.0/33"}, err: true},
+		{name: "bad_trusted", trusted: []string{"proxy.example.com"}, err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := NewIPAllowlist(tc.allowed, tc.trusted)
+			if tc.err && err == nil {
+				t.Errorf("Expected error for allowlist %v, trusted proxies %v", tc.allowed, tc.trusted)
+			} else if !tc.err && err != nil {
+				t.Errorf("Unexpected error for allowlist %v, trusted proxies %v: %v", tc.allowed, tc.trusted, err)
+			}
+		})
+	}
+}
+
+func TestIPAllowlist_Allows(t *testing.T) {
+	a, err := NewIPAllowlist([]string{"10.20.0.0/16", "2001:db8::/32"}, []string{"192.0.2.1", "192.0.2.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       bool
+	}{
+		{name: "allowed", remoteAddr: "10.20.1.2:4321", want: true},
+		{name: "allowed_ipv6", remoteAddr: "[2001:db8::7]:4321", want: true},
+		{name: "not_allowed", remoteAddr: "10.30.1.2:4321"},
+		{name: "untrusted_forwarded", remoteAddr: "10.30.1.2:4321", forwarded: "10.20.1.2"},
+		{name: "trusted_proxy", remoteAddr: "192.0.2.1:4321", forwarded: "10.20.1.2", want: true},
+		{name: "trusted_proxy_not_allowed", remoteAddr: "192.0.2.1:4321", forwarded: "10.30.1.2"},
+		{name: "trusted_proxy_chain", remoteAddr: "192.0.2.1:4321", forwarded: "10.20.1.2, 192.0.2.2", want: true},
+		{name: "spoofed_hop", remoteAddr: "192.0.2.1:4321", forwarded: "10.20.1.2, 10.30.1.2"},
+		{name: "trusted_proxy_no_header", remoteAddr: "192.0.2.1:4321"},
+		{name: "unparseable_hop", remoteAddr: "192.0.2.1:4321", forwarded: "unknown"},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			if got := a.Allows(req); got != tc.want {
+				t.Errorf("Wrong result for %s forwarding %q; got %v, want %v", tc.remoteAddr, tc.forwarded, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServer_handleWebhook_allowlist(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.allowlist, err = NewIPAllowlist([]string{"10.20.0.0/16"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+	req.RemoteAddr = "10.30.1.2:4321"
+	w := httptest.NewRecorder()
+	srv.handleWebhook(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Wrong status for request from address that isn't allowed; got %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if v, _ := getCounterValue(srv.errCounter, ErrLabelForbidden); v != 1 {
+		t.Errorf("Wrong forbidden error count; got %v, want 1", v)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// How long to wait for the annotation API to respond
+	annotationTimeout = 10 * time.Second
+	// How many lines of output are included in annotations, when not configured otherwise
+	defaultAnnotationLines = 5
+	// Tag that annotations are given, when not configured otherwise
+	defaultAnnotationTag = "am-executor"
+)
+
+// AnnotationConfig describes where to post an annotation about each execution of a command for a firing alert,
+// so that graphs of the alerting system show when automation intervened.
+// Annotations are posted to Grafana's HTTP API.
+type AnnotationConfig struct {
+	// Base URL of the Grafana instance, such as https://grafana.example.com.
+	// Defaults to the origin of the alert's GeneratorURL, which is Grafana itself for Grafana-managed alerts.
+	URL string `yaml:"url"`
+	// API token or service account token to authenticate with
+	Token string `yaml:"token"`
+	// Tags to give the annotations. Defaults to am-executor.
+	Tags []string `yaml:"tags"`
+	// How many of the last lines of the command's output to include.
+	// Defaults to 5; a negative value means none are included.
+	OutputLines int `yaml:"output_lines"`
+}
+
+// grafanaAnnotation is the body of a request to Grafana's annotation API
+type grafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd"`
+	Tags    []string `json:"tags"`
+	Text    string   `json:"text"`
+}
+
+// Lines returns how many lines of output to include in annotations
+func (a *AnnotationConfig) Lines() int {
+	if a.OutputLines == 0 {
+		return defaultAnnotationLines
+	}
+	return a.OutputLines
+}
+
+// Validate returns an error if the annotation settings can't be used
+func (a *AnnotationConfig) Validate() error {
+	if a.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(a.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("expected an absolute URL, got %q", a.URL)
+	}
+	return nil
+}
+
+// endpoint returns the URL to post annotations about the alert to, or an error if there's nowhere to post them
+func (a *AnnotationConfig) endpoint(alert template.Alert) (string, error) {
+	base := a.URL
+	if base == "" {
+		u, err := url.Parse(alert.GeneratorURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "", fmt.Errorf("no url is configured, and the alert's generator URL %q can't be used instead", alert.GeneratorURL)
+		}
+		base = u.Scheme + "://" + u.Host
+	}
+	return strings.TrimSuffix(base, "/") + "/api/annotations", nil
+}
+
+// annotation returns the annotation describing an execution for an alert, spanning from when the alert started
+// to when the execution finished.
+func (a *AnnotationConfig) annotation(alert template.Alert, summary ExecutionSummary, finished time.Time) grafanaAnnotation {
+	tags := a.Tags
+	if len(tags) == 0 {
+		tags = []string{defaultAnnotationTag}
+	}
+	start := alert.StartsAt
+	if start.IsZero() || start.After(finished) {
+		start = finished
+	}
+
+	text := fmt.Sprintf("Ran %s: %s in %s", summary.Command, summary.Result, summary.Duration.Round(time.Millisecond))
+	if summary.ExitCode != "" {
+		text += fmt.Sprintf(" (exit code %s)", summary.ExitCode)
+	}
+	if len(summary.Output) > 0 {
+		text += "\n" + strings.Join(summary.Output, "\n")
+	}
+	return grafanaAnnotation{
+		Time:    start.UnixNano() / int64(time.Millisecond),
+		TimeEnd: finished.UnixNano() / int64(time.Millisecond),
+		Tags:    tags,
+		Text:    text,
+	}
+}
+
+// annotate posts an annotation about an execution for a firing alert.
+// It is meant to be called as a goroutine, so that slow annotation APIs don't hold up alert processing.
+func (s *Server) annotate(config *AnnotationConfig, alert template.Alert, summary ExecutionSummary) {
+	endpoint, err := config.endpoint(alert)
+	if err != nil {
+		logger.Warn("Not annotating execution", Fields{"command": summary.Command, "fingerprint": summary.Fingerprint, "error": err})
+		return
+	}
+	data, err := json.Marshal(config.annotation(alert, summary, time.Now()))
+	if err != nil {
+		logger.Error("Failed to encode annotation", Fields{"command": summary.Command, "error": err})
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		logger.Error("Failed to create annotation request", Fields{"command": summary.Command, "error": err})
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	}
+
+	client := &http.Client{Timeout: annotationTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Warn("Failed to post annotation", Fields{"command": summary.Command, "url": endpoint, "error": err})
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warn("Unexpected response to annotation", Fields{"command": summary.Command, "url": endpoint, "status": resp.Status})
+	}
+}
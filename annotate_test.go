@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnnotationConfig_endpoint(t *testing.T) {
+	cases := []struct {
+		name         string
+		url          string
+		generatorURL string
+		want         string
+		err          bool
+	}{
+		{name: "configured", url: "https://grafana.example.com/", generatorURL: "http://prometheus:9090/graph", want: "https://grafana.example.com/api/annotations"},
+		{name: "generator", generatorURL: "https://grafana.example.com/alerting/grafana/abc/view", want: "https://grafana.example.com/api/annotations"},
+		{name: "no_generator", err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			a := &AnnotationConfig{URL: tc.url}
+			got, err := a.endpoint(template.Alert{GeneratorURL: tc.generatorURL})
+			if (err != nil) != tc.err {
+				t.Fatalf("Unexpected error result; got %v, want error %v", err, tc.err)
+			}
+			if got != tc.want {
+				t.Errorf("Wrong endpoint; got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServer_annotate(t *testing.T) {
+	var got grafanaAnnotation
+	var auth string
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		if req.URL.Path != "/api/annotations" {
+			t.Errorf("Wrong annotation path; got %s", req.URL.Path)
+		}
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode annotation: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer grafana.Close()
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	startsAt := time.Now().Add(-time.Minute)
+	alert := template.Alert{StartsAt: startsAt, GeneratorURL: grafana.URL + "/alerting/grafana/abc/view"}
+	summary := ExecutionSummary{Command: "restart-service", Result: "Ok", Duration: 1500 * time.Millisecond, ExitCode: "0", Output: []string{"restarted"}}
+	srv.annotate(&AnnotationConfig{Token: "s3cr3t"}, alert, summary)
+
+	if auth != "Bearer s3cr3t" {
+		t.Errorf("Wrong authorization; got %q", auth)
+	}
+	if got.Time != startsAt.UnixNano()/int64(time.Millisecond) || got.TimeEnd < got.Time {
+		t.Errorf("Wrong annotation time range; got %d to %d, want from %d", got.Time, got.TimeEnd, startsAt.UnixNano()/int64(time.Millisecond))
+	}
+	if !reflect.DeepEqual(got.Tags, []string{defaultAnnotationTag}) {
+		t.Errorf("Wrong annotation tags; got %v", got.Tags)
+	}
+	if want := "Ran restart-service: Ok in 1.5s (exit code 0)\nrestarted"; got.Text != want {
+		t.Errorf("Wrong annotation text; got %q, want %q", got.Text, want)
+	}
+}
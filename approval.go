@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long executions wait for approval, when a command's approval_timeout isn't set
+	defaultApprovalTimeout = Duration(15 * time.Minute)
+	// How long to wait for an approval webhook to respond
+	approvalWebhookTimeout = 10 * time.Second
+	// Path that requests to approve or reject pending executions are made under, as in /api/approvals/{id}/approve
+	approvalsAPIPath = "/api/approvals/"
+
+	// Decisions that pending executions are counted with
+	ApprovalLabelApproved = "approved"
+	ApprovalLabelRejected = "rejected"
+	ApprovalLabelExpired  = "expired"
+	ApprovalLabelResolved = "resolved"
+)
+
+var (
+	approvalCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "approvals",
+		Name:      "total",
+		Help:      "Total number of executions of commands with approval_required that were decided on, by decision.",
+	}
+	approvalLabels = []string{"command", "decision"}
+)
+
+// PendingExecution is an execution of a command with approval_required, waiting for someone to approve or reject it
+type PendingExecution struct {
+	ID          string    `json:"id"`
+	Command     string    `json:"command"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Args        []string  `json:"args,omitempty"`
+	Requested   time.Time `json:"requested"`
+	// When the execution is dropped, if it hasn't been decided on by then
+	Expires time.Time `json:"expires"`
+
+	// Receives the decision on the execution, once
+	decision chan string
+}
+
+// PendingApprovals tracks the executions waiting for approval, by ID
+type PendingApprovals struct {
+	pending map[string]*PendingExecution
+	seq     uint64
+	sync.Mutex
+}
+
+// NewPendingApprovals returns a PendingApprovals instance, with no executions waiting
+func NewPendingApprovals() *PendingApprovals {
+	return &PendingApprovals{pending: make(map[string]*PendingExecution)}
+}
+
+// Add starts tracking an execution, giving it an ID
+func (p *PendingApprovals) Add(e *PendingExecution) {
+	p.Lock()
+	defer p.Unlock()
+	p.seq++
+	e.ID = strconv.FormatUint(p.seq, 10)
+	e.decision = make(chan string, 1)
+	p.pending[e.ID] = e
+}
+
+// List returns the executions waiting for approval, in the order they were requested
+func (p *PendingApprovals) List() []PendingExecution {
+	p.Lock()
+	defer p.Unlock()
+	list := make([]PendingExecution, 0, len(p.pending))
+	for _, e := range p.pending {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := strconv.ParseUint(list[i].ID, 10, 64)
+		b, _ := strconv.ParseUint(list[j].ID, 10, 64)
+		return a < b
+	})
+	return list
+}
+
+// Decide stops tracking an execution, and hands it the decision.
+// Returns false if no execution with the ID is waiting, such as because it was already decided on.
+func (p *PendingApprovals) Decide(id string, decision string) bool {
+	p.Lock()
+	defer p.Unlock()
+	e, ok := p.pending[id]
+	if !ok {
+		return false
+	}
+	delete(p.pending, id)
+	e.decision <- decision
+	return true
+}
+
+// Waiting returns true if an execution of the command for the fingerprinted alert is waiting for approval
+func (p *PendingApprovals) Waiting(command string, fingerprint string) bool {
+	p.Lock()
+	defer p.Unlock()
+	for _, e := range p.pending {
+		if e.Command == command && e.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve drops the executions waiting for approval for an alert that resolved, returning how many there were
+func (p *PendingApprovals) Resolve(fingerprint string) int {
+	p.Lock()
+	defer p.Unlock()
+	n := 0
+	for id, e := range p.pending {
+		if e.Fingerprint == fingerprint {
+			delete(p.pending, id)
+			e.decision <- ApprovalLabelResolved
+			n++
+		}
+	}
+	return n
+}
+
+// requestApproval parks an execution of a command until it's approved, rejected, or its approval times out.
+// The execution runs in the background once approved, so that alertmanager isn't kept waiting for a human.
+func (s *Server) requestApproval(cmd *Command, fingerprint string, alert *template.Alert, args []string, env []string) {
+	now := time.Now()
+	e := &PendingExecution{
+		Command:     cmd.MetricLabel(),
+		Fingerprint: fingerprint,
+		Args:        args,
+		Requested:   now,
+		Expires:     now.Add(cmd.ApprovalWait()),
+	}
+	if fingerprint != "" && s.pending.Waiting(e.Command, fingerprint) {
+		logger.Decision("Command is already waiting for approval for the alert", Fields{"command": cmd, "fingerprint": fingerprint})
+		s.removePayloadFile(env)
+		return
+	}
+	s.pending.Add(e)
+	logger.Decision("Command is waiting for approval", Fields{"command": cmd, "fingerprint": fingerprint, "id": e.ID, "expires": e.Expires})
+	if cmd.ApprovalWebhook != "" {
+		go s.notifyApproval(cmd.ApprovalWebhook, *e)
+	}
+	go s.awaitApproval(cmd, e, alert, env)
+}
+
+// awaitApproval waits for the decision on a pending execution, and runs the command if it's approved
+func (s *Server) awaitApproval(cmd *Command, e *PendingExecution, alert *template.Alert, env []string) {
+	t := time.NewTimer(time.Until(e.Expires))
+	defer t.Stop()
+	var decision string
+	select {
+	case decision = <-e.decision:
+	case <-t.C:
+		// If it was decided on just as it expired, that decision stands
+		_ = s.pending.Decide(e.ID, ApprovalLabelExpired)
+		decision = <-e.decision
+	}
+
+	s.approvalCounter.WithLabelValues(cmd.MetricLabel(), decision).Inc()
+	logger.Decision("Decided on command waiting for approval", Fields{"command": cmd, "fingerprint": e.Fingerprint, "id": e.ID, "decision": decision})
+	if decision != ApprovalLabelApproved {
+		s.removePayloadFile(env)
+		return
+	}
+
+	release := func() {}
+	if alert != nil {
+		var ok bool
+		if release, ok = s.domains.Acquire(alert.Labels); !ok {
+			s.removePayloadFile(env)
+			s.skip(cmd, CmdRunDomainLimit, e.Fingerprint)
+			return
+		}
+	}
+	defer release()
+	out := make(chan CommandResult)
+	if !s.dispatch(e.Fingerprint, alert, cmd, e.Args, env, out, nil) {
+		s.skip(cmd, CmdRunQueueFull, e.Fingerprint)
+		return
+	}
+	// Results are logged and counted by instrumentation; there's no caller left to report them to
+	for range out {
+	}
+}
+
+// notifyApproval posts a pending execution to a webhook, such as a Slack incoming webhook, so that someone can decide
+// on it. The text field says how to approve or reject it. It is meant to be called as a goroutine.
+func (s *Server) notifyApproval(url string, e PendingExecution) {
+	body := struct {
+		PendingExecution
+		Text string `json:"text"`
+		// Signed links to approve or reject the execution, if signed_links is configured
+		ApproveURL string `json:"approve_url,omitempty"`
+		RejectURL  string `json:"reject_url,omitempty"`
+	}{
+		PendingExecution: e,
+		Text: fmt.Sprintf("Command %s is waiting for approval to run for alert %s, until %s. POST to %s%s/approve or %s%s/reject to decide.",
+			e.Command, e.Fingerprint, e.Expires.Format(time.RFC3339), approvalsAPIPath, e.ID, approvalsAPIPath, e.ID),
+	}
+	if s.links != nil {
+		// Links last as long as the execution waits, so that responders can decide on it from chat
+		now := time.Now()
+		ttl := e.Expires.Sub(now)
+		approve, _, errApprove := s.links.Sign(http.MethodPost, approvalsAPIPath+e.ID+"/approve", ttl, now)
+		reject, _, errReject := s.links.Sign(http.MethodPost, approvalsAPIPath+e.ID+"/reject", ttl, now)
+		if errApprove == nil && errReject == nil {
+			body.ApproveURL, body.RejectURL = approve, reject
+			body.Text = fmt.Sprintf("Command %s is waiting for approval to run for alert %s, until %s. Approve: %s Reject: %s",
+				e.Command, e.Fingerprint, e.Expires.Format(time.RFC3339), approve, reject)
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		logger.Error("Failed to encode approval notification", Fields{"command": e.Command, "error": err})
+		return
+	}
+
+	client := &http.Client{Timeout: approvalWebhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logger.Error("Failed to send approval notification", Fields{"command": e.Command, "error": err})
+		return
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Error("Approval notification webhook returned an error", Fields{"command": e.Command, "status": resp.Status})
+	}
+}
+
+// handleApprovals lists the executions waiting for approval, for GET requests
+func (s *Server) handleApprovals(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.pending.List())
+}
+
+// handleApprovalDecision approves or rejects a pending execution, for POST requests to
+// /api/approvals/{id}/approve and /api/approvals/{id}/reject
+func (s *Server) handleApprovalDecision(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, approvalsAPIPath), "/")
+	if len(parts) != 2 || parts[0] == "" || (parts[1] != "approve" && parts[1] != "reject") {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, decision := parts[0], ApprovalLabelApproved
+	if parts[1] == "reject" {
+		decision = ApprovalLabelRejected
+	}
+	if !s.pending.Decide(id, decision) {
+		http.Error(w, "No execution is waiting for approval with ID "+id, http.StatusNotFound)
+		return
+	}
+	logger.Info("Execution "+decision+" at runtime", Fields{"id": id, "remote_addr": req.RemoteAddr})
+	writeJSON(w, struct {
+		ID       string `json:"id"`
+		Decision string `json:"decision"`
+	}{ID: id, Decision: decision})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPendingApprovals(t *testing.T) {
+	t.Parallel()
+	p := NewPendingApprovals()
+	first := &PendingExecution{Command: "failover", Fingerprint: "abc"}
+	second := &PendingExecution{Command: "failover", Fingerprint: "def"}
+	p.Add(first)
+	p.Add(second)
+
+	list := p.List()
+	if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+		t.Fatalf("Wrong pending executions; got %+v", list)
+	}
+	if !p.Waiting("failover", "abc") || p.Waiting("failover", "xyz") || p.Waiting("restart", "abc") {
+		t.Error("Wrong executions reported as waiting")
+	}
+
+	if !p.Decide(first.ID, ApprovalLabelApproved) {
+		t.Fatal("Expected to decide on pending execution")
+	}
+	if got := <-first.decision; got != ApprovalLabelApproved {
+		t.Errorf("Wrong decision; got %s, want %s", got, ApprovalLabelApproved)
+	}
+	if p.Decide(first.ID, ApprovalLabelRejected) {
+		t.Error("Execution shouldn't be decided on twice")
+	}
+
+	if n := p.Resolve("def"); n != 1 {
+		t.Errorf("Wrong number of executions resolved; got %d, want 1", n)
+	}
+	if got := <-second.decision; got != ApprovalLabelResolved {
+		t.Errorf("Wrong decision; got %s, want %s", got, ApprovalLabelResolved)
+	}
+	if list := p.List(); len(list) != 0 {
+		t.Errorf("Expected no pending executions; got %+v", list)
+	}
+}
+
+func TestServer_approval(t *testing.T) {
+	t.Parallel()
+	required := true
+	cmd := &Command{Cmd: "true", Name: "failover", ApprovalRequired: &required}
+	srv := NewServer(&Config{Commands: []*Command{cmd}})
+
+	// Repeated notifications for the same alert only park one execution
+	for i := 0; i < 2; i++ {
+		if errs := srv.runCommands(&amDataFinger, "", nil, nil); len(errs) > 0 {
+			t.Fatalf("Unexpected errors running commands: %v", errs)
+		}
+	}
+	w := httptest.NewRecorder()
+	srv.handleApprovals(w, httptest.NewRequest(http.MethodGet, "/api/approvals", nil))
+	var list []PendingExecution
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode pending executions: %v", err)
+	}
+	if len(list) != 1 || list[0].Command != "failover" || list[0].Fingerprint != "boop" {
+		t.Fatalf("Wrong pending executions; got %+v", list)
+	}
+	if _, ok := srv.lastResults.Get(cmd); ok {
+		t.Fatal("Command shouldn't run before it's approved")
+	}
+
+	for path, want := range map[string]int{
+		approvalsAPIPath + list[0].ID + "/delete":  http.StatusNotFound,
+		approvalsAPIPath + "42/approve":            http.StatusNotFound,
+		approvalsAPIPath + list[0].ID + "/approve": http.StatusOK,
+	} {
+		w = httptest.NewRecorder()
+		srv.handleApprovalDecision(w, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != want {
+			t.Errorf("Wrong status for %s; got %d, want %d", path, w.Code, want)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if r, ok := srv.lastResults.Get(cmd); ok {
+			if r.Result != CmdOk.String() {
+				t.Errorf("Approved command should succeed; got %+v", r)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Approved command didn't run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var m pm.Metric
+	if err := srv.approvalCounter.WithLabelValues("failover", ApprovalLabelApproved).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Wrong count of approved executions; got %v, want 1", got)
+	}
+}
+
+func TestServer_approvalExpired(t *testing.T) {
+	t.Parallel()
+	required := true
+	cmd := &Command{Cmd: "true", Name: "failover", ApprovalRequired: &required, ApprovalTimeout: Duration(time.Millisecond)}
+	srv := NewServer(&Config{Commands: []*Command{cmd}})
+
+	srv.requestApproval(cmd, "abc", &template.Alert{Fingerprint: "abc"}, nil, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.pending.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Execution waiting for approval didn't expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := srv.lastResults.Get(cmd); ok {
+		t.Error("Command shouldn't run once its approval expired")
+	}
+}
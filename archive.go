@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	// How long to wait for the object storage endpoint to accept an execution record
+	archiveTimeout = 30 * time.Second
+	// Key that execution records are stored under, when archive's key isn't set
+	defaultArchiveKey = `{{.Name}}/{{.Started.Format "2006/01/02"}}/{{.Execution}}.json`
+	// How many lines of output are archived with each execution, when archive's output_lines isn't set
+	defaultArchiveOutputLines = 1000
+	// Region that requests are signed for, when archive's region isn't set
+	defaultArchiveRegion = "us-east-1"
+
+	// Outcomes that uploads of execution records are counted with
+	ArchiveLabelOk   = "ok"
+	ArchiveLabelFail = "fail"
+)
+
+var (
+	archiveCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "archive",
+		Name:      "uploads_total",
+		Help:      "Total number of execution records uploaded to object storage, by outcome.",
+	}
+	archiveLabels = []string{"command", "outcome"}
+)
+
+// ArchiveConfig configures uploading a record of each execution, along with its output, to S3 compatible object
+// storage, for organizations that have to keep automation activity off-host. Google Cloud Storage is supported through
+// its XML API, with HMAC keys.
+type ArchiveConfig struct {
+	// Base URL of the object storage endpoint, such as https://s3.eu-west-1.amazonaws.com or
+	// https://storage.googleapis.com. Executions aren't archived if this isn't set.
+	Endpoint string `yaml:"endpoint"`
+	Bucket   string `yaml:"bucket"`
+	// Region that requests are signed for. Defaults to us-east-1.
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// Template of the key that each execution record is stored under, executed with an ArchiveRecord
+	Key string `yaml:"key"`
+	// How long records are locked against being deleted or overwritten, using S3 object lock in compliance mode.
+	// The bucket must have object lock enabled. Records aren't locked if this isn't set.
+	Retention Duration `yaml:"retention"`
+	// How many of the last lines of output are archived with each execution. Defaults to 1000.
+	OutputLines int `yaml:"output_lines"`
+}
+
+// Lines returns how many lines of output are archived with each execution, applying the default
+func (c ArchiveConfig) Lines() int {
+	if c.OutputLines <= 0 {
+		return defaultArchiveOutputLines
+	}
+	return c.OutputLines
+}
+
+// ArchiveRecord is the record of an execution that's uploaded to object storage
+type ArchiveRecord struct {
+	HistoryEntry
+	// The command's name if it has one, otherwise the program it runs, as in the command label of metrics
+	Name string `json:"name"`
+	// Labels of the firing alert that triggered the execution
+	Labels map[string]string `json:"labels,omitempty"`
+	// The last lines of the command's output, up to archive's output_lines
+	Output []string `json:"output"`
+}
+
+// Archiver uploads execution records to object storage
+type Archiver struct {
+	config ArchiveConfig
+	key    *texttemplate.Template
+	client *http.Client
+}
+
+// NewArchiver returns an Archiver for the configured bucket, or nil if no endpoint is configured
+func NewArchiver(config ArchiveConfig) (*Archiver, error) {
+	if config.Endpoint == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(config.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("expected an absolute endpoint URL, got %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("bucket isn't set")
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("access_key_id and secret_access_key must be set")
+	}
+	if config.Retention < 0 {
+		return nil, fmt.Errorf("retention is negative: %s", config.Retention)
+	}
+	if config.OutputLines < 0 {
+		return nil, fmt.Errorf("output_lines is negative: %d", config.OutputLines)
+	}
+	if config.Key == "" {
+		config.Key = defaultArchiveKey
+	}
+	if config.Region == "" {
+		config.Region = defaultArchiveRegion
+	}
+	key, err := texttemplate.New("key").Option("missingkey=error").Parse(config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return &Archiver{config: config, key: key, client: &http.Client{Timeout: archiveTimeout}}, nil
+}
+
+// Key returns the key that the record is stored under
+func (a *Archiver) Key(r ArchiveRecord) (string, error) {
+	var b strings.Builder
+	if err := a.key.Execute(&b, r); err != nil {
+		return "", err
+	}
+	key := strings.TrimLeft(b.String(), "/")
+	if key == "" {
+		return "", fmt.Errorf("key is empty")
+	}
+	return key, nil
+}
+
+// Upload stores the record in the bucket, under its key
+func (a *Archiver) Upload(r ArchiveRecord) error {
+	key, err := a.Key(r)
+	if err != nil {
+		return fmt.Errorf("Failed to render key: %w", err)
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimRight(a.config.Endpoint, "/")
+	req, err := http.NewRequest(http.MethodPut, endpoint+escapeS3Path("/"+a.config.Bucket+"/"+key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sum := md5.Sum(data)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	if a.config.Retention > 0 {
+		until := r.Started.Add(time.Duration(a.config.Retention)).UTC()
+		req.Header.Set("X-Amz-Object-Lock-Mode", "COMPLIANCE")
+		req.Header.Set("X-Amz-Object-Lock-Retain-Until-Date", until.Format(time.RFC3339))
+	}
+	signV4(req, data, a.config.AccessKeyID, a.config.SecretAccessKey, a.config.Region, time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Object storage returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// escapeS3Path escapes every byte of a path except unreserved characters and slashes, as request signatures require
+func escapeS3Path(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signV4 signs a request to S3 compatible object storage with AWS signature version 4, signing every header it has.
+// The request's path must already be escaped the way escapeS3Path does.
+func signV4(req *http.Request, payload []byte, accessKeyID string, secretAccessKey string, region string, now time.Time) {
+	payloadHash := sha256.Sum256(payload)
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.Host}
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+		names = append(names, name)
+		values[name] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, headers.String(), signed, hex.EncodeToString(payloadHash[:])}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signed, signature))
+}
+
+// archive uploads the record of a finished execution, counting and logging whether it was stored.
+// It is meant to be called as a goroutine.
+func (s *Server) archive(cmd *Command, r ArchiveRecord) {
+	label := cmd.MetricLabel()
+	if err := s.archiver.Upload(r); err != nil {
+		s.archiveCounter.WithLabelValues(label, ArchiveLabelFail).Inc()
+		logger.Error("Failed to archive execution", Fields{"command": cmd, "execution": r.Execution, "error": err})
+		return
+	}
+	s.archiveCounter.WithLabelValues(label, ArchiveLabelOk).Inc()
+}
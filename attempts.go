@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Attempt describes the latest attempt at running a command for an alert
+type Attempt struct {
+	// How many times the command has run for the alert, counting retries
+	Number int
+	// Whether the attempt succeeded or failed; zero if it's not known, such as when it was signalled
+	Result Result
+}
+
+// attemptKey identifies the alert a command ran for
+type attemptKey struct {
+	command     string
+	fingerprint string
+}
+
+// Attempts tracks how often commands have run for alerts that are still firing,
+// so that repeated runs for an alert can tell how the ones before them went.
+type Attempts struct {
+	last map[attemptKey]Attempt
+	sync.RWMutex
+}
+
+// Last returns the latest attempt at running the command for the fingerprinted alert
+func (a *Attempts) Last(command string, fingerprint string) Attempt {
+	a.RLock()
+	defer a.RUnlock()
+	return a.last[attemptKey{command: command, fingerprint: fingerprint}]
+}
+
+// Record sets the latest attempt at running the command for the fingerprinted alert
+func (a *Attempts) Record(command string, fingerprint string, attempt Attempt) {
+	a.Lock()
+	defer a.Unlock()
+	a.last[attemptKey{command: command, fingerprint: fingerprint}] = attempt
+}
+
+// Forget removes the attempts at running the command for the fingerprinted alert, such as when the alert resolves
+func (a *Attempts) Forget(command string, fingerprint string) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.last, attemptKey{command: command, fingerprint: fingerprint})
+}
+
+// NewAttempts returns an Attempts instance, with no attempts recorded
+func NewAttempts() *Attempts {
+	return &Attempts{
+		last: make(map[attemptKey]Attempt),
+	}
+}
+
+// attemptEnv returns environment variables telling a command which attempt it's making for an alert,
+// and how the previous attempt went, if there was one.
+func attemptEnv(number int, previous Result) []string {
+	env := []string{"AMX_ATTEMPT=" + strconv.Itoa(number)}
+	if previous != 0 {
+		env = append(env, "AMX_PREVIOUS_RESULT="+previous.String())
+	}
+	return env
+}
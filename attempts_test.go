@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttempts(t *testing.T) {
+	t.Parallel()
+	a := NewAttempts()
+	if got := a.Last("fix", "abc"); got != (Attempt{}) {
+		t.Errorf("Expected no attempt before any were recorded; got %+v", got)
+	}
+	a.Record("fix", "abc", Attempt{Number: 2, Result: CmdFail})
+	if got, want := a.Last("fix", "abc"), (Attempt{Number: 2, Result: CmdFail}); got != want {
+		t.Errorf("Wrong latest attempt; got %+v, want %+v", got, want)
+	}
+	if got := a.Last("other", "abc"); got != (Attempt{}) {
+		t.Errorf("Attempts of one command shouldn't be seen by another; got %+v", got)
+	}
+	a.Forget("fix", "abc")
+	if got := a.Last("fix", "abc"); got != (Attempt{}) {
+		t.Errorf("Expected no attempt after they were forgotten; got %+v", got)
+	}
+}
+
+func Test_attemptEnv(t *testing.T) {
+	cases := []struct {
+		name     string
+		number   int
+		previous Result
+		env      []string
+	}{
+		{name: "first", number: 1, env: []string{"AMX_ATTEMPT=1"}},
+		{name: "after_failure", number: 2, previous: CmdFail, env: []string{"AMX_ATTEMPT=2", "AMX_PREVIOUS_RESULT=Fail"}},
+		{name: "after_success", number: 3, previous: CmdOk, env: []string{"AMX_ATTEMPT=3", "AMX_PREVIOUS_RESULT=Ok"}},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := attemptEnv(tc.number, tc.previous); !reflect.DeepEqual(got, tc.env) {
+				t.Errorf("Wrong environment; got %v, want %v", got, tc.env)
+			}
+		})
+	}
+}
+
+func TestServer_attempts(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor-attempts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	log := filepath.Join(dir, "attempts.log")
+
+	notify := false
+	cmd := &Command{
+		Cmd:             "sh",
+		Args:            []string{"-c", `echo "$AMX_ATTEMPT $AMX_PREVIOUS_RESULT" >> ` + log + `; exit 1`},
+		Name:            "fix",
+		Retries:         1,
+		RetryBackoff:    Duration(time.Millisecond),
+		NotifyOnFailure: &notify,
+	}
+	srv := NewServer(&Config{Commands: []*Command{cmd}})
+	firing := &template.Data{Status: "firing", Alerts: template.Alerts{{Status: "firing", Fingerprint: "abc"}}}
+	resolved := &template.Data{Status: "resolved", Alerts: template.Alerts{{Status: "resolved", Fingerprint: "abc"}}}
+
+	// Repeat runs for the alert carry on counting from the retries of earlier ones, until it resolves
+	_ = srv.runCommands(firing, "", nil, nil)
+	_ = srv.runCommands(firing, "", nil, nil)
+	srv.amResolved(resolved)
+	_ = srv.runCommands(firing, "", nil, nil)
+
+	data, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{"1 ", "2 Fail", "3 Fail", "4 Fail", "1 ", "2 Fail"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong attempts seen by command; got %q, want %q", got, want)
+	}
+}
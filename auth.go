@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	AuthNone   = "none"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthMTLS   = "mtls"
+	AuthHMAC   = "hmac"
+
+	// Header carrying the HMAC signature of the request body, when not configured otherwise
+	defaultHMACHeader = "X-Signature"
+	// Largest request body read to check its HMAC signature, when not configured otherwise
+	defaultHMACMaxBody = 10 << 20
+)
+
+var (
+	// Constructors for the supported authentication schemes, keyed by AuthConfig.Type.
+	// New schemes are added by registering their constructor here.
+	authenticators = map[string]func(AuthConfig) (Authenticator, error){
+		AuthNone:   newNoAuth,
+		AuthBasic:  newBasicAuth,
+		AuthBearer: newBearerAuth,
+		AuthMTLS:   newMTLSAuth,
+		AuthHMAC:   newHMACAuth,
+	}
+
+	errUnauthorized = errors.New("Unauthorized")
+	errBodyTooLarge = errors.New("Request body too large")
+)
+
+// AuthConfig selects and configures how requests to the executor are authenticated
+type AuthConfig struct {
+	// One of none, basic, bearer, mtls or hmac. Defaults to none.
+	Type string `yaml:"type"`
+	// Credentials for basic authentication. The password can be read from a file instead,
+	// so that it can be kept out of the config, such as in a mounted secret.
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	// Token for bearer authentication
+	Token string `yaml:"token"`
+	// File containing the certificate authorities that client certificates must be signed by, for mtls authentication
+	ClientCA string `yaml:"client_ca"`
+	// Secret for hmac authentication, and the header carrying the hex-encoded HMAC-SHA256 signature of the request body
+	HMACSecret string `yaml:"hmac_secret"`
+	HMACHeader string `yaml:"hmac_header"`
+	// Largest request body that's read to check its hmac signature; larger requests are rejected
+	HMACMaxBody ByteSize `yaml:"hmac_max_body"`
+}
+
+// Authenticator decides whether a request is allowed to reach the executor's handlers
+type Authenticator interface {
+	// Authenticate returns an error if the request isn't allowed
+	Authenticate(req *http.Request) error
+	// TLSConfig returns the TLS settings the scheme needs from the listener, or nil if it has no requirements
+	TLSConfig() *tls.Config
+}
+
+type noAuth struct{}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+type bearerAuth struct {
+	token string
+}
+
+type mtlsAuth struct {
+	clientCAs *x509.CertPool
+}
+
+type hmacAuth struct {
+	secret  []byte
+	header  string
+	maxBody int64
+}
+
+// NewAuthenticator returns the Authenticator for the configured scheme
+func NewAuthenticator(c AuthConfig) (Authenticator, error) {
+	t := strings.ToLower(c.Type)
+	if t == "" {
+		t = AuthNone
+	}
+
+	newAuth, ok := authenticators[t]
+	if !ok {
+		types := make([]string, 0, len(authenticators))
+		for k := range authenticators {
+			types = append(types, k)
+		}
+		sort.Strings(types)
+		return nil, fmt.Errorf("Unknown auth type %q, expected one of %s", c.Type, strings.Join(types, ", "))
+	}
+	return newAuth(c)
+}
+
+func newNoAuth(AuthConfig) (Authenticator, error) {
+	return noAuth{}, nil
+}
+
+func (noAuth) Authenticate(*http.Request) error {
+	return nil
+}
+
+func (noAuth) TLSConfig() *tls.Config {
+	return nil
+}
+
+func newBasicAuth(c AuthConfig) (Authenticator, error) {
+	password := c.Password
+	if c.PasswordFile != "" {
+		if password != "" {
+			return nil, fmt.Errorf("%s auth takes either a password or a password_file, not both", AuthBasic)
+		}
+		data, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		// Files written by editors and secret stores often end in a newline, which isn't part of the password
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	if c.Username == "" || password == "" {
+		return nil, fmt.Errorf("%s auth requires a username and password", AuthBasic)
+	}
+	return basicAuth{username: c.Username, password: password}, nil
+}
+
+func (a basicAuth) Authenticate(req *http.Request) error {
+	username, password, ok := req.BasicAuth()
+	if !ok || !secureEqual(username, a.username) || !secureEqual(password, a.password) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+func (basicAuth) TLSConfig() *tls.Config {
+	return nil
+}
+
+func newBearerAuth(c AuthConfig) (Authenticator, error) {
+	if c.Token == "" {
+		return nil, fmt.Errorf("%s auth requires a token", AuthBearer)
+	}
+	return bearerAuth{token: c.Token}, nil
+}
+
+func (a bearerAuth) Authenticate(req *http.Request) error {
+	const prefix = "Bearer "
+	h := req.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) || !secureEqual(strings.TrimPrefix(h, prefix), a.token) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+func (bearerAuth) TLSConfig() *tls.Config {
+	return nil
+}
+
+func newMTLSAuth(c AuthConfig) (Authenticator, error) {
+	if c.ClientCA == "" {
+		return nil, fmt.Errorf("%s auth requires a client_ca file", AuthMTLS)
+	}
+	pem, err := ioutil.ReadFile(c.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in client_ca file %s", c.ClientCA)
+	}
+	return mtlsAuth{clientCAs: pool}, nil
+}
+
+func (mtlsAuth) Authenticate(req *http.Request) error {
+	// The TLS listener verifies client certificates, so we only need to check that one was presented
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return errUnauthorized
+	}
+	return nil
+}
+
+func (a mtlsAuth) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientCAs:  a.clientCAs,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+}
+
+func newHMACAuth(c AuthConfig) (Authenticator, error) {
+	if c.HMACSecret == "" {
+		return nil, fmt.Errorf("%s auth requires an hmac_secret", AuthHMAC)
+	}
+	header := c.HMACHeader
+	if header == "" {
+		header = defaultHMACHeader
+	}
+	maxBody := int64(c.HMACMaxBody)
+	if maxBody <= 0 {
+		maxBody = defaultHMACMaxBody
+	}
+	return hmacAuth{secret: []byte(c.HMACSecret), header: header, maxBody: maxBody}, nil
+}
+
+func (a hmacAuth) Authenticate(req *http.Request) error {
+	// Signatures may be prefixed with the hash algorithm, like "sha256=..."
+	sig, err := hex.DecodeString(strings.TrimPrefix(req.Header.Get(a.header), "sha256="))
+	if err != nil || len(sig) == 0 {
+		return errUnauthorized
+	}
+
+	// The body is read before the signature is checked, so its size is limited to keep anyone from making us buffer it
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, a.maxBody))
+	if err != nil {
+		if int64(len(body)) >= a.maxBody {
+			return errBodyTooLarge
+		}
+		return err
+	}
+	// Put the body back for the handler
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, a.secret)
+	_, _ = mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+func (hmacAuth) TLSConfig() *tls.Config {
+	return nil
+}
+
+// secureEqual compares strings in constant time, so that secrets can't be guessed from response times
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// requireAuth wraps a handler, so that it's only called for requests the Authenticator allows,
+// or that are made through a valid signed link
+func (s *Server) requireAuth(auth Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if signedLink(req) {
+			s.handleSignedLink(w, req, next)
+			return
+		}
+		if err := auth.Authenticate(req); err != nil {
+			logger.Decision("Rejected request", Fields{"remote_addr": req.RemoteAddr, "error": err})
+			s.errCounter.WithLabelValues(ErrLabelAuth, CmdLabelNone).Inc()
+			status := http.StatusUnauthorized
+			if err == errBodyTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next(w, req)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// hmacSignature returns the hex-encoded HMAC-SHA256 signature of body
+func hmacSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	cases := []struct {
+		name   string
+		config AuthConfig
+		err    bool
+	}{
+		{name: "default", config: AuthConfig{}},
+		{name: "none", config: AuthConfig{Type: AuthNone}},
+		{name: "basic", config: AuthConfig{Type: AuthBasic, Username: "am", Password: "secret"}},
+		{name: "basic_no_password", config: AuthConfig{Type: AuthBasic, Username: "am"}, err: true},
+		{name: "bearer", config: AuthConfig{Type: "Bearer", Token: "secret"}},
+		{name: "bearer_no_token", config: AuthConfig{Type: AuthBearer}, err: true},
+		{name: "mtls_no_ca", config: AuthConfig{Type: AuthMTLS}, err: true},
+		{name: "mtls_missing_ca", config: AuthConfig{Type: AuthMTLS, ClientCA: "/nonexistent/ca.pem"}, err: true},
+		{name: "hmac", config: AuthConfig{Type: AuthHMAC, HMACSecret: "secret"}},
+		{name: "hmac_no_secret", config: AuthConfig{Type: AuthHMAC}, err: true},
+		{name: "unknown", config: AuthConfig{Type: "oidc"}, err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := NewAuthenticator(tc.config)
+			if tc.err && err == nil {
+				t.Errorf("Expected error for auth config %+v", tc.config)
+			} else if !tc.err && err != nil {
+				t.Errorf("Unexpected error for auth config %+v: %v", tc.config, err)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticator_passwordFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "am-executor_password-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.WriteString("s3cr3t\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	cases := []struct {
+		name   string
+		config AuthConfig
+		err    bool
+	}{
+		{name: "password_file", config: AuthConfig{Type: AuthBasic, Username: "am", PasswordFile: f.Name()}},
+		{name: "missing_file", config: AuthConfig{Type: AuthBasic, Username: "am", PasswordFile: "/nonexistent/password"}, err: true},
+		{name: "both", config: AuthConfig{Type: AuthBasic, Username: "am", Password: "s3cr3t", PasswordFile: f.Name()}, err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			auth, err := NewAuthenticator(tc.config)
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected error for auth config %+v", tc.config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error for auth config %+v: %v", tc.config, err)
+			}
+
+			// The password is the file's content, without the trailing newline
+			req := httptest.NewRequest("POST", "/", nil)
+			req.SetBasicAuth("am", "s3cr3t")
+			if err := auth.Authenticate(req); err != nil {
+				t.Errorf("Expected the password from the file to be accepted; got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	body := `{"status": "firing"}`
+	cases := []struct {
+		name  string
+		auth  Authenticator
+		setup func(req *http.Request)
+		ok    bool
+	}{
+		{
+			name:  "none",
+			auth:  noAuth{},
+			setup: func(req *http.Request) {},
+			ok:    true,
+		},
+		{
+			name:  "basic",
+			auth:  basicAuth{username: "am", password: "secret"},
+			setup: func(req *http.Request) { req.SetBasicAuth("am", "secret") },
+			ok:    true,
+		},
+		{
+			name:  "basic_wrong_password",
+			auth:  basicAuth{username: "am", password: "secret"},
+			setup: func(req *http.Request) { req.SetBasicAuth("am", "guess") },
+			ok:    false,
+		},
+		{
+			name:  "basic_missing",
+			auth:  basicAuth{username: "am", password: "secret"},
+			setup: func(req *http.Request) {},
+			ok:    false,
+		},
+		{
+			name:  "bearer",
+			auth:  bearerAuth{token: "secret"},
+			setup: func(req *http.Request) { req.Header.Set("Authorization", "Bearer secret") },
+			ok:    true,
+		},
+		{
+			name:  "bearer_wrong_token",
+			auth:  bearerAuth{token: "secret"},
+			setup: func(req *http.Request) { req.Header.Set("Authorization", "Bearer guess") },
+			ok:    false,
+		},
+		{
+			name: "mtls",
+			auth: mtlsAuth{},
+			setup: func(req *http.Request) {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}}}
+			},
+			ok: true,
+		},
+		{
+			name:  "mtls_no_cert",
+			auth:  mtlsAuth{},
+			setup: func(req *http.Request) { req.TLS = &tls.ConnectionState{} },
+			ok:    false,
+		},
+		{
+			name:  "hmac",
+			auth:  hmacAuth{secret: []byte("secret"), header: defaultHMACHeader, maxBody: defaultHMACMaxBody},
+			setup: func(req *http.Request) { req.Header.Set(defaultHMACHeader, hmacSignature("secret", body)) },
+			ok:    true,
+		},
+		{
+			name:  "hmac_prefixed",
+			auth:  hmacAuth{secret: []byte("secret"), header: defaultHMACHeader, maxBody: defaultHMACMaxBody},
+			setup: func(req *http.Request) { req.Header.Set(defaultHMACHeader, "sha256="+hmacSignature("secret", body)) },
+			ok:    true,
+		},
+		{
+			name:  "hmac_wrong_secret",
+			auth:  hmacAuth{secret: []byte("secret"), header: defaultHMACHeader, maxBody: defaultHMACMaxBody},
+			setup: func(req *http.Request) { req.Header.Set(defaultHMACHeader, hmacSignature("guess", body)) },
+			ok:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body)))
+			tc.setup(req)
+			err := tc.auth.Authenticate(req)
+			if tc.ok && err != nil {
+				t.Errorf("Request should be allowed; got %v", err)
+			} else if !tc.ok && err == nil {
+				t.Errorf("Request should be rejected")
+			}
+
+			// The body should still be readable by the handler
+			data, err := ioutil.ReadAll(req.Body)
+			if err != nil || string(data) != body {
+				t.Errorf("Request body wasn't preserved; got %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestServer_requireAuth(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	called := false
+	h := srv.requireAuth(bearerAuth{token: "secret"}, func(w http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/", nil))
+	if resp := w.Result(); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wrong response for unauthenticated request; got %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if called {
+		t.Errorf("Handler shouldn't be called for unauthenticated request")
+	}
+	count, err := getCounterValue(srv.errCounter, ErrLabelAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Wrong auth error count; got %f, want %d", count, 1)
+	}
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	h(httptest.NewRecorder(), req)
+	if !called {
+		t.Errorf("Handler should be called for authenticated request")
+	}
+}
+
+func TestServer_requireAuth_hmacMaxBody(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	auth, err := NewAuthenticator(AuthConfig{Type: AuthHMAC, HMACSecret: "secret", HMACMaxBody: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	h := srv.requireAuth(auth, func(w http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+
+	body := `{"status": "firing"}`
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body)))
+	req.Header.Set(defaultHMACHeader, hmacSignature("secret", body))
+	w := httptest.NewRecorder()
+	h(w, req)
+	if resp := w.Result(); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Wrong response for oversized request; got %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	if called {
+		t.Errorf("Handler shouldn't be called for oversized request")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Name of the alert sent in synthetic webhooks
+	benchAlertName = "AmExecutorBench"
+)
+
+// benchResult represents the outcome of a single synthetic webhook request
+type benchResult struct {
+	status  string
+	latency time.Duration
+	err     error
+}
+
+// benchStats summarizes the outcome of a benchmark run
+type benchStats struct {
+	sent      int
+	errors    int
+	skipped   float64
+	skipKnown bool
+	byStatus  map[string]int
+	latencies []time.Duration
+	elapsed   time.Duration
+}
+
+// benchPayload returns a synthetic alertmanager message for the given fingerprint and status
+func benchPayload(fingerprint, status string) *template.Data {
+	labels := template.KV{
+		"alertname": benchAlertName,
+		"instance":  "bench-" + fingerprint,
+	}
+	alert := template.Alert{
+		Status:      status,
+		Labels:      labels,
+		Annotations: template.KV{},
+		StartsAt:    time.Now(),
+		Fingerprint: fingerprint,
+	}
+	if status == "resolved" {
+		alert.EndsAt = time.Now()
+	}
+
+	return &template.Data{
+		Receiver:          "bench",
+		Status:            status,
+		Alerts:            template.Alerts{alert},
+		GroupLabels:       template.KV{"alertname": benchAlertName},
+		CommonLabels:      labels,
+		CommonAnnotations: template.KV{},
+		ExternalURL:       "http://localhost:9093",
+	}
+}
+
+// percentile returns the latency at the given percentile (0-100) of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+// sumMetric returns the sum of all samples of a metric, read from the prometheus text exposition format
+func sumMetric(r io.Reader, name string) (float64, error) {
+	var sum float64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, name+"{") && !strings.HasPrefix(line, name+" ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		v, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid sample for %s: %s", name, line)
+		}
+		sum += v
+	}
+	return sum, scanner.Err()
+}
+
+// skippedTotal returns the total number of commands the executor skipped, according to its metrics
+func skippedTotal(client *http.Client, metricsURL string) (float64, error) {
+	resp, err := client.Get(metricsURL)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Unexpected response from %s: %s", metricsURL, resp.Status)
+	}
+
+	return sumMetric(resp.Body, strings.Join([]string{metricNamespace, skipCountOpts.Subsystem, skipCountOpts.Name}, "_"))
+}
+
+// report writes a summary of the benchmark statistics
+func (bs *benchStats) report(w io.Writer) {
+	sort.Slice(bs.latencies, func(i, j int) bool { return bs.latencies[i] < bs.latencies[j] })
+	var total time.Duration
+	for _, l := range bs.latencies {
+		total += l
+	}
+	var avg time.Duration
+	if len(bs.latencies) > 0 {
+		avg = total / time.Duration(len(bs.latencies))
+	}
+
+	statuses := make([]string, 0, len(bs.byStatus))
+	for s, n := range bs.byStatus {
+		statuses = append(statuses, fmt.Sprintf("%s=%d", s, n))
+	}
+	sort.Strings(statuses)
+
+	_, _ = fmt.Fprintf(w, "Requests:  %d in %s (%.1f/s)\n", bs.sent, bs.elapsed.Round(time.Millisecond), float64(bs.sent)/bs.elapsed.Seconds())
+	_, _ = fmt.Fprintf(w, "Responses: %s\n", strings.Join(statuses, ", "))
+	_, _ = fmt.Fprintf(w, "Errors:    %d\n", bs.errors)
+	if bs.skipKnown {
+		_, _ = fmt.Fprintf(w, "Skipped:   %.0f\n", bs.skipped)
+	} else {
+		_, _ = fmt.Fprintf(w, "Skipped:   unknown (metrics unavailable)\n")
+	}
+	_, _ = fmt.Fprintf(w, "Latency:   min %s, avg %s, p50 %s, p90 %s, p99 %s, max %s\n",
+		percentile(bs.latencies, 0), avg, percentile(bs.latencies, 50), percentile(bs.latencies, 90),
+		percentile(bs.latencies, 99), percentile(bs.latencies, 100))
+}
+
+// runBench fires synthetic firing and resolved webhooks at an executor at a fixed rate,
+// and writes latency, error and skip statistics to w.
+func runBench(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(w)
+	target := fs.String("u", "http://localhost:8080/", "URL of the executor webhook endpoint")
+	rate := fs.Float64("r", 10, "Webhooks to send per second")
+	duration := fs.Duration("d", 10*time.Second, "How long to send webhooks for")
+	resolved := fs.Float64("resolved", 0.5, "Fraction of webhooks that resolve a previously fired alert")
+	timeout := fs.Duration("t", 30*time.Second, "Timeout for each webhook request")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("Rate must be positive, got %f", *rate)
+	}
+	if *resolved < 0 || *resolved > 1 {
+		return fmt.Errorf("Resolved fraction must be between 0 and 1, got %f", *resolved)
+	}
+
+	u, err := url.Parse(*target)
+	if err != nil {
+		return fmt.Errorf("Invalid webhook URL %s: %w", *target, err)
+	}
+	u.Path = "/metrics"
+	metricsURL := u.String()
+
+	client := &http.Client{Timeout: *timeout}
+	skippedBefore, skipErr := skippedTotal(client, metricsURL)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firing []string
+	results := make([]benchResult, 0)
+	send := func(amMsg *template.Data) {
+		defer wg.Done()
+		var r benchResult
+		data, err := json.Marshal(amMsg)
+		if err == nil {
+			start := time.Now()
+			var resp *http.Response
+			resp, err = client.Post(*target, "application/json", bytes.NewReader(data))
+			r.latency = time.Since(start)
+			if err == nil {
+				_, _ = io.Copy(ioutil.Discard, resp.Body)
+				_ = resp.Body.Close()
+				r.status = strconv.Itoa(resp.StatusCode)
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("Unexpected response: %s", resp.Status)
+				}
+			}
+		}
+		r.err = err
+
+		mu.Lock()
+		results = append(results, r)
+		mu.Unlock()
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	deadline := time.NewTimer(*duration)
+	defer deadline.Stop()
+	start := time.Now()
+	sent := 0
+loop:
+	for {
+		select {
+		case <-deadline.C:
+			break loop
+		case <-ticker.C:
+			var amMsg *template.Data
+			if len(firing) > 0 && rand.Float64() < *resolved {
+				i := rand.Intn(len(firing))
+				amMsg = benchPayload(firing[i], "resolved")
+				firing = append(firing[:i], firing[i+1:]...)
+			} else {
+				fingerprint := fmt.Sprintf("%016x", rand.Uint64())
+				firing = append(firing, fingerprint)
+				amMsg = benchPayload(fingerprint, "firing")
+			}
+			sent++
+			wg.Add(1)
+			go send(amMsg)
+		}
+	}
+	wg.Wait()
+
+	stats := benchStats{
+		sent:     sent,
+		byStatus: make(map[string]int),
+		elapsed:  time.Since(start),
+	}
+	for _, r := range results {
+		if r.err != nil {
+			stats.errors++
+		}
+		if r.status != "" {
+			stats.byStatus[r.status]++
+			stats.latencies = append(stats.latencies, r.latency)
+		}
+	}
+	if skipErr == nil {
+		skippedAfter, err := skippedTotal(client, metricsURL)
+		if err == nil {
+			stats.skipped = skippedAfter - skippedBefore
+			stats.skipKnown = true
+		}
+	}
+
+	stats.report(w)
+	return nil
+}
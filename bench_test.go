@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_percentile(t *testing.T) {
+	t.Parallel()
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	cases := map[float64]time.Duration{
+		0:   time.Millisecond,
+		50:  50 * time.Millisecond,
+		99:  99 * time.Millisecond,
+		100: 100 * time.Millisecond,
+	}
+	for p, want := range cases {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("Wrong percentile %f; got %s, want %s", p, got, want)
+		}
+	}
+
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("Wrong percentile of no latencies; got %s, want 0", got)
+	}
+}
+
+func Test_sumMetric(t *testing.T) {
+	t.Parallel()
+	text := `# HELP am_executor_skipped_total Total number of commands that were skipped instead of run for matching alerts.
+# TYPE am_executor_skipped_total counter
+am_executor_skipped_total{reason="fingerover"} 3
+am_executor_skipped_total{reason="nomatch"} 4
+am_executor_skipped_total_other 100
+`
+	sum, err := sumMetric(strings.NewReader(text), "am_executor_skipped_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 7 {
+		t.Errorf("Wrong metric sum; got %f, want %d", sum, 7)
+	}
+}
+
+func Test_runBench(t *testing.T) {
+	t.Parallel()
+	var skipped, requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/metrics" {
+			_, _ = fmt.Fprintf(w, "am_executor_skipped_total{reason=\"nomatch\"} %d\n", atomic.LoadInt64(&skipped))
+			return
+		}
+		atomic.AddInt64(&skipped, 1)
+		if atomic.AddInt64(&requests, 1)%2 == 0 {
+			http.Error(w, "banana", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	err := runBench([]string{"-u", srv.URL + "/", "-r", "50", "-d", "200ms"}, &out)
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+
+	sent := atomic.LoadInt64(&requests)
+	for _, want := range []string{
+		fmt.Sprintf("Requests:  %d in", sent),
+		fmt.Sprintf("Errors:    %d", sent/2),
+		fmt.Sprintf("Skipped:   %d", sent),
+		"Latency:",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Missing %q in benchmark report:\n%s", want, out.String())
+		}
+	}
+}
+
+func Test_runBenchInvalid(t *testing.T) {
+	t.Parallel()
+	cases := [][]string{
+		{"-r", "0"},
+		{"-resolved", "2"},
+		{"-banana"},
+	}
+
+	for _, args := range cases {
+		var out bytes.Buffer
+		if err := runBench(args, &out); err == nil {
+			t.Errorf("Missing error for arguments %v", args)
+		}
+	}
+}
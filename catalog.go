@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CatalogEntry describes a configured command, as listed by the /api/commands endpoint
+type CatalogEntry struct {
+	Name    string `json:"name,omitempty"`
+	Command string `json:"command"`
+	// Path that alerts are posted to for the command to run; empty for the default webhook path
+	Route string `json:"route,omitempty"`
+	// Cron expression that the command runs on; empty for commands that run for alerts
+	Schedule      string            `json:"schedule,omitempty"`
+	When          string            `json:"when,omitempty"`
+	MatchLabels   map[string]string `json:"match_labels,omitempty"`
+	MatchLabelsRe map[string]string `json:"match_labels_re,omitempty"`
+	// Targets currently read from the files of each label matched with match_labels_sd
+	MatchLabelsSD    map[string][]string `json:"match_labels_sd,omitempty"`
+	MatchAnnotations map[string]string   `json:"match_annotations,omitempty"`
+	MatchExpr        string              `json:"match_expr,omitempty"`
+	Max              int                 `json:"max,omitempty"`
+	Concurrency      int                 `json:"concurrency,omitempty"`
+	QueueSize        int                 `json:"queue_size,omitempty"`
+	MaxQueueAge      string              `json:"max_queue_age,omitempty"`
+	Debounce         string              `json:"debounce,omitempty"`
+	Cooldown         string              `json:"cooldown,omitempty"`
+	MaxPerMinute     int                 `json:"max_per_minute,omitempty"`
+	MaxPerHour       int                 `json:"max_per_hour,omitempty"`
+	CanaryPercent    float64             `json:"canary_percent,omitempty"`
+	Retries          int                 `json:"retries,omitempty"`
+	// How long the command is skipped for an alert after it succeeded for it
+	SuppressAfterSuccess string `json:"suppress_after_success,omitempty"`
+	// Whether the command only runs for alerts that meet the conditions of its guard
+	Destructive bool `json:"destructive,omitempty"`
+	// Whether executions of the command wait to be approved before running
+	ApprovalRequired bool `json:"approval_required,omitempty"`
+	// Whether the command was disabled at runtime
+	Disabled bool `json:"disabled"`
+	// Result of the command's latest execution; nil if it hasn't run since the server started
+	LastResult *LastResult `json:"last_result"`
+}
+
+// LastResult describes how the latest execution of a command finished
+type LastResult struct {
+	Execution uint64    `json:"execution"`
+	Finished  time.Time `json:"finished"`
+	Result    string    `json:"result"`
+	// Exit code of the execution's last attempt; empty if it didn't exit
+	ExitCode string `json:"exit_code,omitempty"`
+}
+
+// LastResults tracks how the latest execution of each command finished
+type LastResults struct {
+	results map[*Command]LastResult
+	sync.RWMutex
+}
+
+// NewLastResults returns a LastResults instance, without any results
+func NewLastResults() *LastResults {
+	return &LastResults{results: make(map[*Command]LastResult)}
+}
+
+// Set records how the latest execution of a command finished
+func (l *LastResults) Set(cmd *Command, r LastResult) {
+	l.Lock()
+	defer l.Unlock()
+	l.results[cmd] = r
+}
+
+// Get returns how the latest execution of a command finished, and false if it hasn't run
+func (l *LastResults) Get(cmd *Command) (LastResult, bool) {
+	l.RLock()
+	defer l.RUnlock()
+	r, ok := l.results[cmd]
+	return r, ok
+}
+
+// catalogEntry describes a command for the /api/commands endpoint
+func (s *Server) catalogEntry(cmd *Command) CatalogEntry {
+	e := CatalogEntry{
+		Name:             cmd.Name,
+		Command:          cmd.String(),
+		Route:            cmd.Route,
+		When:             cmd.When,
+		MatchLabels:      cmd.MatchLabels,
+		MatchLabelsRe:    cmd.MatchLabelsRe,
+		MatchAnnotations: cmd.MatchAnnotations,
+		MatchExpr:        cmd.MatchExpr,
+		Max:              cmd.Max,
+		Concurrency:      cmd.Concurrency,
+		QueueSize:        cmd.QueueSize,
+		MaxPerMinute:     cmd.MaxPerMinute,
+		MaxPerHour:       cmd.MaxPerHour,
+		CanaryPercent:    cmd.CanaryPercent,
+		Retries:          cmd.Retries,
+		Destructive:      cmd.IsDestructive(),
+		ApprovalRequired: cmd.RequiresApproval(),
+		Disabled:         cmd.Name != "" && s.disabled.Disabled(cmd.Name),
+	}
+	if cmd.MaxQueueAge > 0 {
+		e.MaxQueueAge = cmd.MaxQueueAge.String()
+	}
+	if cmd.Debounce > 0 {
+		e.Debounce = cmd.Debounce.String()
+	}
+	if cmd.Cooldown > 0 {
+		e.Cooldown = cmd.Cooldown.String()
+	}
+	if cmd.SuppressAfterSuccess > 0 {
+		e.SuppressAfterSuccess = cmd.SuppressAfterSuccess.String()
+	}
+	if len(cmd.MatchLabelsSD) > 0 {
+		e.MatchLabelsSD = make(map[string][]string, len(cmd.MatchLabelsSD))
+		for _, m := range cmd.MatchLabelsSD {
+			e.MatchLabelsSD[m.Label] = m.Targets()
+		}
+	}
+	if r, ok := s.lastResults.Get(cmd); ok {
+		e.LastResult = &r
+	}
+	return e
+}
+
+// handleCatalog lists the configured commands for GET requests, with what they match, their limits, whether they're
+// disabled and how they last finished, so that tools like chatops bots can present the available remediations.
+// Commands that run for alerts are listed first, in the order they're configured, followed by scheduled commands.
+func (s *Server) handleCatalog(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries := make([]CatalogEntry, 0, len(s.config.Commands)+len(s.config.Schedules))
+	for _, cmd := range s.config.Commands {
+		entries = append(entries, s.catalogEntry(cmd))
+	}
+	for _, sched := range s.config.Schedules {
+		e := s.catalogEntry(&sched.Command)
+		e.Schedule = sched.Cron
+		entries = append(entries, e)
+	}
+	writeJSON(w, entries)
+}
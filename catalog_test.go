@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_handleCatalog(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	restart := &Command{Cmd: "false", Name: "restart", MatchLabels: map[string]string{"alertname": "Down"}, Max: 2}
+	srv.config.Commands = []*Command{restart, {Cmd: "echo"}}
+	srv.config.Schedules = []*Schedule{{Cron: "@daily", Command: Command{Cmd: "cleanup"}}}
+	srv.disabled.Disable("restart")
+
+	out := make(chan CommandResult)
+	go srv.instrument("", nil, restart, nil, nil, out, nil)
+	for range out {
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleCatalog(w, httptest.NewRequest(http.MethodGet, "/api/commands", nil))
+	var got []CatalogEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode catalog: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Wrong number of commands; got %d, want 3", len(got))
+	}
+
+	e := got[0]
+	if e.Name != "restart" || !e.Disabled || e.Max != 2 || e.MatchLabels["alertname"] != "Down" {
+		t.Errorf("Wrong entry for named command; got %+v", e)
+	}
+	if e.LastResult == nil || e.LastResult.Result != CmdFail.String() || e.LastResult.ExitCode != "1" {
+		t.Errorf("Wrong last result for command that ran; got %+v", e.LastResult)
+	}
+	if got[1].Disabled || got[1].LastResult != nil {
+		t.Errorf("Wrong entry for command that didn't run; got %+v", got[1])
+	}
+	if got[2].Schedule != "@daily" || got[2].Command != "cleanup" {
+		t.Errorf("Wrong entry for scheduled command; got %+v", got[2])
+	}
+
+	w = httptest.NewRecorder()
+	srv.handleCatalog(w, httptest.NewRequest(http.MethodPost, "/api/commands", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status for POST; got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
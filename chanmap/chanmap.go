@@ -45,6 +45,13 @@ func (cm *ChannelMap) Get(key string) (chan struct{}, bool) {
 	return c.ch, ok
 }
 
+// Len returns how many control channels there are
+func (cm *ChannelMap) Len() int {
+	cm.RLock()
+	defer cm.RUnlock()
+	return len(cm.channels)
+}
+
 // Close closes a matching control channel and discards it
 func (cm *ChannelMap) Close(key string) {
 	cm.Lock()
@@ -53,6 +53,24 @@ func TestChannelMap_Add(t *testing.T) {
 	}
 }
 
+func TestChannelMap_Len(t *testing.T) {
+	t.Parallel()
+
+	var cm = NewChannelMap()
+	if n := cm.Len(); n != 0 {
+		t.Errorf("wrong number of channels; got %d, want %d", n, 0)
+	}
+	_ = cm.Add(testKey)
+	_ = cm.Add("tomato")
+	if n := cm.Len(); n != 2 {
+		t.Errorf("wrong number of channels; got %d, want %d", n, 2)
+	}
+	cm.Close(testKey)
+	if n := cm.Len(); n != 1 {
+		t.Errorf("wrong number of channels; got %d, want %d", n, 1)
+	}
+}
+
 func TestChannelMap_Close(t *testing.T) {
 	t.Parallel()
 
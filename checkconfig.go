@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// checkExecutables returns an error for each program in the config that can't be run, such as because it doesn't
+// exist or isn't executable, so that typos in paths are caught before an alert needs the command.
+// Scripts run through an interpreter are checked by looking up the interpreter instead.
+func checkExecutables(c *Config) []error {
+	var errors []error
+	check := func(kind string, cmd *Command, setting string) {
+		program := cmd.WithInterpreter(c.Interpreters).Cmd
+		if _, err := exec.LookPath(program); err != nil {
+			errors = append(errors, fmt.Errorf("Invalid %s specified for %s %q: %w", setting, kind, cmd, err))
+		}
+	}
+	visit := func(kind string, cmd *Command) {
+		check(kind, cmd, "cmd")
+		if resolved, ok := cmd.ResolvedCommand(); ok {
+			check(kind, resolved, "resolved_cmd")
+		}
+		if rollback, ok := cmd.RollbackCommand(); ok {
+			check(kind, rollback, "rollback_cmd")
+		}
+	}
+
+	for _, cmd := range c.Commands {
+		visit("command", cmd)
+	}
+	for _, sched := range c.Schedules {
+		visit("schedule", &sched.Command)
+	}
+	return errors
+}
+
+// checkConfig reports whether a config that was read successfully can be used, for the -check-config flag.
+// The settings themselves are validated when the config is read, so this checks what can only be known on the host it
+// runs on, like whether the commands' programs exist.
+// Problems are written to out, and an error is returned if there were any.
+func checkConfig(c *Config, out io.Writer) error {
+	errors := checkExecutables(c)
+	for _, err := range errors {
+		_, _ = fmt.Fprintln(out, err)
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("found %d problems with the configuration", len(errors))
+	}
+
+	_, _ = fmt.Fprintf(out, "Configuration is valid, with %d commands and %d schedules\n", len(c.Commands), len(c.Schedules))
+	return nil
+}
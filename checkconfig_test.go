@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "am-executor-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	script := filepath.Join(dir, "fix.py")
+	if err := ioutil.WriteFile(script, []byte("print('fixed')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.sh")
+
+	cases := []struct {
+		name     string
+		config   Config
+		problems int
+	}{
+		{name: "valid", config: Config{Commands: []*Command{{Cmd: "echo"}}}},
+		{name: "interpreter", config: Config{Interpreters: map[string]string{".py": "sh"}, Commands: []*Command{{Cmd: script}}}},
+		{name: "not_executable", config: Config{Commands: []*Command{{Cmd: script}}}, problems: 1},
+		{name: "missing_interpreter", config: Config{Interpreters: map[string]string{".py": filepath.Join(dir, "python")}, Commands: []*Command{{Cmd: script}}}, problems: 1},
+		{name: "missing", config: Config{Commands: []*Command{{Cmd: missing, ResolvedCmd: "echo", RollbackCmd: "no-such-program-amx"}}}, problems: 2},
+		{name: "schedule", config: Config{Schedules: []*Schedule{{Cron: "@daily", Command: Command{Cmd: missing}}}}, problems: 1},
+	}
+	for _, tc := range cases {
+		var out bytes.Buffer
+		err := checkConfig(&tc.config, &out)
+		if (err != nil) != (tc.problems > 0) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if tc.problems > 0 {
+			if lines := strings.Count(out.String(), "\n"); lines != tc.problems {
+				t.Errorf("%s: wrong number of problems reported; got %d, want %d:\n%s", tc.name, lines, tc.problems, out.String())
+			}
+		}
+	}
+}
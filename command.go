@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/prometheus/alertmanager/template"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	texttemplate "text/template"
+	"time"
 	"unicode"
 )
 
@@ -21,6 +34,22 @@ const (
 	CmdSigOk   Result = 1 << iota
 	CmdSigFail Result = 1 << iota
 	CmdSkipSig Result = 1 << iota
+	CmdRetry   Result = 1 << iota
+	CmdKill    Result = 1 << iota
+)
+
+const (
+	// How long to wait before the first retry of a failed command, when Command.RetryBackoff isn't set
+	defaultRetryBackoff = time.Second
+	// The longest we're willing to wait between retries of a failed command
+	maxRetryDelay = time.Hour
+)
+
+const (
+	// Values for Command.When, specifying which alert statuses a command runs for
+	WhenFiring   = "firing"
+	WhenResolved = "resolved"
+	WhenBoth     = "both"
 )
 
 var (
@@ -30,39 +59,8 @@ var (
 		CmdSigOk:   "SigOk",
 		CmdSigFail: "SigFail",
 		CmdSkipSig: "SkipSig",
-	}
-
-	signals = map[string]syscall.Signal{
-		"SIGABRT":   syscall.SIGABRT,
-		"SIGALRM":   syscall.SIGALRM,
-		"SIGBUS":    syscall.SIGBUS,
-		"SIGCHLD":   syscall.SIGCHLD,
-		"SIGCONT":   syscall.SIGCONT,
-		"SIGFPE":    syscall.SIGFPE,
-		"SIGHUP":    syscall.SIGHUP,
-		"SIGILL":    syscall.SIGILL,
-		"SIGINT":    syscall.SIGINT,
-		"SIGIO":     syscall.SIGIO,
-		"SIGIOT":    syscall.SIGIOT,
-		"SIGKILL":   syscall.SIGKILL,
-		"SIGPIPE":   syscall.SIGPIPE,
-		"SIGPROF":   syscall.SIGPROF,
-		"SIGQUIT":   syscall.SIGQUIT,
-		"SIGSEGV":   syscall.SIGSEGV,
-		"SIGSTOP":   syscall.SIGSTOP,
-		"SIGSYS":    syscall.SIGSYS,
-		"SIGTERM":   syscall.SIGTERM,
-		"SIGTRAP":   syscall.SIGTRAP,
-		"SIGTSTP":   syscall.SIGTSTP,
-		"SIGTTIN":   syscall.SIGTTIN,
-		"SIGTTOU":   syscall.SIGTTOU,
-		"SIGURG":    syscall.SIGURG,
-		"SIGUSR1":   syscall.SIGUSR1,
-		"SIGUSR2":   syscall.SIGUSR2,
-		"SIGVTALRM": syscall.SIGVTALRM,
-		"SIGWINCH":  syscall.SIGWINCH,
-		"SIGXCPU":   syscall.SIGXCPU,
-		"SIGXFSZ":   syscall.SIGXFSZ,
+		CmdRetry:   "Retry",
+		CmdKill:    "Kill",
 	}
 )
 
@@ -71,15 +69,35 @@ type Result int
 type CommandResult struct {
 	Kind Result
 	Err  error
+	// Which attempt at running the command for the alert the result is about, counting from 1
+	Attempt int
+	// Whether the attempt was killed because it was still running at its deadline
+	TimedOut bool
 }
 
 // Command represents a command that could be run based on what labels match
 type Command struct {
 	Cmd  string   `yaml:"cmd"`
 	Args []string `yaml:"args"`
+	// Environment variables set for the command. Like args, their values can contain template placeholders,
+	// which are expanded against the alert message.
+	Env map[string]string `yaml:"env"`
 	// Only execute this command when all of the given labels match.
 	// The CommonLabels field of prometheus alert data is used for comparison.
 	MatchLabels map[string]string `yaml:"match_labels"`
+	// Only execute this command when all of the given labels match these regular expressions.
+	// Like alertmanager's matchers, the expressions are anchored at both ends,
+	// and a label that is missing from the alert is matched as an empty string.
+	MatchLabelsRe map[string]string `yaml:"match_labels_re"`
+	// Only execute this command when labels are among the targets listed in file_sd files,
+	// which are re-read periodically so that changes to them are picked up.
+	MatchLabelsSD []*LabelSDMatcher `yaml:"match_labels_sd"`
+	// Only execute this command when all of the given annotations match.
+	// The CommonAnnotations field of prometheus alert data is used for comparison.
+	MatchAnnotations map[string]string `yaml:"match_annotations"`
+	// Only execute this command when the given expression is true for an alert of the message, for conditions that
+	// label and annotation equality can't express. See MatchExpr for the language.
+	MatchExpr string `yaml:"match_expr"`
 	// How many instances of this command can run at the same time.
 	// A zero or negative value is interpreted as 'no limit'.
 	Max int `yaml:"max"`
@@ -94,6 +112,136 @@ type Command struct {
 	// Defaults to false.
 	IgnoreResolved *bool  `yaml:"ignore_resolved,omitempty"`
 	ResolvedSig    string `yaml:"resolved_signal"`
+	// How long to wait for the command to exit after sending ResolvedSig, before escalating to SIGKILL.
+	// A zero value means the command isn't killed if it ignores the signal.
+	KillAfter Duration `yaml:"kill_after"`
+	// The longest each attempt at running the command can take. Attempts still running after this are killed, and fail.
+	// The deadline is passed to the command through AMX_DEADLINE, so that it can finish cleanly before then.
+	// A zero value means attempts can take as long as they need.
+	Timeout Duration `yaml:"timeout"`
+	// Whether to run the command in its own process group, and signal the whole group when a matching alert resolves,
+	// so that children started by shell wrappers are stopped along with the command.
+	// Defaults to false.
+	SignalGroup *bool `yaml:"signal_group,omitempty"`
+	// How many times to re-run the command if it fails, before reporting the failure.
+	// A zero or negative value means the command isn't retried.
+	Retries int `yaml:"retries"`
+	// How long to wait before the first retry. The wait doubles with each subsequent retry,
+	// and random jitter is applied so that retries for many alerts don't happen in lock-step.
+	RetryBackoff Duration `yaml:"retry_backoff"`
+	// The longest random delay before each execution starts, so that a notification matching many instances
+	// doesn't have them all call a shared downstream at once. Executions start right away if this isn't set.
+	Jitter Duration `yaml:"jitter"`
+	// How many instances of this command can run at the same time, across all alerts.
+	// Executions beyond this limit wait in a queue for a worker.
+	// A zero or negative value is interpreted as 'no limit', with no queue.
+	Concurrency int `yaml:"concurrency"`
+	// How many executions can wait for a worker when Concurrency is set.
+	// Alerts arriving while the queue is full are skipped.
+	QueueSize int `yaml:"queue_size"`
+	// How long executions can wait in the queue before they're dropped instead of run,
+	// since the alert they were queued for has likely changed by then.
+	// A zero value means executions wait for as long as it takes.
+	MaxQueueAge Duration `yaml:"max_queue_age"`
+	// How long after running for an alert the command is skipped for repeated firing notifications of the same alert,
+	// such as the ones alertmanager re-sends on its repeat_interval. The window starts over once the alert resolves.
+	// A zero value means the command runs for every notification.
+	Debounce Duration `yaml:"debounce"`
+	// How long after finishing running for an alert the command is skipped for it, even if Max would allow it to run,
+	// and even if the alert resolved and fired again in the meantime. This keeps flapping alerts from re-running it.
+	// A zero value means there's no cooldown.
+	Cooldown Duration `yaml:"cooldown"`
+	// How long after succeeding for an alert the command is skipped for its firing notifications, since the problem
+	// was already remediated. Unlike Cooldown, executions that fail don't start the window.
+	// A zero value means the command runs for every notification.
+	SuppressAfterSuccess Duration `yaml:"suppress_after_success"`
+	// How many times the command can run in a minute, and in an hour, for any alerts. Executions beyond these limits
+	// are skipped, so that a misconfigured alert can't run the command hundreds of times.
+	// The limits are token buckets, so the command can use up a limit in a burst, and then runs as it refills.
+	// A zero value means there's no limit.
+	MaxPerMinute int `yaml:"max_per_minute"`
+	MaxPerHour   int `yaml:"max_per_hour"`
+	// Percentage of alerts that the command runs for, such as 10, so that new automation can be rolled out
+	// gradually. Each alert is consistently in or out of the canary, going by its fingerprint.
+	// A zero value means the command runs for all alerts.
+	CanaryPercent float64 `yaml:"canary_percent"`
+	// How long to wait after a firing notification before running the command, such as 1m. If the alert resolves in
+	// the meantime, the command doesn't run at all, since many alerts resolve by themselves.
+	// A zero value means the command runs right away.
+	WaitFor Duration `yaml:"wait_for"`
+	// Only these labels are exposed to the command as environment variables.
+	// All labels are exposed when not defined.
+	EnvLabelAllowlist []string `yaml:"env_label_allowlist"`
+	// These annotations are never exposed to the command as environment variables.
+	EnvAnnotationDenylist []string `yaml:"env_annotation_denylist"`
+	// A Go template that rewrites the alert message before the command's arguments and environment are generated from
+	// it, such as to only keep the alerts that match the command. It has to output the JSON of a webhook payload.
+	Transform string `yaml:"transform"`
+	// Whether to log verbose/debug messages about this command.
+	// Defaults to the global verbose setting when not defined.
+	Verbose *bool `yaml:"verbose,omitempty"`
+	// A separate command to run when a matching alert resolves, regardless of whether this command is still running.
+	// It's matched, filtered, retried and logged like this command.
+	ResolvedCmd  string   `yaml:"resolved_cmd"`
+	ResolvedArgs []string `yaml:"resolved_args"`
+	// Whether to warn, count per-command metrics and call SkipWebhook when the command is skipped
+	// for a matching alert, such as when Max is exceeded or its queue is full.
+	// Defaults to false.
+	NotifyOnSkip *bool `yaml:"notify_on_skip,omitempty"`
+	// URL that a JSON description of each skip is POSTed to, when NotifyOnSkip is set.
+	SkipWebhook string `yaml:"skip_webhook"`
+	// Which alert statuses the command runs for: firing, resolved or both.
+	// Defaults to firing; resolved alerts then only signal running commands.
+	When string `yaml:"when"`
+	// Custom metrics that the command can update by writing to the file named by AMX_METRICS_FILE.
+	Metrics []CustomMetric `yaml:"metrics"`
+	// Identifies the command, so that other commands can run it as a follow-up.
+	Name string `yaml:"name"`
+	// Path that alertmanager receivers post to for the command to run, such as /hooks/disk, so that receivers can target
+	// different sets of commands. Commands with a route don't run for alerts posted to other paths.
+	// Commands without one run for alerts posted to any path that isn't a route.
+	Route string `yaml:"route"`
+	// Names of commands to run for the alert after this command fails or succeeds, such as to escalate to a heavier fix
+	// when a cheap one fails. Commands named here only run as follow-ups, and not directly for alerts.
+	OnFailureRun []string `yaml:"on_failure_run"`
+	OnSuccessRun []string `yaml:"on_success_run"`
+	// A command that undoes what this command did, such as uncordoning a node that it drained.
+	// When a step in a chain of on_success_run follow-ups fails, the rollbacks of the steps that succeeded before it
+	// are run in reverse order.
+	RollbackCmd  string   `yaml:"rollback_cmd"`
+	RollbackArgs []string `yaml:"rollback_args"`
+	// The most output to log from each execution of the command; the rest is discarded.
+	// A zero value means no limit.
+	MaxOutputBytes ByteSize `yaml:"max_output_bytes"`
+	// Where to post an annotation about each execution for a firing alert, such as a Grafana instance,
+	// so that graphs show when the command intervened. No annotations are posted if this isn't set.
+	Annotate *AnnotationConfig `yaml:"annotate"`
+	// A service level objective for the command's executions, which is exported as metrics along with how executions
+	// measured up to it, so that the automation itself can be alerted on.
+	SLO *SLOConfig `yaml:"slo"`
+	// Whether the command is destructive, such as wiping or rebooting hosts, meaning it only runs for alerts that meet
+	// the conditions of its guard.
+	// Defaults to false.
+	Destructive *bool `yaml:"destructive,omitempty"`
+	// The conditions that alerts have to meet for a destructive command to run for them.
+	Guard *GuardConfig `yaml:"guard"`
+	// Whether executions of the command wait for someone to approve them through the /api/approvals endpoint,
+	// instead of running as soon as an alert matches.
+	// Defaults to false.
+	ApprovalRequired *bool `yaml:"approval_required,omitempty"`
+	// How long executions wait for approval before they're dropped. Defaults to 15m.
+	ApprovalTimeout Duration `yaml:"approval_timeout"`
+	// URL that each execution waiting for approval is POSTed to, such as a Slack incoming webhook.
+	ApprovalWebhook string `yaml:"approval_webhook"`
+
+	// The latest attempt at running the command for the alert it's being run for, before this run
+	previous Attempt
+	// Called with the PID of each attempt once it has started, if set
+	started func(pid int)
+	// MatchLabelsRe compiled by CompileLabelRegexps when the config is read, so that they aren't compiled for every alert
+	labelRegexps map[string]*regexp.Regexp
+	// MatchExpr parsed by CompileMatchExpr when the config is read, so that it isn't parsed for every alert
+	matchExpr *MatchExpr
 }
 
 // Return a string representing the result state
@@ -131,9 +279,32 @@ func (r Result) Has(flag Result) bool {
 	return r&flag != 0
 }
 
+// Signalled returns true if the result is of a command that was signalled, or would have been, because its alert resolved
+func (r Result) Signalled() bool {
+	return r.Has(CmdSigOk) || r.Has(CmdSigFail) || r.Has(CmdSkipSig) || r.Has(CmdKill)
+}
+
+// ExitCode returns the exit code of the process that the result is about, and true if the result is of a process exiting.
+// Processes that were terminated by a signal have an exit code of -1.
+// Results of processes that couldn't be started, or of signalling them, have no exit code.
+func (r CommandResult) ExitCode() (int, bool) {
+	if !r.Kind.Has(CmdOk) && !r.Kind.Has(CmdFail) && !r.Kind.Has(CmdRetry) {
+		return 0, false
+	}
+	if r.Err == nil {
+		return 0, true
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(r.Err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
+
 // Equal returns true if the Command is identical to another Command
 func (c Command) Equal(other *Command) bool {
-	if c.Cmd != other.Cmd {
+	if c.Cmd != other.Cmd || c.Name != other.Name {
 		return false
 	}
 
@@ -162,92 +333,644 @@ func (c Command) Equal(other *Command) bool {
 		}
 	}
 
-	return true
+	if len(c.MatchLabelsRe) != len(other.MatchLabelsRe) {
+		return false
+	}
+
+	for k, v := range c.MatchLabelsRe {
+		otherValue, ok := other.MatchLabelsRe[k]
+		if !ok || v != otherValue {
+			return false
+		}
+	}
+
+	if len(c.MatchLabelsSD) != len(other.MatchLabelsSD) {
+		return false
+	}
+
+	for i, m := range c.MatchLabelsSD {
+		if !m.Equal(other.MatchLabelsSD[i]) {
+			return false
+		}
+	}
+
+	if len(c.MatchAnnotations) != len(other.MatchAnnotations) {
+		return false
+	}
+
+	for k, v := range c.MatchAnnotations {
+		otherValue, ok := other.MatchAnnotations[k]
+		if !ok || v != otherValue {
+			return false
+		}
+	}
+
+	if c.MatchExpr != other.MatchExpr || c.Route != other.Route {
+		return false
+	}
+
+	if len(c.Env) != len(other.Env) {
+		return false
+	}
+
+	for k, v := range c.Env {
+		otherValue, ok := other.Env[k]
+		if !ok || v != otherValue {
+			return false
+		}
+	}
+
+	if c.ResolvedCmd != other.ResolvedCmd || len(c.ResolvedArgs) != len(other.ResolvedArgs) {
+		return false
+	}
+
+	for i, arg := range c.ResolvedArgs {
+		if arg != other.ResolvedArgs[i] {
+			return false
+		}
+	}
+
+	if c.RollbackCmd != other.RollbackCmd || len(c.RollbackArgs) != len(other.RollbackArgs) {
+		return false
+	}
+
+	for i, arg := range c.RollbackArgs {
+		if arg != other.RollbackArgs[i] {
+			return false
+		}
+	}
+
+	when, _ := c.ParseWhen()
+	otherWhen, _ := other.ParseWhen()
+	return when == otherWhen
 }
 
-// Fingerprint returns the fingerprint of the first alarm that matches the command's labels.
-// The first fingerprint found is returned if we have no MatchLabels defined.
-func (c Command) Fingerprint(msg *template.Data) (string, bool) {
-	for _, alert := range msg.Alerts {
-		matched := 0
-		for k, v := range c.MatchLabels {
-			other, ok := alert.Labels[k]
-			if ok && v == other {
-				matched += 1
+// FilterData returns a copy of the alert message that only contains the labels and annotations
+// which the command's EnvLabelAllowlist and EnvAnnotationDenylist allow to be exposed to it.
+func (c Command) FilterData(msg *template.Data) *template.Data {
+	if len(c.EnvLabelAllowlist) == 0 && len(c.EnvAnnotationDenylist) == 0 {
+		return msg
+	}
+
+	var labels = func(kv template.KV) template.KV {
+		if len(c.EnvLabelAllowlist) == 0 {
+			return kv
+		}
+		allowed := template.KV{}
+		for _, k := range c.EnvLabelAllowlist {
+			if v, ok := kv[k]; ok {
+				allowed[k] = v
 			}
 		}
-		if matched == len(c.MatchLabels) {
-			return alert.Fingerprint, true
+		return allowed
+	}
+
+	filtered := *msg
+	filtered.CommonLabels = labels(msg.CommonLabels)
+	filtered.GroupLabels = labels(msg.GroupLabels)
+	filtered.CommonAnnotations = msg.CommonAnnotations.Remove(c.EnvAnnotationDenylist)
+	filtered.Alerts = make(template.Alerts, len(msg.Alerts))
+	for i, alert := range msg.Alerts {
+		alert.Labels = labels(alert.Labels)
+		alert.Annotations = alert.Annotations.Remove(c.EnvAnnotationDenylist)
+		filtered.Alerts[i] = alert
+	}
+
+	return &filtered
+}
+
+// Fingerprint returns the fingerprint of the first alarm that matches the command's labels and annotations.
+// The first fingerprint found is returned if we have no MatchLabels, MatchLabelsRe or MatchAnnotations defined.
+func (c Command) Fingerprint(msg *template.Data) (string, bool) {
+	alert, ok := c.Alert(msg)
+	return alert.Fingerprint, ok
+}
+
+// InCanary returns true if the command runs for the alert message under its canary_percent.
+// Alerts are placed in or out of the canary by hashing the command's metric label with the fingerprint of the first
+// alert it matches, or the alert's labels if it has no fingerprint, so that the same alert always gets the same answer,
+// including when it resolves.
+func (c Command) InCanary(msg *template.Data) bool {
+	if c.CanaryPercent <= 0 || c.CanaryPercent >= 100 {
+		return true
+	}
+	alert, _ := c.Alert(msg)
+	key := alert.Fingerprint
+	if key == "" {
+		for _, p := range alert.Labels.SortedPairs() {
+			key += p.Name + "=" + p.Value + ","
+		}
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(c.MetricLabel() + "\x00" + key))
+	return float64(h.Sum64()%10000) < c.CanaryPercent*100
+}
+
+// Alert returns the first alarm that matches the command's labels, annotations and expression.
+// The first alarm is returned if we have no MatchLabels, MatchLabelsRe, MatchAnnotations or MatchExpr defined.
+func (c Command) Alert(msg *template.Data) (template.Alert, bool) {
+	for _, alert := range msg.Alerts {
+		if c.matchesLabels(alert.Labels) && c.matchesAnnotations(alert.Annotations) && c.matchesExpr(msg, alert) {
+			return alert, true
 		}
 	}
 
-	return "", false
+	return template.Alert{}, false
 }
 
-// Matches returns true if all of its labels match against the given prometheus alert message.
-// If we have no MatchLabels defined, we also return true.
+// Matches returns true if all of its labels and annotations match against the given prometheus alert message,
+// and its expression is true for one of the message's alerts.
+// If we have no MatchLabels, MatchLabelsRe, MatchAnnotations or MatchExpr defined, we also return true.
 func (c Command) Matches(msg *template.Data) bool {
-	if len(c.MatchLabels) == 0 {
+	if !c.matchesLabels(msg.CommonLabels) || !c.matchesAnnotations(msg.CommonAnnotations) {
+		return false
+	}
+	if c.MatchExpr == "" {
 		return true
 	}
+	for _, alert := range msg.Alerts {
+		if c.matchesExpr(msg, alert) {
+			return true
+		}
+	}
+	return false
+}
 
+// matchesExpr returns true if the command's MatchExpr is true for an alert of the given message, or isn't set
+func (c Command) matchesExpr(msg *template.Data, alert template.Alert) bool {
+	if c.MatchExpr == "" {
+		return true
+	}
+	e := c.matchExpr
+	if e == nil {
+		// Commands that weren't read from a config file, such as ones built in tests, parse it as they go
+		var err error
+		if e, err = ParseMatchExpr(c.MatchExpr); err != nil {
+			// Invalid expressions can't match anything
+			return false
+		}
+	}
+	ok, err := e.Eval(msg, alert)
+	return err == nil && ok
+}
+
+// matchesAnnotations returns true if all of the command's MatchAnnotations match the given annotations
+func (c Command) matchesAnnotations(annotations template.KV) bool {
+	for k, v := range c.MatchAnnotations {
+		other, ok := annotations[k]
+		if !ok || v != other {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesLabels returns true if all of the command's MatchLabels, MatchLabelsSD and MatchLabelsRe match the given labels
+func (c Command) matchesLabels(labels template.KV) bool {
 	for k, v := range c.MatchLabels {
-		other, ok := msg.CommonLabels[k]
+		other, ok := labels[k]
 		if !ok || v != other {
 			return false
 		}
 	}
 
+	for _, m := range c.MatchLabelsSD {
+		if !m.Matches(labels[m.Label]) {
+			return false
+		}
+	}
+
+	if len(c.MatchLabelsRe) == 0 {
+		return true
+	}
+
+	res := c.labelRegexps
+	if res == nil {
+		// Commands that weren't read from a config file, such as ones built in tests, compile them as they go
+		var err error
+		if res, err = c.ParseLabelRegexps(); err != nil {
+			// Invalid expressions can't match anything
+			return false
+		}
+	}
+	for k, re := range res {
+		if !re.MatchString(labels[k]) {
+			return false
+		}
+	}
+
 	return true
 }
 
+// FollowUps returns the names of the commands to run after this command finished with the given result.
+// Commands that were signalled don't have follow-ups, since their alert has resolved.
+func (c Command) FollowUps(r Result) []string {
+	if r.Signalled() {
+		return nil
+	}
+	if r.Has(CmdOk) {
+		return c.OnSuccessRun
+	}
+	if r.Has(CmdFail) {
+		return c.OnFailureRun
+	}
+	return nil
+}
+
+// followUpNames returns the names of all of the command's follow-ups, whatever the result
+func (c Command) followUpNames() []string {
+	names := make([]string, 0, len(c.OnFailureRun)+len(c.OnSuccessRun))
+	names = append(names, c.OnFailureRun...)
+	return append(names, c.OnSuccessRun...)
+}
+
+// ParseArgTemplates parses the command's arguments as Go templates.
+// Arguments without template placeholders have a nil template, since they're used as-is.
+// Templates can use the "local" function to convert times to the given location, as in {{ (local (index .Alerts 0).StartsAt).Format "15:04" }}.
+func (c Command) ParseArgTemplates(loc *time.Location) ([]*texttemplate.Template, error) {
+	templates := make([]*texttemplate.Template, len(c.Args))
+	for i, arg := range c.Args {
+		t, err := parseValueTemplate(fmt.Sprintf("arg%d", i), arg, loc)
+		if err != nil {
+			return nil, err
+		}
+		templates[i] = t
+	}
+
+	return templates, nil
+}
+
+// parseValueTemplate parses an argument, environment variable value or signal of a command as a Go template,
+// which can use the "local" function to convert times to the given location.
+// Values without template placeholders have a nil template, since they're used as-is.
+func parseValueTemplate(name string, value string, loc *time.Location) (*texttemplate.Template, error) {
+	if !strings.Contains(value, "{{") {
+		return nil, nil
+	}
+	funcs := texttemplate.FuncMap{
+		"local": func(t time.Time) time.Time { return t.In(loc) },
+	}
+	return texttemplate.New(name).Funcs(funcs).Option("missingkey=error").Parse(value)
+}
+
+// ParseEnvTemplates parses the values of the command's Env as Go templates, keyed by the variable's name.
+// Values without template placeholders have a nil template, since they're used as-is.
+func (c Command) ParseEnvTemplates(loc *time.Location) (map[string]*texttemplate.Template, error) {
+	templates := make(map[string]*texttemplate.Template, len(c.Env))
+	for name, value := range c.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("Invalid environment variable name %q", name)
+		}
+		t, err := parseValueTemplate(name, value, loc)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+
+	return templates, nil
+}
+
+// ParseSignalTemplate parses the command's ResolvedSig as a Go template.
+// It returns a nil template if ResolvedSig has no template placeholders, in which case ParseSignal parses it.
+func (c Command) ParseSignalTemplate(loc *time.Location) (*texttemplate.Template, error) {
+	return parseValueTemplate("resolved_signal", c.ResolvedSig, loc)
+}
+
+// ExpandEnv returns the command's Env as environment variables sorted by name, with template placeholders in their
+// values expanded against the alert message, as ExpandArgs does for arguments.
+// If ResolvedSig has template placeholders, it's expanded too, and checked, since it can't be until then. The signal
+// is returned as AMX_RESOLVED_SIGNAL, which tells the command what it'll be signalled with, and the server too.
+func (c Command) ExpandEnv(msg *template.Data, loc *time.Location) ([]string, error) {
+	templates, err := c.ParseEnvTemplates(loc)
+	if err != nil {
+		return nil, err
+	}
+	sigTemplate, err := c.ParseSignalTemplate(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.Env))
+	for name := range c.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		value := c.Env[name]
+		if t := templates[name]; t != nil {
+			var b strings.Builder
+			if err := t.Execute(&b, msg); err != nil {
+				return nil, fmt.Errorf("Failed to expand environment variable %s: %w", name, err)
+			}
+			value = b.String()
+		}
+		env = append(env, name+"="+value)
+	}
+
+	if sigTemplate != nil {
+		var b strings.Builder
+		if err := sigTemplate.Execute(&b, msg); err != nil {
+			return nil, fmt.Errorf("Failed to expand resolved_signal %q: %w", c.ResolvedSig, err)
+		}
+		expanded := c
+		expanded.ResolvedSig = strings.TrimSpace(b.String())
+		if _, err := expanded.ParseSignal(); err != nil {
+			return nil, fmt.Errorf("Invalid resolved_signal %q expanded from %q: %w", expanded.ResolvedSig, c.ResolvedSig, err)
+		}
+		env = append(env, resolvedSignalEnv+"="+expanded.ResolvedSig)
+	}
+
+	return env, nil
+}
+
+// ExpandArgs returns the command's arguments, with template placeholders like {{ .CommonLabels.instance }}
+// expanded against the alert message. Times are converted to the given location by the "local" function.
+func (c Command) ExpandArgs(msg *template.Data, loc *time.Location) ([]string, error) {
+	templates, err := c.ParseArgTemplates(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		if templates[i] == nil {
+			args[i] = arg
+			continue
+		}
+
+		var b strings.Builder
+		if err := templates[i].Execute(&b, msg); err != nil {
+			return nil, fmt.Errorf("Failed to expand argument %q: %w", arg, err)
+		}
+		args[i] = b.String()
+	}
+
+	return args, nil
+}
+
+// ParseLabelRegexps returns the compiled regular expressions of c.MatchLabelsRe, anchored at both ends,
+// and any error encountered while compiling them.
+func (c Command) ParseLabelRegexps() (map[string]*regexp.Regexp, error) {
+	res := make(map[string]*regexp.Regexp, len(c.MatchLabelsRe))
+	for k, v := range c.MatchLabelsRe {
+		re, err := regexp.Compile("^(?:" + v + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression for label %s: %w", k, err)
+		}
+		res[k] = re
+	}
+
+	return res, nil
+}
+
+// CompileLabelRegexps compiles c.MatchLabelsRe once, so that matching alerts doesn't compile them again
+func (c *Command) CompileLabelRegexps() error {
+	res, err := c.ParseLabelRegexps()
+	if err != nil {
+		return err
+	}
+	c.labelRegexps = res
+	return nil
+}
+
+// CompileMatchExpr parses c.MatchExpr once, so that matching alerts doesn't parse it again
+func (c *Command) CompileMatchExpr() error {
+	if c.MatchExpr == "" {
+		c.matchExpr = nil
+		return nil
+	}
+	e, err := ParseMatchExpr(c.MatchExpr)
+	if err != nil {
+		return err
+	}
+	c.matchExpr = e
+	return nil
+}
+
 // Run executes the command, potentially signalling it if alarm that triggered command resolves.
+// A failed command is re-run up to c.Retries times, with each retry also being reported through the out channel.
+// ctx is cancelled when execution should quit early: because the alert resolved, the execution was stopped on request,
+// or the executor is shutting down. The command is sent c.ResolvedSig in each case.
 // out channel is used to indicate the result of running or killing the program. May indicate errors.
-// quit channel is used to determine if execution should quit early
 // done channel is used to indicate to caller when execution has completed
-func (c Command) Run(out chan<- CommandResult, quit chan struct{}, done chan struct{}, env ...string) {
+// output receives the command's STDOUT and STDERR, which are attached to the logger if it's nil
+// Each attempt is told its number through AMX_ATTEMPT, and the result of the attempt before it through AMX_PREVIOUS_RESULT.
+// If c.Timeout is set, attempts are told their deadline through AMX_DEADLINE, and killed if they're still running then.
+func (c Command) Run(ctx context.Context, out chan<- CommandResult, done chan struct{}, output io.Writer, env ...string) {
 	defer close(out)
 	defer close(done)
 	var wg sync.WaitGroup
-	cmd := c.WithEnv(env...)
-	// We use a buffer of one, so that if the command is killed before it finishes,
-	// we will still be able to close the channel and end the Command.Run method;
-	// There won't be a channel reader left, because the select statement ended when quit was read from.
-	cmdOut := make(chan CommandResult, 1)
-	wg.Add(1)
-	go func() {
-		defer close(cmdOut)
-		defer wg.Done()
-		err := cmd.Run()
-		if err == nil {
-			cmdOut <- CommandResult{Kind: CmdOk, Err: nil}
-		} else {
-			cmdOut <- CommandResult{Kind: CmdFail, Err: err}
+	defer wg.Wait()
+	previous := c.previous.Result
+	if !c.waitJitter(ctx) {
+		// The alert resolved before the command started, so there's nothing left for it to do
+		out <- CommandResult{Kind: CmdSigOk, Err: nil, Attempt: c.previous.Number + 1}
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		number := c.previous.Number + attempt + 1
+		extraEnv := attemptEnv(number, previous)
+		attemptCtx, cancel := c.attemptContext()
+		if deadline, ok := attemptCtx.Deadline(); ok {
+			extraEnv = append(extraEnv, deadlineEnv(deadline))
 		}
-	}()
-
-	select {
-	case r := <-cmdOut:
-		out <- r
-	case <-quit:
-		if c.ShouldIgnoreResolved() {
-			out <- CommandResult{Kind: CmdSkipSig, Err: nil}
-		} else {
-			sig, err := c.ParseSignal()
-			if err != nil {
-				errMsg := fmt.Errorf("Can't use signal %s to notify pid %d for command %s: %w", c.ResolvedSig, cmd.Process.Pid, c, err)
-				out <- CommandResult{Kind: CmdSigFail, Err: errMsg}
+		cmd := c.WithEnv(attemptCtx, append(env[:len(env):len(env)], extraEnv...)...)
+		previous = CmdFail
+		if output != nil {
+			cmd.Stdout = output
+			cmd.Stderr = output
+		}
+		if ctx.Err() != nil {
+			// The execution was stopped before the attempt started, so there's no process left to signal
+			cancel()
+			out <- CommandResult{Kind: CmdSigOk, Err: nil, Attempt: number}
+			return
+		}
+		// We use a buffer of one, so that if the command is killed before it finishes,
+		// we will still be able to close the channel and end the Command.Run method;
+		// There won't be a channel reader left, because the select statement ended when ctx was cancelled.
+		cmdOut := make(chan CommandResult, 1)
+		// Whether the process started is handed back before ctx is watched, so that it's only signalled once it exists
+		started := make(chan bool, 1)
+		wg.Add(1)
+		go func() {
+			defer close(cmdOut)
+			defer wg.Done()
+			// The attempt's context outlives Run if it returned early, since cancelling it kills the process
+			defer cancel()
+			err := cmd.Start()
+			started <- err == nil
+			if err == nil {
+				if c.started != nil {
+					c.started(cmd.Process.Pid)
+				}
+				err = cmd.Wait()
 			}
-			err = cmd.Process.Signal(sig)
 			if err == nil {
-				out <- CommandResult{Kind: CmdSigOk, Err: nil}
+				cmdOut <- CommandResult{Kind: CmdOk, Err: nil}
 			} else {
-				errMsg := fmt.Errorf("Failed sending %s to pid %d for command %s: %w", sig, cmd.Process.Pid, c, err)
-				out <- CommandResult{Kind: CmdSigFail, Err: errMsg}
+				cmdOut <- CommandResult{Kind: CmdFail, Err: err}
 			}
+		}()
+
+		stopped := ctx.Done()
+		if !<-started {
+			// There's no process to signal, so only the failure to start it is waited for
+			stopped = nil
+		}
+		var r CommandResult
+		select {
+		case r = <-cmdOut:
+		case <-attemptCtx.Done():
+			// exec.CommandContext kills the process at its deadline
+			r = <-cmdOut
+		case <-stopped:
+			if c.ShouldIgnoreResolved() {
+				out <- CommandResult{Kind: CmdSkipSig, Err: nil, Attempt: number}
+			} else {
+				sig, err := c.ParseSignal()
+				if err != nil {
+					errMsg := fmt.Errorf("Can't use signal %s to notify pid %d for command %s: %w", c.ResolvedSig, cmd.Process.Pid, c, err)
+					out <- CommandResult{Kind: CmdSigFail, Err: errMsg, Attempt: number}
+					return
+				}
+				err = c.signal(cmd, sig)
+				if err == nil {
+					out <- CommandResult{Kind: CmdSigOk, Err: nil, Attempt: number}
+					if c.KillAfter > 0 && !kills(sig) {
+						c.killAfter(cmd, cmdOut, out)
+					}
+				} else {
+					errMsg := fmt.Errorf("Failed sending %s to pid %d for command %s: %w", sig, cmd.Process.Pid, c, err)
+					out <- CommandResult{Kind: CmdSigFail, Err: errMsg, Attempt: number}
+				}
+			}
+			return
+		}
+
+		if attemptCtx.Err() == context.DeadlineExceeded {
+			r = c.expire(cmd, r)
+		}
+		r.Attempt = number
+		if r.Kind.Has(CmdFail) && attempt < c.Retries {
+			out <- CommandResult{Kind: CmdRetry, Err: r.Err, Attempt: number, TimedOut: r.TimedOut}
+			if c.waitRetry(ctx, attempt) {
+				continue
+			}
+		}
+		out <- r
+		return
+	}
+}
+
+// RetryDelay returns how long to wait before retrying the command, after the given failed attempt (counting from 0).
+// The delay doubles with each attempt, and is randomly reduced by up to half to spread retries out.
+func (c Command) RetryDelay(attempt int) time.Duration {
+	d := time.Duration(c.RetryBackoff)
+	if d <= 0 {
+		d = defaultRetryBackoff
+	}
+	for i := 0; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// JitterDelay returns a random delay of up to Jitter, for an execution of the command to wait before it starts
+func (c Command) JitterDelay() time.Duration {
+	if c.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(c.Jitter) + 1))
+}
+
+// waitJitter waits a random delay before the command starts.
+// Returns false if ctx was cancelled while waiting, meaning the command shouldn't start,
+// unless it ignores resolved alerts.
+func (c Command) waitJitter(ctx context.Context) bool {
+	d := c.JitterDelay()
+	if d == 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	if c.ShouldIgnoreResolved() {
+		<-t.C
+		return true
+	}
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// attemptContext returns the context an attempt at running the command runs in, which expires after c.Timeout if set.
+// It isn't derived from the context that Run is given, because attempts are signalled when that's cancelled,
+// rather than killed.
+func (c Command) attemptContext() (context.Context, context.CancelFunc) {
+	if c.Timeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(c.Timeout))
+	}
+	return context.WithCancel(context.Background())
+}
+
+// killAfter waits for a signalled process to exit, and kills it if it's still running after the KillAfter grace period
+func (c Command) killAfter(cmd *exec.Cmd, exited <-chan CommandResult, out chan<- CommandResult) {
+	t := time.NewTimer(time.Duration(c.KillAfter))
+	defer t.Stop()
+	select {
+	case <-exited:
+	case <-t.C:
+		err := c.signal(cmd, os.Kill)
+		if err == nil {
+			out <- CommandResult{Kind: CmdKill, Err: nil}
+		} else {
+			errMsg := fmt.Errorf("Failed killing pid %d for command %s after %s: %w", cmd.Process.Pid, c, c.KillAfter, err)
+			out <- CommandResult{Kind: CmdSigFail, Err: errMsg}
 		}
 	}
-	wg.Wait()
+}
+
+// expire returns the result of an attempt that was killed at its deadline.
+// exec.CommandContext only kills the process itself, so the rest of its group is killed here if c.SignalGroup is set.
+// If the attempt succeeded before it could be killed, its own result is returned.
+func (c Command) expire(cmd *exec.Cmd, r CommandResult) CommandResult {
+	if cmd.Process != nil && c.ShouldSignalGroup() {
+		_ = c.signal(cmd, os.Kill)
+	}
+	if !r.Kind.Has(CmdFail) {
+		return r
+	}
+	return CommandResult{Kind: CmdFail, Err: fmt.Errorf("Killed command %s after its timeout of %s: %w", c, c.Timeout, r.Err), TimedOut: true}
+}
+
+// signal sends a signal to the command's process, or to its process group if c.SignalGroup is set.
+// Signals the platform doesn't have are emulated by killing the process.
+func (c Command) signal(cmd *exec.Cmd, sig os.Signal) error {
+	return platformSignals.Signal(cmd.Process, sig, c.ShouldSignalGroup())
+}
+
+// waitRetry waits before the command is retried.
+// Returns false if ctx was cancelled while waiting, meaning the command shouldn't be retried.
+func (c Command) waitRetry(ctx context.Context, attempt int) bool {
+	t := time.NewTimer(c.RetryDelay(attempt))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 // ShouldIgnoreResolved returns the interpreted value of c.IgnoreResolved.
@@ -272,29 +995,157 @@ func (c Command) ShouldNotify() bool {
 	return *c.NotifyOnFailure
 }
 
+// ShouldNotifySkip returns the interpreted value of NotifyOnSkip
+func (c Command) ShouldNotifySkip() bool {
+	return c.NotifyOnSkip != nil && *c.NotifyOnSkip
+}
+
+// RequiresApproval returns the interpreted value of ApprovalRequired
+func (c Command) RequiresApproval() bool {
+	return c.ApprovalRequired != nil && *c.ApprovalRequired
+}
+
+// ApprovalWait returns how long executions of the command wait for approval
+func (c Command) ApprovalWait() time.Duration {
+	if c.ApprovalTimeout <= 0 {
+		return time.Duration(defaultApprovalTimeout)
+	}
+	return time.Duration(c.ApprovalTimeout)
+}
+
+// IsDestructive returns the interpreted value of Destructive
+func (c Command) IsDestructive() bool {
+	return c.Destructive != nil && *c.Destructive
+}
+
+// ShouldSignalGroup returns the interpreted value of SignalGroup
+func (c Command) ShouldSignalGroup() bool {
+	return c.SignalGroup != nil && *c.SignalGroup
+}
+
+// ShouldLog returns the interpreted value of c.Verbose, using the global verbose setting if c.Verbose isn't defined.
+// This method is used to work around ambiguity of unmarshalling yaml boolean values,
+// due to the default value of a bool being false.
+func (c Command) ShouldLog(verbose bool) bool {
+	if c.Verbose == nil {
+		// Default to the global setting when value is not defined
+		return verbose
+	}
+	return *c.Verbose
+}
+
 // ParseSignal returns the signal that is meant to be used for notifying the command that its triggering condition has resolved,
 // and any error encountered while parsing.
+// Signals the platform doesn't have, such as SIGUSR2 on Windows, are returned as an emulatedSignal.
 func (c Command) ParseSignal() (os.Signal, error) {
 	if len(c.ResolvedSig) == 0 {
 		return os.Kill, nil
 	}
 
 	var notFound = os.Signal(syscall.Signal(-1))
-	if IsDigit(c.ResolvedSig) {
-		n, err := strconv.Atoi(c.ResolvedSig)
-		if err != nil {
+	want := strings.ToUpper(c.ResolvedSig)
+	if IsDigit(want) {
+		if _, err := strconv.Atoi(want); err != nil {
 			return notFound, err
 		}
-		return os.Signal(syscall.Signal(n)), nil
+	} else if !knownSignal(want) {
+		return notFound, fmt.Errorf("Unknown signal %s", want)
 	}
 
-	want := strings.ToUpper(c.ResolvedSig)
-	sig, ok := signals[strings.ToUpper(c.ResolvedSig)]
-	if !ok {
-		return notFound, fmt.Errorf("Unknown signal %s", want)
+	if sig, ok := platformSignals.Lookup(want); ok {
+		return sig, nil
 	}
+	return emulatedSignal(want), nil
+}
+
+// ParseWhen returns which alert statuses the command runs for, applying the default
+func (c Command) ParseWhen() (string, error) {
+	switch strings.ToLower(c.When) {
+	case "", WhenFiring:
+		return WhenFiring, nil
+	case WhenResolved:
+		return WhenResolved, nil
+	case WhenBoth:
+		return WhenBoth, nil
+	}
+
+	return "", fmt.Errorf("Unknown status %q, expected %s, %s or %s", c.When, WhenFiring, WhenResolved, WhenBoth)
+}
 
-	return sig, nil
+// ResolvedCommand returns the command to run when a matching alert resolves, if ResolvedCmd is set.
+// It shares this command's name, matchers, environment variables and filters, jitter, and failure and retry settings, so it's disabled along with it.
+func (c Command) ResolvedCommand() (*Command, bool) {
+	if c.ResolvedCmd == "" {
+		return nil, false
+	}
+
+	return &Command{
+		Name:                  c.Name,
+		Cmd:                   c.ResolvedCmd,
+		Args:                  c.ResolvedArgs,
+		Env:                   c.Env,
+		MatchLabels:           c.MatchLabels,
+		MatchLabelsRe:         c.MatchLabelsRe,
+		labelRegexps:          c.labelRegexps,
+		matchExpr:             c.matchExpr,
+		MatchLabelsSD:         c.MatchLabelsSD,
+		MatchAnnotations:      c.MatchAnnotations,
+		MatchExpr:             c.MatchExpr,
+		NotifyOnFailure:       c.NotifyOnFailure,
+		Retries:               c.Retries,
+		RetryBackoff:          c.RetryBackoff,
+		Jitter:                c.Jitter,
+		Timeout:               c.Timeout,
+		EnvLabelAllowlist:     c.EnvLabelAllowlist,
+		EnvAnnotationDenylist: c.EnvAnnotationDenylist,
+		Verbose:               c.Verbose,
+		NotifyOnSkip:          c.NotifyOnSkip,
+		SkipWebhook:           c.SkipWebhook,
+		When:                  WhenResolved,
+	}, true
+}
+
+// RollbackCommand returns the command that undoes this command, if RollbackCmd is set.
+// It shares this command's name, environment variables and filters, and failure and retry settings.
+func (c Command) RollbackCommand() (*Command, bool) {
+	if c.RollbackCmd == "" {
+		return nil, false
+	}
+
+	return &Command{
+		Name:                  c.Name,
+		Cmd:                   c.RollbackCmd,
+		Args:                  c.RollbackArgs,
+		Env:                   c.Env,
+		NotifyOnFailure:       c.NotifyOnFailure,
+		Retries:               c.Retries,
+		RetryBackoff:          c.RetryBackoff,
+		Timeout:               c.Timeout,
+		EnvLabelAllowlist:     c.EnvLabelAllowlist,
+		EnvAnnotationDenylist: c.EnvAnnotationDenylist,
+		Verbose:               c.Verbose,
+	}, true
+}
+
+// QueueCapacity returns how many executions of the command can be running or waiting in its queue at once,
+// or zero if the command doesn't limit its concurrency.
+func (c Command) QueueCapacity() int {
+	if c.Concurrency <= 0 {
+		return 0
+	}
+	if c.QueueSize < 0 {
+		return c.Concurrency
+	}
+	return c.Concurrency + c.QueueSize
+}
+
+// RunsOn returns true if the command should run for alert messages with the given status
+func (c Command) RunsOn(status string) bool {
+	when, err := c.ParseWhen()
+	if err != nil {
+		return false
+	}
+	return when == WhenBoth || when == status
 }
 
 // String returns a string representation of the command
@@ -305,14 +1156,69 @@ func (c Command) String() string {
 	return fmt.Sprintf("%s %s", c.Cmd, strings.Join(c.Args, " "))
 }
 
-// WithEnv returns a runnable command with the given environment variables added.
+// MetricLabel returns the value of the command label in metrics about this command:
+// its name if it has one, otherwise the program it runs.
+func (c Command) MetricLabel() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Cmd
+}
+
+// Version returns a short hash identifying this version of the command.
+// It covers the command's definition, and the contents of the file it executes when it can be found and read,
+// so that logs show exactly which version of a script ran even if it was edited in place.
+func (c Command) Version() string {
+	h := sha256.New()
+	// Maps are encoded with sorted keys, so the encoding is stable for a given definition
+	def, err := json.Marshal(c)
+	if err == nil {
+		_, _ = h.Write(def)
+	}
+
+	path, err := exec.LookPath(c.Cmd)
+	if err == nil {
+		contents, err := ioutil.ReadFile(path)
+		if err == nil {
+			_, _ = h.Write(contents)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// WithInterpreter returns the command with its script run through an interpreter,
+// if the script isn't executable and the interpreters map one to the script's file extension.
+// Otherwise, the command is returned unchanged.
+func (c Command) WithInterpreter(interpreters map[string]string) Command {
+	interpreter, ok := interpreters[filepath.Ext(c.Cmd)]
+	if !ok {
+		return c
+	}
+
+	info, err := os.Stat(c.Cmd)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 != 0 {
+		// Leave missing files and executables alone, so they fail or run as usual
+		return c
+	}
+
+	c.Args = append([]string{c.Cmd}, c.Args...)
+	c.Cmd = interpreter
+	return c
+}
+
+// WithEnv returns a runnable command with the given environment variables added, which is killed once ctx is done.
 // Command STDOUT and STDERR is attached to the logger.
-func (c Command) WithEnv(env ...string) *exec.Cmd {
+// The command is started in its own process group if c.SignalGroup is set.
+func (c Command) WithEnv(ctx context.Context, env ...string) *exec.Cmd {
 	lw := log.Writer()
-	cmd := exec.Command(c.Cmd, c.Args...)
+	cmd := exec.CommandContext(ctx, c.Cmd, c.Args...)
 	cmd.Env = append(os.Environ(), env...)
 	cmd.Stdout = lw
 	cmd.Stderr = lw
+	if c.ShouldSignalGroup() {
+		platformSignals.Isolate(cmd)
+	}
 
 	return cmd
 }
@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Signal names are tested separately, since platforms without POSIX signals, such as Windows, don't define them
+func TestCommand_ParseSignal_names(t *testing.T) {
+	cases := []struct {
+		name string
+		cmd  Command
+		sig  os.Signal
+	}{
+		{
+			name: "signame_lower",
+			cmd:  Command{ResolvedSig: "sigusr2"},
+			sig:  syscall.SIGUSR2,
+		},
+		{
+			name: "signame_upper",
+			cmd:  Command{ResolvedSig: "SIGSTOP"},
+			sig:  syscall.SIGSTOP,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			sig, err := tc.cmd.ParseSignal()
+			if err != nil {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if sig != tc.sig {
+				t.Errorf("Wrong signal value; got %s, want %s", sig, tc.sig)
+			}
+		})
+	}
+}
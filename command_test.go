@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"testing"
@@ -101,6 +107,34 @@ func TestResult_String(t *testing.T) {
 	}
 }
 
+func TestCommandResult_ExitCode(t *testing.T) {
+	exit2 := exec.Command("sh", "-c", "exit 2").Run()
+	missing := exec.Command("/nonexistent/command").Run()
+	cases := []struct {
+		name   string
+		result CommandResult
+		code   int
+		ok     bool
+	}{
+		{name: "ok", result: CommandResult{Kind: CmdOk}, code: 0, ok: true},
+		{name: "fail", result: CommandResult{Kind: CmdFail, Err: exit2}, code: 2, ok: true},
+		{name: "retry", result: CommandResult{Kind: CmdRetry, Err: exit2}, code: 2, ok: true},
+		{name: "not_started", result: CommandResult{Kind: CmdFail, Err: missing}, ok: false},
+		{name: "signalled", result: CommandResult{Kind: CmdSigOk}, ok: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			code, ok := tc.result.ExitCode()
+			if code != tc.code || ok != tc.ok {
+				t.Errorf("Wrong exit code for %v; got %d, %v, want %d, %v", tc.result, code, ok, tc.code, tc.ok)
+			}
+		})
+	}
+}
+
 func TestCommand_Equal(t *testing.T) {
 	cases := []struct {
 		name string
@@ -178,6 +212,20 @@ func TestCommand_Equal(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "different_labels_re",
+			a: &Command{
+				Cmd:           "echo",
+				Args:          []string{"banana", "lemon"},
+				MatchLabelsRe: map[string]string{"env": "test.*"},
+			},
+			b: &Command{
+				Cmd:           "echo",
+				Args:          []string{"banana", "lemon"},
+				MatchLabelsRe: map[string]string{"env": "prod.*"},
+			},
+			want: false,
+		},
 		{
 			name: "different_labels",
 			a: &Command{
@@ -192,6 +240,24 @@ func TestCommand_Equal(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "different_route",
+			a:    &Command{Cmd: "echo", Route: "/hooks/a"},
+			b:    &Command{Cmd: "echo", Route: "/hooks/b"},
+			want: false,
+		},
+		{
+			name: "different_labels_sd",
+			a: &Command{
+				Cmd:           "echo",
+				MatchLabelsSD: []*LabelSDMatcher{{Label: "instance", Files: []string{"/etc/prometheus/canaries.json"}}},
+			},
+			b: &Command{
+				Cmd:           "echo",
+				MatchLabelsSD: []*LabelSDMatcher{{Label: "instance", Files: []string{"/etc/prometheus/stable.json"}}},
+			},
+			want: false,
+		},
 	}
 
 	for _, tc := range cases {
@@ -212,6 +278,54 @@ func TestCommand_Equal(t *testing.T) {
 	}
 }
 
+func TestCommand_FilterData(t *testing.T) {
+	t.Parallel()
+	msg := amData
+	msg.CommonAnnotations = template.KV{"summary": "Instance down", "token_url": "https://example.com/?token=secret"}
+
+	// Without any filters, the message is returned as-is
+	if got := (Command{Cmd: "echo"}).FilterData(&msg); got != &msg {
+		t.Errorf("Unfiltered message should be returned as-is")
+	}
+
+	cmd := Command{
+		Cmd:                   "echo",
+		EnvLabelAllowlist:     []string{"alertname", "instance"},
+		EnvAnnotationDenylist: []string{"token_url"},
+	}
+	filtered := cmd.FilterData(&msg)
+	env := amDataToEnv(filtered, time.UTC)
+
+	for _, want := range []string{
+		"AMX_LABEL_alertname=InstanceDown",
+		"AMX_LABEL_instance=localhost:1234",
+		"AMX_GLABEL_alertname=InstanceDown",
+		"AMX_ALERT_2_LABEL_instance=localhost:5678",
+		"AMX_ANNOTATION_summary=Instance down",
+	} {
+		if !containsString(want, env) {
+			t.Errorf("Missing env var %s", want)
+		}
+	}
+
+	for _, v := range env {
+		if strings.Contains(v, "_LABEL_job=") || strings.Contains(v, "_LABEL_monitor=") || strings.Contains(v, "token_url") {
+			t.Errorf("Env var should have been filtered: %s", v)
+		}
+	}
+
+	// The original message is left untouched
+	if _, ok := msg.CommonLabels["job"]; !ok {
+		t.Errorf("Original message labels were modified")
+	}
+	if _, ok := msg.Alerts[0].Labels["job"]; !ok {
+		t.Errorf("Original message alert labels were modified")
+	}
+	if _, ok := msg.CommonAnnotations["token_url"]; !ok {
+		t.Errorf("Original message annotations were modified")
+	}
+}
+
 func TestCommand_Fingerprint(t *testing.T) {
 	cases := []struct {
 		name        string
@@ -251,6 +365,17 @@ func TestCommand_Fingerprint(t *testing.T) {
 			fingerprint: "",
 			ok:          true,
 		},
+		// The fingerprint of the first alarm matching regular expressions should be used
+		{
+			name: "match_re",
+			cmd: &Command{
+				Cmd: "echo",
+				MatchLabelsRe: map[string]string{
+					"instance": ".*:5678",
+				}},
+			fingerprint: "boop",
+			ok:          true,
+		},
 		// A non-matching alarm should have an empty fingerprint and a false condition
 		{
 			name: "no_match",
@@ -326,6 +451,36 @@ func TestCommand_Matches(t *testing.T) {
 			cmd:  &Command{Cmd: "echo", MatchLabels: someMatching},
 			want: false,
 		},
+		// Labels matching regular expressions should have the command match the alert
+		{
+			cmd:  &Command{Cmd: "echo", MatchLabelsRe: map[string]string{"instance": "localhost:.*", "job": "broken|fixed"}},
+			want: true,
+		},
+		// Regular expressions are anchored, so they need to match the whole label value
+		{
+			cmd:  &Command{Cmd: "echo", MatchLabelsRe: map[string]string{"instance": "localhost"}},
+			want: false,
+		},
+		// A missing label is matched as an empty string
+		{
+			cmd:  &Command{Cmd: "echo", MatchLabelsRe: map[string]string{"banana": ".*"}},
+			want: true,
+		},
+		// Both labels and regular expressions need to match
+		{
+			cmd:  &Command{Cmd: "echo", MatchLabels: noMatching, MatchLabelsRe: map[string]string{"instance": ".*"}},
+			want: false,
+		},
+		// Invalid regular expressions don't match anything
+		{
+			cmd:  &Command{Cmd: "echo", MatchLabelsRe: map[string]string{"instance": "(banana"}},
+			want: false,
+		},
+		// Annotations that don't match means command should not match the alert
+		{
+			cmd:  &Command{Cmd: "echo", MatchAnnotations: map[string]string{"runbook": "auto-remediate"}},
+			want: false,
+		},
 	}
 
 	for i, tc := range cases {
@@ -367,18 +522,6 @@ func TestCommand_ParseSignal(t *testing.T) {
 			sig:     os.Signal(syscall.Signal(-1)),
 			wantErr: true,
 		},
-		{
-			name:    "signame_lower",
-			cmd:     Command{ResolvedSig: "sigusr2"},
-			sig:     syscall.SIGUSR2,
-			wantErr: false,
-		},
-		{
-			name:    "signame_upper",
-			cmd:     Command{ResolvedSig: "SIGSTOP"},
-			sig:     syscall.SIGSTOP,
-			wantErr: false,
-		},
 	}
 
 	for _, tc := range cases {
@@ -401,10 +544,682 @@ func TestCommand_ParseSignal(t *testing.T) {
 	}
 }
 
+func TestCommand_RetryDelay(t *testing.T) {
+	cases := []struct {
+		name    string
+		cmd     Command
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{
+			name:    "default",
+			cmd:     Command{Retries: 1},
+			attempt: 0,
+			min:     defaultRetryBackoff / 2,
+			max:     defaultRetryBackoff,
+		},
+		{
+			name:    "first",
+			cmd:     Command{Retries: 3, RetryBackoff: Duration(10 * time.Second)},
+			attempt: 0,
+			min:     5 * time.Second,
+			max:     10 * time.Second,
+		},
+		{
+			name:    "third",
+			cmd:     Command{Retries: 3, RetryBackoff: Duration(10 * time.Second)},
+			attempt: 2,
+			min:     20 * time.Second,
+			max:     40 * time.Second,
+		},
+		{
+			name:    "capped",
+			cmd:     Command{Retries: 100, RetryBackoff: Duration(10 * time.Second)},
+			attempt: 99,
+			min:     maxRetryDelay / 2,
+			max:     maxRetryDelay,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			d := tc.cmd.RetryDelay(tc.attempt)
+			if d < tc.min || d > tc.max {
+				t.Errorf("Retry delay out of range; got %s, want between %s and %s", d, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+func TestCommand_JitterDelay(t *testing.T) {
+	t.Parallel()
+	if d := (Command{}).JitterDelay(); d != 0 {
+		t.Errorf("Command without jitter shouldn't be delayed; got %s", d)
+	}
+	cmd := Command{Jitter: Duration(time.Second)}
+	for i := 0; i < 100; i++ {
+		if d := cmd.JitterDelay(); d < 0 || d > time.Second {
+			t.Fatalf("Jitter delay out of range; got %s, want between 0s and 1s", d)
+		}
+	}
+}
+
+func TestCommand_RunJitter(t *testing.T) {
+	cases := []struct {
+		name     string
+		jitter   Duration
+		resolved bool
+		want     Result
+		marker   bool
+	}{
+		{name: "runs_after_jitter", jitter: Duration(100 * time.Millisecond), want: CmdOk, marker: true},
+		// The alert resolves long before the jitter is up, so the command never starts
+		{name: "resolved_while_waiting", jitter: Duration(time.Hour), resolved: true, want: CmdSigOk},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "jitter")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			marker := filepath.Join(dir, "ran")
+			cmd := Command{Cmd: "touch", Args: []string{marker}, Jitter: tc.jitter}
+			out := make(chan CommandResult)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan struct{})
+			if tc.resolved {
+				cancel()
+			}
+			go cmd.Run(ctx, out, done, nil)
+
+			var state Result
+			for r := range out {
+				state = state | r.Kind
+			}
+			<-done
+
+			if state != tc.want {
+				t.Errorf("Wrong result; got %s, want %s", state, tc.want)
+			}
+			if _, err := os.Stat(marker); (err == nil) != tc.marker {
+				t.Errorf("Wrong state of whether the command ran; got %t, want %t", err == nil, tc.marker)
+			}
+		})
+	}
+}
+
+func TestCommand_InCanary(t *testing.T) {
+	t.Parallel()
+	msg := func(fingerprint string) *template.Data {
+		return &template.Data{Alerts: template.Alerts{{Fingerprint: fingerprint, Labels: template.KV{"instance": fingerprint}}}}
+	}
+
+	for _, percent := range []float64{0, 100} {
+		cmd := Command{Cmd: "echo", CanaryPercent: percent}
+		if !cmd.InCanary(msg("boop")) {
+			t.Errorf("Command with canary_percent of %g should run for all alerts", percent)
+		}
+	}
+
+	cmd := Command{Cmd: "echo", CanaryPercent: 25}
+	in := 0
+	for i := 0; i < 1000; i++ {
+		fingerprint := strconv.Itoa(i)
+		ok := cmd.InCanary(msg(fingerprint))
+		if ok {
+			in++
+		}
+		if again := cmd.InCanary(msg(fingerprint)); again != ok {
+			t.Fatalf("Alert %s should consistently be in or out of the canary", fingerprint)
+		}
+	}
+	if in < 200 || in > 300 {
+		t.Errorf("Wrong number of alerts in canary of 25%%; got %d of 1000", in)
+	}
+
+	// Alerts without a fingerprint are placed by their labels
+	noFingerprint := &template.Data{Alerts: template.Alerts{{Labels: template.KV{"instance": "a"}}}}
+	if cmd.InCanary(noFingerprint) != cmd.InCanary(noFingerprint) {
+		t.Errorf("Alert without a fingerprint should consistently be in or out of the canary")
+	}
+}
+
+func TestCommand_MatchesAnnotations(t *testing.T) {
+	t.Parallel()
+	msg := amDataFinger
+	msg.CommonAnnotations = template.KV{"runbook": "auto-remediate", "summary": "Instance down"}
+	msg.Alerts = template.Alerts{msg.Alerts[0]}
+	msg.Alerts[0].Annotations = msg.CommonAnnotations
+
+	cases := []struct {
+		name string
+		cmd  *Command
+		want bool
+	}{
+		{
+			name: "match",
+			cmd:  &Command{Cmd: "echo", MatchAnnotations: map[string]string{"runbook": "auto-remediate"}},
+			want: true,
+		},
+		{
+			name: "no_match",
+			cmd:  &Command{Cmd: "echo", MatchAnnotations: map[string]string{"runbook": "manual"}},
+			want: false,
+		},
+		{
+			name: "missing",
+			cmd:  &Command{Cmd: "echo", MatchAnnotations: map[string]string{"owner": "me"}},
+			want: false,
+		},
+		// Both labels and annotations need to match
+		{
+			name: "labels_no_match",
+			cmd: &Command{
+				Cmd:              "echo",
+				MatchLabels:      map[string]string{"job": "fixed"},
+				MatchAnnotations: map[string]string{"runbook": "auto-remediate"},
+			},
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tc.cmd.Matches(&msg); got != tc.want {
+				t.Errorf("Wrong Matches result; got %v, want %v", got, tc.want)
+			}
+			f, ok := tc.cmd.Fingerprint(&msg)
+			if ok != tc.want {
+				t.Errorf("Wrong Fingerprint found boolean; got %v, want %v", ok, tc.want)
+			}
+			if ok && f != "boop" {
+				t.Errorf("Wrong fingerprint; got '%s', want '%s'", f, "boop")
+			}
+		})
+	}
+}
+
+func TestCommand_ExpandArgs(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want []string
+		err  bool
+	}{
+		{
+			name: "plain",
+			args: []string{"-v", "reboot"},
+			want: []string{"-v", "reboot"},
+		},
+		{
+			name: "label",
+			args: []string{"--host", "{{ .CommonLabels.instance }}"},
+			want: []string{"--host", "localhost:1234"},
+		},
+		{
+			name: "mixed",
+			args: []string{"{{ .Status }}:{{ len .Alerts }}"},
+			want: []string{"firing:2"},
+		},
+		{
+			name: "local_time",
+			args: []string{`{{ (local (index .Alerts 0).StartsAt).Format "2006-01-02T15:04" }}`},
+			want: []string{"2016-04-07T16:08"},
+		},
+		{
+			name: "missing_label",
+			args: []string{"{{ .CommonLabels.banana }}"},
+			err:  true,
+		},
+		{
+			name: "bad_template",
+			args: []string{"{{ .CommonLabels.instance"},
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := Command{Cmd: "echo", Args: tc.args}
+			got, err := cmd.ExpandArgs(&amData, time.UTC)
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected error expanding %v", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error expanding %v: %v", tc.args, err)
+			}
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("Wrong expanded args; got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommand_ExpandEnv(t *testing.T) {
+	cases := []struct {
+		name   string
+		env    map[string]string
+		signal string
+		want   []string
+		err    bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "sorted",
+			env:  map[string]string{"ZONE": "a", "HOST": "b"},
+			want: []string{"HOST=b", "ZONE=a"},
+		},
+		{
+			name: "label",
+			env:  map[string]string{"TARGET_HOST": "{{ .CommonLabels.instance }}", "STATUS": "{{ .Status }}"},
+			want: []string{"STATUS=firing", "TARGET_HOST=localhost:1234"},
+		},
+		{
+			name:   "plain_signal",
+			signal: "SIGUSR1",
+		},
+		{
+			name:   "templated_signal",
+			signal: `{{ if eq .Status "firing" }}SIGUSR1{{ else }}SIGTERM{{ end }}`,
+			want:   []string{"AMX_RESOLVED_SIGNAL=SIGUSR1"},
+		},
+		{
+			name:   "templated_bad_signal",
+			signal: "{{ .CommonLabels.instance }}",
+			err:    true,
+		},
+		{
+			name: "missing_label",
+			env:  map[string]string{"BANANA": "{{ .CommonLabels.banana }}"},
+			err:  true,
+		},
+		{
+			name: "bad_name",
+			env:  map[string]string{"A=B": "c"},
+			err:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := Command{Cmd: "echo", Env: tc.env, ResolvedSig: tc.signal}
+			got, err := cmd.ExpandEnv(&amData, time.UTC)
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected error expanding %v with resolved_signal %q", tc.env, tc.signal)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error expanding %v with resolved_signal %q: %v", tc.env, tc.signal, err)
+			}
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("Wrong expanded env; got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommand_ParseLabelRegexps(t *testing.T) {
+	t.Parallel()
+	res, err := Command{MatchLabelsRe: map[string]string{"instance": "db-.*"}}.ParseLabelRegexps()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !res["instance"].MatchString("db-1") || res["instance"].MatchString("web-db-1") {
+		t.Errorf("Wrong regular expression for label; got %s", res["instance"])
+	}
+
+	_, err = Command{MatchLabelsRe: map[string]string{"instance": "(db"}}.ParseLabelRegexps()
+	if err == nil {
+		t.Errorf("Missing error for invalid regular expression")
+	}
+}
+
+func TestCommand_CompileLabelRegexps(t *testing.T) {
+	t.Parallel()
+	cmd := &Command{Cmd: "echo", MatchLabelsRe: map[string]string{"instance": "localhost:.*"}}
+	if err := cmd.CompileLabelRegexps(); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(cmd.labelRegexps) != 1 {
+		t.Fatalf("Wrong compiled regular expressions; got %v", cmd.labelRegexps)
+	}
+	// Matching uses the compiled expressions, rather than compiling MatchLabelsRe again
+	cmd.MatchLabelsRe["instance"] = "(invalid"
+	if !cmd.Matches(&amData) {
+		t.Errorf("Command should match with its compiled regular expressions")
+	}
+	if resolved, _ := (Command{ResolvedCmd: "true", labelRegexps: cmd.labelRegexps}).ResolvedCommand(); resolved.labelRegexps == nil {
+		t.Errorf("Resolved command should share the compiled regular expressions")
+	}
+
+	if err := (&Command{MatchLabelsRe: map[string]string{"instance": "(db"}}).CompileLabelRegexps(); err == nil {
+		t.Errorf("Missing error for invalid regular expression")
+	}
+}
+
+func TestCommand_FollowUps(t *testing.T) {
+	cmd := Command{Cmd: "echo", OnFailureRun: []string{"heavy"}, OnSuccessRun: []string{"notify"}}
+	cases := []struct {
+		result Result
+		want   string
+	}{
+		{result: CmdOk, want: "notify"},
+		{result: CmdFail, want: "heavy"},
+		{result: CmdRetry | CmdOk, want: "notify"},
+		{result: CmdRetry | CmdFail, want: "heavy"},
+		// Commands that were signalled don't have follow-ups, however they exited
+		{result: CmdSigOk | CmdFail, want: ""},
+		{result: CmdSigOk | CmdKill, want: ""},
+		{result: CmdSkipSig, want: ""},
+		{result: 0, want: ""},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.result.String(), func(t *testing.T) {
+			t.Parallel()
+			got := strings.Join(cmd.FollowUps(tc.result), ",")
+			if got != tc.want {
+				t.Errorf("Wrong follow-ups for result %s; got %q, want %q", tc.result, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommand_ResolvedCommand(t *testing.T) {
+	t.Parallel()
+	if _, ok := (Command{Cmd: "echo"}).ResolvedCommand(); ok {
+		t.Errorf("Command without resolved_cmd shouldn't have a resolve-time command")
+	}
+
+	cmd := Command{
+		Cmd:          "scale-up",
+		ResolvedCmd:  "scale-down",
+		ResolvedArgs: []string{"--by", "1"},
+		MatchLabels:  map[string]string{"job": "broken"},
+		Retries:      2,
+		Concurrency:  1,
+	}
+	hook, ok := cmd.ResolvedCommand()
+	if !ok {
+		t.Fatal("Command with resolved_cmd should have a resolve-time command")
+	}
+	want := &Command{Cmd: "scale-down", Args: []string{"--by", "1"}, MatchLabels: map[string]string{"job": "broken"}, When: WhenResolved}
+	if !hook.Equal(want) {
+		t.Errorf("Wrong resolve-time command; got %s, want %s", hook, want)
+	}
+	if hook.Retries != cmd.Retries {
+		t.Errorf("Resolve-time command should be retried like its command; got %d, want %d", hook.Retries, cmd.Retries)
+	}
+	if hook.Concurrency != 0 {
+		t.Errorf("Resolve-time command shouldn't share its command's queue")
+	}
+	if !hook.RunsOn("resolved") || hook.RunsOn("firing") {
+		t.Errorf("Resolve-time command should only run for resolved alerts")
+	}
+}
+
+func TestCommand_RollbackCommand(t *testing.T) {
+	t.Parallel()
+	if _, ok := (Command{Cmd: "drain"}).RollbackCommand(); ok {
+		t.Errorf("Command without rollback_cmd shouldn't have a rollback command")
+	}
+
+	cmd := Command{
+		Name:         "drain",
+		Cmd:          "drain-node",
+		RollbackCmd:  "uncordon-node",
+		RollbackArgs: []string{"--force"},
+		MatchLabels:  map[string]string{"job": "broken"},
+		Retries:      2,
+		Concurrency:  1,
+		OnSuccessRun: []string{"restart"},
+	}
+	undo, ok := cmd.RollbackCommand()
+	if !ok {
+		t.Fatal("Command with rollback_cmd should have a rollback command")
+	}
+	want := &Command{Name: "drain", Cmd: "uncordon-node", Args: []string{"--force"}}
+	if !undo.Equal(want) {
+		t.Errorf("Wrong rollback command; got %s, want %s", undo, want)
+	}
+	if undo.Retries != cmd.Retries {
+		t.Errorf("Rollback command should be retried like its command; got %d, want %d", undo.Retries, cmd.Retries)
+	}
+	if undo.Concurrency != 0 || len(undo.OnSuccessRun) != 0 {
+		t.Errorf("Rollback command shouldn't share its command's queue or follow-ups")
+	}
+}
+
+func TestCommand_RunsOn(t *testing.T) {
+	cases := []struct {
+		when     string
+		firing   bool
+		resolved bool
+		err      bool
+	}{
+		{when: "", firing: true},
+		{when: WhenFiring, firing: true},
+		{when: WhenResolved, resolved: true},
+		{when: WhenBoth, firing: true, resolved: true},
+		{when: "Both", firing: true, resolved: true},
+		{when: "sometimes", err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.when, func(t *testing.T) {
+			t.Parallel()
+			cmd := Command{Cmd: "echo", When: tc.when}
+			if _, err := cmd.ParseWhen(); (err != nil) != tc.err {
+				t.Errorf("Wrong error parsing when %q; got %v, want error %v", tc.when, err, tc.err)
+			}
+			if got := cmd.RunsOn("firing"); got != tc.firing {
+				t.Errorf("Wrong result running on firing alert; got %v, want %v", got, tc.firing)
+			}
+			if got := cmd.RunsOn("resolved"); got != tc.resolved {
+				t.Errorf("Wrong result running on resolved alert; got %v, want %v", got, tc.resolved)
+			}
+		})
+	}
+}
+
 func TestCommand_Run(t *testing.T) {
 	t.Skip("TODO")
 }
 
+func TestCommand_RunKillAfter(t *testing.T) {
+	cases := []struct {
+		name      string
+		script    string
+		killAfter Duration
+		want      Result
+	}{
+		{
+			name:      "exits_on_signal",
+			script:    "sleep 4",
+			killAfter: Duration(2 * time.Second),
+			want:      CmdSigOk,
+		},
+		{
+			name:      "ignores_signal",
+			script:    "trap '' TERM; sleep 4",
+			killAfter: Duration(100 * time.Millisecond),
+			want:      CmdSigOk | CmdKill,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := Command{Cmd: "sh", Args: []string{"-c", tc.script}, ResolvedSig: "SIGTERM", KillAfter: tc.killAfter}
+			out := make(chan CommandResult)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan struct{})
+			go cmd.Run(ctx, out, done, nil)
+
+			// Give the shell time to set up its trap
+			time.Sleep(500 * time.Millisecond)
+			start := time.Now()
+			cancel()
+			var state Result
+			for r := range out {
+				state = state | r.Kind
+			}
+			<-done
+
+			if state != tc.want {
+				t.Errorf("Wrong result; got %s, want %s", state, tc.want)
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("Command took too long to stop; got %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestCommand_RunCancelled(t *testing.T) {
+	t.Parallel()
+	cmd := Command{Cmd: "sleep", Args: []string{"0.01"}, ResolvedSig: "SIGTERM"}
+	out := make(chan CommandResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	// The execution is stopped before it starts, such as when the executor is shutting down
+	cancel()
+	done := make(chan struct{})
+	go cmd.Run(ctx, out, done, nil)
+
+	var state Result
+	for r := range out {
+		state = state | r.Kind
+	}
+	<-done
+	if state != CmdSigOk {
+		t.Errorf("Wrong result; got %s, want %s", state, CmdSigOk)
+	}
+}
+
+func TestCommand_RunTimeout(t *testing.T) {
+	cases := []struct {
+		name     string
+		script   string
+		want     Result
+		timedOut bool
+	}{
+		// Scripts that check AMX_DEADLINE can finish before they're killed
+		{name: "honours_deadline", script: `[ "$AMX_DEADLINE" -gt 0 ] && exit 0; exit 1`, want: CmdOk},
+		{name: "exceeds_deadline", script: "sleep 4", want: CmdFail, timedOut: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := Command{Cmd: "sh", Args: []string{"-c", tc.script}, Timeout: Duration(500 * time.Millisecond)}
+			out := make(chan CommandResult)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan struct{})
+			start := time.Now()
+			go cmd.Run(ctx, out, done, nil)
+
+			var state Result
+			var timedOut bool
+			for r := range out {
+				state = state | r.Kind
+				timedOut = timedOut || r.TimedOut
+			}
+			<-done
+
+			if state != tc.want {
+				t.Errorf("Wrong result; got %s, want %s", state, tc.want)
+			}
+			if timedOut != tc.timedOut {
+				t.Errorf("Wrong timed out state; got %t, want %t", timedOut, tc.timedOut)
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("Command took too long to stop; got %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestCommand_RunSignalGroup(t *testing.T) {
+	// We can create pointers to variables, but not to primitive values like true/false directly.
+	var alsoTrue = true
+	var alsoFalse = false
+
+	cases := []struct {
+		name   string
+		group  *bool
+		leaked bool
+	}{
+		// Only the shell is signalled by default, so its child keeps running
+		{name: "default_value", group: nil, leaked: true},
+		{name: "process", group: &alsoFalse, leaked: true},
+		{name: "group", group: &alsoTrue, leaked: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "amx-signal-group")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			marker := filepath.Join(dir, "leaked")
+
+			cmd := Command{Cmd: "sh", Args: []string{"-c", "(sleep 1; touch \"$MARKER\") & wait"}, ResolvedSig: "SIGTERM", SignalGroup: tc.group}
+			out := make(chan CommandResult)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan struct{})
+			go cmd.Run(ctx, out, done, nil, "MARKER="+marker)
+
+			// Give the shell time to start its child
+			time.Sleep(200 * time.Millisecond)
+			cancel()
+			for r := range out {
+				if r.Kind != CmdSigOk {
+					t.Errorf("Wrong result; got %s, want %s", r.Kind, CmdSigOk)
+				}
+			}
+			<-done
+
+			// Wait for a leaked child to finish
+			time.Sleep(1500 * time.Millisecond)
+			_, err = os.Stat(marker)
+			if leaked := err == nil; leaked != tc.leaked {
+				t.Errorf("Wrong child process state; got leaked %v, want %v", leaked, tc.leaked)
+			}
+		})
+	}
+}
+
 func TestCommand_ShouldIgnoreResolved(t *testing.T) {
 	// We can create pointers to variables, but not to primitive values like true/false directly.
 	var alsoTrue = true
@@ -489,6 +1304,58 @@ func TestCommand_ShouldNotify(t *testing.T) {
 	}
 }
 
+func TestCommand_ShouldLog(t *testing.T) {
+	// We can create pointers to variables, but not to primitive values like true/false directly.
+	var alsoTrue = true
+	var alsoFalse = false
+
+	cases := []struct {
+		name    string
+		cmd     *Command
+		verbose bool
+		ok      bool
+	}{
+		// We should use the global setting if the Verbose field of a Command isn't set.
+		{
+			name:    "default_verbose",
+			cmd:     &Command{Cmd: "echo"},
+			verbose: true,
+			ok:      true,
+		},
+		{
+			name:    "default_quiet",
+			cmd:     &Command{Cmd: "echo"},
+			verbose: false,
+			ok:      false,
+		},
+		// We should log if Verbose is true, even if the global setting isn't.
+		{
+			name:    "verbose",
+			cmd:     &Command{Cmd: "echo", Verbose: &alsoTrue},
+			verbose: false,
+			ok:      true,
+		},
+		// We shouldn't log if Verbose is false, even if the global setting is.
+		{
+			name:    "quiet",
+			cmd:     &Command{Cmd: "echo", Verbose: &alsoFalse},
+			verbose: true,
+			ok:      false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ok := tc.cmd.ShouldLog(tc.verbose)
+			if ok != tc.ok {
+				t.Errorf("wrong ShouldLog boolean; got %v, want %v", ok, tc.ok)
+			}
+		})
+	}
+}
+
 func TestCommand_String(t *testing.T) {
 	t.Parallel()
 	cmdNoArgs := Command{Cmd: "echo"}
@@ -502,10 +1369,136 @@ func TestCommand_String(t *testing.T) {
 	}
 }
 
+func TestCommand_MetricLabel(t *testing.T) {
+	t.Parallel()
+	unnamed := Command{Cmd: "echo", Args: []string{"a"}}
+	if unnamed.MetricLabel() != "echo" {
+		t.Errorf("wrong metric label; got '%s', want '%s'", unnamed.MetricLabel(), "echo")
+	}
+
+	named := Command{Cmd: "echo", Args: []string{"a"}, Name: "greet"}
+	if named.MetricLabel() != "greet" {
+		t.Errorf("wrong metric label; got '%s', want '%s'", named.MetricLabel(), "greet")
+	}
+}
+
+func TestCommand_QueueCapacity(t *testing.T) {
+	cases := []struct {
+		name string
+		cmd  Command
+		want int
+	}{
+		{name: "unlimited", cmd: Command{Cmd: "echo", QueueSize: 5}, want: 0},
+		{name: "no_queue", cmd: Command{Cmd: "echo", Concurrency: 2}, want: 2},
+		{name: "negative_queue", cmd: Command{Cmd: "echo", Concurrency: 2, QueueSize: -1}, want: 2},
+		{name: "queue", cmd: Command{Cmd: "echo", Concurrency: 2, QueueSize: 3}, want: 5},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tc.cmd.QueueCapacity(); got != tc.want {
+				t.Errorf("Wrong queue capacity; got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommand_Version(t *testing.T) {
+	t.Parallel()
+	a := Command{Cmd: "echo", Args: []string{"a"}}
+	if a.Version() != (Command{Cmd: "echo", Args: []string{"a"}}).Version() {
+		t.Errorf("Identical commands should have the same version")
+	}
+	if a.Version() == (Command{Cmd: "echo", Args: []string{"b"}}).Version() {
+		t.Errorf("Commands with different arguments should have different versions")
+	}
+
+	dir, err := ioutil.TempDir("", "am-executor-version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	script := filepath.Join(dir, "remediate.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := Command{Cmd: script}
+	before := cmd.Version()
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Version() == before {
+		t.Errorf("Editing the script should change the command's version")
+	}
+}
+
+func TestCommand_WithInterpreter(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor-interpreter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	script := filepath.Join(dir, "remediate.sh")
+	if err := ioutil.WriteFile(script, []byte("exit 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	executable := filepath.Join(dir, "executable.sh")
+	if err := ioutil.WriteFile(executable, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	interpreters := map[string]string{".sh": "/bin/sh"}
+
+	cases := []struct {
+		name string
+		cmd  Command
+		want Command
+	}{
+		{
+			name: "not_executable",
+			cmd:  Command{Cmd: script, Args: []string{"a"}},
+			want: Command{Cmd: "/bin/sh", Args: []string{script, "a"}},
+		},
+		{
+			name: "executable",
+			cmd:  Command{Cmd: executable},
+			want: Command{Cmd: executable},
+		},
+		{
+			name: "unknown_extension",
+			cmd:  Command{Cmd: "echo"},
+			want: Command{Cmd: "echo"},
+		},
+		{
+			name: "missing",
+			cmd:  Command{Cmd: filepath.Join(dir, "missing.sh")},
+			want: Command{Cmd: filepath.Join(dir, "missing.sh")},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		// Subtests don't run in parallel, so the scripts are still around when they run
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.cmd.WithInterpreter(interpreters)
+			if !got.Equal(&tc.want) {
+				t.Errorf("Wrong command with interpreter; got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestCommand_WithEnv(t *testing.T) {
 	t.Parallel()
 	env := []string{"BANANAS=3", "PRIORITY=TOP"}
-	cmd := Command{Cmd: "echo"}.WithEnv(env...)
+	cmd := Command{Cmd: "echo"}.WithEnv(context.Background(), env...)
 
 	for _, v := range os.Environ() {
 		if !containsString(v, cmd.Env) {
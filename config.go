@@ -5,20 +5,75 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 )
 
 const (
 	defaultListenAddr = ":8080"
+	// How long command queues can be full before the executor reports that it isn't ready
+	defaultSaturationThreshold = Duration(time.Minute)
+)
+
+var (
+	// Paths served by the executor itself, which commands can't use as their route.
+	// Paths ending in a slash cover everything under them.
+	reservedPaths = []string{"/_health", "/_ready", "/_maintenance", "/_state", "/api/commands", commandsAPIPath, "/api/report", "/api/approvals", approvalsAPIPath, "/api/resolve", linksAPIPath, "/processes", processesAPIPath, "/history", "/metrics"}
 )
 
 // Config represents the configuration for this program
 type Config struct {
-	ListenAddr string     `yaml:"listen_address"`
-	Verbose    bool       `yaml:"verbose"`
-	TLSKey     string     `yaml:"tls_key"`
-	TLSCrt     string     `yaml:"tls_crt"`
-	Commands   []*Command `yaml:"commands"`
+	ListenAddr          string            `yaml:"listen_address"`
+	ListenNetwork       string            `yaml:"listen_network"`
+	ListenSocketMode    string            `yaml:"listen_socket_mode"`
+	Verbose             bool              `yaml:"verbose"`
+	LogDecisions        bool              `yaml:"log_decisions"`
+	DetailedResponse    bool              `yaml:"detailed_response"`
+	LogFormat           string            `yaml:"log_format"`
+	LogLevel            string            `yaml:"log_level"`
+	SummaryTemplate     string            `yaml:"summary_template"`
+	TLSKey              string            `yaml:"tls_key"`
+	TLSCrt              string            `yaml:"tls_crt"`
+	SpoolDir            string            `yaml:"spool_dir"`
+	StateFile           string            `yaml:"state_file"`
+	DecisionHook        string            `yaml:"decision_hook"`
+	DecisionHookTimeout Duration          `yaml:"decision_hook_timeout"`
+	HistorySize         int               `yaml:"history_size"`
+	HistoryDB           string            `yaml:"history_db"`
+	HistoryRetention    Duration          `yaml:"history_retention"`
+	Storage             StorageConfig     `yaml:"storage"`
+	SaturationThreshold Duration          `yaml:"saturation_threshold"`
+	Interpreters        map[string]string `yaml:"interpreters"`
+	MaxEnvSize          ByteSize          `yaml:"max_env_size"`
+	EnvOverflow         string            `yaml:"env_overflow"`
+	Timezone            string            `yaml:"timezone"`
+	Auth                AuthConfig        `yaml:"auth"`
+	AllowedCIDRs        []string          `yaml:"allowed_cidrs"`
+	TrustedProxies      []string          `yaml:"trusted_proxies"`
+	ReconcileInterval   Duration          `yaml:"reconcile_interval"`
+	ResolveTombstone    Duration          `yaml:"resolve_tombstone"`
+	WebhookRateLimit    float64           `yaml:"webhook_rate_limit"`
+	WebhookBurst        int               `yaml:"webhook_burst"`
+	RedeliveryWindow    Duration          `yaml:"redelivery_window"`
+	RedeliveryHeader    string            `yaml:"redelivery_header"`
+	SyncTimeout         Duration          `yaml:"sync_timeout"`
+	HTTPServer          HTTPServerConfig  `yaml:"http_server"`
+	Faults              Faults            `yaml:"faults"`
+	Registry            RegistryConfig    `yaml:"registry"`
+	Kubernetes          KubernetesConfig  `yaml:"kubernetes"`
+	Tracing             TracingConfig     `yaml:"tracing"`
+	Pushgateway         PushgatewayConfig `yaml:"pushgateway"`
+	RemoteWrite         RemoteWriteConfig `yaml:"remote_write"`
+	Archive             ArchiveConfig     `yaml:"archive"`
+	Janitor             JanitorConfig     `yaml:"janitor"`
+	SignedLinks         SignedLinksConfig `yaml:"signed_links"`
+	FailureDomains      []DomainLimit     `yaml:"failure_domains"`
+	Commands            []*Command        `yaml:"commands"`
+	Schedules           []*Schedule       `yaml:"schedules"`
+	// Whether to check the config and exit, instead of running the server; only set from the cli
+	CheckConfig bool `yaml:"-"`
 }
 
 // HasCommand returns true if the config contains the given Command
@@ -31,6 +86,160 @@ func (c *Config) HasCommand(other *Command) bool {
 	return false
 }
 
+// CommandNamed returns the command with the given name
+func (c *Config) CommandNamed(name string) (*Command, bool) {
+	for _, cmd := range c.Commands {
+		if cmd.Name != "" && cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return nil, false
+}
+
+// AllCommands returns the commands run for alerts, followed by the commands run on a schedule
+func (c *Config) AllCommands() []*Command {
+	all := make([]*Command, 0, len(c.Commands)+len(c.Schedules))
+	all = append(all, c.Commands...)
+	for _, sched := range c.Schedules {
+		all = append(all, &sched.Command)
+	}
+	return all
+}
+
+// HasName returns true if a command run for alerts or on a schedule has the given name
+func (c *Config) HasName(name string) bool {
+	for _, cmd := range c.AllCommands() {
+		if cmd.Name != "" && cmd.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFollowUp returns true if another command names the given command in on_failure_run or on_success_run,
+// meaning that it's only run as a follow-up.
+func (c *Config) IsFollowUp(cmd *Command) bool {
+	if cmd.Name == "" {
+		return false
+	}
+	for _, other := range c.Commands {
+		for _, name := range other.followUpNames() {
+			if name == cmd.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Routes returns the paths that commands declare as their route, in the order they're first configured
+func (c *Config) Routes() []string {
+	var routes []string
+	seen := make(map[string]bool)
+	for _, cmd := range c.Commands {
+		if cmd.Route != "" && !seen[cmd.Route] {
+			seen[cmd.Route] = true
+			routes = append(routes, cmd.Route)
+		}
+	}
+	return routes
+}
+
+// RouteOf returns the route of webhook requests to the given path,
+// which is empty if no command declares the path as its route.
+func (c *Config) RouteOf(path string) string {
+	for _, cmd := range c.Commands {
+		if cmd.Route != "" && cmd.Route == path {
+			return path
+		}
+	}
+	return ""
+}
+
+// checkRoute returns an error if a command's route can't be served,
+// such as because it isn't an absolute path, or the executor serves something else there.
+func checkRoute(route string) error {
+	if !strings.HasPrefix(route, "/") || route == "/" {
+		return fmt.Errorf("%q must be an absolute path other than /", route)
+	}
+	if path.Clean(route) != route {
+		return fmt.Errorf("%q isn't a clean path", route)
+	}
+	for _, reserved := range reservedPaths {
+		if route == reserved || strings.HasSuffix(reserved, "/") && strings.HasPrefix(route, reserved) {
+			return fmt.Errorf("%q is already served by the executor", route)
+		}
+	}
+	return nil
+}
+
+// checkFollowUps returns an error if commands have duplicate names,
+// or name follow-up commands that don't exist or that would lead back to themselves.
+func checkFollowUps(commands []*Command) error {
+	named := make(map[string]*Command)
+	for i, cmd := range commands {
+		if cmd.Name == "" {
+			continue
+		}
+		if _, ok := named[cmd.Name]; ok {
+			return fmt.Errorf("Invalid name specified for command %q at index %d: %q is already used", cmd, i, cmd.Name)
+		}
+		named[cmd.Name] = cmd
+	}
+
+	// Follow-ups are checked depth-first, so that a command found again while its own follow-ups are being visited is a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(cmd *Command) error
+	visit = func(cmd *Command) error {
+		state[cmd.Name] = visiting
+		for _, name := range cmd.followUpNames() {
+			next, ok := named[name]
+			if !ok {
+				return fmt.Errorf("Invalid follow-up specified for command %q: no command is named %q", cmd, name)
+			}
+			switch state[name] {
+			case visiting:
+				return fmt.Errorf("Invalid follow-up specified for command %q: running %q would loop back to it", cmd, name)
+			case visited:
+				continue
+			}
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[cmd.Name] = visited
+		return nil
+	}
+
+	for _, cmd := range commands {
+		if state[cmd.Name] == visited && cmd.Name != "" {
+			continue
+		}
+		if err := visit(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Location returns the location for the configured timezone, defaulting to UTC.
+// Timezones are validated when the config is read, so unknown timezones also give UTC.
+func (c *Config) Location() *time.Location {
+	if c.Timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
 // mergeConfigs returns a config representing all the Configs merged together,
 // with later Config structs overriding settings in earlier ones (like ListenAddr).
 // Commands are added if they are unique from others.
@@ -45,19 +254,143 @@ func mergeConfigs(all ...*Config) *Config {
 		if len(c.ListenAddr) > 0 {
 			merged.ListenAddr = c.ListenAddr
 		}
+		if len(c.ListenNetwork) > 0 {
+			merged.ListenNetwork = c.ListenNetwork
+		}
+		if len(c.ListenSocketMode) > 0 {
+			merged.ListenSocketMode = c.ListenSocketMode
+		}
 		merged.Verbose = merged.Verbose || c.Verbose
+		merged.LogDecisions = merged.LogDecisions || c.LogDecisions
+		merged.DetailedResponse = merged.DetailedResponse || c.DetailedResponse
+		merged.CheckConfig = merged.CheckConfig || c.CheckConfig
+		if c.LogFormat != "" {
+			merged.LogFormat = c.LogFormat
+		}
+		if c.LogLevel != "" {
+			merged.LogLevel = c.LogLevel
+		}
 		if c.TLSKey != "" {
 			merged.TLSKey = c.TLSKey
 		}
 		if c.TLSCrt != "" {
 			merged.TLSCrt = c.TLSCrt
 		}
+		if c.SpoolDir != "" {
+			merged.SpoolDir = c.SpoolDir
+		}
+		if c.StateFile != "" {
+			merged.StateFile = c.StateFile
+		}
+		if c.DecisionHook != "" {
+			merged.DecisionHook = c.DecisionHook
+		}
+		if c.DecisionHookTimeout != 0 {
+			merged.DecisionHookTimeout = c.DecisionHookTimeout
+		}
+		if c.HistorySize != 0 {
+			merged.HistorySize = c.HistorySize
+		}
+		if c.HistoryDB != "" {
+			merged.HistoryDB = c.HistoryDB
+		}
+		if c.HistoryRetention != 0 {
+			merged.HistoryRetention = c.HistoryRetention
+		}
+		if c.SummaryTemplate != "" {
+			merged.SummaryTemplate = c.SummaryTemplate
+		}
+		if c.SaturationThreshold != 0 {
+			merged.SaturationThreshold = c.SaturationThreshold
+		}
+		if c.Timezone != "" {
+			merged.Timezone = c.Timezone
+		}
+		if c.MaxEnvSize != 0 {
+			merged.MaxEnvSize = c.MaxEnvSize
+		}
+		if c.EnvOverflow != "" {
+			merged.EnvOverflow = c.EnvOverflow
+		}
+		if c.Auth.Type != "" {
+			merged.Auth = c.Auth
+		}
+		if len(c.AllowedCIDRs) > 0 {
+			merged.AllowedCIDRs = c.AllowedCIDRs
+		}
+		if len(c.TrustedProxies) > 0 {
+			merged.TrustedProxies = c.TrustedProxies
+		}
+		if c.ReconcileInterval != 0 {
+			merged.ReconcileInterval = c.ReconcileInterval
+		}
+		if c.ResolveTombstone != 0 {
+			merged.ResolveTombstone = c.ResolveTombstone
+		}
+		if c.WebhookRateLimit != 0 {
+			merged.WebhookRateLimit = c.WebhookRateLimit
+		}
+		if c.WebhookBurst != 0 {
+			merged.WebhookBurst = c.WebhookBurst
+		}
+		if c.RedeliveryWindow != 0 {
+			merged.RedeliveryWindow = c.RedeliveryWindow
+		}
+		if c.RedeliveryHeader != "" {
+			merged.RedeliveryHeader = c.RedeliveryHeader
+		}
+		if c.SyncTimeout != 0 {
+			merged.SyncTimeout = c.SyncTimeout
+		}
+		if c.HTTPServer != (HTTPServerConfig{}) {
+			merged.HTTPServer = c.HTTPServer
+		}
+		if c.Faults.Enabled() {
+			merged.Faults = c.Faults
+		}
+		if c.Registry.URL != "" {
+			merged.Registry = c.Registry
+		}
+		if c.Kubernetes.DownwardAPIDir != "" {
+			merged.Kubernetes = c.Kubernetes
+		}
+		if c.Tracing.Endpoint != "" {
+			merged.Tracing = c.Tracing
+		}
+		if c.Pushgateway.URL != "" {
+			merged.Pushgateway = c.Pushgateway
+		}
+		if c.RemoteWrite.URL != "" {
+			merged.RemoteWrite = c.RemoteWrite
+		}
+		if c.Archive.Endpoint != "" {
+			merged.Archive = c.Archive
+		}
+		if c.Janitor.Enabled() {
+			merged.Janitor = c.Janitor
+		}
+		if c.Storage.Backend != "" {
+			merged.Storage = c.Storage
+		}
+		if c.SignedLinks.Secret != "" {
+			merged.SignedLinks = c.SignedLinks
+		}
+		if len(c.FailureDomains) > 0 {
+			merged.FailureDomains = c.FailureDomains
+		}
+		for ext, interpreter := range c.Interpreters {
+			if merged.Interpreters == nil {
+				merged.Interpreters = make(map[string]string)
+			}
+			merged.Interpreters[ext] = interpreter
+		}
 
 		for _, cmd := range c.Commands {
 			if !merged.HasCommand(cmd) {
 				merged.Commands = append(merged.Commands, cmd)
 			}
 		}
+		merged.Schedules = append(merged.Schedules, c.Schedules...)
 	}
 
 	return merged
@@ -72,8 +405,11 @@ func readCli() (*Config, error) {
 	var err error
 	var configFile string
 	flag.StringVar(&cli.ListenAddr, "l", "", fmt.Sprintf("HTTP Port to listen on (default \"%s\")", defaultListenAddr))
-	flag.BoolVar(&cli.Verbose, "v", false, "Enable verbose/debug logging")
+	flag.BoolVar(&cli.Verbose, "v", false, fmt.Sprintf("Enable verbose/debug logging, same as -log.level=%s", LevelDebug))
+	flag.StringVar(&cli.LogFormat, "log.format", "", fmt.Sprintf("Log format, %s or %s (default \"%s\")", LogFormatText, LogFormatJSON, LogFormatText))
+	flag.StringVar(&cli.LogLevel, "log.level", "", fmt.Sprintf("Least severe level to log, one of %s, %s, %s, %s or %s (default \"%s\")", LevelDebug, LevelDecision, LevelInfo, LevelWarn, LevelError, LevelInfo))
 	flag.StringVar(&configFile, "f", "", "YAML config file to use")
+	flag.BoolVar(&cli.CheckConfig, "check-config", false, "Check the configuration, including that the commands' programs exist, and exit")
 	flag.Parse()
 	args := flag.Args()
 
@@ -94,19 +430,8 @@ func readCli() (*Config, error) {
 		if err != nil {
 			return nil, err
 		}
-	}
-
-	if file != nil {
-		// Check that the commands specify resolved_signal values that we can parse
-		for i, cmd := range file.Commands {
-			_, err := cmd.ParseSignal()
-			if err != nil {
-				return nil, fmt.Errorf("Invalid resolved_signal specified for command %q at index %d: %w", cmd, i, err)
-			}
-
-			if cmd.IgnoreResolved != nil && *cmd.IgnoreResolved {
-				log.Printf("Warning: command %q at index %d specifies a resolved_signal, and also specifies to ignore resolved alert. The signal won't be used.", cmd, i)
-			}
+		if err := validateConfig(file); err != nil {
+			return nil, err
 		}
 	}
 
@@ -122,17 +447,347 @@ func readConfig() (*Config, error) {
 		return nil, err
 	}
 
-	if len(c.Commands) == 0 {
+	if len(c.Commands) == 0 && len(c.Schedules) == 0 {
 		return nil, fmt.Errorf("missing command to execute on receipt of alarm")
 	}
 
 	if len(c.ListenAddr) == 0 {
 		c.ListenAddr = defaultListenAddr
 	}
+	// Catch addresses that can't be listened on now, rather than once the server starts
+	if _, _, err := c.ListenAddress(); err != nil {
+		return nil, err
+	}
+	if _, err := c.SocketMode(); err != nil {
+		return nil, err
+	}
+
+	c.LogFormat, err = ParseLogFormat(c.LogFormat)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log format specified: %w", err)
+	}
+
+	// verbose is kept for compatibility, and means the same as logging at debug level
+	if c.LogLevel == "" && c.Verbose {
+		c.LogLevel = LevelDebug.String()
+	}
+	if c.LogLevel == "" && c.LogDecisions {
+		c.LogLevel = LevelDecision.String()
+	}
+	level, err := ParseLogLevel(c.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log level specified: %w", err)
+	}
+	c.LogLevel = level.String()
+	c.Verbose = level == LevelDebug
+
+	if c.SaturationThreshold == 0 {
+		c.SaturationThreshold = defaultSaturationThreshold
+	}
+
+	if c.ReconcileInterval == 0 {
+		c.ReconcileInterval = defaultReconcileInterval
+	}
+
+	if c.Registry.Interval == 0 {
+		c.Registry.Interval = defaultHeartbeatInterval
+	}
+
+	c.HTTPServer.setDefaults()
 
 	return c, err
 }
 
+// validateConfig returns an error describing the first setting in a config file that can't be used
+func validateConfig(file *Config) error {
+	if file.SaturationThreshold < 0 {
+		return fmt.Errorf("Invalid saturation_threshold specified: %s is negative", file.SaturationThreshold)
+	}
+
+	if file.ReconcileInterval < 0 {
+		return fmt.Errorf("Invalid reconcile_interval specified: %s is negative", file.ReconcileInterval)
+	}
+
+	if file.WebhookRateLimit < 0 {
+		return fmt.Errorf("Invalid webhook_rate_limit specified: %g is negative", file.WebhookRateLimit)
+	}
+	if file.WebhookBurst < 0 {
+		return fmt.Errorf("Invalid webhook_burst specified: %d is negative", file.WebhookBurst)
+	}
+	if file.WebhookBurst > 0 && file.WebhookRateLimit == 0 {
+		return fmt.Errorf("Invalid webhook_burst specified: webhook_rate_limit isn't set")
+	}
+	if file.RedeliveryWindow < 0 {
+		return fmt.Errorf("Invalid redelivery_window specified: %s is negative", file.RedeliveryWindow)
+	}
+	if file.RedeliveryHeader != "" && file.RedeliveryWindow == 0 {
+		return fmt.Errorf("Invalid redelivery_header specified: redelivery_window isn't set")
+	}
+	if file.SyncTimeout < 0 {
+		return fmt.Errorf("Invalid sync_timeout specified: %s is negative", file.SyncTimeout)
+	}
+	if err := file.HTTPServer.Validate(file.SyncTimeout); err != nil {
+		return fmt.Errorf("Invalid http_server specified: %w", err)
+	}
+
+	if file.MaxEnvSize < 0 {
+		return fmt.Errorf("Invalid max_env_size specified: %s is negative", file.MaxEnvSize)
+	}
+	if _, err := ParseEnvOverflow(file.EnvOverflow); err != nil {
+		return fmt.Errorf("Invalid env_overflow specified: %w", err)
+	}
+
+	if file.ResolveTombstone < 0 {
+		return fmt.Errorf("Invalid resolve_tombstone specified: %s is negative", file.ResolveTombstone)
+	}
+
+	if file.HistoryRetention < 0 {
+		return fmt.Errorf("Invalid history_retention specified: %s is negative", file.HistoryRetention)
+	}
+	if err := file.Storage.Validate(); err != nil {
+		return fmt.Errorf("Invalid storage specified: %w", err)
+	}
+
+	if file.Registry.Interval < 0 {
+		return fmt.Errorf("Invalid registry interval specified: %s is negative", file.Registry.Interval)
+	}
+	if file.Registry.URL != "" {
+		if u, err := url.Parse(file.Registry.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Invalid registry url specified: expected an absolute URL, got %q", file.Registry.URL)
+		}
+	}
+	if file.Tracing.Endpoint != "" {
+		if u, err := url.Parse(file.Tracing.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Invalid tracing endpoint specified: expected an absolute URL, got %q", file.Tracing.Endpoint)
+		}
+	}
+
+	if file.Pushgateway.Interval < 0 {
+		return fmt.Errorf("Invalid pushgateway interval specified: %s is negative", file.Pushgateway.Interval)
+	}
+	if file.Pushgateway.URL != "" {
+		if u, err := url.Parse(file.Pushgateway.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Invalid pushgateway url specified: expected an absolute URL, got %q", file.Pushgateway.URL)
+		}
+	}
+	if file.RemoteWrite.URL != "" {
+		if u, err := url.Parse(file.RemoteWrite.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Invalid remote_write url specified: expected an absolute URL, got %q", file.RemoteWrite.URL)
+		}
+	}
+	if _, err := NewArchiver(file.Archive); err != nil {
+		return fmt.Errorf("Invalid archive specified: %w", err)
+	}
+	if err := file.Janitor.Validate(); err != nil {
+		return fmt.Errorf("Invalid janitor specified: %w", err)
+	}
+	if _, err := NewLinkSigner(file.SignedLinks); err != nil {
+		return fmt.Errorf("Invalid signed_links specified: %w", err)
+	}
+	if err := validateDomainLimits(file.FailureDomains); err != nil {
+		return fmt.Errorf("Invalid failure_domains specified: %w", err)
+	}
+
+	if _, err := NewAuthenticator(file.Auth); err != nil {
+		return fmt.Errorf("Invalid auth specified: %w", err)
+	}
+	if strings.ToLower(file.Auth.Type) == AuthMTLS && (file.TLSCrt == "" || file.TLSKey == "") {
+		return fmt.Errorf("Invalid auth specified: %s auth requires tls_crt and tls_key", AuthMTLS)
+	}
+
+	if _, err := NewIPAllowlist(file.AllowedCIDRs, file.TrustedProxies); err != nil {
+		return err
+	}
+
+	if _, err := file.ParseSummaryTemplate(); err != nil {
+		return fmt.Errorf("Invalid summary_template specified: %w", err)
+	}
+
+	if file.Timezone != "" {
+		if _, err := time.LoadLocation(file.Timezone); err != nil {
+			return fmt.Errorf("Invalid timezone specified: %w", err)
+		}
+	}
+
+	for ext, interpreter := range file.Interpreters {
+		if !strings.HasPrefix(ext, ".") || interpreter == "" {
+			return fmt.Errorf("Invalid interpreters specified: expected a file extension like \".py\" and the interpreter to run it with, got %q: %q", ext, interpreter)
+		}
+	}
+
+	if file.DecisionHook != "" {
+		if _, err := LoadDecisionHook(file.DecisionHook, time.Duration(file.DecisionHookTimeout)); err != nil {
+			return fmt.Errorf("Invalid decision_hook specified: %w", err)
+		}
+	}
+
+	// Check that the commands specify resolved_signal values that we can parse
+	for i, cmd := range file.Commands {
+		// Signals that are templates can only be checked once they're expanded for an alert
+		sigTemplate, err := cmd.ParseSignalTemplate(time.UTC)
+		if err != nil {
+			return fmt.Errorf("Invalid resolved_signal specified for command %q at index %d: %w", cmd, i, err)
+		}
+		if sigTemplate == nil {
+			sig, err := cmd.ParseSignal()
+			if err != nil {
+				return fmt.Errorf("Invalid resolved_signal specified for command %q at index %d: %w", cmd, i, err)
+			}
+			if _, ok := sig.(emulatedSignal); ok {
+				logger.Warn("Command's resolved_signal isn't available on this platform, so the command will be killed instead", Fields{"command": cmd, "index": i, "signal": cmd.ResolvedSig})
+			}
+		}
+
+		_, err = cmd.ParseWhen()
+		if err != nil {
+			return fmt.Errorf("Invalid when specified for command %q at index %d: %w", cmd, i, err)
+		}
+
+		if cmd.ResolvedCmd == "" && len(cmd.ResolvedArgs) > 0 {
+			return fmt.Errorf("Invalid resolved_args specified for command %q at index %d: resolved_cmd isn't set", cmd, i)
+		}
+
+		if cmd.RollbackCmd == "" && len(cmd.RollbackArgs) > 0 {
+			return fmt.Errorf("Invalid rollback_args specified for command %q at index %d: rollback_cmd isn't set", cmd, i)
+		}
+
+		_, err = cmd.ParseArgTemplates(time.UTC)
+		if err != nil {
+			return fmt.Errorf("Invalid args specified for command %q at index %d: %w", cmd, i, err)
+		}
+
+		_, err = cmd.ParseEnvTemplates(time.UTC)
+		if err != nil {
+			return fmt.Errorf("Invalid env specified for command %q at index %d: %w", cmd, i, err)
+		}
+
+		_, err = cmd.ParseTransform(time.UTC)
+		if err != nil {
+			return fmt.Errorf("Invalid transform specified for command %q at index %d: %w", cmd, i, err)
+		}
+
+		err = cmd.CompileLabelRegexps()
+		if err != nil {
+			return fmt.Errorf("Invalid match_labels_re specified for command %q at index %d: %w", cmd, i, err)
+		}
+
+		err = cmd.CompileMatchExpr()
+		if err != nil {
+			return fmt.Errorf("Invalid match_expr specified for command %q at index %d: %w", cmd, i, err)
+		}
+
+		for _, m := range cmd.MatchLabelsSD {
+			if err := m.Validate(); err != nil {
+				return fmt.Errorf("Invalid match_labels_sd specified for command %q at index %d: %w", cmd, i, err)
+			}
+		}
+
+		if cmd.Route != "" {
+			if err := checkRoute(cmd.Route); err != nil {
+				return fmt.Errorf("Invalid route specified for command %q at index %d: %w", cmd, i, err)
+			}
+		}
+
+		if cmd.KillAfter < 0 {
+			return fmt.Errorf("Invalid kill_after specified for command %q at index %d: %s is negative", cmd, i, cmd.KillAfter)
+		}
+
+		if cmd.Timeout < 0 {
+			return fmt.Errorf("Invalid timeout specified for command %q at index %d: %s is negative", cmd, i, cmd.Timeout)
+		}
+
+		if cmd.Debounce < 0 {
+			return fmt.Errorf("Invalid debounce specified for command %q at index %d: %s is negative", cmd, i, cmd.Debounce)
+		}
+
+		if cmd.CanaryPercent < 0 || cmd.CanaryPercent > 100 {
+			return fmt.Errorf("Invalid canary_percent specified for command %q at index %d: %g isn't between 0 and 100", cmd, i, cmd.CanaryPercent)
+		}
+		if cmd.WaitFor < 0 {
+			return fmt.Errorf("Invalid wait_for specified for command %q at index %d: %s is negative", cmd, i, cmd.WaitFor)
+		}
+		if cmd.Cooldown < 0 {
+			return fmt.Errorf("Invalid cooldown specified for command %q at index %d: %s is negative", cmd, i, cmd.Cooldown)
+		}
+		if cmd.SuppressAfterSuccess < 0 {
+			return fmt.Errorf("Invalid suppress_after_success specified for command %q at index %d: %s is negative", cmd, i, cmd.SuppressAfterSuccess)
+		}
+
+		if cmd.MaxPerMinute < 0 {
+			return fmt.Errorf("Invalid max_per_minute specified for command %q at index %d: %d is negative", cmd, i, cmd.MaxPerMinute)
+		}
+
+		if cmd.MaxPerHour < 0 {
+			return fmt.Errorf("Invalid max_per_hour specified for command %q at index %d: %d is negative", cmd, i, cmd.MaxPerHour)
+		}
+
+		if cmd.MaxQueueAge < 0 {
+			return fmt.Errorf("Invalid max_queue_age specified for command %q at index %d: %s is negative", cmd, i, cmd.MaxQueueAge)
+		}
+
+		if cmd.RetryBackoff < 0 {
+			return fmt.Errorf("Invalid retry_backoff specified for command %q at index %d: %s is negative", cmd, i, cmd.RetryBackoff)
+		}
+		if cmd.Jitter < 0 {
+			return fmt.Errorf("Invalid jitter specified for command %q at index %d: %s is negative", cmd, i, cmd.Jitter)
+		}
+
+		for _, m := range cmd.Metrics {
+			if err := m.Validate(); err != nil {
+				return fmt.Errorf("Invalid metrics specified for command %q at index %d: %w", cmd, i, err)
+			}
+		}
+
+		if cmd.Annotate != nil {
+			if err := cmd.Annotate.Validate(); err != nil {
+				return fmt.Errorf("Invalid annotate url specified for command %q at index %d: %w", cmd, i, err)
+			}
+		}
+
+		if cmd.SLO != nil {
+			if err := cmd.SLO.Validate(); err != nil {
+				return fmt.Errorf("Invalid slo specified for command %q at index %d: %w", cmd, i, err)
+			}
+		}
+
+		if cmd.ApprovalTimeout < 0 {
+			return fmt.Errorf("Invalid approval_timeout specified for command %q at index %d: %s is negative", cmd, i, cmd.ApprovalTimeout)
+		}
+
+		if cmd.ApprovalWebhook != "" {
+			if _, err := url.ParseRequestURI(cmd.ApprovalWebhook); err != nil {
+				return fmt.Errorf("Invalid approval_webhook specified for command %q at index %d: %w", cmd, i, err)
+			}
+		}
+
+		if cmd.IsDestructive() && cmd.Guard == nil {
+			return fmt.Errorf("Invalid destructive specified for command %q at index %d: destructive commands need a guard", cmd, i)
+		}
+
+		if cmd.Guard != nil {
+			if !cmd.IsDestructive() {
+				return fmt.Errorf("Invalid guard specified for command %q at index %d: guards are only used by destructive commands", cmd, i)
+			}
+			if err := cmd.Guard.Validate(); err != nil {
+				return fmt.Errorf("Invalid guard specified for command %q at index %d: %w", cmd, i, err)
+			}
+			if cmd.Guard.ApprovalWindow > 0 && cmd.Name == "" {
+				return fmt.Errorf("Invalid guard specified for command %q at index %d: commands with an approval_window need a name to be approved by", cmd, i)
+			}
+		}
+
+		if cmd.IgnoreResolved != nil && *cmd.IgnoreResolved {
+			logger.Warn("Command specifies a resolved_signal, and also specifies to ignore resolved alert. The signal won't be used.", Fields{"command": cmd, "index": i})
+		}
+	}
+
+	if err := checkFollowUps(file.Commands); err != nil {
+		return err
+	}
+
+	return checkSchedules(file)
+}
+
 // readConfigFile reads configuration from a yaml file
 func readConfigFile(name string) (*Config, error) {
 	var c = &Config{}
@@ -4,23 +4,27 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_mergeConfigs(t *testing.T) {
 	t.Parallel()
 
 	a := &Config{
-		ListenAddr: "localhost:8080",
-		Verbose:    false,
+		ListenAddr:   "localhost:8080",
+		Verbose:      false,
+		Interpreters: map[string]string{".py": "/usr/bin/python2", ".sh": "/bin/sh"},
 		Commands: []*Command{
 			{Cmd: "echo"},
 		},
 	}
 
 	b := &Config{
-		ListenAddr: "localhost:8081",
-		Verbose:    true,
+		ListenAddr:   "localhost:8081",
+		Verbose:      true,
+		Interpreters: map[string]string{".py": "/usr/bin/python3"},
 		Commands: []*Command{
 			{Cmd: "/bin/echo"},
 		},
@@ -33,6 +37,9 @@ func Test_mergeConfigs(t *testing.T) {
 	if merged.Verbose != b.Verbose {
 		t.Errorf("Wrong Verbose for merged config; got %v, want %v", merged.Verbose, b.Verbose)
 	}
+	if merged.Interpreters[".py"] != "/usr/bin/python3" || merged.Interpreters[".sh"] != "/bin/sh" {
+		t.Errorf("Wrong Interpreters for merged config; got %v", merged.Interpreters)
+	}
 
 	allCmds := make([]*Command, 0)
 	allCmds = append(allCmds, a.Commands...)
@@ -79,6 +86,23 @@ commands:
 	}
 }
 
+func Test_mergeConfigs_routes(t *testing.T) {
+	t.Parallel()
+
+	// Commands that only differ by their route are both kept
+	a := &Config{Commands: []*Command{{Cmd: "echo", Route: "/hooks/a"}}}
+	b := &Config{Commands: []*Command{{Cmd: "echo", Route: "/hooks/b"}}}
+	merged := mergeConfigs(a, b)
+	if len(merged.Commands) != 2 {
+		t.Fatalf("Wrong number of merged commands; got %d, want 2", len(merged.Commands))
+	}
+	for _, cmd := range append(a.Commands, b.Commands...) {
+		if !merged.HasCommand(cmd) {
+			t.Errorf("Missing command %#v", cmd)
+		}
+	}
+}
+
 func Test_readConfigFile(t *testing.T) {
 	tempfile, err := ioutil.TempFile("", "am-executor_readConfigFile-*.yml")
 	if err != nil {
@@ -99,10 +123,13 @@ commands:
       "owner": "me"
     notify_on_failure: false
     resolved_signal: sigusr2
+    retries: 3
+    retry_backoff: 2s
   - cmd: /bin/true
     match_labels:
       "beep": "boop"
     ignore_resolved: true
+    verbose: false
 `
 
 	_, err = tempfile.Write([]byte(yamlFile))
@@ -138,6 +165,7 @@ commands:
 		cmd                  *Command
 		shouldNotify         bool
 		shouldIgnoreResolved bool
+		shouldLog            bool
 	}{
 		{
 			cmd: &Command{
@@ -149,9 +177,12 @@ commands:
 				},
 				NotifyOnFailure: &alsoFalse,
 				ResolvedSig:     "sigusr2",
+				Retries:         3,
+				RetryBackoff:    Duration(2 * time.Second),
 			},
 			shouldNotify:         false,
 			shouldIgnoreResolved: false,
+			shouldLog:            true,
 		},
 		{
 			cmd: &Command{
@@ -160,9 +191,11 @@ commands:
 					"beep": "boop",
 				},
 				IgnoreResolved: &alsoTrue,
+				Verbose:        &alsoFalse,
 			},
 			shouldNotify:         true,
 			shouldIgnoreResolved: true,
+			shouldLog:            false,
 		},
 	}
 
@@ -176,9 +209,18 @@ commands:
 		if c.Commands[i].ShouldIgnoreResolved() != tc.shouldIgnoreResolved {
 			t.Errorf("Wrong IgnoreResolved value for %q; got %v, want %v", c.Commands[i].String(), c.Commands[i].ShouldIgnoreResolved(), tc.shouldIgnoreResolved)
 		}
+		if c.Commands[i].ShouldLog(c.Verbose) != tc.shouldLog {
+			t.Errorf("Wrong Verbose value for %q; got %v, want %v", c.Commands[i].String(), c.Commands[i].ShouldLog(c.Verbose), tc.shouldLog)
+		}
 		if c.Commands[i].ResolvedSig != tc.cmd.ResolvedSig {
 			t.Errorf("Wrong ResolvedSig value for %q; got %s, want %s", c.Commands[i].String(), c.Commands[i].ResolvedSig, tc.cmd.ResolvedSig)
 		}
+		if c.Commands[i].Retries != tc.cmd.Retries {
+			t.Errorf("Wrong Retries value for %q; got %d, want %d", c.Commands[i].String(), c.Commands[i].Retries, tc.cmd.Retries)
+		}
+		if c.Commands[i].RetryBackoff != tc.cmd.RetryBackoff {
+			t.Errorf("Wrong RetryBackoff value for %q; got %s, want %s", c.Commands[i].String(), c.Commands[i].RetryBackoff, tc.cmd.RetryBackoff)
+		}
 		_, err := c.Commands[i].ParseSignal()
 		if err != nil {
 			t.Fatalf("Failed to convert command %q ResolvedSig value %s to signal: %v", c.Commands[i].String(), c.Commands[i].ResolvedSig, err)
@@ -186,6 +228,16 @@ commands:
 	}
 }
 
+func TestConfig_Location(t *testing.T) {
+	t.Parallel()
+	if loc := (&Config{}).Location(); loc != time.UTC {
+		t.Errorf("Wrong default location; got %s, want %s", loc, time.UTC)
+	}
+	if loc := (&Config{Timezone: "Not/AZone"}).Location(); loc != time.UTC {
+		t.Errorf("Unknown timezone should fall back to UTC; got %s", loc)
+	}
+}
+
 func TestConfig_HasCommand(t *testing.T) {
 	t.Parallel()
 	a := &Command{
@@ -203,3 +255,118 @@ func TestConfig_HasCommand(t *testing.T) {
 		t.Errorf("Config should have command")
 	}
 }
+
+func TestConfig_IsFollowUp(t *testing.T) {
+	t.Parallel()
+	c := Config{Commands: []*Command{
+		{Cmd: "cheap-fix", Name: "cheap", OnFailureRun: []string{"heavy"}},
+		{Cmd: "heavy-fix", Name: "heavy"},
+		{Cmd: "echo"},
+	}}
+
+	if cmd, ok := c.CommandNamed("heavy"); !ok || cmd != c.Commands[1] {
+		t.Errorf("Wrong command named heavy; got %v, %v", cmd, ok)
+	}
+	if _, ok := c.CommandNamed(""); ok {
+		t.Errorf("Unnamed commands shouldn't be found by name")
+	}
+	for i, want := range []bool{false, true, false} {
+		if got := c.IsFollowUp(c.Commands[i]); got != want {
+			t.Errorf("Wrong follow-up state for command %s; got %v, want %v", c.Commands[i], got, want)
+		}
+	}
+}
+
+func TestConfig_Routes(t *testing.T) {
+	t.Parallel()
+	c := Config{Commands: []*Command{
+		{Cmd: "clean-disk", Route: "/hooks/disk"},
+		{Cmd: "echo"},
+		{Cmd: "restart", Route: "/hooks/restart"},
+		{Cmd: "df", Route: "/hooks/disk"},
+	}}
+
+	if got, want := c.Routes(), []string{"/hooks/disk", "/hooks/restart"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong routes; got %v, want %v", got, want)
+	}
+	for path, want := range map[string]string{"/hooks/disk": "/hooks/disk", "/": "", "/hooks": ""} {
+		if got := c.RouteOf(path); got != want {
+			t.Errorf("Wrong route of %s; got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func Test_checkRoute(t *testing.T) {
+	t.Parallel()
+	for route, ok := range map[string]bool{
+		"/hooks/disk":      true,
+		"hooks/disk":       false,
+		"/":                false,
+		"/hooks/../disk":   false,
+		"/metrics":         false,
+		"/api/commands/me": false,
+		"/processes2":      true,
+	} {
+		if err := checkRoute(route); (err == nil) != ok {
+			t.Errorf("Wrong result checking route %q; got %v, want ok=%v", route, err, ok)
+		}
+	}
+}
+
+func Test_checkFollowUps(t *testing.T) {
+	cases := []struct {
+		name     string
+		commands []*Command
+		ok       bool
+	}{
+		{
+			name:     "none",
+			commands: []*Command{{Cmd: "echo"}, {Cmd: "true"}},
+			ok:       true,
+		},
+		{
+			name: "escalation",
+			commands: []*Command{
+				{Cmd: "cheap-fix", Name: "cheap", OnFailureRun: []string{"heavy"}},
+				{Cmd: "heavy-fix", Name: "heavy", OnFailureRun: []string{"page"}, OnSuccessRun: []string{"notify"}},
+				{Cmd: "page", Name: "page", OnSuccessRun: []string{"notify"}},
+				{Cmd: "notify", Name: "notify"},
+			},
+			ok: true,
+		},
+		{
+			name:     "duplicate_name",
+			commands: []*Command{{Cmd: "echo", Name: "a"}, {Cmd: "true", Name: "a"}},
+			ok:       false,
+		},
+		{
+			name:     "missing",
+			commands: []*Command{{Cmd: "echo", OnSuccessRun: []string{"nope"}}},
+			ok:       false,
+		},
+		{
+			name:     "self",
+			commands: []*Command{{Cmd: "echo", Name: "a", OnFailureRun: []string{"a"}}},
+			ok:       false,
+		},
+		{
+			name: "loop",
+			commands: []*Command{
+				{Cmd: "echo", Name: "a", OnFailureRun: []string{"b"}},
+				{Cmd: "true", Name: "b", OnSuccessRun: []string{"a"}},
+			},
+			ok: false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkFollowUps(tc.commands)
+			if ok := err == nil; ok != tc.ok {
+				t.Errorf("Wrong result checking follow-ups; got %v, want ok %v", err, tc.ok)
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ const (
 	incValue
 	decValue
 	delValue
+	allValues
 )
 
 const (
@@ -24,6 +25,7 @@ const (
 type msgAnswer struct {
 	value int
 	ok    bool
+	all   map[string]int
 }
 
 // msg represents a message that can be sent to the counter
@@ -36,6 +38,9 @@ type msg struct {
 
 // Counter tracks values for unique keys
 type Counter struct {
+	// Number of messages sent that the handler hasn't received yet.
+	// It comes first, so that it's aligned for atomic operations on 32-bit platforms.
+	pending int64
 	in      chan msg
 	quit    chan struct{}
 	wg      sync.WaitGroup
@@ -52,6 +57,7 @@ func (c *Counter) handler() {
 	for {
 		select {
 		case m := <-c.in:
+			atomic.AddInt64(&c.pending, -1)
 			switch m.kind {
 			case getValue:
 				v, ok := counts[m.key]
@@ -74,6 +80,12 @@ func (c *Counter) handler() {
 				}
 			case delValue:
 				delete(counts, m.key)
+			case allValues:
+				all := make(map[string]int, len(counts))
+				for k, v := range counts {
+					all[k] = v
+				}
+				m.answer <- msgAnswer{all: all}
 			}
 			if m.answer != nil {
 				close(m.answer)
@@ -96,6 +108,26 @@ func (c *Counter) turnOn() bool {
 	return atomic.CompareAndSwapInt32(&c.started, off, on)
 }
 
+// send passes a message to the handler, counting it as pending until the handler receives it
+func (c *Counter) send(m msg) {
+	atomic.AddInt64(&c.pending, 1)
+	c.in <- m
+}
+
+// Pending returns how many messages are waiting for the handler to receive them.
+// A number that keeps growing means the handler is stuck.
+func (c *Counter) Pending() int {
+	return int(atomic.LoadInt64(&c.pending))
+}
+
+// All returns a copy of all the counters' values, keyed by counter
+func (c *Counter) All() map[string]int {
+	resp := make(chan msgAnswer)
+	c.send(msg{kind: allValues, answer: resp})
+	a := <-resp
+	return a.all
+}
+
 // Dec decrements the counter by 1
 func (c *Counter) Dec(key string) {
 	c.DecBy(key, 1)
@@ -103,18 +135,18 @@ func (c *Counter) Dec(key string) {
 
 // DecBy decrements the counter by the given amount
 func (c *Counter) DecBy(key string, amt int) {
-	c.in <- msg{kind: decValue, key: key, value: amt}
+	c.send(msg{kind: decValue, key: key, value: amt})
 }
 
 // Delete removes the counter
 func (c *Counter) Delete(key string) {
-	c.in <- msg{kind: delValue, key: key}
+	c.send(msg{kind: delValue, key: key})
 }
 
 // Get returns the current value of the counter, and if it exists
 func (c *Counter) Get(key string) (int, bool) {
 	resp := make(chan msgAnswer)
-	c.in <- msg{kind: getValue, key: key, answer: resp}
+	c.send(msg{kind: getValue, key: key, answer: resp})
 	a := <-resp
 	return a.value, a.ok
 }
@@ -126,7 +158,7 @@ func (c *Counter) Inc(key string) {
 
 // IncBy increments the counter by the given amount
 func (c *Counter) IncBy(key string, amt int) {
-	c.in <- msg{kind: incValue, key: key, value: amt}
+	c.send(msg{kind: incValue, key: key, value: amt})
 }
 
 // Reset sets the counter to zero
@@ -136,7 +168,7 @@ func (c *Counter) Reset(key string) {
 
 // Set the counter to the given amount
 func (c *Counter) Set(key string, to int) {
-	c.in <- msg{kind: setValue, key: key, value: to}
+	c.send(msg{kind: setValue, key: key, value: to})
 }
 
 // Start a counter message handler goroutine
@@ -11,6 +11,26 @@ const (
 	testKey = "banana"
 )
 
+func TestCounter_All(t *testing.T) {
+	t.Parallel()
+	var c = NewCounter()
+	defer c.Stop()
+
+	c.Inc(testKey)
+	c.Set("tomato", 3)
+	all := c.All()
+	if len(all) != 2 || all[testKey] != 1 || all["tomato"] != 3 {
+		t.Errorf("wrong counter values; got %v", all)
+	}
+
+	// Changing the returned values doesn't affect the counters
+	all[testKey] = 5
+	v, _ := c.Get(testKey)
+	if v != 1 {
+		t.Errorf("wrong counter value; got %d, want %d", v, 1)
+	}
+}
+
 func TestCounter_Dec(t *testing.T) {
 	t.Parallel()
 	var c = NewCounter()
@@ -99,6 +119,20 @@ func TestCounter_Get(t *testing.T) {
 	}
 }
 
+func TestCounter_Pending(t *testing.T) {
+	t.Parallel()
+	var c = NewCounter()
+	defer c.Stop()
+
+	c.Inc(testKey)
+	c.Set("tomato", 3)
+	// Get only returns once the handler has received every earlier message
+	_, _ = c.Get(testKey)
+	if n := c.Pending(); n != 0 {
+		t.Errorf("wrong number of pending messages; got %d, want %d", n, 0)
+	}
+}
+
 func TestCounter_Inc(t *testing.T) {
 	t.Parallel()
 	var c = NewCounter()
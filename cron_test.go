@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "every_minute", in: "* * * * *"},
+		{name: "lists_ranges_steps", in: "0,30 9-17 */2 1-6/2 mon-fri"},
+		{name: "names", in: "0 0 * JAN sun"},
+		{name: "macro", in: "@daily"},
+		{name: "too_few_fields", in: "* * * *", wantErr: true},
+		{name: "out_of_range", in: "60 * * * *", wantErr: true},
+		{name: "backwards_range", in: "* 5-1 * * *", wantErr: true},
+		{name: "bad_step", in: "*/0 * * * *", wantErr: true},
+		{name: "bad_name", in: "* * * foo *", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseCron(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Unexpected error result; got %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2020, time.January, 15, 10, 20, 30, 0, time.UTC)
+	cases := []struct {
+		name string
+		expr string
+		want time.Time
+	}{
+		{
+			name: "next_minute",
+			expr: "* * * * *",
+			want: time.Date(2020, time.January, 15, 10, 21, 0, 0, time.UTC),
+		},
+		{
+			name: "later_today",
+			expr: "0 3,12 * * *",
+			want: time.Date(2020, time.January, 15, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "tomorrow",
+			expr: "@daily",
+			want: time.Date(2020, time.January, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day_of_week",
+			expr: "30 2 * * sun",
+			want: time.Date(2020, time.January, 19, 2, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday_as_7",
+			expr: "30 2 * * 7",
+			want: time.Date(2020, time.January, 19, 2, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "day_of_month_or_week",
+			expr: "0 0 1 * fri",
+			want: time.Date(2020, time.January, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "next_year",
+			expr: "0 0 1 jan *",
+			want: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap_day",
+			expr: "0 0 29 2 *",
+			want: time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "never",
+			expr: "0 0 30 2 *",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			c, err := ParseCron(tc.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse cron expression: %v", err)
+			}
+			if got := c.Next(from); !got.Equal(tc.want) {
+				t.Errorf("Wrong next time; got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+const (
+	// Outcomes that attempts of commands with a timeout are counted with
+	DeadlineLabelMet      = "met"
+	DeadlineLabelExceeded = "exceeded"
+)
+
+var (
+	deadlineCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "deadline",
+		Name:      "total",
+		Help:      "Total number of attempts at running commands with a timeout, by whether they exited before their deadline or were killed at it.",
+	}
+	deadlineLabels = []string{"command", "outcome"}
+)
+
+// deadlineEnv returns the environment variable telling a command when it'll be killed, in seconds since the epoch,
+// so that it can finish cleanly before then
+func deadlineEnv(deadline time.Time) string {
+	return "AMX_DEADLINE=" + strconv.FormatInt(deadline.Unix(), 10)
+}
+
+// initDeadlineMetrics exports the outcomes of attempts of commands with a timeout, before they first run
+func (s *Server) initDeadlineMetrics() {
+	for _, cmd := range s.config.AllCommands() {
+		if cmd.Timeout <= 0 {
+			continue
+		}
+		for _, outcome := range []string{DeadlineLabelMet, DeadlineLabelExceeded} {
+			_ = s.deadlineCounter.WithLabelValues(cmd.MetricLabel(), outcome)
+		}
+	}
+}
+
+// observeDeadline counts whether an attempt of a command with a timeout exited before its deadline.
+// Results that aren't about an attempt exiting, such as ones about signalling it, aren't counted.
+func (s *Server) observeDeadline(cmd *Command, r CommandResult) {
+	if cmd.Timeout <= 0 {
+		return
+	}
+	if r.TimedOut {
+		s.deadlineCounter.WithLabelValues(cmd.MetricLabel(), DeadlineLabelExceeded).Inc()
+	} else if _, ok := r.ExitCode(); ok {
+		s.deadlineCounter.WithLabelValues(cmd.MetricLabel(), DeadlineLabelMet).Inc()
+	}
+}
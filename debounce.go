@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// debounceKey identifies the alert a command ran for
+type debounceKey struct {
+	command     string
+	fingerprint string
+}
+
+// DebounceEntry is a command's window for an alert, as it's kept in the runtime state
+type DebounceEntry struct {
+	Command     string    `json:"command"`
+	Fingerprint string    `json:"fingerprint"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Debouncer remembers when commands with a debounce window last ran for alerts, so that the notifications alertmanager
+// repeats for alerts that are still firing don't re-run them until the window has passed.
+// It's also used to remember when commands with a cooldown last finished running for alerts, and when commands with
+// suppress_after_success last succeeded for them.
+type Debouncer struct {
+	expires map[debounceKey]time.Time
+	sync.Mutex
+}
+
+// NewDebouncer returns a Debouncer instance, with no runs recorded
+func NewDebouncer() *Debouncer {
+	return &Debouncer{expires: make(map[debounceKey]time.Time)}
+}
+
+// Record notes that the command ran for the fingerprinted alert, so that it's debounced until the window has passed.
+// Expired entries are removed, so they don't accumulate over long uptimes.
+func (d *Debouncer) Record(command string, fingerprint string, window time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	now := time.Now()
+	for k, expires := range d.expires {
+		if !now.Before(expires) {
+			delete(d.expires, k)
+		}
+	}
+	d.expires[debounceKey{command: command, fingerprint: fingerprint}] = now.Add(window)
+}
+
+// Debounced returns true if the command ran for the fingerprinted alert within its debounce window
+func (d *Debouncer) Debounced(command string, fingerprint string) bool {
+	d.Lock()
+	defer d.Unlock()
+	expires, ok := d.expires[debounceKey{command: command, fingerprint: fingerprint}]
+	return ok && time.Now().Before(expires)
+}
+
+// Forget removes the record of the command running for the fingerprinted alert, such as when the alert resolves,
+// so that the command runs straight away if the alert fires again
+func (d *Debouncer) Forget(command string, fingerprint string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.expires, debounceKey{command: command, fingerprint: fingerprint})
+}
+
+// Entries returns the windows that haven't passed yet, sorted by command and fingerprint
+func (d *Debouncer) Entries() []DebounceEntry {
+	d.Lock()
+	defer d.Unlock()
+	now := time.Now()
+	entries := make([]DebounceEntry, 0, len(d.expires))
+	for k, expires := range d.expires {
+		if now.Before(expires) {
+			entries = append(entries, DebounceEntry{Command: k.command, Fingerprint: k.fingerprint, Expires: expires})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Command != entries[j].Command {
+			return entries[i].Command < entries[j].Command
+		}
+		return entries[i].Fingerprint < entries[j].Fingerprint
+	})
+	return entries
+}
+
+// Set replaces the recorded windows with the given ones
+func (d *Debouncer) Set(entries []DebounceEntry) {
+	d.Lock()
+	defer d.Unlock()
+	d.expires = make(map[debounceKey]time.Time, len(entries))
+	for _, e := range entries {
+		d.expires[debounceKey{command: e.Command, fingerprint: e.Fingerprint}] = e.Expires
+	}
+}
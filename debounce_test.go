@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	t.Parallel()
+	d := NewDebouncer()
+	d.Record("restart", "boop", time.Minute)
+	d.Record("restart", "beep", -time.Second)
+	if !d.Debounced("restart", "boop") {
+		t.Errorf("Command should be debounced for the alert it just ran for")
+	}
+	if d.Debounced("restart", "beep") {
+		t.Errorf("Command shouldn't be debounced once the window has passed")
+	}
+	if d.Debounced("echo", "boop") {
+		t.Errorf("Other commands shouldn't be debounced for the alert")
+	}
+	d.Forget("restart", "boop")
+	if d.Debounced("restart", "boop") {
+		t.Errorf("Command shouldn't be debounced once the alert was forgotten")
+	}
+}
+
+func TestDebouncer_Entries(t *testing.T) {
+	t.Parallel()
+	src := NewDebouncer()
+	src.Record("restart", "boop", time.Minute)
+	src.Record("restart", "beep", -time.Second)
+	entries := src.Entries()
+	if len(entries) != 1 || entries[0].Command != "restart" || entries[0].Fingerprint != "boop" {
+		t.Fatalf("Wrong entries; got %+v", entries)
+	}
+
+	dst := NewDebouncer()
+	dst.Record("echo", "boop", time.Minute)
+	dst.Set(entries)
+	if !dst.Debounced("restart", "boop") {
+		t.Errorf("Set entries should be debounced")
+	}
+	if dst.Debounced("echo", "boop") {
+		t.Errorf("Set should replace the entries recorded before")
+	}
+}
+
+func TestServer_runCommands_debounce(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "true", Debounce: Duration(time.Minute)}
+	srv.config.Commands = []*Command{cmd}
+
+	firing := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Fingerprint: "boop", StartsAt: time.Now()}},
+	}
+	if ok, reason := srv.CanRun(cmd, firing); !ok {
+		t.Fatalf("Command should run for the first notification; got %s", reason.Label())
+	}
+	_ = srv.runCommands(firing, "", nil, nil)
+	if ok, reason := srv.CanRun(cmd, firing); ok || reason != CmdRunDebounced {
+		t.Errorf("Repeated notification wasn't debounced; got %v, %s", ok, reason.Label())
+	}
+
+	srv.amResolved(&template.Data{
+		Status: "resolved",
+		Alerts: template.Alerts{{Status: "resolved", Fingerprint: "boop", EndsAt: time.Now()}},
+	})
+	if ok, reason := srv.CanRun(cmd, firing); !ok {
+		t.Errorf("Command should run for an alert firing again after it resolved; got %s", reason.Label())
+	}
+}
+
+func TestServer_runCommands_cooldown(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "true", Max: 5, Cooldown: Duration(time.Minute)}
+	srv.config.Commands = []*Command{cmd}
+
+	firing := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Fingerprint: "boop", StartsAt: time.Now()}},
+	}
+	_ = srv.runCommands(firing, "", nil, nil)
+	if ok, reason := srv.CanRun(cmd, firing); ok || reason != CmdRunCooldown {
+		t.Errorf("Command should be cooling down after it ran; got %v, %s", ok, reason.Label())
+	}
+
+	// The cooldown carries on when a flapping alert resolves and fires again
+	srv.amResolved(&template.Data{
+		Status: "resolved",
+		Alerts: template.Alerts{{Status: "resolved", Fingerprint: "boop", EndsAt: time.Now()}},
+	})
+	if ok, reason := srv.CanRun(cmd, firing); ok || reason != CmdRunCooldown {
+		t.Errorf("Command should still be cooling down after the alert resolved; got %v, %s", ok, reason.Label())
+	}
+
+	other := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Fingerprint: "beep", StartsAt: time.Now()}},
+	}
+	if ok, reason := srv.CanRun(cmd, other); !ok {
+		t.Errorf("Command shouldn't be cooling down for other alerts; got %s", reason.Label())
+	}
+}
+
+func TestServer_runCommands_suppressAfterSuccess(t *testing.T) {
+	cases := []struct {
+		name       string
+		cmd        string
+		suppressed bool
+	}{
+		{name: "succeeded", cmd: "true", suppressed: true},
+		// Failed executions don't start the window, so the command runs again for the next notification
+		{name: "failed", cmd: "false", suppressed: false},
+	}
+
+	for _, tc := range cases {
+		srv, err := genServer()
+		if err != nil {
+			t.Fatal("Failed to generate server")
+		}
+		cmd := &Command{Cmd: tc.cmd, SuppressAfterSuccess: Duration(time.Minute)}
+		srv.config.Commands = []*Command{cmd}
+
+		firing := &template.Data{
+			Status: "firing",
+			Alerts: template.Alerts{{Status: "firing", Fingerprint: "boop", StartsAt: time.Now()}},
+		}
+		_ = srv.runCommands(firing, "", nil, nil)
+		ok, reason := srv.CanRun(cmd, firing)
+		if suppressed := !ok && reason == CmdRunSuppressed; suppressed != tc.suppressed {
+			t.Errorf("Wrong suppression after command %s; got %v, %s, want suppressed %v", tc.name, ok, reason.Label(), tc.suppressed)
+		}
+	}
+}
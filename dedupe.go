@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var duplicateAlertCountOpts = prometheus.CounterOpts{
+	Namespace: metricNamespace,
+	Subsystem: "webhook",
+	Name:      "duplicate_alerts_total",
+	Help:      "Total number of alerts dropped from alert messages, because an earlier alert in the same message had the same fingerprint.",
+}
+
+// dedupeAlerts returns the alert message with only the first of each set of alerts sharing a fingerprint, so that
+// commands aren't given the same alert twice, along with how many alerts were dropped.
+// Alerts without a fingerprint are all kept, since there's no telling whether they're the same alert.
+// The message is returned as is if it has no duplicates.
+func dedupeAlerts(msg *template.Data) (*template.Data, int) {
+	seen := make(map[string]bool, len(msg.Alerts))
+	alerts := make(template.Alerts, 0, len(msg.Alerts))
+	for _, alert := range msg.Alerts {
+		if alert.Fingerprint != "" {
+			if seen[alert.Fingerprint] {
+				continue
+			}
+			seen[alert.Fingerprint] = true
+		}
+		alerts = append(alerts, alert)
+	}
+
+	dropped := len(msg.Alerts) - len(alerts)
+	if dropped == 0 {
+		return msg, 0
+	}
+	deduped := *msg
+	deduped.Alerts = alerts
+	return &deduped, dropped
+}
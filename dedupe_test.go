@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+func TestDedupeAlerts(t *testing.T) {
+	cases := []struct {
+		name         string
+		fingerprints []string
+		want         []string
+	}{
+		{name: "none", fingerprints: nil, want: nil},
+		{name: "distinct", fingerprints: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "repeated", fingerprints: []string{"a", "b", "a", "a"}, want: []string{"a", "b"}},
+		{name: "no_fingerprint", fingerprints: []string{"", "a", ""}, want: []string{"", "a", ""}},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			msg := &template.Data{Status: "firing"}
+			for i, f := range tc.fingerprints {
+				msg.Alerts = append(msg.Alerts, template.Alert{Fingerprint: f, Labels: template.KV{"n": string(rune('0' + i))}})
+			}
+
+			got, dropped := dedupeAlerts(msg)
+			if dropped != len(tc.fingerprints)-len(tc.want) {
+				t.Errorf("Wrong number of alerts dropped; got %d, want %d", dropped, len(tc.fingerprints)-len(tc.want))
+			}
+			if len(got.Alerts) != len(tc.want) {
+				t.Fatalf("Wrong alerts kept; got %v, want fingerprints %v", got.Alerts, tc.want)
+			}
+			for i, alert := range got.Alerts {
+				if alert.Fingerprint != tc.want[i] {
+					t.Errorf("Wrong alert %d kept; got fingerprint %q, want %q", i, alert.Fingerprint, tc.want[i])
+				}
+			}
+			// The first alert with each fingerprint is the one kept
+			if len(got.Alerts) > 1 && got.Alerts[1].Labels["n"] != "1" {
+				t.Errorf("Expected the first alert with a fingerprint to be kept; got %v", got.Alerts[1])
+			}
+			if len(msg.Alerts) != len(tc.fingerprints) {
+				t.Errorf("The original message was changed; got %d alerts, want %d", len(msg.Alerts), len(tc.fingerprints))
+			}
+		})
+	}
+}
+
+func TestServer_handleMessage_duplicateAlerts(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.Commands = []*Command{{Cmd: "sh", Args: []string{"-c", `test "$AMX_ALERT_LEN" = 1`}}}
+
+	msg := amDataFinger
+	msg.Alerts = append(template.Alerts{}, amDataFinger.Alerts[0], amDataFinger.Alerts[0])
+	if errs := srv.handleMessage(&msg, "", nil, nil); len(errs) > 0 {
+		t.Errorf("Command was given the duplicate alert: %v", errs)
+	}
+
+	var m pm.Metric
+	if err := srv.duplicateAlerts.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Wrong number of duplicate alerts counted; got %v, want 1", got)
+	}
+}
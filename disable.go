@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Path that requests to enable or disable commands are made under, as in /api/commands/{name}/disable
+	commandsAPIPath = "/api/commands/"
+)
+
+// DisabledCommands tracks the names of commands that were disabled at runtime.
+// Disabled commands are skipped for matching alerts, until they're enabled again.
+type DisabledCommands struct {
+	names map[string]bool
+	sync.RWMutex
+}
+
+// commandToggle represents the body of a response to a request to enable or disable a command
+type commandToggle struct {
+	Name     string `json:"name"`
+	Disabled bool   `json:"disabled"`
+}
+
+// Disable stops the named command from running
+func (d *DisabledCommands) Disable(name string) {
+	d.Lock()
+	defer d.Unlock()
+	d.names[name] = true
+}
+
+// Enable lets the named command run again
+func (d *DisabledCommands) Enable(name string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.names, name)
+}
+
+// Disabled returns true if the named command is disabled
+func (d *DisabledCommands) Disabled(name string) bool {
+	d.RLock()
+	defer d.RUnlock()
+	return d.names[name]
+}
+
+// Names returns the names of the disabled commands, in sorted order
+func (d *DisabledCommands) Names() []string {
+	d.RLock()
+	defer d.RUnlock()
+	names := make([]string, 0, len(d.names))
+	for name := range d.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set replaces the disabled commands with the given ones
+func (d *DisabledCommands) Set(names []string) {
+	d.Lock()
+	defer d.Unlock()
+	d.names = make(map[string]bool, len(names))
+	for _, name := range names {
+		d.names[name] = true
+	}
+}
+
+// NewDisabledCommands returns a DisabledCommands instance, with no commands disabled
+func NewDisabledCommands() *DisabledCommands {
+	return &DisabledCommands{
+		names: make(map[string]bool),
+	}
+}
+
+// handleCommandToggle disables or enables a named command for POST requests to
+// /api/commands/{name}/disable and /api/commands/{name}/enable.
+// Destructive commands are approved to run through /api/commands/{name}/approve, as well.
+// Commands are identified by their name setting, so unnamed commands can't be toggled.
+func (s *Server) handleCommandToggle(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, commandsAPIPath), "/")
+	if len(parts) != 2 || parts[0] == "" || (parts[1] != "disable" && parts[1] != "enable" && parts[1] != "approve") {
+		http.NotFound(w, req)
+		return
+	}
+	name, action := parts[0], parts[1]
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.config.HasName(name) {
+		http.Error(w, "No command is named "+name, http.StatusNotFound)
+		return
+	}
+	if action == "approve" {
+		s.approveCommand(w, req, name)
+		return
+	}
+
+	if action == "disable" {
+		s.disabled.Disable(name)
+	} else {
+		s.disabled.Enable(name)
+	}
+	s.saveState()
+	logger.Info("Command "+action+"d at runtime", Fields{"name": name, "remote_addr": req.RemoteAddr})
+	writeJSON(w, commandToggle{Name: name, Disabled: s.disabled.Disabled(name)})
+}
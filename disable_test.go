@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisabledCommands(t *testing.T) {
+	t.Parallel()
+	d := NewDisabledCommands()
+	d.Disable("b")
+	d.Disable("a")
+	if !d.Disabled("a") || d.Disabled("c") {
+		t.Errorf("Wrong disabled state; got %v", d.Names())
+	}
+	d.Enable("a")
+	if names := d.Names(); len(names) != 1 || names[0] != "b" {
+		t.Errorf("Wrong disabled commands after enabling one; got %v", names)
+	}
+	d.Set([]string{"c"})
+	if d.Disabled("b") || !d.Disabled("c") {
+		t.Errorf("Wrong disabled commands after setting them; got %v", d.Names())
+	}
+}
+
+func TestServer_handleCommandToggle(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.Commands = []*Command{{Cmd: "/usr/local/bin/restart-service", Name: "restart"}}
+
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		status   int
+		disabled bool
+	}{
+		{name: "disable", method: "POST", path: "/api/commands/restart/disable", status: http.StatusOK, disabled: true},
+		{name: "disable_again", method: "POST", path: "/api/commands/restart/disable", status: http.StatusOK, disabled: true},
+		{name: "wrong_method", method: "GET", path: "/api/commands/restart/enable", status: http.StatusMethodNotAllowed, disabled: true},
+		{name: "enable", method: "POST", path: "/api/commands/restart/enable", status: http.StatusOK, disabled: false},
+		{name: "unknown_command", method: "POST", path: "/api/commands/reboot/disable", status: http.StatusNotFound, disabled: false},
+		{name: "unknown_action", method: "POST", path: "/api/commands/restart/pause", status: http.StatusNotFound, disabled: false},
+		{name: "missing_name", method: "POST", path: "/api/commands//disable", status: http.StatusNotFound, disabled: false},
+	}
+
+	// Requests are made in order, since each case depends on the state left by the previous ones
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		srv.handleCommandToggle(w, httptest.NewRequest(tc.method, tc.path, nil))
+		resp := w.Result()
+		if resp.StatusCode != tc.status {
+			t.Errorf("Wrong response for %s; got %d, want %d", tc.name, resp.StatusCode, tc.status)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var got commandToggle
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("Failed to decode response for %s: %v", tc.name, err)
+			}
+			if got.Name != "restart" || got.Disabled != tc.disabled {
+				t.Errorf("Wrong response body for %s; got %+v", tc.name, got)
+			}
+		}
+		if disabled := srv.disabled.Disabled("restart"); disabled != tc.disabled {
+			t.Errorf("Wrong disabled state after %s; got %v, want %v", tc.name, disabled, tc.disabled)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"sync"
+)
+
+// DomainLimit limits how many executions can be running at once, across all commands, for firing alerts that have
+// the same value of a label, such as a rack or availability zone, so that remediation can't take out a whole failure
+// domain at once.
+type DomainLimit struct {
+	Label string `yaml:"label"`
+	Max   int    `yaml:"max"`
+}
+
+// domainKey identifies a failure domain, by the label it's keyed by and its value
+type domainKey struct {
+	label string
+	value string
+}
+
+// FailureDomains tracks how many executions are running in each failure domain, under the configured limits
+type FailureDomains struct {
+	limits  []DomainLimit
+	running map[domainKey]int
+	sync.Mutex
+}
+
+// NewFailureDomains returns a FailureDomains instance enforcing the given limits
+func NewFailureDomains(limits []DomainLimit) *FailureDomains {
+	return &FailureDomains{limits: limits, running: make(map[domainKey]int)}
+}
+
+// validateDomainLimits returns an error describing the first limit that can't be used
+func validateDomainLimits(limits []DomainLimit) error {
+	seen := make(map[string]bool, len(limits))
+	for i, l := range limits {
+		if l.Label == "" {
+			return fmt.Errorf("limit at index %d has no label", i)
+		}
+		if l.Max <= 0 {
+			return fmt.Errorf("limit for label %q must have a positive max, got %d", l.Label, l.Max)
+		}
+		if seen[l.Label] {
+			return fmt.Errorf("label %q has more than one limit", l.Label)
+		}
+		seen[l.Label] = true
+	}
+	return nil
+}
+
+// Acquire counts an execution for an alert with the given labels in each failure domain it belongs to, if none of them
+// are at their limit. The returned function stops counting it, and has to be called once the execution finishes.
+// Alerts without a domain's label aren't limited by it.
+func (d *FailureDomains) Acquire(labels template.KV) (func(), bool) {
+	d.Lock()
+	defer d.Unlock()
+	keys := make([]domainKey, 0, len(d.limits))
+	for _, l := range d.limits {
+		v, ok := labels[l.Label]
+		if !ok || v == "" {
+			continue
+		}
+		k := domainKey{label: l.Label, value: v}
+		if d.running[k] >= l.Max {
+			return func() {}, false
+		}
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		d.running[k]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.Lock()
+			defer d.Unlock()
+			for _, k := range keys {
+				if d.running[k]--; d.running[k] <= 0 {
+					delete(d.running, k)
+				}
+			}
+		})
+	}, true
+}
+
+// Running returns how many executions are running in the failure domain with the given label and value
+func (d *FailureDomains) Running(label string, value string) int {
+	d.Lock()
+	defer d.Unlock()
+	return d.running[domainKey{label: label, value: value}]
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+func Test_validateDomainLimits(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name   string
+		limits []DomainLimit
+		ok     bool
+	}{
+		{name: "none", ok: true},
+		{name: "valid", limits: []DomainLimit{{Label: "rack", Max: 2}, {Label: "az", Max: 5}}, ok: true},
+		{name: "no_label", limits: []DomainLimit{{Max: 2}}, ok: false},
+		{name: "no_max", limits: []DomainLimit{{Label: "rack"}}, ok: false},
+		{name: "duplicate", limits: []DomainLimit{{Label: "rack", Max: 2}, {Label: "rack", Max: 3}}, ok: false},
+	}
+	for _, tc := range cases {
+		if err := validateDomainLimits(tc.limits); (err == nil) != tc.ok {
+			t.Errorf("Wrong validation result for %s; got %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestFailureDomains_Acquire(t *testing.T) {
+	t.Parallel()
+	d := NewFailureDomains([]DomainLimit{{Label: "rack", Max: 2}, {Label: "az", Max: 3}})
+	r1 := template.KV{"rack": "r1", "az": "a"}
+	r2 := template.KV{"rack": "r2", "az": "a"}
+
+	release1, ok := d.Acquire(r1)
+	if !ok {
+		t.Fatal("First execution in the rack should be allowed")
+	}
+	if _, ok := d.Acquire(r1); !ok {
+		t.Fatal("Second execution in the rack should be allowed")
+	}
+	if _, ok := d.Acquire(r1); ok {
+		t.Error("Third execution in the rack should be limited")
+	}
+	if _, ok := d.Acquire(r2); !ok {
+		t.Error("Execution in another rack should be allowed")
+	}
+	// The availability zone is at its limit now, even though rack r2 isn't
+	if _, ok := d.Acquire(r2); ok {
+		t.Error("Execution in a full availability zone should be limited")
+	}
+	if _, ok := d.Acquire(template.KV{"host": "db1"}); !ok {
+		t.Error("Alerts without the labels shouldn't be limited")
+	}
+
+	release1()
+	release1()
+	if got := d.Running("rack", "r1"); got != 1 {
+		t.Errorf("Releasing twice should only count once; got %d running, want 1", got)
+	}
+	if got := d.Running("az", "a"); got != 2 {
+		t.Errorf("Wrong number running in the availability zone; got %d, want 2", got)
+	}
+}
+
+func TestServer_runCommands_domainLimit(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.FailureDomains = []DomainLimit{{Label: "rack", Max: 1}}
+	srv.domains = NewFailureDomains(srv.config.FailureDomains)
+	first := &Command{Cmd: "sleep", Args: []string{"0.2"}, Name: "first"}
+	second := &Command{Cmd: "true", Name: "second"}
+	srv.config.Commands = []*Command{first, second}
+
+	_ = srv.runCommands(&template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Labels: template.KV{"rack": "r1"}, Fingerprint: "boop", StartsAt: time.Now()}},
+	}, "", nil, nil)
+
+	var m pm.Metric
+	if err := srv.skipCounter.WithLabelValues(CmdRunDomainLimit.Label(), "second").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Wrong number of executions limited by failure domain; got %v, want 1", got)
+	}
+	if got := srv.domains.Running("rack", "r1"); got != 0 {
+		t.Errorf("Finished executions should no longer count; got %d running", got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Strategies for alert environments that are larger than max_env_size
+	EnvOverflowTruncate = "truncate"
+	EnvOverflowFile     = "file"
+	EnvOverflowReject   = "reject"
+
+	// Environment variable naming the file that the alert message is written to, when its environment is too large
+	payloadFileEnv = "AMX_PAYLOAD_FILE"
+	// Environment variable with the number of alerts left out of a truncated environment
+	alertsDroppedEnv = "AMX_ALERTS_DROPPED"
+	// Environment variable with the signal that a command whose resolved_signal is a template is signalled with
+	resolvedSignalEnv = "AMX_RESOLVED_SIGNAL"
+)
+
+// ParseEnvOverflow returns the strategy for environments larger than max_env_size, defaulting to truncate
+func ParseEnvOverflow(strategy string) (string, error) {
+	switch strings.ToLower(strategy) {
+	case "", EnvOverflowTruncate:
+		return EnvOverflowTruncate, nil
+	case EnvOverflowFile:
+		return EnvOverflowFile, nil
+	case EnvOverflowReject:
+		return EnvOverflowReject, nil
+	}
+
+	return "", fmt.Errorf("Unknown env_overflow strategy %q, expected %s, %s or %s", strategy, EnvOverflowTruncate, EnvOverflowFile, EnvOverflowReject)
+}
+
+// envSize returns how many bytes environment variables take up when passed to a program, including their terminators
+func envSize(env []string) ByteSize {
+	var size ByteSize
+	for _, v := range env {
+		size += ByteSize(len(v) + 1)
+	}
+	return size
+}
+
+// alertEnv returns the environment variables describing an alert message to a command.
+// If they're larger than max_env_size, the configured env_overflow strategy is applied, so that commands don't fail
+// to start with E2BIG: alerts are left out from the end of the list until the rest fit, the message is written to a
+// file instead, or an error is returned.
+func (s *Server) alertEnv(data *template.Data) ([]string, error) {
+	env := amDataToEnv(data, s.location)
+	limit := s.config.MaxEnvSize
+	if limit <= 0 || envSize(env) <= limit {
+		return env, nil
+	}
+
+	strategy, _ := ParseEnvOverflow(s.config.EnvOverflow)
+	switch strategy {
+	case EnvOverflowFile:
+		f, err := s.tempFiles.Create("am-executor-payload-*")
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		if err := json.NewEncoder(f).Encode(data); err != nil {
+			s.tempFiles.Remove(f.Name())
+			return nil, err
+		}
+		// The alerts are left to the file, since they're what makes the environment large
+		summary := *data
+		summary.Alerts = nil
+		env = append(amDataToEnv(&summary, s.location), payloadFileEnv+"="+f.Name())
+		if envSize(env) > limit {
+			s.tempFiles.Remove(f.Name())
+			return nil, fmt.Errorf("Environment is %s even without alerts, which is over max_env_size of %s", envSize(env), limit)
+		}
+		return env, nil
+	case EnvOverflowTruncate:
+		truncated := *data
+		for n := len(data.Alerts) - 1; n >= 0; n-- {
+			truncated.Alerts = data.Alerts[:n]
+			env = append(amDataToEnv(&truncated, s.location), alertsDroppedEnv+"="+strconv.Itoa(len(data.Alerts)-n))
+			if envSize(env) <= limit {
+				return env, nil
+			}
+		}
+		return nil, fmt.Errorf("Environment is %s even without alerts, which is over max_env_size of %s", envSize(env), limit)
+	}
+	return nil, fmt.Errorf("Environment is %s, which is over max_env_size of %s", envSize(env), limit)
+}
+
+// removePayloadFile removes the file that an alert message was written to for a command, if there is one
+func (s *Server) removePayloadFile(env []string) {
+	for _, v := range env {
+		if strings.HasPrefix(v, payloadFileEnv+"=") {
+			s.tempFiles.Remove(strings.TrimPrefix(v, payloadFileEnv+"="))
+		}
+	}
+}
+
+// envValue returns the value of an environment variable, and whether it's set
+func envValue(env []string, name string) (string, bool) {
+	for _, v := range env {
+		if strings.HasPrefix(v, name+"=") {
+			return strings.TrimPrefix(v, name+"="), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_alertEnv(t *testing.T) {
+	data := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			{Status: "firing", Fingerprint: "one", Labels: template.KV{"description": strings.Repeat("a", 500)}},
+			{Status: "firing", Fingerprint: "two", Labels: template.KV{"description": strings.Repeat("b", 500)}},
+			{Status: "firing", Fingerprint: "three", Labels: template.KV{"description": strings.Repeat("c", 500)}},
+		},
+	}
+	full := envSize(amDataToEnv(data, time.UTC))
+
+	cases := []struct {
+		name     string
+		limit    ByteSize
+		strategy string
+		alerts   string
+		dropped  string
+		file     bool
+		err      bool
+	}{
+		{name: "no_limit", alerts: "3"},
+		{name: "under_limit", limit: full, strategy: EnvOverflowReject, alerts: "3"},
+		{name: "truncate", limit: full - 100, alerts: "2", dropped: "1"},
+		{name: "truncate_too_small", limit: 10, strategy: EnvOverflowTruncate, err: true},
+		{name: "file", limit: full - 100, strategy: EnvOverflowFile, alerts: "0", file: true},
+		{name: "reject", limit: full - 100, strategy: EnvOverflowReject, err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv := NewServer(&Config{MaxEnvSize: tc.limit, EnvOverflow: tc.strategy})
+			env, err := srv.alertEnv(data)
+			if (err != nil) != tc.err {
+				t.Fatalf("Unexpected error result; got %v, want error %v", err, tc.err)
+			}
+			if tc.err {
+				return
+			}
+			if tc.limit > 0 && envSize(env) > tc.limit {
+				t.Errorf("Environment is over the limit; got %s, want at most %s", envSize(env), tc.limit)
+			}
+			if got, _ := envValue(env, "AMX_ALERT_LEN"); got != tc.alerts {
+				t.Errorf("Wrong number of alerts in environment; got %s, want %s", got, tc.alerts)
+			}
+			if got, _ := envValue(env, alertsDroppedEnv); got != tc.dropped {
+				t.Errorf("Wrong number of dropped alerts; got %q, want %q", got, tc.dropped)
+			}
+
+			name, ok := envValue(env, payloadFileEnv)
+			if ok != tc.file {
+				t.Fatalf("Wrong payload file setting; got %q, want file %v", name, tc.file)
+			}
+			if !ok {
+				return
+			}
+			content, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload template.Data
+			if err := json.Unmarshal(content, &payload); err != nil || len(payload.Alerts) != 3 {
+				t.Errorf("Wrong payload in file; got %d alerts, error %v", len(payload.Alerts), err)
+			}
+			if !srv.tempFiles.InUse(name) {
+				t.Errorf("Payload file should be in use until it's removed")
+			}
+			srv.removePayloadFile(env)
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Errorf("Payload file wasn't removed; got %v", err)
+			}
+			if srv.tempFiles.InUse(name) {
+				t.Errorf("Removed payload file shouldn't be in use")
+			}
+		})
+	}
+}
+
+func TestParseEnvOverflow(t *testing.T) {
+	for in, want := range map[string]string{"": EnvOverflowTruncate, "FILE": EnvOverflowFile, "reject": EnvOverflowReject} {
+		if got, err := ParseEnvOverflow(in); err != nil || got != want {
+			t.Errorf("Wrong strategy for %q; got %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseEnvOverflow("compress"); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Faults configures faults to inject into the executor, so that its handling of races and failures can be tested
+// deterministically. None of them should be enabled in production.
+type Faults struct {
+	// How long to wait before starting each command, widening the window for alerts to resolve before commands start.
+	StartDelay Duration `yaml:"start_delay"`
+	// Don't signal running commands when their alert resolves, as if the signal was lost.
+	DropResolveSignals bool `yaml:"drop_resolve_signals"`
+	// Fail requests for metrics, as if they couldn't be written.
+	FailMetricWrites bool `yaml:"fail_metric_writes"`
+}
+
+// Enabled returns true if any fault is injected
+func (f Faults) Enabled() bool {
+	return f.StartDelay > 0 || f.DropResolveSignals || f.FailMetricWrites
+}
+
+// delayStart waits before a command starts, if configured to
+func (s *Server) delayStart(cmd *Command) {
+	if s.config.Faults.StartDelay <= 0 {
+		return
+	}
+	s.debug(cmd, "Injecting fault: delaying start of command", Fields{"command": cmd, "delay": s.config.Faults.StartDelay})
+	time.Sleep(time.Duration(s.config.Faults.StartDelay))
+}
+
+// failMetricWrites wraps the metrics handler, failing requests if configured to
+func (s *Server) failMetricWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if s.config.Faults.FailMetricWrites {
+			http.Error(w, "Injected fault: failing metric writes", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
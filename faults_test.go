@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFaults_Enabled(t *testing.T) {
+	t.Parallel()
+	if (Faults{}).Enabled() {
+		t.Errorf("No faults should be enabled by default")
+	}
+	if !(Faults{DropResolveSignals: true}).Enabled() {
+		t.Errorf("Faults should be enabled when one is configured")
+	}
+}
+
+func TestServer_amResolvedDropSignals(t *testing.T) {
+	cases := []struct {
+		name     string
+		drop     bool
+		signaled bool
+	}{
+		{name: "signal", drop: false, signaled: true},
+		{name: "drop", drop: true, signaled: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv, err := genServer()
+			if err != nil {
+				t.Fatal("Failed to generate server")
+			}
+			srv.config.Faults.DropResolveSignals = tc.drop
+
+			quit := srv.tellFingers.Add("boop")
+			srv.amResolved(&amDataFingerResolved)
+			select {
+			case <-quit:
+				if !tc.signaled {
+					t.Errorf("Running commands shouldn't be signalled when resolve signals are dropped")
+				}
+			default:
+				if tc.signaled {
+					t.Errorf("Running commands should be signalled when their alert resolves")
+				}
+			}
+		})
+	}
+}
+
+func TestServer_delayStart(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.Faults.StartDelay = Duration(200 * time.Millisecond)
+
+	start := time.Now()
+	srv.delayStart(srv.config.Commands[0])
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Command start wasn't delayed long enough; got %s, want %s", elapsed, srv.config.Faults.StartDelay)
+	}
+}
+
+func TestServer_failMetricWrites(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	h := srv.failMetricWrites(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	for _, fail := range []bool{false, true} {
+		srv.config.Faults.FailMetricWrites = fail
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		want := http.StatusOK
+		if fail {
+			want = http.StatusInternalServerError
+		}
+		if got := w.Result().StatusCode; got != want {
+			t.Errorf("Wrong response with failing metric writes %v; got %d, want %d", fail, got, want)
+		}
+	}
+}
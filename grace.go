@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"time"
+)
+
+// dispatchAfter dispatches a command once its wait_for grace period is over, unless the alert resolves before then,
+// given the channel that's closed when it does. It is meant to be called as a goroutine.
+func (s *Server) dispatchAfter(wait time.Duration, quit chan struct{}, fingerprint string, alert *template.Alert, cmd *Command, args []string, env []string, out chan<- CommandResult, span *Span) {
+	s.debug(cmd, "Waiting for the alert to resolve by itself before running command", Fields{"command": cmd, "fingerprint": fingerprint, "wait_for": cmd.WaitFor})
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-quit:
+		// There's nothing left to remediate
+		s.skip(cmd, CmdRunSelfResolved, fingerprint)
+		s.removePayloadFile(env)
+		close(out)
+		return
+	case <-t.C:
+	}
+
+	if !s.enqueue(fingerprint, alert, cmd, args, env, out, span) {
+		s.skip(cmd, CmdRunQueueFull, fingerprint)
+	}
+}
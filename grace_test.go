@@ -0,0 +1,67 @@
+package main
+
+import (
+	pm "github.com/prometheus/client_model/go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer_dispatchWaitFor(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	dir, err := ioutil.TempDir("", "grace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name     string
+		resolves bool
+		want     Result
+	}{
+		{name: "still_firing", resolves: false, want: CmdOk},
+		{name: "resolves", resolves: true, want: 0},
+	}
+
+	for _, tc := range cases {
+		marker := filepath.Join(dir, tc.name)
+		cmd := &Command{Cmd: "touch", Args: []string{marker}, Name: tc.name, WaitFor: Duration(200 * time.Millisecond)}
+		fingerprint := "grace-" + tc.name
+		out := make(chan CommandResult)
+		if !srv.dispatch(fingerprint, nil, cmd, cmd.Args, nil, out, nil) {
+			t.Fatalf("Command should be dispatched for %s", tc.name)
+		}
+		if tc.resolves {
+			srv.tellFingers.Close(fingerprint)
+		}
+
+		var state Result
+		for r := range out {
+			state = state | r.Kind
+		}
+		if state != tc.want {
+			t.Errorf("Wrong result for %s; got %s, want %s", tc.name, state, tc.want)
+		}
+		if _, err := os.Stat(marker); (err == nil) == tc.resolves {
+			t.Errorf("Command should only run for an alert that's still firing for %s; got %v", tc.name, err)
+		}
+
+		var m pm.Metric
+		if err := srv.skipCounter.WithLabelValues(CmdRunSelfResolved.Label(), tc.name).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		want := 0.0
+		if tc.resolves {
+			want = 1
+		}
+		if got := m.GetCounter().GetValue(); got != want {
+			t.Errorf("Wrong number of skips for %s; got %v, want %v", tc.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Label of alerts that min_severity is compared against, when severity_label isn't set
+	defaultSeverityLabel = "severity"
+	// Outcomes that checks of destructive commands' guards are counted with
+	GuardLabelAllowed = "allowed"
+	GuardLabelBlocked = "blocked"
+)
+
+var (
+	// Severities that min_severity can be set to, from least to most severe
+	severityLevels = []string{"info", "warning", "error", "critical"}
+
+	guardCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "destructive",
+		Name:      "checks_total",
+		Help:      "Total number of times destructive commands matched an alert, by whether their guard allowed them to run.",
+	}
+	guardLabels = []string{"command", "outcome"}
+)
+
+// GuardConfig sets the conditions that alerts have to meet for a destructive command to run for them.
+// All of the conditions that are set have to be met.
+type GuardConfig struct {
+	// The least severe alerts that the command runs for, going by their severity label: info, warning, error or critical.
+	MinSeverity string `yaml:"min_severity"`
+	// Label of alerts that holds their severity. Defaults to severity.
+	SeverityLabel string `yaml:"severity_label"`
+	// Annotation that alerts must have, with a true value, as a confirmation that the command can run for them.
+	ConfirmAnnotation string `yaml:"confirm_annotation"`
+	// How long an approval of the command through the /api/commands/{name}/approve endpoint lasts.
+	// The command only runs while approved, if this is set.
+	ApprovalWindow Duration `yaml:"approval_window"`
+}
+
+// Validate returns an error if the guard's settings can't be used
+func (g *GuardConfig) Validate() error {
+	if g.MinSeverity == "" && g.ConfirmAnnotation == "" && g.ApprovalWindow == 0 {
+		return fmt.Errorf("at least one of min_severity, confirm_annotation or approval_window must be set")
+	}
+	if g.MinSeverity != "" && severityLevel(g.MinSeverity) < 0 {
+		return fmt.Errorf("unknown min_severity %q, expected one of %s", g.MinSeverity, strings.Join(severityLevels, ", "))
+	}
+	if g.ApprovalWindow < 0 {
+		return fmt.Errorf("approval_window is negative: %s", g.ApprovalWindow)
+	}
+	return nil
+}
+
+// severityLevel returns the position of a severity in severityLevels, or -1 if it isn't one of them
+func severityLevel(severity string) int {
+	for i, s := range severityLevels {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check returns an error describing the first condition that an alert message doesn't meet.
+// The message's common labels and annotations are checked, as they are for matching commands.
+// approvedUntil is when the command's latest approval runs out; zero if it hasn't been approved.
+func (g *GuardConfig) Check(amMsg *template.Data, approvedUntil time.Time, now time.Time) error {
+	if g.MinSeverity != "" {
+		label := g.SeverityLabel
+		if label == "" {
+			label = defaultSeverityLabel
+		}
+		severity := amMsg.CommonLabels[label]
+		if severityLevel(severity) < severityLevel(g.MinSeverity) {
+			return fmt.Errorf("alert's %s %q is less severe than %s", label, severity, g.MinSeverity)
+		}
+	}
+	if g.ConfirmAnnotation != "" {
+		confirmed, err := strconv.ParseBool(amMsg.CommonAnnotations[g.ConfirmAnnotation])
+		if err != nil || !confirmed {
+			return fmt.Errorf("alert doesn't have the %s annotation set to true", g.ConfirmAnnotation)
+		}
+	}
+	if g.ApprovalWindow > 0 && !now.Before(approvedUntil) {
+		return fmt.Errorf("command isn't approved")
+	}
+	return nil
+}
+
+// Approvals tracks until when destructive commands were approved to run, by name
+type Approvals struct {
+	until map[string]time.Time
+	sync.RWMutex
+}
+
+// NewApprovals returns an Approvals instance, with no commands approved
+func NewApprovals() *Approvals {
+	return &Approvals{until: make(map[string]time.Time)}
+}
+
+// Approve lets the named command run until the given time
+func (a *Approvals) Approve(name string, until time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	a.until[name] = until
+}
+
+// Until returns when the named command's approval runs out; zero if it wasn't approved
+func (a *Approvals) Until(name string) time.Time {
+	a.RLock()
+	defer a.RUnlock()
+	return a.until[name]
+}
+
+// commandApproval represents the body of a response to a request to approve a command
+type commandApproval struct {
+	Name  string    `json:"name"`
+	Until time.Time `json:"approved_until"`
+}
+
+// guardAllows checks whether a destructive command's guard allows it to run for an alert message.
+// Each check is counted, and logged as a decision regardless of the command's verbosity, as an audit trail.
+func (s *Server) guardAllows(cmd *Command, amMsg *template.Data) bool {
+	label := cmd.MetricLabel()
+	err := cmd.Guard.Check(amMsg, s.approvals.Until(cmd.Name), time.Now())
+	if err != nil {
+		s.guardCounter.WithLabelValues(label, GuardLabelBlocked).Inc()
+		logger.Decision("Blocked destructive command, because its guard wasn't satisfied", Fields{"command": cmd, "destructive": true, "reason": err, "group_labels": amMsg.GroupLabels})
+		return false
+	}
+	s.guardCounter.WithLabelValues(label, GuardLabelAllowed).Inc()
+	logger.Decision("Allowed destructive command to run", Fields{"command": cmd, "destructive": true, "group_labels": amMsg.GroupLabels})
+	return true
+}
+
+// approveCommand approves a destructive command to run for its approval window, for POST requests to
+// /api/commands/{name}/approve
+func (s *Server) approveCommand(w http.ResponseWriter, req *http.Request, name string) {
+	cmd, ok := s.config.CommandNamed(name)
+	if !ok || !cmd.IsDestructive() || cmd.Guard.ApprovalWindow <= 0 {
+		http.Error(w, "No destructive command with an approval_window is named "+name, http.StatusNotFound)
+		return
+	}
+
+	until := time.Now().Add(time.Duration(cmd.Guard.ApprovalWindow))
+	s.approvals.Approve(name, until)
+	logger.Decision("Destructive command approved at runtime", Fields{"name": name, "destructive": true, "until": until, "remote_addr": req.RemoteAddr})
+	writeJSON(w, commandApproval{Name: name, Until: until})
+}
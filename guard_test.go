@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGuardConfig_Validate(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name  string
+		guard GuardConfig
+		ok    bool
+	}{
+		{name: "severity", guard: GuardConfig{MinSeverity: "critical"}, ok: true},
+		{name: "annotation", guard: GuardConfig{ConfirmAnnotation: "remediate"}, ok: true},
+		{name: "approval", guard: GuardConfig{ApprovalWindow: Duration(time.Minute)}, ok: true},
+		{name: "empty", guard: GuardConfig{}, ok: false},
+		{name: "unknown_severity", guard: GuardConfig{MinSeverity: "apocalyptic"}, ok: false},
+		{name: "negative_window", guard: GuardConfig{MinSeverity: "error", ApprovalWindow: Duration(-time.Minute)}, ok: false},
+	}
+
+	for _, tc := range cases {
+		if err := tc.guard.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Wrong validation result for %s; got %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestGuardConfig_Check(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	msg := func(severity, confirm string) *template.Data {
+		return &template.Data{
+			CommonLabels:      template.KV{"alertname": "DiskFull", "severity": severity},
+			CommonAnnotations: template.KV{"remediate": confirm},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		guard    GuardConfig
+		amMsg    *template.Data
+		approved time.Time
+		ok       bool
+	}{
+		{name: "severe_enough", guard: GuardConfig{MinSeverity: "error"}, amMsg: msg("Critical", ""), ok: true},
+		{name: "not_severe_enough", guard: GuardConfig{MinSeverity: "error"}, amMsg: msg("warning", ""), ok: false},
+		{name: "unknown_severity", guard: GuardConfig{MinSeverity: "info"}, amMsg: msg("", ""), ok: false},
+		{name: "other_severity_label", guard: GuardConfig{MinSeverity: "info", SeverityLabel: "alertname"}, amMsg: msg("critical", ""), ok: false},
+		{name: "confirmed", guard: GuardConfig{ConfirmAnnotation: "remediate"}, amMsg: msg("", "true"), ok: true},
+		{name: "not_confirmed", guard: GuardConfig{ConfirmAnnotation: "remediate"}, amMsg: msg("", "no"), ok: false},
+		{name: "approved", guard: GuardConfig{ApprovalWindow: Duration(time.Minute)}, amMsg: msg("", ""), approved: now.Add(time.Second), ok: true},
+		{name: "approval_expired", guard: GuardConfig{ApprovalWindow: Duration(time.Minute)}, amMsg: msg("", ""), approved: now.Add(-time.Second), ok: false},
+		{name: "all", guard: GuardConfig{MinSeverity: "critical", ConfirmAnnotation: "remediate"}, amMsg: msg("critical", "true"), ok: true},
+		{name: "not_all", guard: GuardConfig{MinSeverity: "critical", ConfirmAnnotation: "remediate"}, amMsg: msg("critical", ""), ok: false},
+	}
+
+	for _, tc := range cases {
+		if err := tc.guard.Check(tc.amMsg, tc.approved, now); (err == nil) != tc.ok {
+			t.Errorf("Wrong guard result for %s; got %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestServer_approveCommand(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	destructive := true
+	wipe := &Command{Cmd: "wipe", Name: "wipe", Destructive: &destructive, Guard: &GuardConfig{ApprovalWindow: Duration(time.Minute)}}
+	srv.config.Commands = []*Command{wipe, {Cmd: "echo", Name: "echo"}}
+
+	if ok, reason := srv.CanRun(wipe, &amDataFinger); ok || reason != CmdRunGuarded {
+		t.Errorf("Unapproved destructive command shouldn't run; got %v, %s", ok, reason.Label())
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleCommandToggle(w, httptest.NewRequest(http.MethodPost, "/api/commands/wipe/approve", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status approving command; got %d, want %d", w.Code, http.StatusOK)
+	}
+	var got commandApproval
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode approval: %v", err)
+	}
+	if got.Name != "wipe" || got.Until.Before(time.Now()) {
+		t.Errorf("Wrong approval; got %+v", got)
+	}
+	if ok, reason := srv.CanRun(wipe, &amDataFinger); !ok {
+		t.Errorf("Approved destructive command should run; got %s", reason.Label())
+	}
+
+	w = httptest.NewRecorder()
+	srv.handleCommandToggle(w, httptest.NewRequest(http.MethodPost, "/api/commands/echo/approve", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Wrong status approving command that isn't destructive; got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	for outcome, want := range map[string]float64{GuardLabelAllowed: 1, GuardLabelBlocked: 1} {
+		var m pm.Metric
+		if err := srv.guardCounter.WithLabelValues("wipe", outcome).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetCounter().GetValue(); got != want {
+			t.Errorf("Wrong count of %s checks; got %v, want %v", outcome, got, want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often the watchdog samples the health gauges
+	watchdogInterval = time.Minute
+	// How many consecutive samples a gauge has to grow across before the watchdog warns about it
+	watchdogSamples = 10
+)
+
+// liveCounts tracks the goroutines that are started for each command the server runs,
+// which should all finish once their command does
+type liveCounts struct {
+	collect    int64
+	instrument int64
+}
+
+// begin counts a goroutine as live, returning a function that counts it as finished
+func begin(count *int64) func() {
+	atomic.AddInt64(count, 1)
+	return func() { atomic.AddInt64(count, -1) }
+}
+
+// healthGauge is a gauge of the server's goroutines and channels, which leaks show up in as steady growth
+type healthGauge struct {
+	opts  prometheus.GaugeOpts
+	value func() float64
+}
+
+// name returns what identifies a health gauge in logs
+func (g healthGauge) name() string {
+	name := prometheus.BuildFQName(g.opts.Namespace, g.opts.Subsystem, g.opts.Name)
+	for k, v := range g.opts.ConstLabels {
+		name += "{" + k + "=" + v + "}"
+	}
+	return name
+}
+
+// healthGauges returns the gauges of the server's goroutines and channels
+func (s *Server) healthGauges() []healthGauge {
+	opts := func(name string, help string, labels prometheus.Labels) prometheus.GaugeOpts {
+		return prometheus.GaugeOpts{
+			Namespace:   metricNamespace,
+			Subsystem:   "runtime",
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}
+	}
+	const (
+		goroutinesHelp = "Number of goroutines that are running, by kind: ones collecting command results, ones instrumenting commands, and the total for the process."
+		channelsHelp   = "Number of channels that running commands listen to for being stopped, by what they're keyed by: alert fingerprints or execution IDs."
+	)
+
+	return []healthGauge{
+		{
+			opts: opts("goroutines", goroutinesHelp, prometheus.Labels{"kind": "collect"}),
+			value: func() float64 {
+				return float64(atomic.LoadInt64(&s.live.collect))
+			},
+		},
+		{
+			opts: opts("goroutines", goroutinesHelp, prometheus.Labels{"kind": "instrument"}),
+			value: func() float64 {
+				return float64(atomic.LoadInt64(&s.live.instrument))
+			},
+		},
+		{
+			opts: opts("goroutines", goroutinesHelp, prometheus.Labels{"kind": "total"}),
+			value: func() float64 {
+				return float64(runtime.NumGoroutine())
+			},
+		},
+		{
+			opts: opts("quit_channels", channelsHelp, prometheus.Labels{"map": "fingerprints"}),
+			value: func() float64 {
+				return float64(s.tellFingers.Len())
+			},
+		},
+		{
+			opts: opts("quit_channels", channelsHelp, prometheus.Labels{"map": "executions"}),
+			value: func() float64 {
+				return float64(s.killers.Len())
+			},
+		},
+		{
+			opts: opts("fingerprint_counter_pending", "Number of updates to the fingerprint counts that are waiting to be applied.", nil),
+			value: func() float64 {
+				return float64(s.fingerCount.Pending())
+			},
+		},
+	}
+}
+
+// registerHealthGauges registers the gauges of the server's goroutines and channels
+func (s *Server) registerHealthGauges() {
+	for _, g := range s.health {
+		s.registry.MustRegister(prometheus.NewGaugeFunc(g.opts, g.value))
+	}
+}
+
+// growing returns true if each value is larger than the one before it
+func growing(history []float64) bool {
+	for i := 1; i < len(history); i++ {
+		if history[i] <= history[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// watchdog warns about health gauges that keep growing, which is what leaked goroutines and channels look like
+type watchdog struct {
+	samples int
+	history map[string][]float64
+	// Gauges that were warned about, which aren't warned about again until they stop growing
+	warned map[string]bool
+}
+
+// newWatchdog returns a watchdog that warns about gauges growing across the given number of samples
+func newWatchdog(samples int) *watchdog {
+	return &watchdog{samples: samples, history: make(map[string][]float64), warned: make(map[string]bool)}
+}
+
+// check samples the gauges, returning the names of those that started growing across every sample kept
+func (d *watchdog) check(gauges []healthGauge) []string {
+	var grown []string
+	for _, g := range gauges {
+		name := g.name()
+		history := append(d.history[name], g.value())
+		if len(history) > d.samples {
+			history = history[len(history)-d.samples:]
+		}
+		d.history[name] = history
+
+		if len(history) < d.samples || !growing(history) {
+			d.warned[name] = false
+			continue
+		}
+		if !d.warned[name] {
+			d.warned[name] = true
+			grown = append(grown, name)
+		}
+	}
+	return grown
+}
+
+// watchEvery samples the health gauges at the given interval, and warns about those that keep growing.
+// It is meant to be called as a goroutine.
+func (s *Server) watchEvery(interval time.Duration) {
+	d := newWatchdog(watchdogSamples)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, name := range d.check(s.health) {
+			logger.Warn("Gauge has grown at every sample, which may mean goroutines or channels are leaking", Fields{"gauge": name, "samples": watchdogSamples, "interval": Duration(interval)})
+		}
+	}
+}
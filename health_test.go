@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+func TestGrowing(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		history []float64
+		want    bool
+	}{
+		{name: "empty", history: nil, want: true},
+		{name: "growing", history: []float64{1, 2, 5}, want: true},
+		{name: "flat", history: []float64{1, 2, 2}, want: false},
+		{name: "shrinking", history: []float64{3, 4, 1}, want: false},
+	}
+
+	for _, tc := range cases {
+		if got := growing(tc.history); got != tc.want {
+			t.Errorf("Wrong result for %s; got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWatchdog_check(t *testing.T) {
+	t.Parallel()
+	var leaking, steady float64
+	gauges := []healthGauge{
+		{opts: prometheus.GaugeOpts{Name: "leaking"}, value: func() float64 { leaking++; return leaking }},
+		{opts: prometheus.GaugeOpts{Name: "steady"}, value: func() float64 { return steady }},
+	}
+
+	d := newWatchdog(3)
+	var warnings []string
+	for i := 0; i < 5; i++ {
+		warnings = append(warnings, d.check(gauges)...)
+	}
+	// The leaking gauge is only warned about once, as soon as it's grown across enough samples
+	if len(warnings) != 1 || warnings[0] != "leaking" {
+		t.Errorf("Wrong gauges warned about; got %v, want [leaking]", warnings)
+	}
+
+	// Once it stops growing, it's warned about again if it starts growing again
+	leaking = -10
+	warnings = d.check(gauges)
+	for i := 0; i < 3; i++ {
+		warnings = append(warnings, d.check(gauges)...)
+	}
+	if len(warnings) != 1 || warnings[0] != "leaking" {
+		t.Errorf("Wrong gauges warned about after recovering; got %v, want [leaking]", warnings)
+	}
+}
+
+func TestServer_healthGauges(t *testing.T) {
+	t.Parallel()
+	s, err := genServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := begin(&s.live.instrument)
+	_ = s.tellFingers.Add("abc")
+	want := map[string]float64{
+		"am_executor_runtime_goroutines{kind=collect}":        0,
+		"am_executor_runtime_goroutines{kind=instrument}":     1,
+		"am_executor_runtime_quit_channels{map=fingerprints}": 1,
+		"am_executor_runtime_quit_channels{map=executions}":   0,
+		"am_executor_runtime_fingerprint_counter_pending":     0,
+	}
+	for _, g := range s.health {
+		if v, ok := want[g.name()]; ok && g.value() != v {
+			t.Errorf("Wrong value of %s; got %v, want %v", g.name(), g.value(), v)
+		}
+	}
+
+	done()
+	for _, g := range s.health {
+		if g.name() == "am_executor_runtime_goroutines{kind=instrument}" && g.value() != 0 {
+			t.Errorf("Wrong value of %s once finished; got %v, want 0", g.name(), g.value())
+		}
+	}
+}
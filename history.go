@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// How many executions are kept in the history, when not configured otherwise
+	defaultHistorySize = 100
+	// How many of the last lines of output are kept for each execution in the history
+	historyOutputLines = 20
+	// How many executions the /history endpoint lists, when not asked for a different limit
+	defaultHistoryLimit = 1000
+)
+
+// HistoryEntry describes a finished execution
+type HistoryEntry struct {
+	// Identifies the execution, as in the execution field of its logged output
+	Execution uint64 `json:"execution"`
+	Command   string `json:"command"`
+	// Version of the command that ran, as in the version field of its logged output
+	Version string `json:"version,omitempty"`
+	// Fingerprint of the alert the command ran for; empty if it didn't run for a particular alert
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Result      string    `json:"result"`
+	// Exit code of the command's last attempt; empty if it didn't exit
+	ExitCode string `json:"exit_code,omitempty"`
+	// The last lines of the command's output
+	Output []string `json:"output,omitempty"`
+}
+
+// History keeps the most recent executions in a ring buffer, so that what happened can be checked without the logs
+type History struct {
+	entries []HistoryEntry
+	// Index that the next entry is written at
+	next int
+	full bool
+	sync.Mutex
+}
+
+// NewHistory returns a History keeping the given number of executions, defaulting to defaultHistorySize.
+// Returns nil if size is negative, meaning executions aren't kept.
+func NewHistory(size int) *History {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultHistorySize
+	}
+	return &History{entries: make([]HistoryEntry, size)}
+}
+
+// Add records a finished execution, replacing the oldest one if the history is full
+func (h *History) Add(e HistoryEntry) {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Entries returns the executions kept, most recent first
+func (h *History) Entries() []HistoryEntry {
+	if h == nil {
+		return []HistoryEntry{}
+	}
+	h.Lock()
+	defer h.Unlock()
+	n := h.next
+	if h.full {
+		n = len(h.entries)
+	}
+	entries := make([]HistoryEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, h.entries[(h.next-i+len(h.entries))%len(h.entries)])
+	}
+	return entries
+}
+
+// HistoryQuery narrows down the executions listed from the history.
+// Empty fields match any execution.
+type HistoryQuery struct {
+	Command     string
+	Fingerprint string
+	// Executions that started at or after Since, and before Until, are matched
+	Since time.Time
+	Until time.Time
+	// How many executions to list at most, defaulting to defaultHistoryLimit
+	Limit int
+}
+
+// ParseHistoryQuery returns the query given by the command, fingerprint, since, until and limit parameters.
+// Times are in RFC 3339 format.
+func ParseHistoryQuery(params url.Values) (HistoryQuery, error) {
+	q := HistoryQuery{Command: params.Get("command"), Fingerprint: params.Get("fingerprint")}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("Invalid %s parameter %q, expected an RFC 3339 time", name, v)
+			}
+			*t = parsed
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("Invalid limit parameter %q, expected a positive integer", v)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// Matches returns true if the execution matches the query, regardless of the limit
+func (q HistoryQuery) Matches(e HistoryEntry) bool {
+	return (q.Command == "" || e.Command == q.Command) &&
+		(q.Fingerprint == "" || e.Fingerprint == q.Fingerprint) &&
+		(q.Since.IsZero() || !e.Started.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Started.Before(q.Until))
+}
+
+// limit returns how many executions to list at most
+func (q HistoryQuery) limit() int {
+	if q.Limit <= 0 {
+		return defaultHistoryLimit
+	}
+	return q.Limit
+}
+
+// Query returns the executions kept that match the query, most recent first
+func (h *History) Query(q HistoryQuery) []HistoryEntry {
+	entries := make([]HistoryEntry, 0)
+	for _, e := range h.Entries() {
+		if len(entries) == q.limit() {
+			break
+		}
+		if q.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// handleHistory lists recent executions for GET requests, most recent first.
+// They can be narrowed down with the query parameters read by ParseHistoryQuery.
+// Executions are listed from the history database when there is one, so that they include ones from before the
+// server last restarted.
+func (s *Server) handleHistory(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := ParseHistoryQuery(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.historyDB == nil {
+		writeJSON(w, s.history.Query(q))
+		return
+	}
+	entries, err := s.historyDB.Query(q)
+	if err != nil {
+		logger.Error("Failed to read execution history", Fields{"error": err})
+		s.errCounter.WithLabelValues(ErrLabelHistory, CmdLabelNone).Inc()
+		http.Error(w, "Failed to read execution history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
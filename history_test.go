@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	cases := []struct {
+		name  string
+		size  int
+		added int
+		want  []uint64
+	}{
+		{name: "empty", size: 3, want: []uint64{}},
+		{name: "partial", size: 3, added: 2, want: []uint64{2, 1}},
+		{name: "full", size: 3, added: 3, want: []uint64{3, 2, 1}},
+		{name: "wrapped", size: 3, added: 5, want: []uint64{5, 4, 3}},
+		{name: "disabled", size: -1, added: 2, want: []uint64{}},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			h := NewHistory(tc.size)
+			for i := 1; i <= tc.added; i++ {
+				h.Add(HistoryEntry{Execution: uint64(i)})
+			}
+			got := make([]uint64, 0)
+			for _, e := range h.Entries() {
+				got = append(got, e.Execution)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Wrong entries; got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("Wrong entries; got %v, want %v", got, tc.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestParseHistoryQuery(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "all", query: "command=beep&fingerprint=boop&since=2020-06-01T00:00:00Z&until=2020-06-02T00:00:00%2B02:00&limit=10"},
+		{name: "bad_since", query: "since=yesterday", wantErr: true},
+		{name: "bad_limit", query: "limit=0", wantErr: true},
+	}
+	for _, tc := range cases {
+		values, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := ParseHistoryQuery(values)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if tc.name == "all" && (q.Command != "beep" || q.Fingerprint != "boop" || q.Limit != 10 || !q.Until.Equal(time.Date(2020, 6, 1, 22, 0, 0, 0, time.UTC))) {
+			t.Errorf("%s: wrong query; got %+v", tc.name, q)
+		}
+	}
+}
+
+func TestServer_handleHistory(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	cmd := &Command{Cmd: "sh", Args: []string{"-c", "echo one; echo two; exit 3"}}
+	out := make(chan CommandResult)
+	go srv.instrument("boop", nil, cmd, cmd.Args, nil, out, nil)
+	for range out {
+	}
+	srv.history.Add(HistoryEntry{Execution: 100, Command: "other", Fingerprint: "beep"})
+
+	cases := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "all", want: 2},
+		{name: "fingerprint", query: "?fingerprint=boop", want: 1},
+		{name: "command", query: "?command=other", want: 1},
+		{name: "no_match", query: "?command=nope", want: 0},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/history"+tc.query, nil)
+		w := httptest.NewRecorder()
+		srv.handleHistory(w, req)
+		var got []HistoryEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: failed to decode history: %v", tc.name, err)
+		}
+		if len(got) != tc.want {
+			t.Errorf("%s: wrong number of entries; got %d, want %d", tc.name, len(got), tc.want)
+		}
+		if tc.name == "fingerprint" && len(got) > 0 && got[0].Version != cmd.Version() {
+			t.Errorf("%s: wrong version; got %q, want %q", tc.name, got[0].Version, cmd.Version())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/history?until=soon", nil)
+	w := httptest.NewRecorder()
+	srv.handleHistory(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid time, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	entries := srv.history.Entries()
+	e := entries[1]
+	if e.Fingerprint != "boop" || e.ExitCode != "3" || e.Result != CmdFail.String() || len(e.Output) != 2 || e.Output[1] != "two" {
+		t.Errorf("Wrong history entry for execution; got %+v", e)
+	}
+	if e.Finished.Before(e.Started) {
+		t.Errorf("Execution finished before it started; got %s to %s", e.Started, e.Finished)
+	}
+}
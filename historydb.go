@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// How long executions are kept in the history database, when not configured otherwise
+	defaultHistoryRetention = Duration(7 * 24 * time.Hour)
+	// How often executions older than the retention period are removed from the history database
+	historyPruneInterval = time.Hour
+	// How long to wait for another process to release its lock on the history database
+	historyDBLockTimeout = 5 * time.Second
+)
+
+const (
+	// Bucket that executions are kept in, keyed by historyKey
+	historyBucket = "executions"
+)
+
+// HistoryDB persists finished executions to a store, so that the history survives restarts.
+// Executions are kept for a retention period, after which they're pruned.
+type HistoryDB struct {
+	store     Store
+	retention time.Duration
+}
+
+// NewHistoryDB opens the history database at the given path, creating it if it doesn't exist.
+// Executions are kept until they started longer ago than the retention period, defaulting to defaultHistoryRetention.
+func NewHistoryDB(path string, retention time.Duration) (*HistoryDB, error) {
+	store, err := NewBoltStore(path)
+	if err != nil {
+		return nil, err
+	}
+	return newHistoryDB(store, retention), nil
+}
+
+// newHistoryDB returns a HistoryDB keeping executions in the given store, for the retention period
+func newHistoryDB(store Store, retention time.Duration) *HistoryDB {
+	if retention <= 0 {
+		retention = time.Duration(defaultHistoryRetention)
+	}
+	return &HistoryDB{store: store, retention: retention}
+}
+
+// historyKey returns the key an execution is stored under.
+// Keys sort by when executions started, and then by execution ID, which is only unique until the server restarts.
+func historyKey(started time.Time, execution uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(started.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], execution)
+	return key
+}
+
+// Add persists a finished execution
+func (h *HistoryDB) Add(e HistoryEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return h.store.Put(historyBucket, historyKey(e.Started, e.Execution), data)
+}
+
+// Query returns the executions matching the query, most recent first
+func (h *HistoryDB) Query(q HistoryQuery) ([]HistoryEntry, error) {
+	entries := make([]HistoryEntry, 0)
+	var since, until []byte
+	if !q.Since.IsZero() {
+		since = historyKey(q.Since, 0)
+	}
+	if !q.Until.IsZero() {
+		until = historyKey(q.Until, 0)
+	}
+	var decodeErr error
+	err := h.store.Scan(historyBucket, since, until, true, func(_ []byte, v []byte) bool {
+		var e HistoryEntry
+		if decodeErr = json.Unmarshal(v, &e); decodeErr != nil {
+			return false
+		}
+		if q.Matches(e) {
+			entries = append(entries, e)
+		}
+		return len(entries) < q.limit()
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	return entries, err
+}
+
+// Prune removes executions that started before the given time, returning how many were removed
+func (h *HistoryDB) Prune(before time.Time) (int, error) {
+	var keys [][]byte
+	err := h.store.Scan(historyBucket, nil, historyKey(before, 0), false, func(k []byte, _ []byte) bool {
+		keys = append(keys, append([]byte(nil), k...))
+		return true
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	if err := h.store.Delete(historyBucket, keys...); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// Close closes the store that executions are kept in. It's safe to call on a nil HistoryDB.
+func (h *HistoryDB) Close() error {
+	if h == nil {
+		return nil
+	}
+	return h.store.Close()
+}
+
+// OpenHistoryDB persists finished executions in the database at the given path,
+// so that the /history endpoint can list executions from before the server was last restarted.
+func (s *Server) OpenHistoryDB(path string) error {
+	db, err := NewHistoryDB(path, time.Duration(s.config.HistoryRetention))
+	if err != nil {
+		return err
+	}
+	s.historyDB = db
+	return nil
+}
+
+// persistHistory adds a finished execution to the history database, if there is one
+func (s *Server) persistHistory(e HistoryEntry) {
+	if s.historyDB == nil {
+		return
+	}
+	if err := s.historyDB.Add(e); err != nil {
+		logger.Error("Failed to persist execution history", Fields{"execution": e.Execution, "error": err})
+		s.errCounter.WithLabelValues(ErrLabelHistory, CmdLabelNone).Inc()
+	}
+}
+
+// pruneHistory removes executions older than the retention period from the history database
+func (s *Server) pruneHistory() {
+	removed, err := s.historyDB.Prune(time.Now().Add(-s.historyDB.retention))
+	if err != nil {
+		logger.Error("Failed to prune execution history", Fields{"error": err})
+		s.errCounter.WithLabelValues(ErrLabelHistory, CmdLabelNone).Inc()
+	} else if removed > 0 {
+		logger.Debug("Pruned execution history", Fields{"removed": removed, "retention": s.historyDB.retention})
+	}
+}
+
+// pruneHistoryEvery prunes the history database now, and then at the given interval
+func (s *Server) pruneHistoryEvery(interval time.Duration) {
+	s.pruneHistory()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.pruneHistory()
+	}
+}
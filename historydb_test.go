@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tempHistoryDB returns a HistoryDB in a new temporary directory, and a function to close and remove it
+func tempHistoryDB(t *testing.T) (*HistoryDB, func()) {
+	dir, err := ioutil.TempDir("", "am-executor_history-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHistoryDB(filepath.Join(dir, "history.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, func() {
+		_ = h.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestHistoryDB_Query(t *testing.T) {
+	t.Parallel()
+	h, cleanup := tempHistoryDB(t)
+	defer cleanup()
+
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		e := HistoryEntry{Execution: uint64(i), Command: "beep", Fingerprint: "boop", Started: start.Add(time.Duration(i) * time.Hour)}
+		if i%2 == 0 {
+			e.Command = "other"
+		}
+		if err := h.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		query HistoryQuery
+		want  []uint64
+	}{
+		{name: "all", want: []uint64{5, 4, 3, 2, 1}},
+		{name: "command", query: HistoryQuery{Command: "other"}, want: []uint64{4, 2}},
+		{name: "fingerprint", query: HistoryQuery{Fingerprint: "nope"}, want: []uint64{}},
+		{name: "since", query: HistoryQuery{Since: start.Add(3 * time.Hour)}, want: []uint64{5, 4, 3}},
+		{name: "until", query: HistoryQuery{Until: start.Add(3 * time.Hour)}, want: []uint64{2, 1}},
+		{name: "range", query: HistoryQuery{Since: start.Add(2 * time.Hour), Until: start.Add(4*time.Hour + time.Minute)}, want: []uint64{4, 3, 2}},
+		{name: "after_last", query: HistoryQuery{Until: start.Add(24 * time.Hour)}, want: []uint64{5, 4, 3, 2, 1}},
+		{name: "limit", query: HistoryQuery{Command: "beep", Limit: 2}, want: []uint64{5, 3}},
+	}
+	for _, tc := range cases {
+		entries, err := h.Query(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := make([]uint64, 0, len(entries))
+		for _, e := range entries {
+			got = append(got, e.Execution)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: wrong entries; got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: wrong entries; got %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHistoryDB_Prune(t *testing.T) {
+	t.Parallel()
+	h, cleanup := tempHistoryDB(t)
+	defer cleanup()
+
+	now := time.Now()
+	for i := 1; i <= 4; i++ {
+		if err := h.Add(HistoryEntry{Execution: uint64(i), Started: now.Add(-time.Duration(i) * 24 * time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed, err := h.Prune(now.Add(-36 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 executions to be pruned, got %d", removed)
+	}
+	entries, err := h.Query(HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Execution != 1 {
+		t.Errorf("Expected only the most recent execution to remain, got %+v", entries)
+	}
+}
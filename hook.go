@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// Most Starlark computation steps that a decision hook can take in each call, so that a runaway script is stopped
+	hookMaxSteps = 1000000
+	// How long a decision hook can run for in each call, when decision_hook_timeout isn't set
+	defaultHookTimeout = time.Second
+)
+
+// DecisionHook is a Starlark script that decides which of the commands matching an alert message run, and in what
+// order, for policies that the config can't express. The script defines a function
+//
+//	def decide(payload, commands, state):
+//
+// which returns the commands to run, out of those it was given. Commands it leaves out are vetoed.
+// The script is stopped if it takes more than hookMaxSteps steps or runs longer than its timeout,
+// since it's called while alertmanager waits for the webhook to be handled.
+type DecisionHook struct {
+	path    string
+	timeout time.Duration
+	decide  starlark.Callable
+}
+
+// HookState is the runtime state that decision hooks are given along with the alert message
+type HookState struct {
+	Now time.Time
+	// How many executions of each command are running, by command name
+	Running          map[string]int
+	Disabled         []string
+	PendingApprovals int
+}
+
+// LoadDecisionHook reads the Starlark script at the given path, and checks that it defines a decide function.
+// Each call of the script is stopped after the given timeout, or defaultHookTimeout if it's zero.
+func LoadDecisionHook(path string, timeout time.Duration) (*DecisionHook, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	h := &DecisionHook{path: path, timeout: timeout}
+	thread, stop := h.thread()
+	defer stop()
+	globals, err := starlark.ExecFile(thread, path, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	decide, ok := globals["decide"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't define a decide function", path)
+	}
+	h.decide = decide
+	return h, nil
+}
+
+// thread returns a Starlark thread to run the script in, which is cancelled once the hook's timeout passes.
+// The returned function must be called once the thread is finished with.
+func (h *DecisionHook) thread() (*starlark.Thread, func()) {
+	thread := &starlark.Thread{Name: h.path, Print: hookPrint}
+	thread.SetMaxExecutionSteps(hookMaxSteps)
+	timer := time.AfterFunc(h.timeout, func() {
+		thread.Cancel(fmt.Sprintf("decision hook timed out after %s", h.timeout))
+	})
+	return thread, func() { timer.Stop() }
+}
+
+// Decide calls the script's decide function, returning the commands it chose in the order it returned them
+func (h *DecisionHook) Decide(amMsg *template.Data, commands []*Command, state HookState) ([]*Command, error) {
+	values := make([]starlark.Value, len(commands))
+	for i, cmd := range commands {
+		values[i] = starlarkstruct.FromStringDict(starlark.String("command"), starlark.StringDict{
+			"name": starlark.String(cmd.MetricLabel()),
+			"cmd":  starlark.String(cmd.Cmd),
+			"args": stringList(cmd.Args),
+		})
+	}
+
+	thread, stop := h.thread()
+	defer stop()
+	args := starlark.Tuple{hookPayload(amMsg), starlark.NewList(values), hookState(state)}
+	result, err := starlark.Call(thread, h.decide, args, nil)
+	if err != nil {
+		return nil, err
+	}
+	iterable, ok := result.(starlark.Iterable)
+	if !ok {
+		return nil, fmt.Errorf("decide returned a %s, instead of a list of commands", result.Type())
+	}
+
+	var chosen []*Command
+	seen := make(map[*Command]bool)
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var v starlark.Value
+	for iter.Next(&v) {
+		cmd := hookCommand(v, values, commands)
+		if cmd == nil {
+			return nil, fmt.Errorf("decide returned %s, which isn't one of the commands it was given", v)
+		}
+		if !seen[cmd] {
+			seen[cmd] = true
+			chosen = append(chosen, cmd)
+		}
+	}
+	return chosen, nil
+}
+
+// hookCommand returns the command that the given value was made for, or nil if it's not one of the values
+func hookCommand(v starlark.Value, values []starlark.Value, commands []*Command) *Command {
+	for i, value := range values {
+		if v == value {
+			return commands[i]
+		}
+	}
+	return nil
+}
+
+// hookPayload returns the alert message as a Starlark struct, with the same field names as the webhook payload
+func hookPayload(amMsg *template.Data) starlark.Value {
+	alerts := make([]starlark.Value, len(amMsg.Alerts))
+	for i, alert := range amMsg.Alerts {
+		alerts[i] = starlarkstruct.FromStringDict(starlark.String("alert"), starlark.StringDict{
+			"status":        starlark.String(alert.Status),
+			"labels":        stringDict(alert.Labels),
+			"annotations":   stringDict(alert.Annotations),
+			"starts_at":     hookTime(alert.StartsAt),
+			"ends_at":       hookTime(alert.EndsAt),
+			"generator_url": starlark.String(alert.GeneratorURL),
+			"fingerprint":   starlark.String(alert.Fingerprint),
+		})
+	}
+	return starlarkstruct.FromStringDict(starlark.String("payload"), starlark.StringDict{
+		"status":             starlark.String(amMsg.Status),
+		"receiver":           starlark.String(amMsg.Receiver),
+		"external_url":       starlark.String(amMsg.ExternalURL),
+		"group_labels":       stringDict(amMsg.GroupLabels),
+		"common_labels":      stringDict(amMsg.CommonLabels),
+		"common_annotations": stringDict(amMsg.CommonAnnotations),
+		"alerts":             starlark.NewList(alerts),
+	})
+}
+
+// hookState returns the runtime state as a Starlark struct
+func hookState(state HookState) starlark.Value {
+	running := starlark.NewDict(len(state.Running))
+	var total int
+	for name, n := range state.Running {
+		_ = running.SetKey(starlark.String(name), starlark.MakeInt(n))
+		total += n
+	}
+	return starlarkstruct.FromStringDict(starlark.String("state"), starlark.StringDict{
+		"now":               hookTime(state.Now),
+		"running":           running,
+		"running_total":     starlark.MakeInt(total),
+		"disabled":          stringList(state.Disabled),
+		"pending_approvals": starlark.MakeInt(state.PendingApprovals),
+	})
+}
+
+// hookTime returns a time as seconds since the unix epoch, or 0 for the zero time
+func hookTime(t time.Time) starlark.Value {
+	if t.IsZero() {
+		return starlark.MakeInt(0)
+	}
+	return starlark.MakeInt64(t.Unix())
+}
+
+// stringDict returns a Starlark dict with the keys of the given map in sorted order
+func stringDict(kv template.KV) *starlark.Dict {
+	d := starlark.NewDict(len(kv))
+	for _, k := range kv.SortedPairs().Names() {
+		_ = d.SetKey(starlark.String(k), starlark.String(kv[k]))
+	}
+	return d
+}
+
+// stringList returns a Starlark list of the given strings
+func stringList(values []string) *starlark.List {
+	list := make([]starlark.Value, len(values))
+	for i, v := range values {
+		list[i] = starlark.String(v)
+	}
+	return starlark.NewList(list)
+}
+
+// hookPrint logs what decision hooks print, so that scripts can be debugged
+func hookPrint(thread *starlark.Thread, msg string) {
+	logger.Debug(msg, Fields{"decision_hook": thread.Name})
+}
+
+// LoadDecisionHook loads the decision hook at the given path, which decides which commands run for each alert message
+func (s *Server) LoadDecisionHook(path string) error {
+	hook, err := LoadDecisionHook(path, time.Duration(s.config.DecisionHookTimeout))
+	if err != nil {
+		return err
+	}
+	s.decisionHook = hook
+	return nil
+}
+
+// decide asks the decision hook which of the commands to run for an alert message, returning them in the order the
+// hook chose, followed by the commands that it vetoed, and those that don't match the message at all.
+// If the hook fails, the commands run as configured.
+func (s *Server) decide(amMsg *template.Data, commands []*Command) ([]*Command, map[*Command]bool) {
+	// The hook only decides between commands that would otherwise be considered for the message
+	var matching []*Command
+	for _, cmd := range commands {
+		if cmd.Matches(amMsg) {
+			matching = append(matching, cmd)
+		}
+	}
+	if len(matching) == 0 {
+		return commands, nil
+	}
+
+	running := make(map[string]int)
+	for _, m := range collectMetrics(s.processCurrent) {
+		n := int(m.GetGauge().GetValue())
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "command" && n > 0 {
+				running[l.GetValue()] = n
+			}
+		}
+	}
+	state := HookState{
+		Now:              time.Now(),
+		Running:          running,
+		Disabled:         s.disabled.Names(),
+		PendingApprovals: len(s.pending.List()),
+	}
+	chosen, err := s.decisionHook.Decide(amMsg, matching, state)
+	if err != nil {
+		logger.Error("Decision hook failed, running commands as configured", Fields{"decision_hook": s.decisionHook.path, "error": err})
+		s.errCounter.WithLabelValues(ErrLabelHook, CmdLabelNone).Inc()
+		return commands, nil
+	}
+
+	picked := make(map[*Command]bool, len(chosen))
+	for _, cmd := range chosen {
+		picked[cmd] = true
+	}
+	vetoed := make(map[*Command]bool)
+	ordered := chosen
+	for _, cmd := range commands {
+		if picked[cmd] {
+			continue
+		}
+		if cmd.Matches(amMsg) {
+			vetoed[cmd] = true
+			s.decision(cmd, "Decision hook vetoed command", Fields{"command": cmd, "decision_hook": s.decisionHook.path})
+		}
+		ordered = append(ordered, cmd)
+	}
+	return ordered, vetoed
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeHook writes a decision hook script to a temporary directory, returning its path
+func writeHook(t *testing.T, dir string, name string, src string) string {
+	t.Helper()
+	path := filepath.Join(dir, name+".star")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDecisionHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "am-executor-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name string
+		src  string
+		ok   bool
+	}{
+		{name: "decide", src: "def decide(payload, commands, state):\n    return commands\n", ok: true},
+		{name: "missing", src: "def choose(payload, commands, state):\n    return commands\n", ok: false},
+		{name: "not_function", src: "decide = 1\n", ok: false},
+		{name: "syntax", src: "def decide(payload, commands, state)\n    return commands\n", ok: false},
+	}
+
+	for _, tc := range cases {
+		_, err := LoadDecisionHook(writeHook(t, dir, tc.name, tc.src), 0)
+		if (err == nil) != tc.ok {
+			t.Errorf("Wrong result loading %s; got %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+	if _, err := LoadDecisionHook(filepath.Join(dir, "nonexistent.star"), 0); err == nil {
+		t.Error("Expected an error loading a hook that doesn't exist")
+	}
+}
+
+func TestDecisionHook_Decide(t *testing.T) {
+	dir, err := ioutil.TempDir("", "am-executor-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	page := &Command{Cmd: "page", Name: "page"}
+	restart := &Command{Cmd: "restart", Name: "restart", Args: []string{"db"}}
+	commands := []*Command{restart, page}
+	amMsg := &template.Data{
+		Status:       "firing",
+		CommonLabels: template.KV{"env": "prod"},
+		Alerts: template.Alerts{
+			{Status: "firing", Labels: template.KV{"env": "prod", "severity": "critical"}, Fingerprint: "boop"},
+		},
+	}
+	state := HookState{Now: time.Now(), Running: map[string]int{"restart": 2}, Disabled: []string{"failover"}}
+
+	cases := []struct {
+		name string
+		src  string
+		want []*Command
+		ok   bool
+	}{
+		{
+			name: "all",
+			src:  "def decide(payload, commands, state):\n    return commands\n",
+			want: []*Command{restart, page},
+			ok:   true,
+		},
+		{
+			name: "reorder",
+			src:  "def name(c):\n    return c.name\n\ndef decide(payload, commands, state):\n    return sorted(commands, key = name)\n",
+			want: []*Command{page, restart},
+			ok:   true,
+		},
+		{
+			name: "veto_running",
+			src:  "def decide(payload, commands, state):\n    return [c for c in commands if state.running.get(c.name, 0) == 0]\n",
+			want: []*Command{page},
+			ok:   true,
+		},
+		{
+			name: "payload",
+			src: "def decide(payload, commands, state):\n" +
+				"    if payload.alerts[0].labels['severity'] == 'critical' and payload.common_labels['env'] == 'prod':\n" +
+				"        return [c for c in commands if c.args == ['db']]\n" +
+				"    return []\n",
+			want: []*Command{restart},
+			ok:   true,
+		},
+		{
+			name: "state",
+			src:  "def decide(payload, commands, state):\n    return commands if state.disabled == ['failover'] and state.running_total == 2 and state.now > 0 else []\n",
+			want: []*Command{restart, page},
+			ok:   true,
+		},
+		{
+			name: "duplicates",
+			src:  "def decide(payload, commands, state):\n    return [commands[1], commands[1]]\n",
+			want: []*Command{page},
+			ok:   true,
+		},
+		{
+			name: "none",
+			src:  "def decide(payload, commands, state):\n    return []\n",
+			want: nil,
+			ok:   true,
+		},
+		{
+			name: "unknown_command",
+			src:  "def decide(payload, commands, state):\n    return ['page']\n",
+			ok:   false,
+		},
+		{
+			name: "not_list",
+			src:  "def decide(payload, commands, state):\n    return None\n",
+			ok:   false,
+		},
+		{
+			name: "fails",
+			src:  "def decide(payload, commands, state):\n    return payload.alerts[5]\n",
+			ok:   false,
+		},
+		{
+			name: "runaway",
+			src:  "def decide(payload, commands, state):\n    for i in range(100000000):\n        pass\n    return commands\n",
+			ok:   false,
+		},
+	}
+
+	for _, tc := range cases {
+		hook, err := LoadDecisionHook(writeHook(t, dir, tc.name, tc.src), 0)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", tc.name, err)
+		}
+		got, err := hook.Decide(amMsg, commands, state)
+		if (err == nil) != tc.ok {
+			t.Errorf("Wrong result for %s; got %v, want ok %v", tc.name, err, tc.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Wrong commands for %s; got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDecisionHook_DecideTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "am-executor-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Sorting a long list takes few steps, so the script runs until its timeout stops it
+	src := "def decide(payload, commands, state):\n    for i in range(100):\n        sorted(range(1000000))\n    return commands\n"
+	hook, err := LoadDecisionHook(writeHook(t, dir, "slow", src), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := hook.Decide(&template.Data{}, []*Command{{Cmd: "page"}}, HookState{}); err == nil {
+		t.Error("Expected an error from a hook that runs past its timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Hook wasn't stopped at its timeout; took %s", elapsed)
+	}
+}
+
+func TestServer_runCommands_decisionHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "am-executor-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name   string
+		src    string
+		vetoed float64
+		errors float64
+	}{
+		{
+			name:   "veto",
+			src:    "def decide(payload, commands, state):\n    return [c for c in commands if c.name != 'vetoed']\n",
+			vetoed: 1,
+		},
+		{
+			name:   "fails",
+			src:    "def decide(payload, commands, state):\n    fail('no')\n",
+			errors: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		srv, err := genServer()
+		if err != nil {
+			t.Fatal("Failed to generate server")
+		}
+		vetoed := &Command{Cmd: "true", Name: "vetoed"}
+		srv.config.Commands = []*Command{{Cmd: "true", Name: "chosen"}, vetoed}
+		if err := srv.LoadDecisionHook(writeHook(t, dir, tc.name, tc.src)); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = srv.runCommands(&template.Data{
+			Status: "firing",
+			Alerts: template.Alerts{{Status: "firing", Fingerprint: "boop", StartsAt: time.Now()}},
+		}, "", nil, nil)
+
+		var m pm.Metric
+		if err := srv.skipCounter.WithLabelValues(CmdRunVetoed.Label(), vetoed.MetricLabel()).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetCounter().GetValue(); got != tc.vetoed {
+			t.Errorf("Wrong number of vetoed executions for %s; got %v, want %v", tc.name, got, tc.vetoed)
+		}
+		if err := srv.errCounter.WithLabelValues(ErrLabelHook, CmdLabelNone).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetCounter().GetValue(); got != tc.errors {
+			t.Errorf("Wrong number of hook errors for %s; got %v, want %v", tc.name, got, tc.errors)
+		}
+	}
+}
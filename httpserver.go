@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// How long clients have to send the headers of a request, when not configured otherwise
+	defaultReadHeaderTimeout = Duration(10 * time.Second)
+	// How long idle keep-alive connections are kept open, when not configured otherwise
+	defaultIdleTimeout = Duration(2 * time.Minute)
+)
+
+// HTTPServerConfig configures the timeouts of the HTTP server, which keep slow or idle clients from holding on to
+// connections indefinitely
+type HTTPServerConfig struct {
+	// How long clients have to send a whole request, including its body
+	ReadTimeout Duration `yaml:"read_timeout"`
+	// How long clients have to send the headers of a request. Defaults to 10 seconds.
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout"`
+	// How long the server has to write a response, from when the request's headers were read.
+	// Webhook requests are only responded to once their commands finish, unless sync_timeout is set,
+	// so this isn't set by default.
+	WriteTimeout Duration `yaml:"write_timeout"`
+	// How long keep-alive connections are kept open while waiting for the next request. Defaults to 2 minutes.
+	IdleTimeout Duration `yaml:"idle_timeout"`
+}
+
+// setDefaults fills in the timeouts that have defaults and aren't configured
+func (c *HTTPServerConfig) setDefaults() {
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = defaultIdleTimeout
+	}
+}
+
+// Validate returns an error if the timeouts can't be used with the given sync_timeout
+func (c HTTPServerConfig) Validate(syncTimeout Duration) error {
+	for _, t := range []struct {
+		name    string
+		timeout Duration
+	}{
+		{"read_timeout", c.ReadTimeout},
+		{"read_header_timeout", c.ReadHeaderTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+	} {
+		if t.timeout < 0 {
+			return fmt.Errorf("%s %s is negative", t.name, t.timeout)
+		}
+	}
+	if c.WriteTimeout > 0 && syncTimeout > 0 && c.WriteTimeout <= syncTimeout {
+		return fmt.Errorf("write_timeout %s isn't longer than sync_timeout %s, so webhook requests could be cut off before they're responded to", c.WriteTimeout, syncTimeout)
+	}
+	return nil
+}
+
+// apply sets the timeouts of an HTTP server
+func (c HTTPServerConfig) apply(srv *http.Server) {
+	srv.ReadTimeout = time.Duration(c.ReadTimeout)
+	srv.ReadHeaderTimeout = time.Duration(c.ReadHeaderTimeout)
+	srv.WriteTimeout = time.Duration(c.WriteTimeout)
+	srv.IdleTimeout = time.Duration(c.IdleTimeout)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTPServerConfig_Validate(t *testing.T) {
+	cases := []struct {
+		name        string
+		config      HTTPServerConfig
+		syncTimeout Duration
+		ok          bool
+	}{
+		{name: "unset", ok: true},
+		{name: "all", config: HTTPServerConfig{ReadTimeout: Duration(time.Minute), ReadHeaderTimeout: Duration(5 * time.Second), WriteTimeout: Duration(time.Minute), IdleTimeout: Duration(time.Minute)}, ok: true},
+		{name: "negative", config: HTTPServerConfig{IdleTimeout: Duration(-time.Second)}, ok: false},
+		{name: "longer_than_sync_timeout", config: HTTPServerConfig{WriteTimeout: Duration(time.Minute)}, syncTimeout: Duration(30 * time.Second), ok: true},
+		{name: "within_sync_timeout", config: HTTPServerConfig{WriteTimeout: Duration(30 * time.Second)}, syncTimeout: Duration(30 * time.Second), ok: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.config.Validate(tc.syncTimeout)
+			if ok := err == nil; ok != tc.ok {
+				t.Errorf("Wrong result validating %+v; got %v, want ok %v", tc.config, err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestHTTPServerConfig_apply(t *testing.T) {
+	t.Parallel()
+	c := HTTPServerConfig{ReadTimeout: Duration(time.Minute), WriteTimeout: Duration(2 * time.Minute)}
+	c.setDefaults()
+	var srv http.Server
+	c.apply(&srv)
+	if srv.ReadTimeout != time.Minute || srv.WriteTimeout != 2*time.Minute {
+		t.Errorf("Wrong configured timeouts; got read %s, write %s", srv.ReadTimeout, srv.WriteTimeout)
+	}
+	if srv.ReadHeaderTimeout != time.Duration(defaultReadHeaderTimeout) || srv.IdleTimeout != time.Duration(defaultIdleTimeout) {
+		t.Errorf("Wrong default timeouts; got read header %s, idle %s", srv.ReadHeaderTimeout, srv.IdleTimeout)
+	}
+}
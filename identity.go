@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Environment variables that the pod's identity is read from, which the downward API sets with fieldRefs to
+	// metadata.name, metadata.namespace, spec.nodeName and status.podIP
+	podNameEnv      = "POD_NAME"
+	podNamespaceEnv = "POD_NAMESPACE"
+	nodeNameEnv     = "NODE_NAME"
+	podIPEnv        = "POD_IP"
+
+	// Files in a downward API volume that the pod's name and namespace are read from, when their environment
+	// variables aren't set. Volumes can't expose the node name or pod IP, so those are only read from the environment.
+	podNameFile      = "name"
+	podNamespaceFile = "namespace"
+)
+
+var identityInfoOpts = prometheus.GaugeOpts{
+	Namespace: metricNamespace,
+	Name:      "pod_info",
+	Help:      "Always 1, with labels identifying the Kubernetes pod that the executor runs in.",
+}
+
+// KubernetesConfig configures how an executor running in a Kubernetes pod, such as a sidecar, finds out which pod
+// it is
+type KubernetesConfig struct {
+	// Directory that a downward API volume is mounted at, with the pod's name and namespace in files named name and
+	// namespace. Environment variables set through the downward API are used instead when they're set.
+	DownwardAPIDir string `yaml:"downward_api_dir"`
+}
+
+// Identity identifies the Kubernetes pod that the executor runs in, so that executors running as sidecars of
+// different pods can be told apart. Fields are empty when they aren't known, such as when not running in Kubernetes.
+type Identity struct {
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Node      string `json:"node,omitempty"`
+	PodIP     string `json:"pod_ip,omitempty"`
+}
+
+// ReadIdentity returns the identity of the pod that the executor runs in, from the environment variables that getenv
+// looks up, falling back to the files of the configured downward API volume
+func ReadIdentity(c KubernetesConfig, getenv func(string) string) (Identity, error) {
+	id := Identity{
+		Pod:       getenv(podNameEnv),
+		Namespace: getenv(podNamespaceEnv),
+		Node:      getenv(nodeNameEnv),
+		PodIP:     getenv(podIPEnv),
+	}
+	if c.DownwardAPIDir == "" {
+		return id, nil
+	}
+
+	for file, value := range map[string]*string{podNameFile: &id.Pod, podNamespaceFile: &id.Namespace} {
+		if *value != "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(c.DownwardAPIDir, file))
+		if os.IsNotExist(err) {
+			// The volume doesn't have to expose every field
+			continue
+		}
+		if err != nil {
+			return Identity{}, fmt.Errorf("failed to read downward API file: %w", err)
+		}
+		*value = strings.TrimSpace(string(data))
+	}
+	return id, nil
+}
+
+// Empty returns true if nothing is known about the pod
+func (i Identity) Empty() bool {
+	return i == Identity{}
+}
+
+// Fields returns the known parts of the identity, to be attached to log entries
+func (i Identity) Fields() Fields {
+	fields := Fields{}
+	for k, v := range i.labels() {
+		fields[k] = v
+	}
+	return fields
+}
+
+// labels returns the known parts of the identity as metric labels
+func (i Identity) labels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	for k, v := range map[string]string{"pod": i.Pod, "namespace": i.Namespace, "node": i.Node, "pod_ip": i.PodIP} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// Env returns the known parts of the identity as environment variables of commands, so that commands can act on the
+// executor's own pod or node
+func (i Identity) Env() []string {
+	var env []string
+	for _, v := range []struct {
+		name  string
+		value string
+	}{
+		{"AMX_POD_NAME", i.Pod},
+		{"AMX_POD_NAMESPACE", i.Namespace},
+		{"AMX_NODE_NAME", i.Node},
+		{"AMX_POD_IP", i.PodIP},
+	} {
+		if v.value != "" {
+			env = append(env, v.name+"="+v.value)
+		}
+	}
+	return env
+}
+
+// registerIdentity registers a metric identifying the executor's pod, if the executor knows which pod it runs in
+func (s *Server) registerIdentity() {
+	if s.identity.Empty() {
+		return
+	}
+	opts := identityInfoOpts
+	opts.ConstLabels = s.identity.labels()
+	s.registry.MustRegister(prometheus.NewGaugeFunc(opts, func() float64 {
+		return 1
+	}))
+}
+
+// SetIdentity records which pod the executor runs in, which is attached to its metrics, heartbeats and the
+// environment of the commands it runs
+func (s *Server) SetIdentity(id Identity) {
+	s.identity = id
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "am-executor-downward-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	if err := ioutil.WriteFile(filepath.Join(dir, podNameFile), []byte("web-0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, podNamespaceFile), []byte("shop"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		config KubernetesConfig
+		env    map[string]string
+		want   Identity
+	}{
+		{name: "none", want: Identity{}},
+		{
+			name: "env",
+			env:  map[string]string{podNameEnv: "api-1", podNamespaceEnv: "prod", nodeNameEnv: "node-a", podIPEnv: "10.0.0.5"},
+			want: Identity{Pod: "api-1", Namespace: "prod", Node: "node-a", PodIP: "10.0.0.5"},
+		},
+		{
+			name:   "files",
+			config: KubernetesConfig{DownwardAPIDir: dir},
+			env:    map[string]string{nodeNameEnv: "node-a"},
+			want:   Identity{Pod: "web-0", Namespace: "shop", Node: "node-a"},
+		},
+		{
+			name:   "env over files",
+			config: KubernetesConfig{DownwardAPIDir: dir},
+			env:    map[string]string{podNameEnv: "api-1"},
+			want:   Identity{Pod: "api-1", Namespace: "shop"},
+		},
+		{
+			name:   "missing files",
+			config: KubernetesConfig{DownwardAPIDir: filepath.Join(dir, "missing")},
+			env:    map[string]string{podNameEnv: "api-1"},
+			want:   Identity{Pod: "api-1"},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ReadIdentity(tc.config, func(name string) string { return tc.env[name] })
+			if err != nil {
+				t.Fatalf("Failed to read identity: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Wrong identity; got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIdentity_Env(t *testing.T) {
+	t.Parallel()
+	id := Identity{Pod: "web-0", Node: "node-a"}
+	want := []string{"AMX_POD_NAME=web-0", "AMX_NODE_NAME=node-a"}
+	if got := id.Env(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong environment; got %v, want %v", got, want)
+	}
+	if got := (Identity{}).Env(); len(got) != 0 {
+		t.Errorf("Expected no environment for an unknown identity; got %v", got)
+	}
+}
+
+func TestServer_heartbeatIdentity(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	if hb := srv.heartbeat(); hb.Pod != nil {
+		t.Errorf("Expected no pod in heartbeat outside Kubernetes; got %+v", hb.Pod)
+	}
+
+	id := Identity{Pod: "web-0", Namespace: "shop", Node: "node-a"}
+	srv.SetIdentity(id)
+	hb := srv.heartbeat()
+	if hb.Name != "shop/web-0" {
+		t.Errorf("Wrong name in heartbeat; got %q, want %q", hb.Name, "shop/web-0")
+	}
+	if hb.Pod == nil || *hb.Pod != id {
+		t.Errorf("Wrong pod in heartbeat; got %+v, want %+v", hb.Pod, id)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often directories are cleaned up, when janitor's interval isn't set
+	defaultJanitorInterval = Duration(10 * time.Minute)
+)
+
+var (
+	// Prefixes of the files that the executor creates in the system's temporary directory
+	tempFilePrefixes = []string{"am-executor-payload-", "am-executor-metrics-"}
+
+	janitorFilesOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "janitor",
+		Name:      "removed_files_total",
+		Help:      "Total number of files removed from directories the executor writes to, because they exceeded the janitor's retention.",
+	}
+	janitorBytesOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "janitor",
+		Name:      "reclaimed_bytes_total",
+		Help:      "Total size of the files removed from directories the executor writes to, because they exceeded the janitor's retention.",
+	}
+	janitorLabels = []string{"directory"}
+)
+
+// JanitorConfig configures removing old files from the directories the executor writes to: its files in the system's
+// temporary directory, and any scratch directories of commands, so that long-running deployments don't fill their
+// disks. The spool is left alone, since it holds payloads that haven't been processed yet. Files are removed once
+// they're older than max_age, and then oldest first until each directory fits in max_size.
+type JanitorConfig struct {
+	// How often to clean up. Defaults to 10 minutes.
+	Interval Duration `yaml:"interval"`
+	// How long files are kept for. Files aren't removed for their age if this isn't set.
+	MaxAge Duration `yaml:"max_age"`
+	// How large each directory can grow. Files aren't removed for size if this isn't set.
+	MaxSize ByteSize `yaml:"max_size"`
+	// Further directories to clean up, such as ones commands write scratch files to. All files in them are subject
+	// to retention, including in subdirectories.
+	Dirs []string `yaml:"dirs"`
+}
+
+// Enabled returns true if files are removed from directories
+func (c JanitorConfig) Enabled() bool {
+	return c.MaxAge > 0 || c.MaxSize > 0
+}
+
+// Validate returns an error if the janitor's settings can't be used
+func (c JanitorConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval is negative: %s", c.Interval)
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age is negative: %s", c.MaxAge)
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("max_size is negative: %s", c.MaxSize)
+	}
+	if !c.Enabled() && (c.Interval != 0 || len(c.Dirs) > 0) {
+		return fmt.Errorf("neither max_age nor max_size is set")
+	}
+	for _, dir := range c.Dirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("directory %q isn't an absolute path", dir)
+		}
+	}
+	return nil
+}
+
+// TempFiles tracks the files that the executor created in the system's temporary directory and is still using, such as
+// the payload files of executions waiting in a queue, so that the janitor doesn't remove them however old they are
+type TempFiles struct {
+	paths map[string]bool
+	sync.Mutex
+}
+
+// NewTempFiles returns a TempFiles instance, with no files in use
+func NewTempFiles() *TempFiles {
+	return &TempFiles{paths: make(map[string]bool)}
+}
+
+// Create creates a file in the system's temporary directory, as ioutil.TempFile does, and tracks it until it's removed
+func (t *TempFiles) Create(pattern string) (*os.File, error) {
+	// The file is created while locked, so that the janitor can't find it before it's tracked
+	t.Lock()
+	defer t.Unlock()
+	f, err := ioutil.TempFile("", pattern)
+	if err == nil {
+		t.paths[f.Name()] = true
+	}
+	return f, err
+}
+
+// Remove removes a file that was created with Create, and stops tracking it
+func (t *TempFiles) Remove(path string) {
+	t.Lock()
+	defer t.Unlock()
+	_ = os.Remove(path)
+	delete(t.paths, path)
+}
+
+// InUse returns true if the file at the given path was created with Create, and hasn't been removed since
+func (t *TempFiles) InUse(path string) bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.paths[path]
+}
+
+// janitorDir is a directory that the janitor cleans up
+type janitorDir struct {
+	path string
+	// Only files directly in the directory whose names start with one of these are cleaned up, if set.
+	// Otherwise, all files in the directory and its subdirectories are.
+	prefixes []string
+	// Subdirectories that are left alone, such as the spool when it's inside a directory that's cleaned up
+	exclude []string
+	// Returns true for files that are still in use, which are left alone; all files are subject to retention if nil
+	inUse func(path string) bool
+}
+
+// janitorFile is a file that the janitor may remove
+type janitorFile struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// files returns the files in the directory that are subject to retention, oldest first
+func (d janitorDir) files() ([]janitorFile, error) {
+	var files []janitorFile
+	err := filepath.Walk(d.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if path != d.path && len(d.prefixes) > 0 || d.excludes(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !d.matches(info.Name()) || d.inUse != nil && d.inUse(path) {
+			return nil
+		}
+		files = append(files, janitorFile{path: path, size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modified.Before(files[j].modified)
+	})
+	return files, err
+}
+
+// matches returns true if a file with the given name is subject to retention
+func (d janitorDir) matches(name string) bool {
+	if len(d.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range d.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludes returns true if the directory at the given path is left alone
+func (d janitorDir) excludes(path string) bool {
+	for _, dir := range d.exclude {
+		if filepath.Clean(dir) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep removes the files in a directory that are older than maxAge, and then the oldest files until the rest fit in
+// maxSize, returning how many files were removed and their total size. Limits that aren't positive aren't applied.
+func (d janitorDir) sweep(maxAge time.Duration, maxSize ByteSize, now time.Time) (int, int64, error) {
+	files, err := d.files()
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	removed, reclaimed := 0, int64(0)
+	for _, f := range files {
+		expired := maxAge > 0 && now.Sub(f.modified) > maxAge
+		oversized := maxSize > 0 && total > int64(maxSize)
+		if !expired && !oversized {
+			// Files are oldest first, so none of the rest are expired either
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return removed, reclaimed, err
+		}
+		removed++
+		reclaimed += f.size
+		total -= f.size
+	}
+	return removed, reclaimed, nil
+}
+
+// janitorDirs returns the directories that the janitor cleans up.
+// The spool isn't one of them, even if it's inside one of the configured directories, since removing payloads from it
+// would drop alerts that haven't been processed yet. The executor's files that are still in use are left alone in
+// configured directories too, in case the temporary directory is inside one of them.
+func (s *Server) janitorDirs() []janitorDir {
+	var exclude []string
+	if s.config.SpoolDir != "" {
+		exclude = append(exclude, s.config.SpoolDir)
+	}
+	temp := os.TempDir()
+	dirs := []janitorDir{{path: temp, prefixes: tempFilePrefixes, inUse: s.tempFiles.InUse}}
+	for _, dir := range s.config.Janitor.Dirs {
+		if filepath.Clean(dir) == filepath.Clean(temp) {
+			// Only the executor's own files are cleaned up in the temporary directory
+			continue
+		}
+		dirs = append(dirs, janitorDir{path: dir, exclude: exclude, inUse: s.tempFiles.InUse})
+	}
+	return dirs
+}
+
+// cleanUp removes files that exceed the janitor's retention from each of the directories the executor writes to
+func (s *Server) cleanUp() {
+	c := s.config.Janitor
+	now := time.Now()
+	for _, dir := range s.janitorDirs() {
+		removed, reclaimed, err := dir.sweep(time.Duration(c.MaxAge), c.MaxSize, now)
+		if removed > 0 {
+			logger.Info("Removed files that exceeded the janitor's retention", Fields{"directory": dir.path, "files": removed, "reclaimed": ByteSize(reclaimed)})
+			s.janitorFiles.WithLabelValues(dir.path).Add(float64(removed))
+			s.janitorBytes.WithLabelValues(dir.path).Add(float64(reclaimed))
+		}
+		if err != nil {
+			logger.Error("Failed to clean up directory", Fields{"directory": dir.path, "error": err})
+		}
+	}
+}
+
+// cleanUpEvery cleans up directories now, and then at the given interval.
+// It is meant to be called as a goroutine.
+func (s *Server) cleanUpEvery(interval time.Duration) {
+	s.cleanUp()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.cleanUp()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJanitorConfig_Validate(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		janitor JanitorConfig
+		ok      bool
+	}{
+		{name: "unset", janitor: JanitorConfig{}, ok: true},
+		{name: "age", janitor: JanitorConfig{MaxAge: Duration(time.Hour)}, ok: true},
+		{name: "size", janitor: JanitorConfig{MaxSize: 1024, Dirs: []string{"/var/tmp/scratch"}}, ok: true},
+		{name: "no_limits", janitor: JanitorConfig{Dirs: []string{"/var/tmp/scratch"}}, ok: false},
+		{name: "negative_age", janitor: JanitorConfig{MaxAge: Duration(-time.Hour)}, ok: false},
+		{name: "relative_dir", janitor: JanitorConfig{MaxAge: Duration(time.Hour), Dirs: []string{"scratch"}}, ok: false},
+	}
+
+	for _, tc := range cases {
+		if err := tc.janitor.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Wrong validation result for %s; got %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+// writeAged writes a file of the given size, last modified the given time ago
+func writeAged(t *testing.T, path string, size int, age time.Duration) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, make([]byte, size), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mod := time.Now().Add(-age)
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJanitorDir_sweep(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "janitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeAged(t, filepath.Join(dir, "expired"), 10, 3*time.Hour)
+	writeAged(t, filepath.Join(dir, "sub", "old"), 20, 90*time.Minute)
+	writeAged(t, filepath.Join(dir, "recent"), 30, time.Minute)
+	writeAged(t, filepath.Join(dir, "new"), 40, 0)
+
+	removed, reclaimed, err := janitorDir{path: dir}.sweep(2*time.Hour, 80, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The expired file goes for its age, and the next oldest until the rest fit
+	if removed != 2 || reclaimed != 30 {
+		t.Errorf("Wrong files removed; got %d files of %d bytes, want 2 files of 30 bytes", removed, reclaimed)
+	}
+	for name, want := range map[string]bool{"expired": false, "sub/old": false, "recent": true, "new": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("Wrong existence of %s after sweep; got %v, want %v", name, err == nil, want)
+		}
+	}
+
+	// Only files with the prefixes are removed, and not from subdirectories
+	writeAged(t, filepath.Join(dir, "am-executor-payload-1"), 1, 3*time.Hour)
+	writeAged(t, filepath.Join(dir, "sub", "am-executor-payload-2"), 1, 3*time.Hour)
+	removed, _, err = janitorDir{path: dir, prefixes: tempFilePrefixes}.sweep(time.Hour, 0, time.Now())
+	if err != nil || removed != 1 {
+		t.Errorf("Wrong files removed with prefixes; got %d, %v, want 1", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "am-executor-payload-2")); err != nil {
+		t.Errorf("File in subdirectory shouldn't be removed with prefixes; got %v", err)
+	}
+
+	// Files in use and excluded subdirectories are left alone
+	writeAged(t, filepath.Join(dir, "am-executor-payload-3"), 1, 3*time.Hour)
+	inUse := func(path string) bool { return filepath.Base(path) == "am-executor-payload-3" }
+	removed, _, err = janitorDir{path: dir, prefixes: tempFilePrefixes, inUse: inUse}.sweep(time.Hour, 0, time.Now())
+	if err != nil || removed != 0 {
+		t.Errorf("File in use shouldn't be removed; got %d, %v", removed, err)
+	}
+	removed, _, err = janitorDir{path: dir, exclude: []string{filepath.Join(dir, "sub")}, inUse: inUse}.sweep(time.Hour, 0, time.Now())
+	if err != nil || removed != 0 {
+		t.Errorf("Files in excluded directory shouldn't be removed; got %d, %v", removed, err)
+	}
+
+	if removed, _, err := (janitorDir{path: filepath.Join(dir, "missing")}).sweep(time.Hour, 0, time.Now()); err != nil || removed != 0 {
+		t.Errorf("Missing directory should be skipped; got %d, %v", removed, err)
+	}
+}
+
+func TestServer_janitorDirs(t *testing.T) {
+	t.Parallel()
+	srv := NewServer(&Config{SpoolDir: "/var/spool/am-executor", Janitor: JanitorConfig{MaxAge: Duration(time.Hour), Dirs: []string{"/var/spool"}}})
+	dirs := srv.janitorDirs()
+	if len(dirs) != 2 {
+		t.Fatalf("Wrong number of directories; got %+v", dirs)
+	}
+	for _, dir := range dirs {
+		if dir.path == srv.config.SpoolDir {
+			t.Errorf("The spool shouldn't be cleaned up")
+		}
+	}
+	if !dirs[1].excludes("/var/spool/am-executor/") {
+		t.Errorf("The spool should be excluded from directories it's in")
+	}
+
+	f, err := srv.tempFiles.Create("am-executor-payload-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if !dirs[0].inUse(f.Name()) {
+		t.Errorf("Temporary file should be in use until it's removed")
+	}
+	if !dirs[1].inUse(f.Name()) {
+		t.Errorf("Temporary file should be in use in directories containing the temporary directory")
+	}
+	srv.tempFiles.Remove(f.Name())
+	if dirs[0].inUse(f.Name()) {
+		t.Errorf("Removed temporary file shouldn't be in use")
+	}
+
+	// The temporary directory is only cleaned up once, of the executor's own files
+	srv = NewServer(&Config{Janitor: JanitorConfig{MaxAge: Duration(time.Hour), Dirs: []string{os.TempDir() + "/"}}})
+	if dirs := srv.janitorDirs(); len(dirs) != 1 || len(dirs[0].prefixes) == 0 {
+		t.Errorf("The temporary directory should only be cleaned up of the executor's files; got %+v", dirs)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often files are re-read for changes to their targets, when not configured otherwise
+	defaultSDRefreshInterval = Duration(30 * time.Second)
+)
+
+// targetGroup is a group of targets in a file_sd file, as read by prometheus' file-based service discovery.
+// The labels of groups aren't used, so they aren't decoded.
+type targetGroup struct {
+	Targets []string `json:"targets" yaml:"targets"`
+}
+
+// LabelSDMatcher matches a label of alerts against the targets listed in file_sd files, such as the current list of
+// canary instances, so that frequently changing sets of values don't need config edits.
+// The files are re-read periodically, so changes to them are picked up without restarting the executor.
+type LabelSDMatcher struct {
+	// Label of the alert that has to be one of the targets, such as instance
+	Label string `yaml:"label"`
+	// Paths to JSON or YAML files in prometheus' file_sd format. The last element of a path can be a glob pattern,
+	// such as /etc/prometheus/canaries/*.json.
+	Files []string `yaml:"files"`
+	// How often the files are re-read. Defaults to 30s.
+	RefreshInterval Duration `yaml:"refresh_interval"`
+
+	targets map[string]bool
+	loaded  bool
+	sync.RWMutex
+}
+
+// Validate returns an error if the matcher's settings can't be used
+func (m *LabelSDMatcher) Validate() error {
+	if m.Label == "" {
+		return fmt.Errorf("label isn't set")
+	}
+	if len(m.Files) == 0 {
+		return fmt.Errorf("files aren't set for label %s", m.Label)
+	}
+	for _, f := range m.Files {
+		if _, err := filepath.Match(filepath.Base(f), ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q for label %s: %w", f, m.Label, err)
+		}
+	}
+	if m.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval for label %s is negative: %s", m.Label, m.RefreshInterval)
+	}
+	return nil
+}
+
+// Equal returns true if the other matcher has the same settings
+func (m *LabelSDMatcher) Equal(other *LabelSDMatcher) bool {
+	if m.Label != other.Label || m.RefreshInterval != other.RefreshInterval || len(m.Files) != len(other.Files) {
+		return false
+	}
+	for i, f := range m.Files {
+		if f != other.Files[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Interval returns how often the files are re-read
+func (m *LabelSDMatcher) Interval() time.Duration {
+	if m.RefreshInterval <= 0 {
+		return time.Duration(defaultSDRefreshInterval)
+	}
+	return time.Duration(m.RefreshInterval)
+}
+
+// ensureLoaded reads the files if they haven't been read yet, so that targets are known before the first refresh
+func (m *LabelSDMatcher) ensureLoaded() {
+	m.RLock()
+	loaded := m.loaded
+	m.RUnlock()
+	if loaded {
+		return
+	}
+	if _, err := m.Reload(); err != nil {
+		logger.Error("Failed to read targets for label matcher", Fields{"label": m.Label, "files": m.Files, "error": err})
+	}
+}
+
+// Matches returns true if the value is one of the targets in the files
+func (m *LabelSDMatcher) Matches(value string) bool {
+	m.ensureLoaded()
+	m.RLock()
+	defer m.RUnlock()
+	return m.targets[value]
+}
+
+// Targets returns the targets read from the files, in sorted order
+func (m *LabelSDMatcher) Targets() []string {
+	m.ensureLoaded()
+	m.RLock()
+	defer m.RUnlock()
+	targets := make([]string, 0, len(m.targets))
+	for t := range m.targets {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Reload re-reads the targets from the files, returning whether they changed.
+// If any of the files can't be read, the previous targets are kept, so that a partially written file can't make
+// matching commands stop running.
+func (m *LabelSDMatcher) Reload() (bool, error) {
+	targets, err := readSDTargets(m.Files)
+
+	m.Lock()
+	defer m.Unlock()
+	m.loaded = true
+	if err != nil {
+		if m.targets == nil {
+			m.targets = make(map[string]bool)
+		}
+		return false, err
+	}
+	changed := len(targets) != len(m.targets)
+	for t := range targets {
+		changed = changed || !m.targets[t]
+	}
+	m.targets = targets
+	return changed, nil
+}
+
+// readSDTargets returns the targets of all the target groups in files matching the given paths
+func readSDTargets(paths []string) (map[string]bool, error) {
+	targets := make(map[string]bool)
+	for _, pattern := range paths {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			var groups []targetGroup
+			switch strings.ToLower(filepath.Ext(f)) {
+			case ".yml", ".yaml":
+				err = yaml.Unmarshal(data, &groups)
+			default:
+				err = json.Unmarshal(data, &groups)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid target groups in %s: %w", f, err)
+			}
+			for _, g := range groups {
+				for _, t := range g.Targets {
+					targets[t] = true
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+// labelSDMatchers returns the matchers of all configured commands
+func (c *Config) labelSDMatchers() []*LabelSDMatcher {
+	var matchers []*LabelSDMatcher
+	for _, cmd := range c.AllCommands() {
+		matchers = append(matchers, cmd.MatchLabelsSD...)
+	}
+	return matchers
+}
+
+// refreshLabelSD re-reads a matcher's files at its refresh interval, until the executor stops
+func (s *Server) refreshLabelSD(m *LabelSDMatcher) {
+	ticker := time.NewTicker(m.Interval())
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := m.Reload()
+		if err != nil {
+			logger.Error("Failed to re-read targets for label matcher; keeping the previous targets", Fields{"label": m.Label, "files": m.Files, "error": err})
+			s.errCounter.WithLabelValues(ErrLabelSD, CmdLabelNone).Inc()
+		} else if changed {
+			logger.Info("Targets for label matcher changed", Fields{"label": m.Label, "files": m.Files, "targets": len(m.Targets())})
+		}
+	}
+}
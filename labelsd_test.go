@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLabelSDMatcher(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor-sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.json", `[{"targets": ["db1:9100", "db2:9100"], "labels": {"env": "canary"}}]`)
+	write("b.yml", "- targets: [\"web1:9100\"]\n")
+
+	m := &LabelSDMatcher{Label: "instance", Files: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "b.yml")}}
+	cmd := Command{Cmd: "echo", MatchLabels: map[string]string{"alertname": "Down"}, MatchLabelsSD: []*LabelSDMatcher{m}}
+	matches := func(instance string) bool {
+		return cmd.Matches(&template.Data{CommonLabels: template.KV{"alertname": "Down", "instance": instance}})
+	}
+	if !matches("db2:9100") || !matches("web1:9100") || matches("db3:9100") {
+		t.Errorf("Wrong matches for targets %v", m.Targets())
+	}
+
+	write("a.json", `[{"targets": ["db3:9100"]}]`)
+	if changed, err := m.Reload(); err != nil || !changed {
+		t.Errorf("Expected targets to change without errors; changed %v, error %v", changed, err)
+	}
+	if !matches("db3:9100") || matches("db2:9100") {
+		t.Errorf("Wrong matches after reloading targets %v", m.Targets())
+	}
+	if changed, _ := m.Reload(); changed {
+		t.Error("Expected targets not to change when the files are the same")
+	}
+
+	write("a.json", `[{"targets": [`)
+	if _, err := m.Reload(); err == nil {
+		t.Error("Expected an error for an invalid file")
+	}
+	if want := []string{"db3:9100", "web1:9100"}; !reflect.DeepEqual(m.Targets(), want) {
+		t.Errorf("Expected previous targets to be kept after an error; got %v, want %v", m.Targets(), want)
+	}
+}
+
+func TestLabelSDMatcher_Validate(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		m       *LabelSDMatcher
+		wantErr bool
+	}{
+		{name: "valid", m: &LabelSDMatcher{Label: "instance", Files: []string{"/etc/prometheus/*.json"}}},
+		{name: "no_label", m: &LabelSDMatcher{Files: []string{"canaries.json"}}, wantErr: true},
+		{name: "no_files", m: &LabelSDMatcher{Label: "instance"}, wantErr: true},
+		{name: "bad_pattern", m: &LabelSDMatcher{Label: "instance", Files: []string{"["}}, wantErr: true},
+		{name: "negative_interval", m: &LabelSDMatcher{Label: "instance", Files: []string{"a.json"}, RefreshInterval: -1}, wantErr: true},
+	}
+	for _, tc := range cases {
+		if err := tc.m.Validate(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
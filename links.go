@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How long signed links are valid for, when signed_links' ttl isn't set
+	defaultLinkTTL = Duration(15 * time.Minute)
+	// The longest that signed links can be valid for
+	maxLinkTTL = 24 * time.Hour
+	// Path that signed links are requested from
+	linksAPIPath = "/api/links"
+
+	// Query parameters of signed links
+	linkMethodParam    = "method"
+	linkExpiresParam   = "expires"
+	linkSignatureParam = "signature"
+)
+
+var (
+	errLinkExpired = errors.New("Link expired")
+
+	// Page that signed links show when they're opened, so that link previews in chat don't act on them
+	linkConfirmTemplate = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Action}}</title></head>
+<body>
+<form method="post">
+<p>{{.Action}}? This link is valid until {{.Expires}}.</p>
+<button type="submit">Confirm</button>
+</form>
+</body>
+</html>
+`))
+)
+
+// SignedLinksConfig configures short-lived signed links to actions of the admin API, such as approving an execution,
+// that responders can follow from chat without holding credentials for the executor
+type SignedLinksConfig struct {
+	// Secret that links are signed with. Links aren't accepted or generated if this isn't set.
+	Secret string `yaml:"secret"`
+	// URL that responders reach the executor at, such as https://executor.example.com, which links are built on
+	BaseURL string `yaml:"base_url"`
+	// How long links are valid for. Defaults to 15 minutes, and can be up to a day.
+	TTL Duration `yaml:"ttl"`
+}
+
+// LinkSigner signs links to actions of the admin API, and verifies requests made through them
+type LinkSigner struct {
+	secret []byte
+	base   *url.URL
+	ttl    time.Duration
+}
+
+// NewLinkSigner returns a LinkSigner for the configured secret, or nil if no secret is configured
+func NewLinkSigner(c SignedLinksConfig) (*LinkSigner, error) {
+	if c.Secret == "" {
+		if c.BaseURL != "" || c.TTL != 0 {
+			return nil, fmt.Errorf("secret isn't set")
+		}
+		return nil, nil
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("expected an absolute base_url, got %q", c.BaseURL)
+	}
+	ttl := time.Duration(c.TTL)
+	if ttl == 0 {
+		ttl = time.Duration(defaultLinkTTL)
+	}
+	if ttl < 0 || ttl > maxLinkTTL {
+		return nil, fmt.Errorf("ttl %s isn't between 0 and %s", c.TTL, maxLinkTTL)
+	}
+	return &LinkSigner{secret: []byte(c.Secret), base: base, ttl: ttl}, nil
+}
+
+// linkAction returns a description of the action that a request with the given method and path takes, or an empty
+// string if links can't be signed for it
+func linkAction(method string, path string) string {
+	switch {
+	case method == http.MethodPost && strings.HasPrefix(path, approvalsAPIPath):
+		parts := strings.Split(strings.TrimPrefix(path, approvalsAPIPath), "/")
+		if len(parts) == 2 && parts[0] != "" && (parts[1] == "approve" || parts[1] == "reject") {
+			return strings.Title(parts[1]) + " execution " + parts[0]
+		}
+	case method == http.MethodPost && strings.HasPrefix(path, commandsAPIPath):
+		parts := strings.Split(strings.TrimPrefix(path, commandsAPIPath), "/")
+		if len(parts) == 2 && parts[0] != "" && (parts[1] == "disable" || parts[1] == "enable" || parts[1] == "approve") {
+			return strings.Title(parts[1]) + " command " + parts[0]
+		}
+	case method == http.MethodDelete && strings.HasPrefix(path, processesAPIPath):
+		if _, err := strconv.ParseUint(strings.TrimPrefix(path, processesAPIPath), 10, 64); err == nil {
+			return "Kill execution " + strings.TrimPrefix(path, processesAPIPath)
+		}
+	}
+	return ""
+}
+
+// signature returns the hex-encoded HMAC-SHA256 signature of a link
+func (l *LinkSigner) signature(method string, path string, expires string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	_, _ = mac.Write([]byte(method + "\n" + path + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns a link to take an action of the admin API, by making a request with the given method to the path,
+// which is valid until the returned time. ttl defaults to the configured one if it isn't positive.
+func (l *LinkSigner) Sign(method string, path string, ttl time.Duration, now time.Time) (string, time.Time, error) {
+	if linkAction(method, path) == "" {
+		return "", time.Time{}, fmt.Errorf("Links can't be signed for %s %s", method, path)
+	}
+	if ttl <= 0 {
+		ttl = l.ttl
+	}
+	if ttl > maxLinkTTL {
+		return "", time.Time{}, fmt.Errorf("Links can't be valid for longer than %s", maxLinkTTL)
+	}
+
+	expires := now.Add(ttl).Truncate(time.Second)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set(linkMethodParam, method)
+	q.Set(linkExpiresParam, exp)
+	q.Set(linkSignatureParam, l.signature(method, path, exp))
+	u := *l.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = q.Encode()
+	return u.String(), expires, nil
+}
+
+// signedLink returns true if a request was made through a signed link
+func signedLink(req *http.Request) bool {
+	return req.URL.Query().Get(linkSignatureParam) != ""
+}
+
+// Verify returns the method of the action that a request made through a signed link takes,
+// or an error if the link isn't valid at the given time
+func (l *LinkSigner) Verify(req *http.Request, now time.Time) (string, error) {
+	if l == nil {
+		return "", errUnauthorized
+	}
+	q := req.URL.Query()
+	method, exp := q.Get(linkMethodParam), q.Get(linkExpiresParam)
+	if linkAction(method, req.URL.Path) == "" || !hmac.Equal([]byte(q.Get(linkSignatureParam)), []byte(l.signature(method, req.URL.Path, exp))) {
+		return "", errUnauthorized
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", errUnauthorized
+	}
+	if !now.Before(time.Unix(expires, 0)) {
+		return "", errLinkExpired
+	}
+	return method, nil
+}
+
+// handleSignedLink serves a request made through a signed link. Opening the link shows a page to confirm the action,
+// whose form posts back to it; the action is only taken then, with the method the link was signed for.
+func (s *Server) handleSignedLink(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	method, err := s.links.Verify(req, time.Now())
+	if err != nil {
+		logger.Decision("Rejected request through signed link", Fields{"remote_addr": req.RemoteAddr, "path": req.URL.Path, "error": err})
+		s.errCounter.WithLabelValues(ErrLabelAuth, CmdLabelNone).Inc()
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		expires, _ := strconv.ParseInt(req.URL.Query().Get(linkExpiresParam), 10, 64)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		// Keep the signature out of caches and referrers
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		err := linkConfirmTemplate.Execute(w, struct {
+			Action  string
+			Expires string
+		}{
+			Action:  linkAction(method, req.URL.Path),
+			Expires: time.Unix(expires, 0).UTC().Format(time.RFC1123),
+		})
+		if err != nil {
+			logger.Error("Failed to write response", Fields{"error": err})
+		}
+	case http.MethodPost:
+		logger.Decision("Taking action through signed link", Fields{"remote_addr": req.RemoteAddr, "method": method, "path": req.URL.Path})
+		req.Method = method
+		next(w, req)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// linkRequest represents the body of a request to sign a link
+type linkRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// How long the link is valid for, such as 10m. Defaults to signed_links' ttl.
+	TTL string `json:"ttl"`
+}
+
+// signedLinkResponse represents the body of a response to a request to sign a link
+type signedLinkResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// handleLinks signs a link to an action of the admin API, for POST requests
+func (s *Server) handleLinks(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if s.links == nil {
+		http.Error(w, "Signed links aren't configured", http.StatusNotFound)
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	var lr linkRequest
+	if err := json.Unmarshal(data, &lr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if lr.TTL != "" {
+		if ttl, err = time.ParseDuration(lr.TTL); err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid ttl %q, expected a positive duration", lr.TTL), http.StatusBadRequest)
+			return
+		}
+	}
+	method := strings.ToUpper(lr.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	link, expires, err := s.links.Sign(method, lr.Path, ttl, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Decision("Signed link", Fields{"method": method, "path": lr.Path, "expires": expires, "remote_addr": req.RemoteAddr})
+	writeJSON(w, signedLinkResponse{URL: link, Expires: expires})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLinkSigner(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name   string
+		config SignedLinksConfig
+		ok     bool
+	}{
+		{name: "unset", config: SignedLinksConfig{}, ok: true},
+		{name: "set", config: SignedLinksConfig{Secret: "s", BaseURL: "https://executor.example.com"}, ok: true},
+		{name: "no_secret", config: SignedLinksConfig{BaseURL: "https://executor.example.com"}, ok: false},
+		{name: "relative_base", config: SignedLinksConfig{Secret: "s", BaseURL: "executor"}, ok: false},
+		{name: "long_ttl", config: SignedLinksConfig{Secret: "s", BaseURL: "https://executor.example.com", TTL: Duration(48 * time.Hour)}, ok: false},
+	}
+
+	for _, tc := range cases {
+		if _, err := NewLinkSigner(tc.config); (err == nil) != tc.ok {
+			t.Errorf("Wrong validation result for %s; got %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestLinkSigner_Verify(t *testing.T) {
+	t.Parallel()
+	l, err := NewLinkSigner(SignedLinksConfig{Secret: "s", BaseURL: "https://executor.example.com/executor/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	link, expires, err := l.Sign(http.MethodDelete, "/processes/42", 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://executor.example.com/executor/processes/42?") {
+		t.Errorf("Wrong link; got %s", link)
+	}
+	if got := expires.Sub(now); got > time.Duration(defaultLinkTTL) || got < time.Duration(defaultLinkTTL)-time.Second {
+		t.Errorf("Wrong expiry; got %s from now, want %s", got, defaultLinkTTL)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The executor sees paths without the base URL's, such as behind a proxy
+	req := httptest.NewRequest(http.MethodGet, "/processes/42?"+u.RawQuery, nil)
+	if method, err := l.Verify(req, now); err != nil || method != http.MethodDelete {
+		t.Errorf("Signed link should be valid; got %s, %v", method, err)
+	}
+	if _, err := l.Verify(req, expires); err != errLinkExpired {
+		t.Errorf("Link should expire; got %v", err)
+	}
+	tampered := httptest.NewRequest(http.MethodGet, "/processes/43?"+u.RawQuery, nil)
+	if _, err := l.Verify(tampered, now); err == nil {
+		t.Errorf("Link shouldn't be valid for another path")
+	}
+	q := u.Query()
+	q.Set(linkExpiresParam, "9999999999")
+	if _, err := l.Verify(httptest.NewRequest(http.MethodGet, "/processes/42?"+q.Encode(), nil), now); err == nil {
+		t.Errorf("Link shouldn't be valid with another expiry")
+	}
+
+	if _, _, err := l.Sign(http.MethodPost, "/_state", 0, now); err == nil {
+		t.Errorf("Links shouldn't be signed for endpoints other than actions")
+	}
+}
+
+func TestServer_signedLink(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.Commands = []*Command{{Cmd: "echo", Name: "echo"}}
+	srv.links, _ = NewLinkSigner(SignedLinksConfig{Secret: "s", BaseURL: "https://executor.example.com"})
+	auth := bearerAuth{token: "secret"}
+	links := srv.requireAuth(auth, srv.handleLinks)
+	toggle := srv.requireAuth(auth, srv.handleCommandToggle)
+
+	body, _ := json.Marshal(linkRequest{Path: "/api/commands/echo/disable"})
+	req := httptest.NewRequest(http.MethodPost, linksAPIPath, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	links(w, req)
+	var signed signedLinkResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &signed); err != nil {
+		t.Fatalf("Failed to decode signed link %q: %v", w.Body.String(), err)
+	}
+	u, err := url.Parse(signed.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the link only shows the confirmation page
+	w = httptest.NewRecorder()
+	toggle(w, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Disable command echo") {
+		t.Errorf("Wrong confirmation page; got %d %q", w.Code, w.Body.String())
+	}
+	if srv.disabled.Disabled("echo") {
+		t.Fatal("Opening the link shouldn't disable the command")
+	}
+
+	w = httptest.NewRecorder()
+	toggle(w, httptest.NewRequest(http.MethodPost, u.RequestURI(), nil))
+	if w.Code != http.StatusOK || !srv.disabled.Disabled("echo") {
+		t.Errorf("Confirming the link should disable the command; got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	toggle(w, httptest.NewRequest(http.MethodPost, "/api/commands/echo/enable?"+u.RawQuery, nil))
+	if w.Code != http.StatusUnauthorized || !srv.disabled.Disabled("echo") {
+		t.Errorf("Link shouldn't be usable for another action; got %d", w.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Values for Config.ListenNetwork
+	ListenNetworkDual = "tcp"
+	ListenNetwork4    = "tcp4"
+	ListenNetwork6    = "tcp6"
+	// Network of listen addresses that are unix domain sockets, which listen_network can be left empty for
+	ListenNetworkUnix = "unix"
+	// Network of listen addresses that are sockets passed by systemd socket activation
+	ListenNetworkSystemd = "systemd"
+
+	// Scheme of listen addresses that are unix domain sockets, as in unix:///var/run/am-executor.sock
+	unixScheme = "unix://"
+	// Scheme of listen addresses that are sockets passed by systemd, optionally followed by their FileDescriptorName
+	systemdScheme = "systemd://"
+	// Permissions of the socket file, when listen_socket_mode isn't set.
+	// Members of the executor's group can connect, so that alertmanager can be given access by sharing it.
+	defaultSocketMode = os.FileMode(0660)
+)
+
+// ListenAddress returns the network and address that the server listens on, after validating them.
+//
+// The listen_address host can be empty to listen on all addresses, an IP address (IPv6 addresses are written in
+// brackets, with an optional zone as in [fe80::1%eth0]:8080), a hostname, or the name of a network interface to
+// listen on that interface's first address. The listen_network chooses between dual-stack (tcp, the default),
+// IPv4-only (tcp4) and IPv6-only (tcp6) listening.
+// Alternatively, listen_address can be the path of a unix domain socket after unix://, which the unix network is
+// returned for, or systemd:// to listen on a socket passed by systemd socket activation, which the systemd network is
+// returned for along with the FileDescriptorName after systemd://, if any.
+func (c *Config) ListenAddress() (string, string, error) {
+	network := strings.ToLower(c.ListenNetwork)
+	if strings.HasPrefix(strings.ToLower(c.ListenAddr), systemdScheme) {
+		if network != "" {
+			return "", "", fmt.Errorf("Invalid listen_address %q: listen_network can't be set for sockets passed by systemd", c.ListenAddr)
+		}
+		return ListenNetworkSystemd, c.ListenAddr[len(systemdScheme):], nil
+	}
+	if strings.HasPrefix(strings.ToLower(c.ListenAddr), unixScheme) {
+		path := c.ListenAddr[len(unixScheme):]
+		if path == "" {
+			return "", "", fmt.Errorf("Invalid listen_address %q, expected the path of a socket such as unix:///var/run/am-executor.sock", c.ListenAddr)
+		}
+		if network != "" && network != ListenNetworkUnix {
+			return "", "", fmt.Errorf("Invalid listen_address %q: can't listen on a unix socket with listen_network %s", c.ListenAddr, c.ListenNetwork)
+		}
+		return ListenNetworkUnix, path, nil
+	}
+	switch network {
+	case "":
+		network = ListenNetworkDual
+	case ListenNetworkDual, ListenNetwork4, ListenNetwork6:
+	case ListenNetworkUnix:
+		return "", "", fmt.Errorf("Invalid listen_address %q: listen_network %s needs an address such as unix:///var/run/am-executor.sock", c.ListenAddr, network)
+	default:
+		return "", "", fmt.Errorf("Unknown listen_network %q, expected one of %s, %s, %s or %s", c.ListenNetwork, ListenNetworkDual, ListenNetwork4, ListenNetwork6, ListenNetworkUnix)
+	}
+
+	host, port, err := net.SplitHostPort(c.ListenAddr)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid listen_address %q, expected host:port such as :8080, 127.0.0.1:8080 or [::1]:8080: %w", c.ListenAddr, err)
+	}
+	if err := checkListenPort(port); err != nil {
+		return "", "", fmt.Errorf("Invalid listen_address %q: %w", c.ListenAddr, err)
+	}
+
+	if host == "" {
+		return network, c.ListenAddr, nil
+	}
+
+	ipHost, zone := host, ""
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		ipHost, zone = host[:i], host[i+1:]
+	}
+	if ip := net.ParseIP(ipHost); ip != nil {
+		if zone != "" {
+			if ip.To4() != nil {
+				return "", "", fmt.Errorf("Invalid listen_address %q: zones are only used with IPv6 addresses", c.ListenAddr)
+			}
+			if _, err := net.InterfaceByName(zone); err != nil {
+				return "", "", fmt.Errorf("Invalid listen_address %q: no network interface named %q for the zone", c.ListenAddr, zone)
+			}
+		}
+		if ip.To4() != nil && network == ListenNetwork6 {
+			return "", "", fmt.Errorf("Invalid listen_address %q: can't listen on an IPv4 address with listen_network %s", c.ListenAddr, network)
+		}
+		if ip.To4() == nil && network == ListenNetwork4 {
+			return "", "", fmt.Errorf("Invalid listen_address %q: can't listen on an IPv6 address with listen_network %s", c.ListenAddr, network)
+		}
+		return network, c.ListenAddr, nil
+	}
+
+	// Hosts that aren't IP addresses are either network interfaces, or hostnames that are resolved when listening
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		return network, c.ListenAddr, nil
+	}
+	ip, err := interfaceAddr(iface, network)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid listen_address %q: %w", c.ListenAddr, err)
+	}
+	return network, net.JoinHostPort(ip, port), nil
+}
+
+// checkListenPort returns an error if port isn't a port number or a known service name, such as http
+func checkListenPort(port string) error {
+	if port == "" {
+		return fmt.Errorf("missing port")
+	}
+	if IsDigit(port) {
+		if n, err := strconv.Atoi(port); err != nil || n > 65535 {
+			return fmt.Errorf("port %s is out of range, expected 0 to 65535", port)
+		}
+		return nil
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("unknown port %q", port)
+	}
+	return nil
+}
+
+// interfaceAddr returns the first address of a network interface that can be listened on with the given network,
+// with the interface as the zone of IPv6 link-local addresses.
+func interfaceAddr(iface *net.Interface, network string) (string, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("can't read addresses of network interface %s: %w", iface.Name, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if (ip.To4() != nil && network == ListenNetwork6) || (ip.To4() == nil && network == ListenNetwork4) {
+			continue
+		}
+		if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+			return ip.String() + "%" + iface.Name, nil
+		}
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("network interface %s has no addresses for listen_network %s", iface.Name, network)
+}
+
+// SocketMode returns the permissions that the socket file is given when listening on a unix domain socket.
+// listen_socket_mode is written in octal, as in 0660.
+func (c *Config) SocketMode() (os.FileMode, error) {
+	if c.ListenSocketMode == "" {
+		return defaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(c.ListenSocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("Invalid listen_socket_mode %q, expected permissions in octal such as 0660", c.ListenSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listen listens on the network and address returned by Config.ListenAddress.
+// The socket file of a unix domain socket is given the mode, after removing one left behind by an executor that
+// didn't shut down cleanly. The socket file is removed again once the listener is closed.
+func listen(network string, addr string, mode os.FileMode) (net.Listener, error) {
+	if network == ListenNetworkSystemd {
+		return activatedListener(addr)
+	}
+	if network != ListenNetworkUnix {
+		return net.Listen(network, addr)
+	}
+
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes a socket file at the given path that nothing is listening on anymore.
+// Other files, and sockets that are still listened on, are left alone, and returned as an error.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("Can't listen on %s: the file exists and isn't a socket", path)
+	}
+	if conn, err := net.Dial(ListenNetworkUnix, path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("Can't listen on %s: the socket is in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig_ListenAddress(t *testing.T) {
+	cases := []struct {
+		name    string
+		addr    string
+		network string
+		want    string
+		err     bool
+	}{
+		{name: "all_addresses", addr: ":8080", want: ":8080"},
+		{name: "ipv4", addr: "127.0.0.1:8080", want: "127.0.0.1:8080"},
+		{name: "ipv6", addr: "[::1]:8080", want: "[::1]:8080"},
+		{name: "hostname", addr: "localhost:8080", want: "localhost:8080"},
+		{name: "service_name", addr: ":http", want: ":http"},
+		{name: "ipv4_only", addr: "127.0.0.1:8080", network: "TCP4", want: "127.0.0.1:8080"},
+		{name: "ipv6_only", addr: "[::1]:8080", network: ListenNetwork6, want: "[::1]:8080"},
+		{name: "missing_port", addr: "127.0.0.1", err: true},
+		{name: "unbracketed_ipv6", addr: "::1:8080", err: true},
+		{name: "port_out_of_range", addr: ":65536", err: true},
+		{name: "unknown_service", addr: ":not-a-service", err: true},
+		{name: "unknown_zone", addr: "[fe80::1%not-an-interface]:8080", err: true},
+		{name: "ipv4_zone", addr: "[127.0.0.1%lo]:8080", err: true},
+		{name: "ipv4_on_ipv6_only", addr: "127.0.0.1:8080", network: ListenNetwork6, err: true},
+		{name: "ipv6_on_ipv4_only", addr: "[::1]:8080", network: ListenNetwork4, err: true},
+		{name: "unknown_network", addr: ":8080", network: "udp", err: true},
+		{name: "unix", addr: "unix:///var/run/am-executor.sock", want: "/var/run/am-executor.sock"},
+		{name: "unix_network", addr: "unix:///var/run/am-executor.sock", network: ListenNetworkUnix, want: "/var/run/am-executor.sock"},
+		{name: "unix_missing_path", addr: "unix://", err: true},
+		{name: "unix_on_tcp", addr: "unix:///var/run/am-executor.sock", network: ListenNetwork4, err: true},
+		{name: "tcp_on_unix", addr: ":8080", network: ListenNetworkUnix, err: true},
+		{name: "systemd", addr: "systemd://", want: ""},
+		{name: "systemd_named", addr: "systemd://am-executor-http", want: "am-executor-http"},
+		{name: "systemd_network", addr: "systemd://", network: ListenNetworkDual, err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			c := &Config{ListenAddr: tc.addr, ListenNetwork: tc.network}
+			network, addr, err := c.ListenAddress()
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected an error for listen address %q on %q", tc.addr, tc.network)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error for listen address %q on %q: %v", tc.addr, tc.network, err)
+			}
+			if addr != tc.want {
+				t.Errorf("Wrong address; got %q, want %q", addr, tc.want)
+			}
+			switch {
+			case strings.HasPrefix(tc.addr, systemdScheme):
+				if network != ListenNetworkSystemd {
+					t.Errorf("Expected to listen on a socket passed by systemd; got %q", network)
+				}
+			case strings.HasPrefix(tc.addr, unixScheme):
+				if network != ListenNetworkUnix {
+					t.Errorf("Expected to listen on a unix socket; got %q", network)
+				}
+			case tc.network == "" && network != ListenNetworkDual:
+				t.Errorf("Expected dual-stack listening by default; got %q", network)
+			}
+		})
+	}
+}
+
+func TestConfig_ListenAddressInterface(t *testing.T) {
+	t.Parallel()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("Can't list network interfaces: %v", err)
+	}
+	var loopback *net.Interface
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 {
+			loopback = &ifaces[i]
+			break
+		}
+	}
+	if loopback == nil {
+		t.Skip("No loopback interface to listen on")
+	}
+
+	c := &Config{ListenAddr: loopback.Name + ":8080", ListenNetwork: ListenNetwork4}
+	_, addr, err := c.ListenAddress()
+	if err != nil {
+		t.Fatalf("Failed to listen on interface %s: %v", loopback.Name, err)
+	}
+	if addr != "127.0.0.1:8080" {
+		t.Errorf("Wrong address for interface %s; got %q, want %q", loopback.Name, addr, "127.0.0.1:8080")
+	}
+}
+
+func TestConfig_SocketMode(t *testing.T) {
+	cases := []struct {
+		mode string
+		want os.FileMode
+		err  bool
+	}{
+		{mode: "", want: defaultSocketMode},
+		{mode: "0600", want: 0600},
+		{mode: "666", want: 0666},
+		{mode: "0999", err: true},
+		{mode: "01777", err: true},
+		{mode: "rw-rw----", err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.mode, func(t *testing.T) {
+			t.Parallel()
+			c := &Config{ListenSocketMode: tc.mode}
+			got, err := c.SocketMode()
+			if ok := err == nil; ok == tc.err || got != tc.want {
+				t.Errorf("Wrong result for %q; got %v, %v, want %v, error %v", tc.mode, got, err, tc.want, tc.err)
+			}
+		})
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "am-executor-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "executor.sock")
+
+	ln, err := listen(ListenNetworkUnix, path, 0600)
+	if err != nil {
+		t.Fatalf("Failed to listen on socket: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat socket: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("Wrong socket file mode; got %v", info.Mode())
+	}
+
+	// A socket that's still listened on can't be taken over
+	if _, err := listen(ListenNetworkUnix, path, 0600); err == nil {
+		t.Error("Expected an error listening on a socket that's in use")
+	}
+
+	// Closing the listener removes the socket file
+	_ = ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket file to be removed once the listener closed; got %v", err)
+	}
+
+	// A socket file left behind by an executor that didn't shut down cleanly is replaced
+	stale, err := net.ListenUnix(ListenNetworkUnix, &net.UnixAddr{Name: path, Net: ListenNetworkUnix})
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+	ln, err = listen(ListenNetworkUnix, path, 0600)
+	if err != nil {
+		t.Fatalf("Failed to listen on stale socket: %v", err)
+	}
+	_ = ln.Close()
+
+	// Files that aren't sockets are left alone
+	if err := ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(ListenNetworkUnix, path, 0600); err == nil {
+		t.Error("Expected an error listening over a regular file")
+	}
+}
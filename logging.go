@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Formats that log entries can be written in
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	// Layout of entry times in text format, matching the standard logger's
+	logTextTimeLayout = "2006/01/02 15:04:05"
+)
+
+// LogLevel is the severity of a log entry. Entries below a logger's level are discarded.
+type LogLevel int
+
+// Levels that entries can be logged at, from least to most severe
+const (
+	LevelDebug LogLevel = iota
+	// Decisions about alerts and commands, such as commands being run, skipped or signalled,
+	// without the payloads and environments that debug entries include
+	LevelDecision
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Names of log levels, as written in entries and accepted by ParseLogLevel
+var logLevelNames = map[LogLevel]string{
+	LevelDebug:    "debug",
+	LevelDecision: "decision",
+	LevelInfo:     "info",
+	LevelWarn:     "warn",
+	LevelError:    "error",
+}
+
+// String returns the name of a log level
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLogLevel returns the log level for a level name, defaulting to info
+func ParseLogLevel(level string) (LogLevel, error) {
+	lower := strings.ToLower(level)
+	if lower == "" {
+		return LevelInfo, nil
+	}
+	if lower == "warning" {
+		return LevelWarn, nil
+	}
+	for l, name := range logLevelNames {
+		if name == lower {
+			return l, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("Unknown log level %q, expected one of %s, %s, %s, %s or %s", level, LevelDebug, LevelDecision, LevelInfo, LevelWarn, LevelError)
+}
+
+// The logger used throughout the executor. main configures its format and level once the config has been read.
+var logger = NewLogger(log.Writer(), LogFormatText)
+
+// Fields are named values attached to a log entry, such as the command and alert fingerprint it's about
+type Fields map[string]interface{}
+
+// Logger writes log entries made of a message and fields, either as text or as one JSON object per line,
+// so that log aggregation systems can parse them.
+type Logger struct {
+	out    io.Writer
+	format string
+	level  LogLevel
+	// Fields attached to every entry, which an entry's own fields of the same name take precedence over
+	fields Fields
+	sync.Mutex
+}
+
+// NewLogger returns a logger writing entries at info level and above to w in the given format.
+// Unknown formats are written as text.
+func NewLogger(w io.Writer, format string) *Logger {
+	return &Logger{out: w, format: strings.ToLower(format), level: LevelInfo}
+}
+
+// ParseLogFormat returns the log format for a format name, defaulting to text
+func ParseLogFormat(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "", LogFormatText:
+		return LogFormatText, nil
+	case LogFormatJSON:
+		return LogFormatJSON, nil
+	}
+
+	return "", fmt.Errorf("Unknown log format %q, expected %s or %s", format, LogFormatText, LogFormatJSON)
+}
+
+// SetFormat changes the format that entries are written in
+func (l *Logger) SetFormat(format string) {
+	l.Lock()
+	defer l.Unlock()
+	l.format = strings.ToLower(format)
+}
+
+// SetLevel changes the least severe level that entries are written at
+func (l *Logger) SetLevel(level LogLevel) {
+	l.Lock()
+	defer l.Unlock()
+	l.level = level
+}
+
+// SetFields changes the fields attached to every entry, such as ones identifying the executor
+func (l *Logger) SetFields(fields Fields) {
+	l.Lock()
+	defer l.Unlock()
+	l.fields = fields
+}
+
+// Enabled returns true if entries at the given level are written
+func (l *Logger) Enabled(level LogLevel) bool {
+	l.Lock()
+	defer l.Unlock()
+	return level >= l.level
+}
+
+// Debug writes a log entry at debug level
+func (l *Logger) Debug(msg string, fields Fields) {
+	l.log(LevelDebug, msg, fields)
+}
+
+// Decision writes a log entry at decision level
+func (l *Logger) Decision(msg string, fields Fields) {
+	l.log(LevelDecision, msg, fields)
+}
+
+// Info writes a log entry at info level
+func (l *Logger) Info(msg string, fields Fields) {
+	l.log(LevelInfo, msg, fields)
+}
+
+// Warn writes a log entry at warn level
+func (l *Logger) Warn(msg string, fields Fields) {
+	l.log(LevelWarn, msg, fields)
+}
+
+// Error writes a log entry at error level
+func (l *Logger) Error(msg string, fields Fields) {
+	l.log(LevelError, msg, fields)
+}
+
+// Println writes a log entry at error level without fields, for libraries that log errors through the standard
+// logger's interface
+func (l *Logger) Println(v ...interface{}) {
+	l.Error(strings.TrimSuffix(fmt.Sprintln(v...), "\n"), nil)
+}
+
+// Fatal writes a log entry at error level, then exits the program
+func (l *Logger) Fatal(msg string, fields Fields) {
+	l.Error(msg, fields)
+	os.Exit(1)
+}
+
+// log writes an entry at a level, if the logger's level allows it
+func (l *Logger) log(level LogLevel, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.print(level, msg, fields)
+}
+
+// print writes an entry regardless of the logger's level
+func (l *Logger) print(level LogLevel, msg string, fields Fields) {
+	now := time.Now()
+	var line []byte
+	l.Lock()
+	defer l.Unlock()
+	if len(l.fields) > 0 {
+		all := make(Fields, len(l.fields)+len(fields))
+		for k, v := range l.fields {
+			all[k] = v
+		}
+		for k, v := range fields {
+			all[k] = v
+		}
+		fields = all
+	}
+	if l.format == LogFormatJSON {
+		line = l.formatJSON(now, level, msg, fields)
+	} else {
+		line = l.formatText(now, level, msg, fields)
+	}
+	_, _ = l.out.Write(line)
+}
+
+// formatJSON returns an entry as a line of JSON, with the time, level and message alongside the fields
+func (l *Logger) formatJSON(t time.Time, level LogLevel, msg string, fields Fields) []byte {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		entry[k] = fieldValue(v)
+	}
+	entry["time"] = t.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// Fall back to something parseable, rather than losing the entry
+		data, _ = json.Marshal(map[string]string{"time": t.Format(time.RFC3339Nano), "level": level.String(), "msg": msg, "error": err.Error()})
+	}
+	return append(data, '\n')
+}
+
+// formatText returns an entry like the standard logger writes it, with its level in capitals before the message and
+// its fields sorted by name after it
+func (l *Logger) formatText(t time.Time, level LogLevel, msg string, fields Fields) []byte {
+	var b strings.Builder
+	b.WriteString(t.Format(logTextTimeLayout))
+	b.WriteByte(' ')
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteByte(' ')
+	b.WriteString(msg)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(fieldValue(fields[k]))
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		_, _ = fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// fieldValue returns a field value in the form it should be logged in.
+// Errors and values with a String method are logged as strings, rather than as JSON objects.
+func fieldValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case error:
+		return val.Error()
+	case fmt.Stringer:
+		return val.String()
+	}
+	return v
+}
+
+// debug logs an entry at debug level about a command, if the command is verbose.
+// Commands can be verbose when debug logging is otherwise off, so the entry is written regardless of the logger's level.
+func (s *Server) debug(cmd *Command, msg string, fields Fields) {
+	if cmd.ShouldLog(s.config.Verbose) {
+		logger.print(LevelDebug, msg, fields)
+	}
+}
+
+// decision logs an entry at decision level about a command.
+// Verbose commands are logged regardless of the logger's level, like their debug entries,
+// and commands that turn verbose logging off aren't logged.
+func (s *Server) decision(cmd *Command, msg string, fields Fields) {
+	switch {
+	case cmd.ShouldLog(s.config.Verbose):
+		logger.print(LevelDecision, msg, fields)
+	case cmd.Verbose == nil:
+		logger.Decision(msg, fields)
+	}
+}
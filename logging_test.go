@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogFormat(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{in: "", want: LogFormatText, ok: true},
+		{in: "text", want: LogFormatText, ok: true},
+		{in: "JSON", want: LogFormatJSON, ok: true},
+		{in: "xml", ok: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseLogFormat(tc.in)
+			if ok := err == nil; ok != tc.ok || got != tc.want {
+				t.Errorf("Wrong result for %q; got %q, %v, want %q, ok %v", tc.in, got, err, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	cases := []struct {
+		in   string
+		want LogLevel
+		ok   bool
+	}{
+		{in: "", want: LevelInfo, ok: true},
+		{in: "debug", want: LevelDebug, ok: true},
+		{in: "decision", want: LevelDecision, ok: true},
+		{in: "INFO", want: LevelInfo, ok: true},
+		{in: "warn", want: LevelWarn, ok: true},
+		{in: "warning", want: LevelWarn, ok: true},
+		{in: "error", want: LevelError, ok: true},
+		{in: "trace", want: LevelInfo, ok: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseLogLevel(tc.in)
+			if ok := err == nil; ok != tc.ok || got != tc.want {
+				t.Errorf("Wrong result for %q; got %v, %v, want %v, ok %v", tc.in, got, err, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestLogger_Level(t *testing.T) {
+	cases := []struct {
+		level LogLevel
+		want  []string
+	}{
+		{level: LevelDebug, want: []string{"debug", "decision", "info", "warn", "error"}},
+		{level: LevelDecision, want: []string{"decision", "info", "warn", "error"}},
+		{level: LevelInfo, want: []string{"info", "warn", "error"}},
+		{level: LevelWarn, want: []string{"warn", "error"}},
+		{level: LevelError, want: []string{"error"}},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.level.String(), func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			l := NewLogger(&buf, LogFormatJSON)
+			l.SetLevel(tc.level)
+			l.Debug("debug", nil)
+			l.Decision("decision", nil)
+			l.Info("info", nil)
+			l.Warn("warn", nil)
+			l.Error("error", nil)
+
+			var got []string
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var entry map[string]interface{}
+				if err := dec.Decode(&entry); err != nil {
+					t.Fatalf("Failed to decode JSON entry: %v", err)
+				}
+				if entry["level"] != entry["msg"] {
+					t.Errorf("Wrong level for entry %v; got %v, want %v", entry["msg"], entry["level"], entry["msg"])
+				}
+				got = append(got, entry["msg"].(string))
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("Wrong entries logged at level %s; got %v, want %v", tc.level, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLogger_Info(t *testing.T) {
+	t.Parallel()
+	fields := Fields{
+		"command":  &Command{Cmd: "echo", Args: []string{"hi"}},
+		"duration": 1.5,
+		"error":    errors.New("boom"),
+		"result":   CmdOk,
+	}
+
+	var text bytes.Buffer
+	NewLogger(&text, LogFormatText).Info("Command finished", fields)
+	line := text.String()
+	want := ` INFO Command finished command="echo hi" duration=1.5 error=boom result=Ok` + "\n"
+	if !strings.HasSuffix(line, want) {
+		t.Errorf("Wrong text entry; got %q, want suffix %q", line, want)
+	}
+	if _, err := time.Parse(logTextTimeLayout, strings.TrimSuffix(line, want)); err != nil {
+		t.Errorf("Text entry doesn't start with a time: %v", err)
+	}
+
+	var js bytes.Buffer
+	NewLogger(&js, LogFormatJSON).Info("Command finished", fields)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(js.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to decode JSON entry %q: %v", js.String(), err)
+	}
+	wantJSON := map[string]interface{}{
+		"level":    "info",
+		"msg":      "Command finished",
+		"command":  "echo hi",
+		"duration": 1.5,
+		"error":    "boom",
+		"result":   "Ok",
+	}
+	for k, v := range wantJSON {
+		if entry[k] != v {
+			t.Errorf("Wrong JSON field %s; got %v, want %v", k, entry[k], v)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+		t.Errorf("Wrong JSON time: %v", err)
+	}
+}
+
+func TestLogger_SetFields(t *testing.T) {
+	t.Parallel()
+	var text bytes.Buffer
+	l := NewLogger(&text, LogFormatText)
+	l.SetFields(Fields{"pod": "web-0", "namespace": "shop"})
+	l.Info("Command finished", Fields{"namespace": "override", "result": CmdOk})
+	want := ` INFO Command finished namespace=override pod=web-0 result=Ok` + "\n"
+	if line := text.String(); !strings.HasSuffix(line, want) {
+		t.Errorf("Wrong text entry; got %q, want suffix %q", line, want)
+	}
+}
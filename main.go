@@ -4,7 +4,6 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -26,19 +25,99 @@ func stopServer(srv *http.Server) error {
 func init() {
 	// Customize the flag.Usage function's output
 	flag.Usage = func() {
-		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s [options] script [args..]\n\n", os.Args[0])
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s [options] script [args..]\n", os.Args[0])
+		_, _ = fmt.Fprintf(os.Stderr, "       %s bench [options]\n", os.Args[0])
+		_, _ = fmt.Fprintf(os.Stderr, "       %s simulate [options]\n\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		// Fire synthetic webhooks at an executor instead of running one
+		err := runBench(os.Args[2:], os.Stdout)
+		if err != nil && err != flag.ErrHelp {
+			logger.Fatal("Benchmark failed", Fields{"error": err})
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		// Send a single alert described by flags, to test how commands are wired up
+		err := runSimulate(os.Args[2:], os.Stdout)
+		if err != nil && err != flag.ErrHelp {
+			logger.Fatal("Simulation failed", Fields{"error": err})
+		}
+		return
+	}
+
 	// Determine configuration for service
 	c, err := readConfig()
 	if err != nil {
-		log.Fatalf("Couldn't determine configuration: %v", err)
+		logger.Fatal("Couldn't determine configuration", Fields{"error": err})
+	}
+	if c.CheckConfig {
+		if err := checkConfig(c, os.Stdout); err != nil {
+			logger.Fatal("Configuration check failed", Fields{"error": err})
+		}
+		return
 	}
+	logger.SetFormat(c.LogFormat)
+	level, err := ParseLogLevel(c.LogLevel)
+	if err != nil {
+		logger.Fatal("Couldn't determine log level", Fields{"error": err})
+	}
+	logger.SetLevel(level)
+	identity, err := ReadIdentity(c.Kubernetes, os.Getenv)
+	if err != nil {
+		logger.Fatal("Couldn't determine pod identity", Fields{"downward_api_dir": c.Kubernetes.DownwardAPIDir, "error": err})
+	}
+	// Tell apart the logs of executors running as sidecars of different pods
+	logger.SetFields(identity.Fields())
 	s := NewServer(c)
+	s.SetIdentity(identity)
 	defer s.fingerCount.Stop()
+	// Export spans that finished since the last export, so that they aren't lost when shutting down
+	defer s.tracer.Flush()
+	// Write samples of executions that finished since the last write
+	defer s.remoteWriter.Flush()
+	// Push the results of the latest executions, since the pushgateway won't see them otherwise
+	defer s.StopPushing()
+	if len(c.SpoolDir) > 0 {
+		err = s.OpenSpool(c.SpoolDir)
+		if err != nil {
+			logger.Fatal("Couldn't open spool directory", Fields{"spool_dir": c.SpoolDir, "error": err})
+		}
+	}
+	if len(c.HistoryDB) > 0 {
+		err = s.OpenHistoryDB(c.HistoryDB)
+		if err != nil {
+			logger.Fatal("Couldn't open history database", Fields{"history_db": c.HistoryDB, "error": err})
+		}
+		defer func() {
+			_ = s.historyDB.Close()
+		}()
+	}
+	if len(c.StateFile) > 0 {
+		err = s.LoadState(c.StateFile)
+		if err != nil {
+			logger.Fatal("Couldn't load runtime state", Fields{"state_file": c.StateFile, "error": err})
+		}
+	}
+	if len(c.DecisionHook) > 0 {
+		err = s.LoadDecisionHook(c.DecisionHook)
+		if err != nil {
+			logger.Fatal("Couldn't load decision hook", Fields{"decision_hook": c.DecisionHook, "error": err})
+		}
+	}
+	if len(c.Storage.Backend) > 0 {
+		err = s.OpenStorage(c.Storage)
+		if err != nil {
+			logger.Fatal("Couldn't open storage", Fields{"backend": c.Storage.Backend, "path": c.Storage.Path, "error": err})
+		}
+		defer func() {
+			_ = s.store.Close()
+		}()
+	}
 
 	// Listen for signals telling us to stop
 	signals := make(chan os.Signal, 1)
@@ -50,15 +129,17 @@ func main() {
 	select {
 	case err := <-srvResult:
 		if err != nil {
-			log.Fatalf("Failed to serve for %s: %v", c.ListenAddr, err)
+			logger.Fatal("Failed to serve", Fields{"address": c.ListenAddr, "error": err})
 		} else {
-			log.Println("HTTP server shut down")
+			logger.Info("HTTP server shut down", nil)
 		}
-	case s := <-signals:
-		log.Println("Shutting down due to signal:", s)
+	case sig := <-signals:
+		logger.Info("Shutting down due to signal", Fields{"signal": sig})
 		err := stopServer(srv)
 		if err != nil {
-			log.Printf("Failed to shut down HTTP server: %v", err)
+			logger.Error("Failed to shut down HTTP server", Fields{"error": err})
 		}
+		// Don't leave commands running without anything to report their results
+		s.StopCommands(serverShutdownTime)
 	}
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MaintenanceWindow marks alerts matching all of its labels as being under maintenance, until it expires.
+type MaintenanceWindow struct {
+	Labels  map[string]string `json:"labels"`
+	Expires time.Time         `json:"expires"`
+}
+
+// maintenanceRequest represents the body of a request to start a maintenance window
+type maintenanceRequest struct {
+	Labels map[string]string `json:"labels"`
+	// A duration string accepted by time.ParseDuration, such as "90m"
+	Duration string `json:"duration"`
+}
+
+// Maintenance tracks the maintenance windows that were requested at runtime.
+type Maintenance struct {
+	windows []MaintenanceWindow
+	sync.RWMutex
+}
+
+// Active returns true if the maintenance window hasn't expired at the given time
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	return now.Before(w.Expires)
+}
+
+// Matches returns true if all of the window's labels match the given alert labels
+func (w MaintenanceWindow) Matches(labels template.KV) bool {
+	for k, v := range w.Labels {
+		other, ok := labels[k]
+		if !ok || v != other {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Add starts a maintenance window
+func (m *Maintenance) Add(w MaintenanceWindow) {
+	m.Lock()
+	defer m.Unlock()
+	m.windows = append(m.windows, w)
+}
+
+// Set replaces all maintenance windows with the given ones, discarding any that have expired
+func (m *Maintenance) Set(windows []MaintenanceWindow) {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	m.windows = make([]MaintenanceWindow, 0, len(windows))
+	for _, w := range windows {
+		if w.Active(now) {
+			m.windows = append(m.windows, w)
+		}
+	}
+}
+
+// Matches returns true if an active maintenance window matches the common labels of the alert message.
+func (m *Maintenance) Matches(msg *template.Data) bool {
+	m.RLock()
+	defer m.RUnlock()
+	now := time.Now()
+	for _, w := range m.windows {
+		if w.Active(now) && w.Matches(msg.CommonLabels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Windows returns the active maintenance windows, discarding any that have expired
+func (m *Maintenance) Windows() []MaintenanceWindow {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	active := make([]MaintenanceWindow, 0, len(m.windows))
+	for _, w := range m.windows {
+		if w.Active(now) {
+			active = append(active, w)
+		}
+	}
+	m.windows = active
+
+	windows := make([]MaintenanceWindow, len(active))
+	copy(windows, active)
+	return windows
+}
+
+// NewMaintenance returns a Maintenance instance
+func NewMaintenance() *Maintenance {
+	return &Maintenance{
+		windows: make([]MaintenanceWindow, 0),
+	}
+}
+
+// handleMaintenance lists the active maintenance windows for GET requests, and starts a new one for POST requests.
+// Alerts matching an active maintenance window are skipped instead of running commands for them.
+func (s *Server) handleMaintenance(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, s.maintenance.Windows())
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		var mr maintenanceRequest
+		if err := json.Unmarshal(data, &mr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(mr.Labels) == 0 {
+			http.Error(w, "Missing labels for maintenance window", http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(mr.Duration)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid duration for maintenance window: %v", err), http.StatusBadRequest)
+			return
+		}
+		if d <= 0 {
+			http.Error(w, "Duration for maintenance window must be positive", http.StatusBadRequest)
+			return
+		}
+
+		mw := MaintenanceWindow{Labels: mr.Labels, Expires: time.Now().Add(d)}
+		s.maintenance.Add(mw)
+		s.saveState()
+		logger.Debug("Maintenance window active", Fields{"labels": mw.Labels, "expires": mw.Expires})
+		writeJSON(w, mw)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// writeJSON responds to an HTTP request with the JSON encoding of v
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	if err != nil {
+		logger.Error("Failed to write response", Fields{"error": err})
+	}
+}
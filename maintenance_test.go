@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaintenance_Matches(t *testing.T) {
+	cases := []struct {
+		name   string
+		window MaintenanceWindow
+		want   bool
+	}{
+		{
+			name:   "match",
+			window: MaintenanceWindow{Labels: map[string]string{"instance": "localhost:1234"}, Expires: time.Now().Add(time.Hour)},
+			want:   true,
+		},
+		{
+			name:   "no_match",
+			window: MaintenanceWindow{Labels: map[string]string{"instance": "localhost:5678"}, Expires: time.Now().Add(time.Hour)},
+			want:   false,
+		},
+		{
+			name:   "some_match",
+			window: MaintenanceWindow{Labels: map[string]string{"job": "broken", "env": "testing"}, Expires: time.Now().Add(time.Hour)},
+			want:   false,
+		},
+		{
+			name:   "expired",
+			window: MaintenanceWindow{Labels: map[string]string{"instance": "localhost:1234"}, Expires: time.Now().Add(-time.Second)},
+			want:   false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := NewMaintenance()
+			m.Add(tc.window)
+			if m.Matches(&amData) != tc.want {
+				t.Errorf("Wrong match for maintenance window %v; want %v", tc.window.Labels, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaintenance_Windows(t *testing.T) {
+	t.Parallel()
+	m := NewMaintenance()
+	m.Add(MaintenanceWindow{Labels: map[string]string{"job": "broken"}, Expires: time.Now().Add(-time.Second)})
+	m.Add(MaintenanceWindow{Labels: map[string]string{"job": "fixed"}, Expires: time.Now().Add(time.Hour)})
+
+	windows := m.Windows()
+	if len(windows) != 1 {
+		t.Fatalf("Wrong number of active maintenance windows; got %d, want %d", len(windows), 1)
+	}
+	if windows[0].Labels["job"] != "fixed" {
+		t.Errorf("Wrong maintenance window returned; got %v", windows[0].Labels)
+	}
+}
+
+func TestServer_handleMaintenance(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name       string
+		method     string
+		body       string
+		statusCode int
+		windows    int
+	}{
+		{
+			name:       "list",
+			method:     "GET",
+			statusCode: http.StatusOK,
+			windows:    0,
+		},
+		{
+			name:       "add",
+			method:     "POST",
+			body:       `{"labels": {"instance": "localhost:1234"}, "duration": "1h"}`,
+			statusCode: http.StatusOK,
+			windows:    1,
+		},
+		{
+			name:       "missing_labels",
+			method:     "POST",
+			body:       `{"duration": "1h"}`,
+			statusCode: http.StatusBadRequest,
+			windows:    0,
+		},
+		{
+			name:       "bad_duration",
+			method:     "POST",
+			body:       `{"labels": {"instance": "localhost:1234"}, "duration": "banana"}`,
+			statusCode: http.StatusBadRequest,
+			windows:    0,
+		},
+		{
+			name:       "negative_duration",
+			method:     "POST",
+			body:       `{"labels": {"instance": "localhost:1234"}, "duration": "-1h"}`,
+			statusCode: http.StatusBadRequest,
+			windows:    0,
+		},
+		{
+			name:       "bad_method",
+			method:     "DELETE",
+			statusCode: http.StatusMethodNotAllowed,
+			windows:    0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv, err := genServer()
+			if err != nil {
+				t.Fatal("Failed to generate server")
+			}
+
+			req := httptest.NewRequest(tc.method, "/_maintenance", bytes.NewReader([]byte(tc.body)))
+			w := httptest.NewRecorder()
+			srv.handleMaintenance(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.statusCode {
+				t.Errorf("Wrong response from handleMaintenance; got %d, want %d", resp.StatusCode, tc.statusCode)
+			}
+
+			if got := len(srv.maintenance.Windows()); got != tc.windows {
+				t.Errorf("Wrong number of maintenance windows; got %d, want %d", got, tc.windows)
+			}
+
+			if tc.method == "GET" {
+				var windows []MaintenanceWindow
+				if err := json.NewDecoder(resp.Body).Decode(&windows); err != nil {
+					t.Errorf("Failed to decode maintenance windows: %v", err)
+				}
+			}
+		})
+	}
+}
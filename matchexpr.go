@@ -0,0 +1,699 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"regexp"
+	"strings"
+)
+
+// Variables that match expressions can refer to, describing the alert being matched and the message it's part of
+var matchExprVars = map[string]bool{
+	"labels":            true,
+	"annotations":       true,
+	"status":            true,
+	"fingerprint":       true,
+	"generatorURL":      true,
+	"receiver":          true,
+	"groupLabels":       true,
+	"commonLabels":      true,
+	"commonAnnotations": true,
+	"externalURL":       true,
+}
+
+// MatchExpr is a parsed match_expr: a boolean expression over an alert, written in a small subset of CEL, as in
+//
+//	labels.severity in ['critical', 'page'] && !('silenced' in annotations)
+//
+// Expressions are made of string literals in single or double quotes, true and false, lists in brackets, the variables
+// in matchExprVars, field selection (labels.severity) and indexing (labels['severity']) of maps, the ==, !=, in, !, &&
+// and || operators, parentheses, and the matches, startsWith, endsWith and contains methods of strings.
+// Selecting a key that a map doesn't have gives an empty string, like match_labels_re does for missing labels;
+// use in to tell whether the key is there.
+type MatchExpr struct {
+	root exprNode
+}
+
+// ParseMatchExpr parses a match expression, checking the types of all of its parts, so that type errors like comparing
+// a map with a string are caught before any alert arrives, even in branches that && and || would skip
+func ParseMatchExpr(src string) (*MatchExpr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+
+	kind, err := root.check()
+	if err != nil {
+		return nil, err
+	}
+	if kind != kindBool {
+		return nil, fmt.Errorf("expression evaluates to %s, expected a boolean", kind)
+	}
+	return &MatchExpr{root: root}, nil
+}
+
+// Eval evaluates the expression for an alert of the given message
+func (e *MatchExpr) Eval(msg *template.Data, alert template.Alert) (bool, error) {
+	env := map[string]interface{}{
+		"labels":            alert.Labels,
+		"annotations":       alert.Annotations,
+		"status":            alert.Status,
+		"fingerprint":       alert.Fingerprint,
+		"generatorURL":      alert.GeneratorURL,
+		"receiver":          msg.Receiver,
+		"groupLabels":       msg.GroupLabels,
+		"commonLabels":      msg.CommonLabels,
+		"commonAnnotations": msg.CommonAnnotations,
+		"externalURL":       msg.ExternalURL,
+	}
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluates to %s, expected a boolean", exprType(v))
+	}
+	return b, nil
+}
+
+// exprType returns the name of the type of a value, for errors
+func exprType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case template.KV:
+		return "a map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// exprKind is the type of value that a part of a match expression evaluates to
+type exprKind int
+
+// Types of values in match expressions
+const (
+	kindString exprKind = iota
+	kindBool
+	kindList
+	kindMap
+)
+
+func (k exprKind) String() string {
+	switch k {
+	case kindString:
+		return "a string"
+	case kindBool:
+		return "a boolean"
+	case kindList:
+		return "a list"
+	}
+	return "a map"
+}
+
+// Kinds of tokens of match expressions
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokPunct
+)
+
+type exprToken struct {
+	kind int
+	text string
+	// Offset of the token in the expression, for errors
+	pos int
+}
+
+// lexExpr splits a match expression into tokens, ending with a tokEOF token
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+			start := i
+			for i < len(src) && (src[i] == '_' || ('a' <= src[i] && src[i] <= 'z') || ('A' <= src[i] && src[i] <= 'Z') || ('0' <= src[i] && src[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokIdent, text: src[start:i], pos: start})
+		case c == '\'' || c == '"':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(src) {
+					return nil, fmt.Errorf("unterminated string at offset %d", start)
+				}
+				if src[i] == c {
+					i++
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					case '\\', '\'', '"':
+						b.WriteByte(src[i])
+					default:
+						// Keep other escapes, which regular expressions for matches rely on
+						b.WriteByte('\\')
+						b.WriteByte(src[i])
+					}
+					continue
+				}
+				b.WriteByte(src[i])
+			}
+			tokens = append(tokens, exprToken{kind: tokString, text: b.String(), pos: start})
+		case strings.HasPrefix(src[i:], "&&") || strings.HasPrefix(src[i:], "||") || strings.HasPrefix(src[i:], "==") || strings.HasPrefix(src[i:], "!="):
+			tokens = append(tokens, exprToken{kind: tokPunct, text: src[i : i+2], pos: i})
+			i += 2
+		case strings.IndexByte("!()[],.", c) >= 0:
+			tokens = append(tokens, exprToken{kind: tokPunct, text: src[i : i+1], pos: i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+	return append(tokens, exprToken{kind: tokEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+// exprParser parses match expressions by recursive descent, with a method for each level of precedence
+type exprParser struct {
+	tokens []exprToken
+	next   int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+// accept consumes the next token if it's the given punctuation or keyword
+func (p *exprParser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokPunct || t.kind == tokIdent) && t.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// expect consumes the next token, returning an error if it isn't the given punctuation
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at offset %d, got %q", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right exprNode
+		right, err = p.parseAnd()
+		left = logicNode{or: true, left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseRelation()
+	for err == nil && p.accept("&&") {
+		var right exprNode
+		right, err = p.parseRelation()
+		left = logicNode{left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseRelation() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "in"} {
+		if p.accept(op) {
+			right, err := p.parseUnary()
+			return relationNode{op: op, left: left, right: right}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		return notNode{x: x}, err
+	}
+	return p.parseMember()
+}
+
+func (p *exprParser) parseMember() (exprNode, error) {
+	x, err := p.parsePrimary()
+	for err == nil {
+		switch {
+		case p.accept("."):
+			t := p.peek()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected a field or method name at offset %d, got %q", t.pos, t.text)
+			}
+			p.next++
+			if !p.accept("(") {
+				x = indexNode{x: x, key: literalNode{value: t.text}}
+				continue
+			}
+			var args []exprNode
+			if args, err = p.parseList(")"); err == nil {
+				x, err = newCallNode(x, t, args)
+			}
+		case p.accept("["):
+			var key exprNode
+			if key, err = p.parseOr(); err == nil {
+				err = p.expect("]")
+			}
+			x = indexNode{x: x, key: key}
+		default:
+			return x, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokString:
+		p.next++
+		return literalNode{value: t.text}, nil
+	case t.kind == tokIdent && (t.text == "true" || t.text == "false"):
+		p.next++
+		return literalNode{value: t.text == "true"}, nil
+	case t.kind == tokIdent:
+		if !matchExprVars[t.text] {
+			return nil, fmt.Errorf("unknown variable %q at offset %d", t.text, t.pos)
+		}
+		p.next++
+		return varNode{name: t.text}, nil
+	case p.accept("("):
+		x, err := p.parseOr()
+		if err == nil {
+			err = p.expect(")")
+		}
+		return x, err
+	case p.accept("["):
+		items, err := p.parseList("]")
+		return listNode{items: items}, err
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+// parseList parses comma-separated expressions up to the given closing punctuation
+func (p *exprParser) parseList(end string) ([]exprNode, error) {
+	var items []exprNode
+	if p.accept(end) {
+		return items, nil
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept(end) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// exprNode is a node of a parsed match expression
+type exprNode interface {
+	eval(env map[string]interface{}) (interface{}, error)
+	// check returns the type of value the node evaluates to, or an error if its parts have the wrong types for it
+	check() (exprKind, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n literalNode) check() (exprKind, error) {
+	if _, ok := n.value.(bool); ok {
+		return kindBool, nil
+	}
+	return kindString, nil
+}
+
+type varNode struct {
+	name string
+}
+
+func (n varNode) eval(env map[string]interface{}) (interface{}, error) {
+	return env[n.name], nil
+}
+
+func (n varNode) check() (exprKind, error) {
+	switch n.name {
+	case "labels", "annotations", "groupLabels", "commonLabels", "commonAnnotations":
+		return kindMap, nil
+	}
+	return kindString, nil
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+func (n listNode) eval(env map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+func (n listNode) check() (exprKind, error) {
+	for _, item := range n.items {
+		if _, err := item.check(); err != nil {
+			return 0, err
+		}
+	}
+	return kindList, nil
+}
+
+// indexNode looks up a key of a map, giving an empty string for keys it doesn't have
+type indexNode struct {
+	x   exprNode
+	key exprNode
+}
+
+func (n indexNode) eval(env map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := x.(template.KV)
+	if !ok {
+		return nil, fmt.Errorf("can't look up a key of %s", exprType(x))
+	}
+	k, ok := key.(string)
+	if !ok {
+		return nil, fmt.Errorf("can't look up %s as a key", exprType(key))
+	}
+	return m[k], nil
+}
+
+func (n indexNode) check() (exprKind, error) {
+	x, err := n.x.check()
+	if err != nil {
+		return 0, err
+	}
+	key, err := n.key.check()
+	if err != nil {
+		return 0, err
+	}
+	if x != kindMap {
+		return 0, fmt.Errorf("can't look up a key of %s", x)
+	}
+	if key != kindString {
+		return 0, fmt.Errorf("can't look up %s as a key", key)
+	}
+	return kindString, nil
+}
+
+type notNode struct {
+	x exprNode
+}
+
+func (n notNode) eval(env map[string]interface{}) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := x.(bool)
+	if !ok {
+		return nil, fmt.Errorf("can't negate %s", exprType(x))
+	}
+	return !b, nil
+}
+
+func (n notNode) check() (exprKind, error) {
+	x, err := n.x.check()
+	if err == nil && x != kindBool {
+		err = fmt.Errorf("can't negate %s", x)
+	}
+	return kindBool, err
+}
+
+// logicNode is an && or || of booleans, which only evaluates its right side if the left side doesn't decide it
+type logicNode struct {
+	or    bool
+	left  exprNode
+	right exprNode
+}
+
+func (n logicNode) eval(env map[string]interface{}) (interface{}, error) {
+	for _, side := range []exprNode{n.left, n.right} {
+		v, err := side.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean on each side of && and ||, got %s", exprType(v))
+		}
+		if b == n.or {
+			return b, nil
+		}
+	}
+	return !n.or, nil
+}
+
+func (n logicNode) check() (exprKind, error) {
+	for _, side := range []exprNode{n.left, n.right} {
+		kind, err := side.check()
+		if err != nil {
+			return 0, err
+		}
+		if kind != kindBool {
+			return 0, fmt.Errorf("expected a boolean on each side of && and ||, got %s", kind)
+		}
+	}
+	return kindBool, nil
+}
+
+// relationNode compares strings or booleans with == and !=, or tells whether a value is in a list or a key is in a map
+type relationNode struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+func (n relationNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op != "in" {
+		equal, err := exprEqual(left, right)
+		return equal == (n.op == "=="), err
+	}
+	switch r := right.(type) {
+	case []interface{}:
+		for _, item := range r {
+			if equal, err := exprEqual(left, item); err != nil || equal {
+				return equal, err
+			}
+		}
+		return false, nil
+	case template.KV:
+		k, ok := left.(string)
+		if !ok {
+			return nil, fmt.Errorf("can't look up %s as a key", exprType(left))
+		}
+		_, ok = r[k]
+		return ok, nil
+	}
+	return nil, fmt.Errorf("expected a list or a map on the right of in, got %s", exprType(right))
+}
+
+func (n relationNode) check() (exprKind, error) {
+	left, err := n.left.check()
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.check()
+	if err != nil {
+		return 0, err
+	}
+
+	if n.op != "in" {
+		return kindBool, checkEqual(left, right)
+	}
+	switch right {
+	case kindList:
+		// Lists are only ever written out, so the items' types are known
+		for _, item := range n.right.(listNode).items {
+			kind, _ := item.check()
+			if err := checkEqual(left, kind); err != nil {
+				return 0, err
+			}
+		}
+		return kindBool, nil
+	case kindMap:
+		if left != kindString {
+			return 0, fmt.Errorf("can't look up %s as a key", left)
+		}
+		return kindBool, nil
+	}
+	return 0, fmt.Errorf("expected a list or a map on the right of in, got %s", right)
+}
+
+// exprEqual returns true if two strings or booleans are equal
+func exprEqual(a interface{}, b interface{}) (bool, error) {
+	switch a.(type) {
+	case string, bool:
+		if exprType(a) == exprType(b) {
+			return a == b, nil
+		}
+	}
+	return false, fmt.Errorf("can't compare %s with %s", exprType(a), exprType(b))
+}
+
+// checkEqual returns an error unless values of the given types can be compared, as exprEqual does
+func checkEqual(a exprKind, b exprKind) error {
+	if a != b || (a != kindString && a != kindBool) {
+		return fmt.Errorf("can't compare %s with %s", a, b)
+	}
+	return nil
+}
+
+// callNode calls a method of a string with string arguments
+type callNode struct {
+	recv   exprNode
+	method string
+	args   []exprNode
+	// The expression of matches, if its argument is a literal, so that it's only compiled once
+	re *regexp.Regexp
+}
+
+// String methods that match expressions can call, and how many arguments they take
+var matchExprMethods = map[string]int{
+	"matches":    1,
+	"startsWith": 1,
+	"endsWith":   1,
+	"contains":   1,
+}
+
+// newCallNode returns a node calling the method named by t, checking its arguments
+func newCallNode(recv exprNode, t exprToken, args []exprNode) (exprNode, error) {
+	n, ok := matchExprMethods[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %q at offset %d", t.text, t.pos)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("method %s at offset %d takes %d argument, got %d", t.text, t.pos, n, len(args))
+	}
+	call := callNode{recv: recv, method: t.text, args: args}
+	if lit, ok := args[0].(literalNode); ok && t.text == "matches" {
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("method matches at offset %d takes a string", t.pos)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at offset %d: %w", t.pos, err)
+		}
+		call.re = re
+	}
+	return call, nil
+}
+
+func (n callNode) eval(env map[string]interface{}) (interface{}, error) {
+	recv, err := n.recv.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := recv.(string)
+	if !ok {
+		return nil, fmt.Errorf("can't call %s on %s", n.method, exprType(recv))
+	}
+	arg, err := n.args[0].eval(env)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s takes a string, got %s", n.method, exprType(arg))
+	}
+
+	switch n.method {
+	case "matches":
+		re := n.re
+		if re == nil {
+			if re, err = regexp.Compile(a); err != nil {
+				return nil, err
+			}
+		}
+		return re.MatchString(s), nil
+	case "startsWith":
+		return strings.HasPrefix(s, a), nil
+	case "endsWith":
+		return strings.HasSuffix(s, a), nil
+	}
+	return strings.Contains(s, a), nil
+}
+
+func (n callNode) check() (exprKind, error) {
+	recv, err := n.recv.check()
+	if err != nil {
+		return 0, err
+	}
+	if recv != kindString {
+		return 0, fmt.Errorf("can't call %s on %s", n.method, recv)
+	}
+	arg, err := n.args[0].check()
+	if err != nil {
+		return 0, err
+	}
+	if arg != kindString {
+		return 0, fmt.Errorf("%s takes a string, got %s", n.method, arg)
+	}
+	return kindBool, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"testing"
+)
+
+func TestMatchExpr_Eval(t *testing.T) {
+	msg := &template.Data{
+		Receiver:     "pager",
+		CommonLabels: template.KV{"alertname": "DiskFull"},
+	}
+	alert := template.Alert{
+		Status:      "firing",
+		Fingerprint: "boop",
+		Labels:      template.KV{"alertname": "DiskFull", "severity": "critical", "instance": "db-1.example.com:9100"},
+		Annotations: template.KV{"summary": "Disk is full"},
+	}
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{expr: `labels.severity == 'critical'`, want: true},
+		{expr: `labels["severity"] != "critical"`, want: false},
+		{expr: `labels.severity in ['critical', 'page'] && !('silenced' in annotations)`, want: true},
+		{expr: `labels.severity in ['warning'] || receiver == 'pager'`, want: true},
+		{expr: `'summary' in annotations`, want: true},
+		{expr: `labels.team == ''`, want: true},
+		{expr: `labels.instance.matches('^db-[0-9]+\\.')`, want: true},
+		{expr: `labels.instance.matches(labels.alertname)`, want: false},
+		{expr: `labels.instance.startsWith('db-') && labels.instance.endsWith(':9100')`, want: true},
+		{expr: `annotations.summary.contains('full')`, want: true},
+		{expr: `status == 'firing' && fingerprint == 'boop' && commonLabels.alertname == labels.alertname`, want: true},
+		{expr: `!(labels.severity == 'critical' || true)`, want: false},
+		{expr: `true && false`, want: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.expr, func(t *testing.T) {
+			t.Parallel()
+			e, err := ParseMatchExpr(tc.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", tc.expr, err)
+			}
+			got, err := e.Eval(msg, alert)
+			if err != nil {
+				t.Fatalf("Failed to evaluate %q: %v", tc.expr, err)
+			}
+			if got != tc.want {
+				t.Errorf("Wrong result for %q; got %v, want %v", tc.expr, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseMatchExpr_errors(t *testing.T) {
+	cases := []string{
+		``,
+		`labels.severity`,
+		`labels == 'critical'`,
+		`severity == 'critical'`,
+		`labels.severity = 'critical'`,
+		`labels.severity == 'critical`,
+		`labels.severity in 'critical'`,
+		`labels.severity.matches('(')`,
+		`labels.severity.lower()`,
+		`labels.severity.contains('a', 'b')`,
+		`(labels.severity == 'critical'`,
+		`['a', 'b'`,
+		`!labels.severity`,
+		`labels.severity == true`,
+		// Type errors are caught in branches that && and || skip
+		`false && labels == 'critical'`,
+		`true || !labels`,
+		`labels.severity == 'critical' || labels.severity in [true]`,
+		`false && labels.severity.startsWith(annotations)`,
+	}
+
+	for _, expr := range cases {
+		expr := expr // Capture range variable, for use in anonymous function
+		t.Run(expr, func(t *testing.T) {
+			t.Parallel()
+			if _, err := ParseMatchExpr(expr); err == nil {
+				t.Errorf("Expected an error parsing %q", expr)
+			}
+		})
+	}
+}
+
+func TestCommand_MatchExpr(t *testing.T) {
+	t.Parallel()
+	msg := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			{Fingerprint: "warn", Labels: template.KV{"severity": "warning"}},
+			{Fingerprint: "crit", Labels: template.KV{"severity": "critical"}},
+		},
+	}
+
+	cmd := Command{Cmd: "echo", MatchExpr: `labels.severity in ['critical', 'page']`}
+	if !cmd.Matches(msg) {
+		t.Error("Expected the command to match a message with a critical alert")
+	}
+	if alert, ok := cmd.Alert(msg); !ok || alert.Fingerprint != "crit" {
+		t.Errorf("Expected the critical alert to be the one matched; got %q, %v", alert.Fingerprint, ok)
+	}
+
+	cmd.MatchExpr = `labels.severity == 'page'`
+	if cmd.Matches(msg) {
+		t.Error("Expected the command not to match a message without a paging alert")
+	}
+}
+
+func TestCommand_CompileMatchExpr(t *testing.T) {
+	t.Parallel()
+	msg := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Fingerprint: "crit", Labels: template.KV{"severity": "critical"}}},
+	}
+
+	cmd := &Command{Cmd: "echo", MatchExpr: `labels.severity == 'critical'`}
+	if err := cmd.CompileMatchExpr(); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	// Matching uses the parsed expression, rather than parsing MatchExpr again
+	cmd.MatchExpr = `labels.severity ==`
+	if !cmd.Matches(msg) {
+		t.Errorf("Command should match with its parsed expression")
+	}
+	if resolved, _ := (Command{ResolvedCmd: "true", matchExpr: cmd.matchExpr}).ResolvedCommand(); resolved.matchExpr == nil {
+		t.Errorf("Resolved command should share the parsed expression")
+	}
+
+	if err := (&Command{MatchExpr: `labels == 'critical'`}).CompileMatchExpr(); err == nil {
+		t.Errorf("Missing error for invalid expression")
+	}
+}
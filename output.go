@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// lineLogger is an io.Writer that logs command output one line at a time, with fields attributing it to an execution,
+// so that output from commands running at the same time can be told apart.
+// Output beyond a limit is discarded, so that chatty or broken commands can't flood the log or use up memory.
+type lineLogger struct {
+	logger *Logger
+	fields Fields
+	buf    []byte
+	// The most bytes of output to log; zero or negative means no limit
+	limit     ByteSize
+	written   ByteSize
+	truncated bool
+	// The most recent lines logged, up to tailLines of them
+	tail      []string
+	tailLines int
+	sync.Mutex
+}
+
+// newLineLogger returns a writer that logs lines to l with the given fields.
+// Output after the first limit bytes is discarded, if limit is positive.
+func newLineLogger(l *Logger, fields Fields, limit ByteSize) *lineLogger {
+	return &lineLogger{logger: l, fields: fields, limit: limit}
+}
+
+// Write logs each complete line written, holding on to any trailing partial line until it's completed or flushed.
+// Output beyond the limit is discarded without an error, so that the command isn't disturbed by failed writes.
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	n := len(p)
+	if l.truncated {
+		return n, nil
+	}
+	if l.limit > 0 && l.written+ByteSize(len(p)) > l.limit {
+		p = p[:l.limit-l.written]
+		l.truncated = true
+	}
+	l.written += ByteSize(len(p))
+
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	if l.truncated {
+		l.flush()
+		l.logger.Info("Command output truncated", l.with("limit", l.limit))
+	}
+
+	return n, nil
+}
+
+// KeepTail keeps the last n lines of output, so that they can be reported once the command finishes
+func (l *lineLogger) KeepTail(n int) {
+	l.Lock()
+	defer l.Unlock()
+	l.tailLines = n
+}
+
+// Tail returns the last lines of output kept
+func (l *lineLogger) Tail() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string(nil), l.tail...)
+}
+
+// lastLines returns the last n lines, or none if n isn't positive
+func lastLines(lines []string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Truncated returns true if output was discarded for being over the limit
+func (l *lineLogger) Truncated() bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.truncated
+}
+
+// Flush logs any partial line left over once the command has finished writing
+func (l *lineLogger) Flush() {
+	l.Lock()
+	defer l.Unlock()
+	l.flush()
+}
+
+// flush logs any partial line; the lock must be held
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.log(l.buf)
+		l.buf = nil
+	}
+}
+
+// log writes a line to the logger, as the message of an entry with the execution's fields
+func (l *lineLogger) log(line []byte) {
+	msg := string(bytes.TrimSuffix(line, []byte("\r")))
+	l.logger.Info(msg, l.fields)
+	if l.tailLines > 0 {
+		if len(l.tail) >= l.tailLines {
+			l.tail = l.tail[len(l.tail)-l.tailLines+1:]
+		}
+		l.tail = append(l.tail, msg)
+	}
+}
+
+// with returns the execution's fields, along with another field
+func (l *lineLogger) with(key string, value interface{}) Fields {
+	fields := make(Fields, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return fields
+}
+
+// outputFields returns the fields that output of an execution of a command is logged with, naming the command,
+// the fingerprint of the alert it's running for, and an ID that's unique to the execution.
+func (s *Server) outputFields(cmd *Command, fingerprint string) Fields {
+	fields := Fields{
+		"command":   cmd.Cmd,
+		"execution": atomic.AddUint64(&s.executions, 1),
+	}
+	if fingerprint != "" {
+		fields["fingerprint"] = fingerprint
+	}
+	return fields
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLineLogger_Write(t *testing.T) {
+	cases := []struct {
+		name      string
+		writes    []string
+		limit     ByteSize
+		want      []string
+		truncated bool
+	}{
+		{name: "empty", writes: []string{""}, want: nil},
+		{name: "lines", writes: []string{"one\ntwo\n"}, want: []string{"one", "two"}},
+		{name: "split_line", writes: []string{"o", "ne\ntw", "o\n"}, want: []string{"one", "two"}},
+		{name: "partial_line", writes: []string{"one\ntwo"}, want: []string{"one", "two"}},
+		{name: "crlf", writes: []string{"one\r\n"}, want: []string{"one"}},
+		{name: "under_limit", writes: []string{"one\ntwo\n"}, limit: 8, want: []string{"one", "two"}},
+		{
+			name:      "over_limit",
+			writes:    []string{"one\ntwo\n", "three\n"},
+			limit:     6,
+			want:      []string{"one", "tw", "Command output truncated"},
+			truncated: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var b bytes.Buffer
+			l := newLineLogger(NewLogger(&b, LogFormatJSON), Fields{"execution": 1}, tc.limit)
+			for _, w := range tc.writes {
+				n, err := l.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Failed to write %q; got %d, %v", w, n, err)
+				}
+			}
+			l.Flush()
+
+			var got []string
+			scanner := bufio.NewScanner(&b)
+			for scanner.Scan() {
+				var entry map[string]interface{}
+				if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+					t.Fatalf("Failed to decode log entry %q: %v", scanner.Text(), err)
+				}
+				if entry["execution"] != float64(1) {
+					t.Errorf("Log entry is missing execution field: %v", entry)
+				}
+				got = append(got, entry["msg"].(string))
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("Wrong lines logged; got %q, want %q", got, tc.want)
+			}
+			if l.Truncated() != tc.truncated {
+				t.Errorf("Wrong truncated state; got %v, want %v", l.Truncated(), tc.truncated)
+			}
+		})
+	}
+}
+
+func TestServer_outputFields(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "echo"}
+
+	first := srv.outputFields(cmd, "boop")
+	if first["command"] != "echo" || first["fingerprint"] != "boop" || first["execution"] != uint64(1) {
+		t.Errorf("Wrong fields for first execution; got %v", first)
+	}
+	second := srv.outputFields(cmd, "")
+	if _, ok := second["fingerprint"]; ok || second["execution"] != uint64(2) {
+		t.Errorf("Wrong fields for second execution without a fingerprint; got %v", second)
+	}
+}
+
+func TestLineLogger_Tail(t *testing.T) {
+	var b bytes.Buffer
+	l := newLineLogger(NewLogger(&b, LogFormatJSON), Fields{"execution": 1}, 0)
+	l.KeepTail(2)
+	_, _ = l.Write([]byte("one\ntwo\nthree\nfo"))
+	l.Flush()
+	if got := l.Tail(); len(got) != 2 || got[0] != "three" || got[1] != "fo" {
+		t.Errorf("Wrong tail; got %q, want [\"three\" \"fo\"]", got)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// Version of alertmanager's webhook payload schema that the executor understands
+	payloadVersionCurrent = 4
+	// Metric label for payloads that don't state a version, such as hand-written ones
+	PayloadLabelNone = "none"
+	// Metric label for arrays of alerts in the format of alertmanager's api/v2, such as sent by amtool
+	PayloadLabelAPIv2 = "api_v2"
+	// Separates label names and values when fingerprinting, the same as prometheus does
+	fingerprintSeparator = byte(255)
+)
+
+// payloadVersion holds the part of a webhook payload that says which schema version the rest of it follows
+type payloadVersion struct {
+	Version string `json:"version"`
+}
+
+// apiAlert is an alert in the format of alertmanager's api/v2, as posted to it by prometheus and amtool, or as listed
+// by it. Listed alerts also have a fingerprint; their status is an object rather than a string, so it isn't decoded.
+type apiAlert struct {
+	Labels       template.KV `json:"labels"`
+	Annotations  template.KV `json:"annotations"`
+	StartsAt     time.Time   `json:"startsAt"`
+	EndsAt       time.Time   `json:"endsAt"`
+	GeneratorURL string      `json:"generatorURL"`
+	Fingerprint  string      `json:"fingerprint"`
+}
+
+// decodePayload decodes a webhook payload from alertmanager, adapting it to the schema version the executor understands.
+// Payloads are counted per version, so that operators can tell when alertmanager's webhook schema changes under them.
+// Arrays of alerts in the format of alertmanager's api/v2 are accepted too, and converted to a webhook payload.
+//
+// Payloads without a version, or from versions older than the current one, may lack fields that newer versions have;
+// these are filled in where they can be derived from the rest of the payload.
+// Payloads from newer versions are decoded as far as the fields we know of go, with a warning,
+// rather than being rejected outright.
+func (s *Server) decodePayload(data []byte) (*template.Data, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		amMsg, err := decodeAPIAlerts(trimmed, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		s.payloadCounter.WithLabelValues(PayloadLabelAPIv2).Inc()
+		return amMsg, nil
+	}
+
+	var pv payloadVersion
+	if err := json.Unmarshal(data, &pv); err != nil {
+		return nil, err
+	}
+
+	label := PayloadLabelNone
+	if pv.Version != "" {
+		version, err := strconv.Atoi(pv.Version)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("Invalid webhook payload version %q", pv.Version)
+		}
+		label = strconv.Itoa(version)
+		if version > payloadVersionCurrent {
+			logger.Warn("Webhook payload version is newer than supported; only known fields are used", Fields{"version": version, "supported": payloadVersionCurrent})
+		}
+	}
+	s.payloadCounter.WithLabelValues(label).Inc()
+
+	var amMsg = &template.Data{}
+	if err := json.Unmarshal(data, amMsg); err != nil {
+		return nil, err
+	}
+	adaptPayload(amMsg)
+	return amMsg, nil
+}
+
+// decodeAPIAlerts converts an array of alerts in the format of alertmanager's api/v2 to a webhook payload.
+// Alerts that ended before now are resolved, and the others are firing. The labels and annotations that all the alerts
+// share become the payload's common ones, the same as alertmanager would send for a group of them.
+func decodeAPIAlerts(data []byte, now time.Time) (*template.Data, error) {
+	var alerts []apiAlert
+	if err := json.Unmarshal(data, &alerts); err != nil {
+		return nil, err
+	}
+
+	amMsg := &template.Data{
+		Alerts:            make(template.Alerts, 0, len(alerts)),
+		GroupLabels:       template.KV{},
+		CommonLabels:      template.KV{},
+		CommonAnnotations: template.KV{},
+	}
+	for i, a := range alerts {
+		alert := template.Alert{
+			Status:       "firing",
+			Labels:       a.Labels,
+			Annotations:  a.Annotations,
+			StartsAt:     a.StartsAt,
+			EndsAt:       a.EndsAt,
+			GeneratorURL: a.GeneratorURL,
+			Fingerprint:  a.Fingerprint,
+		}
+		if !a.EndsAt.IsZero() && !a.EndsAt.After(now) {
+			alert.Status = "resolved"
+		}
+		if alert.Labels == nil {
+			alert.Labels = template.KV{}
+		}
+		if alert.Annotations == nil {
+			alert.Annotations = template.KV{}
+		}
+		amMsg.Alerts = append(amMsg.Alerts, alert)
+
+		if i == 0 {
+			for k, v := range alert.Labels {
+				amMsg.CommonLabels[k] = v
+			}
+			for k, v := range alert.Annotations {
+				amMsg.CommonAnnotations[k] = v
+			}
+			continue
+		}
+		keepCommon(amMsg.CommonLabels, alert.Labels)
+		keepCommon(amMsg.CommonAnnotations, alert.Annotations)
+	}
+	adaptPayload(amMsg)
+	return amMsg, nil
+}
+
+// keepCommon removes the pairs from common that kv doesn't have
+func keepCommon(common, kv template.KV) {
+	for k, v := range common {
+		if kv[k] != v {
+			delete(common, k)
+		}
+	}
+}
+
+// adaptPayload fills in fields that payloads from older alertmanager versions don't have.
+//
+// Alertmanager only began sending alert fingerprints in version 0.19, without changing the payload version,
+// so they're computed from alert labels when missing, the same way alertmanager computes them.
+// The message status is derived from its alerts if it's missing: firing if any of them are.
+func adaptPayload(amMsg *template.Data) {
+	for i, alert := range amMsg.Alerts {
+		if alert.Fingerprint == "" && len(alert.Labels) > 0 {
+			amMsg.Alerts[i].Fingerprint = fingerprint(alert.Labels)
+		}
+	}
+
+	if amMsg.Status == "" && len(amMsg.Alerts) > 0 {
+		amMsg.Status = "resolved"
+		if len(amMsg.Alerts.Firing()) > 0 {
+			amMsg.Status = "firing"
+		}
+	}
+}
+
+// fingerprint returns the fingerprint of an alert with the given labels, as alertmanager would compute it:
+// an FNV-1a hash of the label names and values in name order, formatted as 16 hex digits.
+func fingerprint(labels template.KV) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write([]byte{fingerprintSeparator})
+		_, _ = h.Write([]byte(labels[name]))
+		_, _ = h.Write([]byte{fingerprintSeparator})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
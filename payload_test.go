@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServer_decodePayload(t *testing.T) {
+	cases := []struct {
+		name        string
+		payload     string
+		err         bool
+		label       string
+		status      string
+		fingerprint string
+	}{
+		{
+			name:        "current",
+			payload:     `{"version":"4","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"HighLoad"},"fingerprint":"abc"}]}`,
+			label:       "4",
+			status:      "firing",
+			fingerprint: "abc",
+		},
+		{
+			name:        "missing_fingerprint",
+			payload:     `{"version":"4","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"HighLoad","instance":"host1"}}]}`,
+			label:       "4",
+			status:      "firing",
+			fingerprint: "b542feeb2bd6405a",
+		},
+		{
+			name:        "unversioned_without_status",
+			payload:     `{"alerts":[{"status":"resolved","labels":{"alertname":"HighLoad","instance":"host1"}}]}`,
+			label:       PayloadLabelNone,
+			status:      "resolved",
+			fingerprint: "b542feeb2bd6405a",
+		},
+		{
+			name:        "older",
+			payload:     `{"version":"3","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"HighLoad"},"fingerprint":"abc"}]}`,
+			label:       "3",
+			status:      "firing",
+			fingerprint: "abc",
+		},
+		{
+			name:        "newer",
+			payload:     `{"version":"5","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"HighLoad"},"fingerprint":"abc"}],"unknownField":true}`,
+			label:       "5",
+			status:      "firing",
+			fingerprint: "abc",
+		},
+		{
+			name:        "api_v2",
+			payload:     ` [{"labels":{"alertname":"HighLoad","instance":"host1"},"startsAt":"2020-01-01T00:00:00Z"}]`,
+			label:       PayloadLabelAPIv2,
+			status:      "firing",
+			fingerprint: "b542feeb2bd6405a",
+		},
+		{
+			name:        "api_v2_listed",
+			payload:     `[{"labels":{"alertname":"HighLoad"},"fingerprint":"abc","status":{"state":"active"},"endsAt":"2020-01-01T00:00:00Z"}]`,
+			label:       PayloadLabelAPIv2,
+			status:      "resolved",
+			fingerprint: "abc",
+		},
+		{
+			name:    "invalid_api_v2",
+			payload: `[{"labels":"HighLoad"}]`,
+			err:     true,
+		},
+		{
+			name:    "invalid_version",
+			payload: `{"version":"four","status":"firing"}`,
+			err:     true,
+		},
+		{
+			name:    "invalid_json",
+			payload: `{"version":`,
+			err:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s := NewServer(&Config{})
+			amMsg, err := s.decodePayload([]byte(tc.payload))
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected an error decoding %s", tc.payload)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to decode %s: %v", tc.payload, err)
+			}
+
+			if amMsg.Status != tc.status {
+				t.Errorf("Wrong status; got %q, want %q", amMsg.Status, tc.status)
+			}
+			if got := amMsg.Alerts[0].Fingerprint; got != tc.fingerprint {
+				t.Errorf("Wrong fingerprint; got %q, want %q", got, tc.fingerprint)
+			}
+
+			var m pm.Metric
+			if err := s.payloadCounter.WithLabelValues(tc.label).Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.GetCounter().GetValue(); got != 1 {
+				t.Errorf("Wrong count of payloads with version %s; got %v, want %v", tc.label, got, 1)
+			}
+		})
+	}
+}
+
+func Test_decodeAPIAlerts(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	payload := `[
+		{"labels":{"alertname":"HighLoad","instance":"host1"},"annotations":{"summary":"load is high"},"endsAt":"2020-01-01T13:00:00Z"},
+		{"labels":{"alertname":"HighLoad","instance":"host2"},"annotations":{"summary":"load is high"},"endsAt":"2020-01-01T11:00:00Z"}
+	]`
+	amMsg, err := decodeAPIAlerts([]byte(payload), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(amMsg.Alerts) != 2 {
+		t.Fatalf("Wrong number of alerts; got %d, want %d", len(amMsg.Alerts), 2)
+	}
+	if got, want := amMsg.Alerts[0].Status, "firing"; got != want {
+		t.Errorf("Wrong status for alert that hasn't ended; got %q, want %q", got, want)
+	}
+	if got, want := amMsg.Alerts[1].Status, "resolved"; got != want {
+		t.Errorf("Wrong status for alert that has ended; got %q, want %q", got, want)
+	}
+	if got, want := amMsg.Status, "firing"; got != want {
+		t.Errorf("Wrong message status; got %q, want %q", got, want)
+	}
+	if want := (template.KV{"alertname": "HighLoad"}); !reflect.DeepEqual(amMsg.CommonLabels, want) {
+		t.Errorf("Wrong common labels; got %v, want %v", amMsg.CommonLabels, want)
+	}
+	if want := (template.KV{"summary": "load is high"}); !reflect.DeepEqual(amMsg.CommonAnnotations, want) {
+		t.Errorf("Wrong common annotations; got %v, want %v", amMsg.CommonAnnotations, want)
+	}
+}
+
+func Test_fingerprint(t *testing.T) {
+	t.Parallel()
+	labels := template.KV{"alertname": "HighLoad", "instance": "host1"}
+	if got, want := fingerprint(labels), "b542feeb2bd6405a"; got != want {
+		t.Errorf("Wrong fingerprint; got %s, want %s", got, want)
+	}
+
+	other := template.KV{"alertname": "HighLoad", "instance": "host2"}
+	if fingerprint(labels) == fingerprint(other) {
+		t.Errorf("Expected different labels to have different fingerprints")
+	}
+}
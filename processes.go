@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Path that requests to stop running executions are made under, as in /processes/{execution}
+	processesAPIPath = "/processes/"
+	// How often StopCommands checks whether running commands have exited
+	stopPollInterval = 50 * time.Millisecond
+)
+
+// Process describes an execution of a command that is still running
+type Process struct {
+	// Identifies the execution, as in the execution field of its logged output
+	Execution uint64 `json:"execution"`
+	Command   string `json:"command"`
+	// Fingerprint of the alert the command is running for; empty if it isn't running for a particular alert
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// PID of the command's current attempt; zero until it has started
+	PID     int       `json:"pid,omitempty"`
+	Started time.Time `json:"started"`
+	// Labels of the firing alert that triggered the execution
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Processes tracks the executions that are running
+type Processes struct {
+	running map[uint64]*Process
+	sync.Mutex
+}
+
+// NewProcesses returns a Processes instance
+func NewProcesses() *Processes {
+	return &Processes{running: make(map[uint64]*Process)}
+}
+
+// Add records that an execution started
+func (p *Processes) Add(proc Process) {
+	p.Lock()
+	defer p.Unlock()
+	p.running[proc.Execution] = &proc
+}
+
+// SetPID records the PID of an execution's current attempt, which changes when the command is retried
+func (p *Processes) SetPID(execution uint64, pid int) {
+	p.Lock()
+	defer p.Unlock()
+	if proc, ok := p.running[execution]; ok {
+		proc.PID = pid
+	}
+}
+
+// Get returns the running execution with the given ID
+func (p *Processes) Get(execution uint64) (Process, bool) {
+	p.Lock()
+	defer p.Unlock()
+	proc, ok := p.running[execution]
+	if !ok {
+		return Process{}, false
+	}
+	return *proc, true
+}
+
+// Remove records that an execution finished
+func (p *Processes) Remove(execution uint64) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.running, execution)
+}
+
+// List returns the running executions, in the order they started
+func (p *Processes) List() []Process {
+	p.Lock()
+	defer p.Unlock()
+	procs := make([]Process, 0, len(p.running))
+	for _, proc := range p.running {
+		procs = append(procs, *proc)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].Execution < procs[j].Execution
+	})
+	return procs
+}
+
+// handleProcesses lists the executions that are running, for GET requests
+func (s *Server) handleProcesses(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.processes.List())
+}
+
+// executionKey returns the key of an execution's channel in Server.killers
+func executionKey(execution uint64) string {
+	return strconv.FormatUint(execution, 10)
+}
+
+// executionContext returns the context an execution runs in, which is cancelled when the quit channel of the alert
+// it's running for is closed, when the execution is stopped on request, or when the executor shuts down, so that the
+// command is signalled in each case. Commands that ignore resolved alerts can't be stopped on request.
+// The returned function has to be called once the execution finished.
+func (s *Server) executionContext(execution uint64, cmd *Command, quit chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	var kill chan struct{}
+	if !cmd.ShouldIgnoreResolved() {
+		// Operators can stop the execution through the /processes endpoint, as if its alert resolved
+		kill = s.killers.Add(executionKey(execution))
+	}
+	go func() {
+		select {
+		case <-quit:
+		case <-kill:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// StopCommands signals running commands as if their alerts resolved, for when the executor shuts down,
+// and waits up to the given time for them to exit. Commands that ignore resolved alerts are left to finish.
+func (s *Server) StopCommands(wait time.Duration) {
+	s.stop()
+	deadline := time.Now().Add(wait)
+	for s.runningCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// handleProcessKill sends a running execution its command's resolved_signal for DELETE requests,
+// as if the alert it's running for had resolved, so that operators can stop a remediation that's doing harm.
+// Executions are identified by their ID, as listed by the /processes endpoint.
+func (s *Server) handleProcessKill(w http.ResponseWriter, req *http.Request) {
+	execution, err := strconv.ParseUint(strings.TrimPrefix(req.URL.Path, processesAPIPath), 10, 64)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	proc, ok := s.processes.Get(execution)
+	if !ok {
+		http.Error(w, "No execution is running with ID "+executionKey(execution), http.StatusNotFound)
+		return
+	}
+	if _, ok := s.killers.Get(executionKey(execution)); !ok {
+		http.Error(w, "Command ignores resolved alerts, so it can't be signalled", http.StatusConflict)
+		return
+	}
+
+	s.killers.Close(executionKey(execution))
+	logger.Info("Execution stopped at runtime", Fields{"execution": execution, "command": proc.Command, "remote_addr": req.RemoteAddr})
+	writeJSON(w, proc)
+}
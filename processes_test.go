@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProcesses(t *testing.T) {
+	t.Parallel()
+	p := NewProcesses()
+	p.Add(Process{Execution: 2, Command: "b"})
+	p.Add(Process{Execution: 1, Command: "a"})
+	p.SetPID(2, 1234)
+	p.SetPID(3, 5678)
+	procs := p.List()
+	if len(procs) != 2 || procs[0].Command != "a" || procs[1].PID != 1234 {
+		t.Errorf("Wrong running executions; got %+v", procs)
+	}
+	p.Remove(1)
+	if procs := p.List(); len(procs) != 1 || procs[0].Execution != 2 {
+		t.Errorf("Wrong running executions after one finished; got %+v", procs)
+	}
+}
+
+func TestServer_handleProcesses(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	cmd := &Command{Cmd: "sleep", Args: []string{"5"}}
+	alert := &template.Alert{Labels: template.KV{"alertname": "Disk"}}
+	out := make(chan CommandResult)
+	go srv.instrument("boop", alert, cmd, cmd.Args, nil, out, nil)
+
+	var procs []Process
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/processes", nil)
+		w := httptest.NewRecorder()
+		srv.handleProcesses(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Wrong status; got %d, want %d", w.Code, http.StatusOK)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &procs); err != nil {
+			t.Fatal(err)
+		}
+		if len(procs) == 1 && procs[0].PID != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(procs) != 1 || procs[0].PID == 0 || procs[0].Fingerprint != "boop" || procs[0].Labels["alertname"] != "Disk" {
+		t.Errorf("Wrong running executions; got %+v", procs)
+	}
+
+	// Resolving the alert stops the command
+	srv.tellFingers.Close("boop")
+	for range out {
+	}
+	if procs := srv.processes.List(); len(procs) != 0 {
+		t.Errorf("Finished execution is still listed; got %+v", procs)
+	}
+}
+
+func TestServer_handleProcessKill(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	ignore := true
+	ignoring := &Command{Cmd: "sleep", Args: []string{"1"}, IgnoreResolved: &ignore}
+	ignoringOut := make(chan CommandResult)
+	go srv.instrument("", nil, ignoring, ignoring.Args, nil, ignoringOut, nil)
+	cmd := &Command{Cmd: "sleep", Args: []string{"5"}}
+	out := make(chan CommandResult)
+	go srv.instrument("", nil, cmd, cmd.Args, nil, out, nil)
+
+	// Wait for both executions to start
+	var procs []Process
+	for i := 0; i < 50; i++ {
+		if procs = srv.processes.List(); len(procs) == 2 && procs[0].PID != 0 && procs[1].PID != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(procs) != 2 {
+		t.Fatalf("Executions didn't start; got %+v", procs)
+	}
+	ids := map[string]string{}
+	for _, proc := range procs {
+		ids[proc.Command] = executionKey(proc.Execution)
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{name: "wrong_method", method: http.MethodPost, path: "/processes/" + ids["sleep 5"], status: http.StatusMethodNotAllowed},
+		{name: "not_running", method: http.MethodDelete, path: "/processes/12345", status: http.StatusNotFound},
+		{name: "bad_id", method: http.MethodDelete, path: "/processes/boop", status: http.StatusNotFound},
+		{name: "ignores_resolved", method: http.MethodDelete, path: "/processes/" + ids["sleep 1"], status: http.StatusConflict},
+		{name: "kill", method: http.MethodDelete, path: "/processes/" + ids["sleep 5"], status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		w := httptest.NewRecorder()
+		srv.handleProcessKill(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: wrong status; got %d, want %d", tc.name, w.Code, tc.status)
+		}
+	}
+
+	var result Result
+	for r := range out {
+		result = result | r.Kind
+	}
+	if !result.Has(CmdSigOk) {
+		t.Errorf("Killed execution wasn't signalled; got result %s", result)
+	}
+	for range ignoringOut {
+	}
+}
+
+func TestServer_executionContext(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name   string
+		ignore bool
+		cancel func(s *Server, quit chan struct{})
+		want   bool
+	}{
+		{name: "resolved", cancel: func(s *Server, quit chan struct{}) { close(quit) }, want: true},
+		{name: "killed", cancel: func(s *Server, quit chan struct{}) { s.killers.Close(executionKey(1)) }, want: true},
+		{name: "shutdown", cancel: func(s *Server, quit chan struct{}) { s.stop() }, want: true},
+		// Executions that ignore resolved alerts aren't registered to be killed
+		{name: "ignores_resolved", ignore: true, cancel: func(s *Server, quit chan struct{}) { s.killers.Close(executionKey(1)) }, want: false},
+	}
+
+	for _, tc := range cases {
+		s, err := genServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := &Command{Cmd: "echo", IgnoreResolved: &tc.ignore}
+		quit := make(chan struct{})
+		ctx, cancel := s.executionContext(1, cmd, quit)
+		tc.cancel(s, quit)
+
+		select {
+		case <-ctx.Done():
+			if !tc.want {
+				t.Errorf("Context of %s execution shouldn't be cancelled", tc.name)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tc.want {
+				t.Errorf("Context of %s execution should be cancelled", tc.name)
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Media type that callers accept to have progress streamed as newline-delimited JSON
+	progressMediaType = "application/x-ndjson"
+
+	ProgressStarted   = "started"
+	ProgressFinished  = "finished"
+	ProgressSignalled = "signalled"
+	ProgressSkipped   = "skipped"
+	ProgressPending   = "pending"
+	ProgressDone      = "done"
+)
+
+// ProgressEvent describes something that happened while handling an alert message.
+// Events are streamed to callers that accept newline-delimited JSON, as they happen.
+type ProgressEvent struct {
+	Event   string    `json:"event"`
+	Command string    `json:"command,omitempty"`
+	Result  string    `json:"result,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Errors  []string  `json:"errors,omitempty"`
+	Time    time.Time `json:"time"`
+	// Exit code of the command's last attempt, for finished events; empty if it didn't exit
+	ExitCode string `json:"exit_code,omitempty"`
+}
+
+// progressFunc is called with progress events while handling an alert message.
+// It may be called concurrently, and a nil progressFunc ignores events.
+type progressFunc func(ProgressEvent)
+
+// progressWriter streams progress events to an HTTP response
+type progressWriter struct {
+	enc     *json.Encoder
+	flusher http.Flusher
+	sync.Mutex
+}
+
+// send records that something happened, if anyone is interested
+func (p progressFunc) send(ev ProgressEvent) {
+	if p == nil {
+		return
+	}
+	ev.Time = time.Now()
+	p(ev)
+}
+
+// wantsProgress returns true if the request accepts progress streamed as newline-delimited JSON
+func wantsProgress(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == progressMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// newProgressWriter starts a streamed response.
+// Since the status code is sent right away, failures are reported by the final "done" event instead.
+func newProgressWriter(w http.ResponseWriter) *progressWriter {
+	w.Header().Set("Content-Type", progressMediaType)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	p := &progressWriter{enc: json.NewEncoder(w), flusher: flusher}
+	if p.flusher != nil {
+		p.flusher.Flush()
+	}
+	return p
+}
+
+// write sends an event to the caller right away
+func (p *progressWriter) write(ev ProgressEvent) {
+	p.Lock()
+	defer p.Unlock()
+	// There's nobody left to report a failed write to; the caller will see a truncated stream
+	_ = p.enc.Encode(ev)
+	if p.flusher != nil {
+		p.flusher.Flush()
+	}
+}
+
+// done sends the final event, with any errors that would otherwise have failed the request
+func (p *progressWriter) done(errors []error) {
+	ev := ProgressEvent{Event: ProgressDone, Time: time.Now()}
+	for _, err := range errors {
+		ev.Errors = append(ev.Errors, err.Error())
+	}
+	p.write(ev)
+}
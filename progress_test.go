@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_wantsProgress(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "application/json", want: false},
+		{accept: "application/x-ndjson", want: true},
+		{accept: "text/plain, application/x-ndjson; q=0.9", want: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.accept, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header.Set("Accept", tc.accept)
+			if got := wantsProgress(req); got != tc.want {
+				t.Errorf("Wrong result for Accept %q; got %v, want %v", tc.accept, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServer_handleWebhookProgress(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.Commands = []*Command{
+		{Cmd: "true", Max: 1},
+		{Cmd: "false"},
+		{Cmd: "true", MatchLabels: map[string]string{"job": "fixed"}},
+	}
+	// The first command is already running for the alert, so it's skipped
+	srv.fingerCount.Inc("boop")
+
+	trigger, err := json.Marshal(&amDataFinger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(trigger))
+	req.Header.Set("Accept", progressMediaType)
+	w := httptest.NewRecorder()
+	srv.handleWebhook(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong response from handleWebhook; got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != progressMediaType {
+		t.Errorf("Wrong content type; got %s, want %s", ct, progressMediaType)
+	}
+
+	var events []ProgressEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var ev ProgressEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("Failed to decode progress event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, ev)
+	}
+
+	want := []ProgressEvent{
+		{Event: ProgressSkipped, Command: "true", Reason: CmdRunFingerOver.Label()},
+		{Event: ProgressStarted, Command: "false"},
+		{Event: ProgressFinished, Command: "false", Result: CmdFail.String()},
+		{Event: ProgressDone},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Wrong number of progress events; got %+v, want %+v", events, want)
+	}
+	for i, ev := range events {
+		if ev.Event != want[i].Event || ev.Command != want[i].Command || ev.Reason != want[i].Reason || ev.Result != want[i].Result {
+			t.Errorf("Wrong progress event %d; got %+v, want %+v", i, ev, want[i])
+		}
+	}
+	if last := events[len(events)-1]; len(last.Errors) != 1 {
+		t.Errorf("Wrong errors in done event; got %v, want 1 error", last.Errors)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus/push"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// How often metrics are pushed to the pushgateway, when not configured otherwise
+	defaultPushInterval = Duration(15 * time.Second)
+	// How long to wait for the pushgateway to accept metrics
+	pushTimeout = 10 * time.Second
+	// Job that metrics are pushed under, when not configured otherwise
+	defaultPushJob = "prometheus-am-executor"
+)
+
+// PushgatewayConfig configures pushing the executor's metrics to a Prometheus pushgateway,
+// for executors that can't be scraped, such as ones behind NAT or ones that don't live long enough.
+type PushgatewayConfig struct {
+	// URL of the pushgateway. Metrics aren't pushed if this isn't set.
+	URL string `yaml:"url"`
+	// How often to push metrics. Defaults to 15 seconds.
+	Interval Duration `yaml:"interval"`
+	// Job label that metrics are grouped under. Defaults to prometheus-am-executor.
+	Job string `yaml:"job"`
+	// Further labels that metrics are grouped under.
+	// Defaults to an instance label of the hostname, so that executors don't replace each other's metrics, and a
+	// namespace label of the pod's namespace if the executor knows which pod it runs in.
+	Grouping map[string]string `yaml:"grouping"`
+	// Whether to delete the executor's metrics from the pushgateway when it shuts down,
+	// rather than leaving the last values pushed in place.
+	DeleteOnShutdown bool `yaml:"delete_on_shutdown"`
+}
+
+// pusher returns a pusher for the executor's metrics, grouped as configured
+func (s *Server) pusher() *push.Pusher {
+	job := s.config.Pushgateway.Job
+	if job == "" {
+		job = defaultPushJob
+	}
+	p := push.New(s.config.Pushgateway.URL, job).
+		Gatherer(s.registry).
+		Client(&http.Client{Timeout: pushTimeout})
+
+	grouping := s.config.Pushgateway.Grouping
+	if len(grouping) == 0 {
+		hostname, _ := os.Hostname()
+		grouping = map[string]string{"instance": hostname}
+		if s.identity.Pod != "" {
+			grouping["instance"] = s.identity.Pod
+		}
+		if s.identity.Namespace != "" {
+			grouping["namespace"] = s.identity.Namespace
+		}
+	}
+	for name, value := range grouping {
+		p = p.Grouping(name, value)
+	}
+	return p
+}
+
+// pushEvery pushes metrics to the pushgateway periodically, starting right away.
+// Each push replaces the metrics that were pushed before it for the same grouping.
+// It is meant to be called as a goroutine.
+func (s *Server) pushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.pusher().Push(); err != nil {
+			logger.Error("Failed to push metrics to pushgateway", Fields{"url": s.config.Pushgateway.URL, "error": err})
+		} else {
+			logger.Debug("Pushed metrics to pushgateway", Fields{"url": s.config.Pushgateway.URL})
+		}
+		<-ticker.C
+	}
+}
+
+// StopPushing pushes metrics a final time when the executor shuts down, so that results of the latest executions
+// aren't lost, or deletes them from the pushgateway if DeleteOnShutdown is set.
+// It does nothing if metrics aren't pushed.
+func (s *Server) StopPushing() {
+	if s.config.Pushgateway.URL == "" {
+		return
+	}
+
+	if s.config.Pushgateway.DeleteOnShutdown {
+		if err := s.pusher().Delete(); err != nil {
+			logger.Error("Failed to delete metrics from pushgateway", Fields{"url": s.config.Pushgateway.URL, "error": err})
+		}
+		return
+	}
+	if err := s.pusher().Push(); err != nil {
+		logger.Error("Failed to push metrics to pushgateway", Fields{"url": s.config.Pushgateway.URL, "error": err})
+	}
+}
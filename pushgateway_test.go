@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestServer_StopPushing(t *testing.T) {
+	hostname, _ := os.Hostname()
+	cases := []struct {
+		name   string
+		config PushgatewayConfig
+		method string
+		path   string
+	}{
+		{
+			name:   "push",
+			config: PushgatewayConfig{Job: "remediation", Grouping: map[string]string{"instance": "executor-1"}},
+			method: "PUT",
+			path:   "/metrics/job/remediation/instance/executor-1",
+		},
+		{
+			name:   "default_grouping",
+			method: "PUT",
+			path:   "/metrics/job/" + defaultPushJob + "/instance/" + hostname,
+		},
+		{
+			name:   "delete_on_shutdown",
+			config: PushgatewayConfig{Grouping: map[string]string{"instance": "executor-1"}, DeleteOnShutdown: true},
+			method: "DELETE",
+			path:   "/metrics/job/" + defaultPushJob + "/instance/executor-1",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var method, path string
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				method, path = req.Method, req.URL.Path
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer gateway.Close()
+
+			tc.config.URL = gateway.URL
+			srv := NewServer(&Config{Pushgateway: tc.config})
+			srv.StopPushing()
+			if method != tc.method || path != tc.path {
+				t.Errorf("Wrong request to pushgateway; got %s %s, want %s %s", method, path, tc.method, tc.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"sync"
+	"time"
+)
+
+// queueJob represents an execution of a command that is waiting for a worker
+type queueJob struct {
+	fingerprint string
+	args        []string
+	env         []string
+	out         chan<- CommandResult
+	// Closed if the alert that triggered the job resolves before the job starts
+	quit   chan struct{}
+	queued time.Time
+	// The span that the execution's span is a child of
+	span *Span
+	// The firing alert the job is remediating, if any
+	alert *template.Alert
+}
+
+// commandQueue runs executions of a command with a bounded number of workers.
+// Executions that can't start right away wait in a bounded queue.
+type commandQueue struct {
+	cmd  *Command
+	jobs chan queueJob
+	// Number of jobs accepted that haven't finished yet; both running and waiting in the queue.
+	pending int
+	// When the workers and queue last became full, or zero if they currently have room.
+	fullSince time.Time
+	sync.Mutex
+}
+
+// accept reserves room for a job, returning false if the workers and queue are full
+func (q *commandQueue) accept() bool {
+	q.Lock()
+	defer q.Unlock()
+	if q.pending >= cap(q.jobs) {
+		return false
+	}
+	q.pending++
+	if q.pending >= cap(q.jobs) && q.fullSince.IsZero() {
+		q.fullSince = time.Now()
+	}
+	return true
+}
+
+// release frees up the room reserved for a job
+func (q *commandQueue) release() {
+	q.Lock()
+	defer q.Unlock()
+	q.pending--
+	q.fullSince = time.Time{}
+}
+
+// saturatedFor returns how long the workers and queue have been full, or zero if they have room
+func (q *commandQueue) saturatedFor() time.Duration {
+	q.Lock()
+	defer q.Unlock()
+	if q.fullSince.IsZero() {
+		return 0
+	}
+	return time.Since(q.fullSince)
+}
+
+// queue returns the queue for a command, creating it and starting its workers if necessary.
+// Returns nil if the command doesn't limit its concurrency.
+func (s *Server) queue(cmd *Command) *commandQueue {
+	if cmd.Concurrency <= 0 {
+		return nil
+	}
+
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+	q, ok := s.queues[cmd]
+	if ok {
+		return q
+	}
+
+	q = &commandQueue{
+		cmd:  cmd,
+		jobs: make(chan queueJob, cmd.QueueCapacity()),
+	}
+	for i := 0; i < cmd.Concurrency; i++ {
+		go s.work(q)
+	}
+	s.queues[cmd] = q
+	return q
+}
+
+// dispatch runs a command for an alert with the given arguments,
+// either right away or through the command's queue if its concurrency is limited.
+// Commands with a wait_for grace period are dispatched once it's over, if the alert hasn't resolved by then.
+// The alert is the firing alert the command is remediating, which may be nil.
+// Returns false if the command's queue is full, in which case the out channel is closed without the command running.
+func (s *Server) dispatch(fingerprint string, alert *template.Alert, cmd *Command, args []string, env []string, out chan<- CommandResult, span *Span) bool {
+	if cmd.WaitFor > 0 && fingerprint != "" {
+		// Waiting happens in the background, so that other commands for the alert aren't held up
+		go s.dispatchAfter(time.Duration(cmd.WaitFor), s.tellFingers.Add(fingerprint), fingerprint, alert, cmd, args, env, out, span)
+		return true
+	}
+	return s.enqueue(fingerprint, alert, cmd, args, env, out, span)
+}
+
+// enqueue runs a command for an alert right away, or through the command's queue if its concurrency is limited.
+// Returns false if the command's queue is full, in which case the out channel is closed without the command running.
+func (s *Server) enqueue(fingerprint string, alert *template.Alert, cmd *Command, args []string, env []string, out chan<- CommandResult, span *Span) bool {
+	q := s.queue(cmd)
+	if q == nil {
+		// s.instrument() runs the command and updates related metrics
+		go s.instrument(fingerprint, alert, cmd, args, env, out, span)
+		return true
+	}
+
+	if !q.accept() {
+		s.removePayloadFile(env)
+		close(out)
+		return false
+	}
+
+	var quit chan struct{}
+	if len(fingerprint) > 0 {
+		quit = s.tellFingers.Add(fingerprint)
+	}
+	s.queueDepth.WithLabelValues(cmd.MetricLabel()).Inc()
+	q.jobs <- queueJob{fingerprint: fingerprint, args: args, env: env, out: out, quit: quit, queued: time.Now(), span: span, alert: alert}
+	return true
+}
+
+// saturatedFor returns the longest time any command queue has been full, or zero if they all have room
+func (s *Server) saturatedFor() time.Duration {
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+	var longest time.Duration
+	for _, q := range s.queues {
+		if d := q.saturatedFor(); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// work runs jobs from the queue, one at a time.
+// It is meant to be called as a goroutine.
+func (s *Server) work(q *commandQueue) {
+	for job := range q.jobs {
+		s.queueDepth.WithLabelValues(q.cmd.MetricLabel()).Dec()
+		waited := time.Since(job.queued)
+		s.queueWait.Observe(waited.Seconds())
+
+		select {
+		case <-job.quit:
+			// The alert resolved while the job was waiting, so there's nothing left to remediate
+			s.skip(q.cmd, CmdRunResolved, job.fingerprint)
+			s.removePayloadFile(job.env)
+			close(job.out)
+		default:
+			if q.cmd.MaxQueueAge > 0 && waited > time.Duration(q.cmd.MaxQueueAge) {
+				// The alert has likely changed since the job was queued, so running it now could do more harm than good
+				s.queueExpired.WithLabelValues(q.cmd.MetricLabel()).Inc()
+				s.skip(q.cmd, CmdRunExpired, job.fingerprint)
+				s.removePayloadFile(job.env)
+				close(job.out)
+			} else {
+				s.instrument(job.fingerprint, job.alert, q.cmd, job.args, job.env, job.out, job.span)
+			}
+		}
+		q.release()
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	pm "github.com/prometheus/client_model/go"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestServer_dispatch(t *testing.T) {
+	if runtime.GOOS == "aix" || runtime.GOOS == "android" || runtime.GOOS == "illumos" || runtime.GOOS == "js" ||
+		runtime.GOOS == "plan9" || runtime.GOOS == "windows" {
+		t.Skip("Skip on platforms without 'sleep' command available")
+	}
+	t.Parallel()
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "sleep", Args: []string{"1s"}, Name: "sleepy", Concurrency: 1, QueueSize: 1}
+
+	// The first execution runs, the second waits in the queue, and the third doesn't fit
+	outs := make([]chan CommandResult, 3)
+	for i := range outs {
+		outs[i] = make(chan CommandResult)
+		ok := srv.dispatch("", nil, cmd, cmd.Args, nil, outs[i], nil)
+		if want := i < 2; ok != want {
+			t.Errorf("Wrong dispatch result for execution %d; got %v, want %v", i, ok, want)
+		}
+	}
+
+	// The rejected execution's channel is closed without results
+	if _, ok := <-outs[2]; ok {
+		t.Errorf("Rejected execution shouldn't have a result")
+	}
+
+	// Give the worker some time to pick up the first execution
+	time.Sleep(100 * time.Millisecond)
+	var depth pm.Metric
+	if err := srv.queueDepth.WithLabelValues("sleepy").Write(&depth); err != nil {
+		t.Fatal(err)
+	}
+	if got := depth.GetGauge().GetValue(); got != 1 {
+		t.Errorf("Wrong queue depth; got %f, want %d", got, 1)
+	}
+	var running pm.Metric
+	if err := srv.processCurrent.WithLabelValues("sleepy").Write(&running); err != nil {
+		t.Fatal(err)
+	}
+	if got := running.GetGauge().GetValue(); got != 1 {
+		t.Errorf("Wrong running count; got %f, want %d", got, 1)
+	}
+
+	if srv.saturatedFor() <= 0 {
+		t.Errorf("Full queue should be reported as saturated")
+	}
+
+	for i, out := range outs[:2] {
+		var state Result
+		for r := range out {
+			state = state | r.Kind
+		}
+		if !state.Has(CmdOk) {
+			t.Errorf("Execution %d didn't succeed; got %s", i, state)
+		}
+	}
+
+	var wait pm.Metric
+	if err := srv.queueWait.Write(&wait); err != nil {
+		t.Fatal(err)
+	}
+	if got := wait.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("Wrong number of queue wait samples; got %d, want %d", got, 2)
+	}
+}
+
+func TestServer_dispatchResolved(t *testing.T) {
+	if runtime.GOOS == "aix" || runtime.GOOS == "android" || runtime.GOOS == "illumos" || runtime.GOOS == "js" ||
+		runtime.GOOS == "plan9" || runtime.GOOS == "windows" {
+		t.Skip("Skip on platforms without 'sleep' command available")
+	}
+	t.Parallel()
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "sleep", Args: []string{"1s"}, Concurrency: 1, QueueSize: 1, IgnoreResolved: new(bool)}
+	*cmd.IgnoreResolved = true
+
+	running := make(chan CommandResult)
+	queued := make(chan CommandResult)
+	srv.dispatch("", nil, cmd, cmd.Args, nil, running, nil)
+	srv.dispatch("boop", nil, cmd, cmd.Args, nil, queued, nil)
+
+	// Resolve the alert for the queued execution before it gets a worker
+	time.Sleep(100 * time.Millisecond)
+	srv.tellFingers.Close("boop")
+
+	for range running {
+	}
+	if _, ok := <-queued; ok {
+		t.Errorf("Execution for resolved alert shouldn't have a result")
+	}
+
+	skipped, err := getCounterValue(srv.skipCounter, CmdRunResolved.Label())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("Wrong skipped count; got %f, want %d", skipped, 1)
+	}
+}
+
+func TestServer_dispatchExpired(t *testing.T) {
+	if runtime.GOOS == "aix" || runtime.GOOS == "android" || runtime.GOOS == "illumos" || runtime.GOOS == "js" ||
+		runtime.GOOS == "plan9" || runtime.GOOS == "windows" {
+		t.Skip("Skip on platforms without 'sleep' command available")
+	}
+	t.Parallel()
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "sleep", Args: []string{"1s"}, Name: "slow", Concurrency: 1, QueueSize: 1, MaxQueueAge: Duration(100 * time.Millisecond)}
+
+	running := make(chan CommandResult)
+	queued := make(chan CommandResult)
+	srv.dispatch("", nil, cmd, cmd.Args, nil, running, nil)
+	srv.dispatch("", nil, cmd, cmd.Args, nil, queued, nil)
+
+	for range running {
+	}
+	if _, ok := <-queued; ok {
+		t.Errorf("Execution that waited longer than max_queue_age shouldn't have a result")
+	}
+
+	skipped, err := getCounterValue(srv.skipCounter, CmdRunExpired.Label())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("Wrong skipped count; got %f, want %d", skipped, 1)
+	}
+
+	var m pm.Metric
+	if err := srv.queueExpired.WithLabelValues("slow").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if expired := m.GetCounter().GetValue(); expired != 1 {
+		t.Errorf("Wrong expired count; got %f, want %d", expired, 1)
+	}
+}
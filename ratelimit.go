@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows up to capacity executions at once, and refills at a rate of capacity executions per period
+type tokenBucket struct {
+	capacity float64
+	period   time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+// newTokenBucket returns a full tokenBucket
+func newTokenBucket(capacity int, period time.Duration, now time.Time) *tokenBucket {
+	return &tokenBucket{capacity: float64(capacity), period: period, tokens: float64(capacity), last: now}
+}
+
+// refill adds the tokens that accrued since the bucket was last refilled
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += b.capacity * float64(elapsed) / float64(b.period)
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+}
+
+// RateBudget is how many executions one of a command's rate limits still allows, as it's kept in the runtime state
+type RateBudget struct {
+	Command string `json:"command"`
+	// Period the limit refills over: a minute for max_per_minute, and an hour for max_per_hour
+	Period  time.Duration `json:"period"`
+	Tokens  float64       `json:"tokens"`
+	Updated time.Time     `json:"updated"`
+}
+
+// RateLimiter limits how often commands with max_per_minute or max_per_hour run, by command
+type RateLimiter struct {
+	buckets map[string][]*tokenBucket
+	// Budgets restored from the runtime state, for commands whose buckets haven't been created since
+	restored map[string][]RateBudget
+	sync.Mutex
+}
+
+// NewRateLimiter returns a RateLimiter instance, with nothing limited yet
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string][]*tokenBucket), restored: make(map[string][]RateBudget)}
+}
+
+// Budgets returns what's left of each command's rate limits, sorted by command and period
+func (r *RateLimiter) Budgets() []RateBudget {
+	r.Lock()
+	defer r.Unlock()
+	budgets := make([]RateBudget, 0)
+	for label, buckets := range r.buckets {
+		for _, b := range buckets {
+			budgets = append(budgets, RateBudget{Command: label, Period: b.period, Tokens: b.tokens, Updated: b.last})
+		}
+	}
+	for _, restored := range r.restored {
+		budgets = append(budgets, restored...)
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		if budgets[i].Command != budgets[j].Command {
+			return budgets[i].Command < budgets[j].Command
+		}
+		return budgets[i].Period < budgets[j].Period
+	})
+	return budgets
+}
+
+// Set replaces what's left of the commands' rate limits with the given budgets.
+// They're applied once each command is next checked, since the limits themselves are part of its configuration.
+func (r *RateLimiter) Set(budgets []RateBudget) {
+	r.Lock()
+	defer r.Unlock()
+	r.buckets = make(map[string][]*tokenBucket)
+	r.restored = make(map[string][]RateBudget)
+	for _, b := range budgets {
+		r.restored[b.Command] = append(r.restored[b.Command], b)
+	}
+}
+
+// Allow returns true if the command can run under its rate limits, taking a token from each of its buckets if so.
+// No tokens are taken unless all of the command's limits allow it to run.
+func (r *RateLimiter) Allow(cmd *Command, now time.Time) bool {
+	if cmd.MaxPerMinute <= 0 && cmd.MaxPerHour <= 0 {
+		return true
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	label := cmd.MetricLabel()
+	buckets, ok := r.buckets[label]
+	if !ok {
+		if cmd.MaxPerMinute > 0 {
+			buckets = append(buckets, newTokenBucket(cmd.MaxPerMinute, time.Minute, now))
+		}
+		if cmd.MaxPerHour > 0 {
+			buckets = append(buckets, newTokenBucket(cmd.MaxPerHour, time.Hour, now))
+		}
+		for _, b := range buckets {
+			for _, restored := range r.restored[label] {
+				if restored.Period == b.period && restored.Tokens < b.capacity {
+					b.tokens, b.last = restored.Tokens, restored.Updated
+				}
+			}
+		}
+		delete(r.restored, label)
+		r.buckets[label] = buckets
+	}
+
+	for _, b := range buckets {
+		b.refill(now)
+		if b.tokens < 1 {
+			return false
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	t.Parallel()
+	r := NewRateLimiter()
+	now := time.Now()
+	cmd := &Command{Cmd: "reboot", MaxPerMinute: 2, MaxPerHour: 3}
+
+	steps := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{at: 0, want: true},
+		{at: time.Second, want: true},
+		// The minute's burst is used up
+		{at: 2 * time.Second, want: false},
+		// Half a minute refills one token
+		{at: 31 * time.Second, want: true},
+		{at: 32 * time.Second, want: false},
+		// The minute's limit has refilled, but the hour's is used up
+		{at: 2 * time.Minute, want: false},
+		// An hour's limit refills a token every 20 minutes
+		{at: 21 * time.Minute, want: true},
+	}
+	for i, step := range steps {
+		if got := r.Allow(cmd, now.Add(step.at)); got != step.want {
+			t.Errorf("Wrong result for step %d at %s; got %v, want %v", i, step.at, got, step.want)
+		}
+	}
+
+	if !r.Allow(&Command{Cmd: "echo"}, now) {
+		t.Error("Commands without limits should always be allowed")
+	}
+}
+
+func TestRateLimiter_Budgets(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	cmd := &Command{Cmd: "reboot", MaxPerMinute: 2, MaxPerHour: 3}
+	src := NewRateLimiter()
+	src.Allow(cmd, now)
+	src.Allow(cmd, now)
+	budgets := src.Budgets()
+	if len(budgets) != 2 || budgets[0].Period != time.Minute || budgets[0].Tokens != 0 || budgets[1].Tokens != 1 {
+		t.Fatalf("Wrong budgets; got %+v", budgets)
+	}
+
+	// The budgets carry over to another limiter
+	dst := NewRateLimiter()
+	dst.Set(budgets)
+	if got := dst.Budgets(); len(got) != 2 {
+		t.Errorf("Budgets not yet applied should still be listed; got %+v", got)
+	}
+	if dst.Allow(cmd, now.Add(time.Second)) {
+		t.Error("Command shouldn't be allowed once its restored minute's budget is used up")
+	}
+	if !dst.Allow(cmd, now.Add(31*time.Second)) {
+		t.Error("Command should be allowed once its restored budget refilled")
+	}
+	if dst.Allow(cmd, now.Add(2*time.Minute)) {
+		t.Error("Command shouldn't be allowed once its restored hour's budget is used up")
+	}
+
+	// Limits that were raised since the budgets were saved keep what was left
+	raised := &Command{Cmd: "reboot", MaxPerMinute: 5}
+	dst.Set(budgets)
+	if dst.Allow(raised, now.Add(time.Second)) {
+		t.Error("Command shouldn't be allowed while its restored budget is used up")
+	}
+}
+
+func TestServer_runCommands_rateLimited(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "true", MaxPerMinute: 1}
+	srv.config.Commands = []*Command{cmd}
+
+	for _, fingerprint := range []string{"boop", "beep"} {
+		_ = srv.runCommands(&template.Data{
+			Status: "firing",
+			Alerts: template.Alerts{{Status: "firing", Fingerprint: fingerprint, StartsAt: time.Now()}},
+		}, "", nil, nil)
+	}
+
+	var m pm.Metric
+	if err := srv.skipCounter.WithLabelValues(CmdRunRateLimited.Label(), cmd.MetricLabel()).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Wrong number of rate limited executions; got %v, want 1", got)
+	}
+}
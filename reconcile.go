@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// How often fingerprint counts are reconciled with running executions, when not configured otherwise
+	defaultReconcileInterval = Duration(5 * time.Minute)
+)
+
+// startExecution records that a command started running for an alert fingerprint.
+// The fingerprint count used to enforce Command.Max is updated along with the executions actually being tracked,
+// so that reconcile can tell when the count drifts.
+func (s *Server) startExecution(fingerprint string) {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	s.running[fingerprint]++
+	s.fingerCount.Inc(fingerprint)
+}
+
+// finishExecution records that a command stopped running for an alert fingerprint
+func (s *Server) finishExecution(fingerprint string) {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	s.running[fingerprint]--
+	if s.running[fingerprint] <= 0 {
+		delete(s.running, fingerprint)
+	}
+	s.fingerCount.Dec(fingerprint)
+}
+
+// reconcile repairs fingerprint counts that don't match the number of executions running for the fingerprint,
+// returning the number of counts that were corrected.
+// Counts of zero for fingerprints without executions are removed, so they don't accumulate over long uptimes.
+func (s *Server) reconcile() int {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+
+	corrected := 0
+	counts := s.fingerCount.All()
+	for fingerprint, count := range counts {
+		running := s.running[fingerprint]
+		if count != running {
+			logger.Warn("Correcting count of commands running for fingerprint", Fields{"fingerprint": fingerprint, "count": count, "running": running})
+			corrected++
+		}
+		if running == 0 {
+			s.fingerCount.Delete(fingerprint)
+		} else if count != running {
+			s.fingerCount.Set(fingerprint, running)
+		}
+	}
+
+	for fingerprint, running := range s.running {
+		if _, ok := counts[fingerprint]; !ok {
+			logger.Warn("Correcting missing count of commands running for fingerprint", Fields{"fingerprint": fingerprint, "running": running})
+			s.fingerCount.Set(fingerprint, running)
+			corrected++
+		}
+	}
+
+	s.driftCounter.Add(float64(corrected))
+	return corrected
+}
+
+// reconcileEvery runs reconcile periodically.
+// It is meant to be called as a goroutine.
+func (s *Server) reconcileEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.reconcile()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	pm "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+func TestServer_reconcile(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	srv.startExecution("running")
+	srv.startExecution("running")
+	srv.startExecution("finished")
+	srv.finishExecution("finished")
+	if corrected := srv.reconcile(); corrected != 0 {
+		t.Errorf("Wrong number of corrections without drift; got %d, want %d", corrected, 0)
+	}
+	if _, ok := srv.fingerCount.Get("finished"); ok {
+		t.Errorf("Count for fingerprint without running commands should be removed")
+	}
+
+	// Simulate a missed decrement, a missed increment, and a count left behind after all commands finished
+	srv.fingerCount.Inc("running")
+	srv.startExecution("undercounted")
+	srv.fingerCount.Dec("undercounted")
+	srv.fingerCount.Set("leaked", 1)
+	if corrected := srv.reconcile(); corrected != 3 {
+		t.Errorf("Wrong number of corrections; got %d, want %d", corrected, 3)
+	}
+
+	for fingerprint, want := range map[string]int{"running": 2, "undercounted": 1} {
+		if got, _ := srv.fingerCount.Get(fingerprint); got != want {
+			t.Errorf("Wrong count for fingerprint %s; got %d, want %d", fingerprint, got, want)
+		}
+	}
+	if _, ok := srv.fingerCount.Get("leaked"); ok {
+		t.Errorf("Leaked count should be removed")
+	}
+
+	var m pm.Metric
+	if err := srv.driftCounter.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 3 {
+		t.Errorf("Wrong corrections metric; got %f, want %d", got, 3)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header that responses to redelivered webhook requests are marked with
+const redeliveredHeader = "X-Redelivered"
+
+var (
+	redeliveryCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "redeliveries_total",
+		Help:      "Total number of webhook requests that were redeliveries of an earlier request, and were answered with its result instead of running commands again.",
+	}
+)
+
+// redeliveryFields holds the part of a webhook payload that identifies the notification it's for, besides its alerts
+type redeliveryFields struct {
+	GroupKey string `json:"groupKey"`
+}
+
+// delivery is a webhook request that later requests may be redeliveries of, and the response it got
+type delivery struct {
+	// Closed once the request was responded to
+	done chan struct{}
+	// When the request was responded to
+	at          time.Time
+	status      int
+	contentType string
+	body        []byte
+}
+
+// Redeliveries recognises webhook requests that alertmanager sent again, because it gave up waiting for a response or
+// got an error, so that the commands they're for don't run twice.
+type Redeliveries struct {
+	// How long after a request was responded to that requests like it are treated as redeliveries
+	window Duration
+	// Header that callers set to the same value on redeliveries; empty to go by the payload
+	header     string
+	deliveries map[string]*delivery
+	sync.Mutex
+}
+
+// NewRedeliveries returns a Redeliveries instance, with no requests seen.
+// Returns nil, which treats every request as new, if window isn't positive.
+func NewRedeliveries(window Duration, header string) *Redeliveries {
+	if window <= 0 {
+		return nil
+	}
+	return &Redeliveries{window: window, header: header, deliveries: make(map[string]*delivery)}
+}
+
+// Key returns what identifies a webhook request among its redeliveries: the value of the configured header, if it's
+// set, or else the payload's group key, status, and the start times of its alerts.
+// Returns an empty string if the request can't be identified, such as for arrays of alerts, which have no group key.
+func (r *Redeliveries) Key(req *http.Request, data []byte, amMsg *template.Data) string {
+	if r == nil {
+		return ""
+	}
+	if r.header != "" {
+		if v := req.Header.Get(r.header); v != "" {
+			return r.header + "=" + v
+		}
+	}
+
+	var fields redeliveryFields
+	if err := json.Unmarshal(data, &fields); err != nil || fields.GroupKey == "" {
+		return ""
+	}
+	starts := make([]string, 0, len(amMsg.Alerts))
+	for _, alert := range amMsg.Alerts {
+		starts = append(starts, alert.StartsAt.UTC().Format(time.RFC3339Nano))
+	}
+	sort.Strings(starts)
+	return strings.Join([]string{fields.GroupKey, amMsg.Status, strings.Join(starts, ",")}, "\x00")
+}
+
+// Begin returns the delivery of the request with the given key. original is true if the request is new, in which case
+// Finish has to be called once it's been responded to; otherwise, the request is a redelivery of the returned one.
+func (r *Redeliveries) Begin(key string, now time.Time) (d *delivery, original bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.prune(now)
+	if d, ok := r.deliveries[key]; ok {
+		return d, false
+	}
+	d = &delivery{done: make(chan struct{})}
+	r.deliveries[key] = d
+	return d, true
+}
+
+// prune forgets the requests that were responded to longer ago than the window
+func (r *Redeliveries) prune(now time.Time) {
+	for key, d := range r.deliveries {
+		select {
+		case <-d.done:
+			if now.Sub(d.at) > time.Duration(r.window) {
+				delete(r.deliveries, key)
+			}
+		default:
+		}
+	}
+}
+
+// Finish records the response that a request got, for its redeliveries to be answered with
+func (r *Redeliveries) Finish(d *delivery, rw *recordingWriter) {
+	r.Lock()
+	defer r.Unlock()
+	d.at = time.Now()
+	d.status = rw.status
+	d.contentType = rw.Header().Get("Content-Type")
+	d.body = rw.body.Bytes()
+	close(d.done)
+}
+
+// recordingWriter is an http.ResponseWriter that keeps a copy of the response it writes
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// newRecordingWriter returns a recordingWriter writing to w
+func newRecordingWriter(w http.ResponseWriter) *recordingWriter {
+	return &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+// replayDelivery answers a redelivered request with the response of the request it's a redelivery of.
+// If that request is still being handled, such as because alertmanager's timeout was shorter than the commands took,
+// the response is sent once it's done, so that the caller gets the real result rather than running commands again.
+func (s *Server) replayDelivery(w http.ResponseWriter, req *http.Request, d *delivery) {
+	select {
+	case <-d.done:
+	case <-req.Context().Done():
+		return
+	}
+
+	s.redeliveryCounter.Inc()
+	logger.Decision("Answered redelivered webhook request with the result of the original", Fields{"remote_addr": req.RemoteAddr, "status": d.status, "delivered_at": d.at})
+	if d.contentType != "" {
+		w.Header().Set("Content-Type", d.contentType)
+	}
+	w.Header().Set(redeliveredHeader, "true")
+	w.WriteHeader(d.status)
+	if _, err := w.Write(d.body); err != nil {
+		logger.Error("Failed to write response", Fields{"error": err})
+	}
+}
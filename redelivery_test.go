@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	pm "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedeliveries_Key(t *testing.T) {
+	t.Parallel()
+	r := NewRedeliveries(Duration(time.Minute), "X-Request-Id")
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	amMsg := &template.Data{Status: "firing", Alerts: template.Alerts{{StartsAt: start}, {StartsAt: start.Add(time.Second)}}}
+	swapped := &template.Data{Status: "firing", Alerts: template.Alerts{amMsg.Alerts[1], amMsg.Alerts[0]}}
+	resolved := &template.Data{Status: "resolved", Alerts: amMsg.Alerts}
+	payload := []byte(`{"groupKey":"{}:{alertname=\"DiskFull\"}"}`)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	key := r.Key(req, payload, amMsg)
+	if key == "" {
+		t.Fatal("Expected a key for a payload with a group key")
+	}
+	if got := r.Key(req, payload, swapped); got != key {
+		t.Errorf("Key shouldn't depend on the order of alerts; got %q, want %q", got, key)
+	}
+	if got := r.Key(req, payload, resolved); got == key {
+		t.Errorf("Resolved notification shouldn't have the same key as the firing one")
+	}
+	if got := r.Key(req, []byte(`[{"labels":{}}]`), amMsg); got != "" {
+		t.Errorf("Arrays of alerts shouldn't have a key; got %q", got)
+	}
+
+	req.Header.Set("X-Request-Id", "abc")
+	if got := r.Key(req, nil, amMsg); got != "X-Request-Id=abc" {
+		t.Errorf("Wrong key for request with the redelivery header; got %q", got)
+	}
+
+	var none *Redeliveries
+	if got := none.Key(req, payload, amMsg); got != "" {
+		t.Errorf("Redeliveries shouldn't be detected without a window; got key %q", got)
+	}
+}
+
+func TestRedeliveries_Begin(t *testing.T) {
+	t.Parallel()
+	r := NewRedeliveries(Duration(time.Minute), "")
+	now := time.Now()
+	d, original := r.Begin("a", now)
+	if !original {
+		t.Fatal("First request should be the original")
+	}
+	// Requests that are still being handled are never forgotten
+	if got, original := r.Begin("a", now.Add(time.Hour)); original || got != d {
+		t.Errorf("Request that's still being handled should be redelivered to")
+	}
+
+	r.Finish(d, newRecordingWriter(httptest.NewRecorder()))
+	if _, original := r.Begin("a", d.at.Add(30*time.Second)); original {
+		t.Errorf("Request within the window should be a redelivery")
+	}
+	if _, original := r.Begin("a", d.at.Add(2*time.Minute)); !original {
+		t.Errorf("Request after the window should be the original")
+	}
+}
+
+func TestServer_handleWebhook_redelivered(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.Commands = []*Command{{Cmd: "false", Name: "redelivered"}}
+	srv.redeliveries = NewRedeliveries(Duration(time.Minute), "")
+	payload := `{"status":"firing","groupKey":"{}:{}","alerts":[{"status":"firing","startsAt":"2020-01-02T03:04:05Z"}]}`
+
+	responses := make([]*httptest.ResponseRecorder, 2)
+	for i := range responses {
+		responses[i] = httptest.NewRecorder()
+		srv.handleWebhook(responses[i], httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload)))
+	}
+	first, second := responses[0], responses[1]
+	if first.Code != http.StatusInternalServerError || second.Code != first.Code {
+		t.Errorf("Redelivery should get the original's status; got %d and %d", first.Code, second.Code)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("Redelivery should get the original's body; got %q, want %q", second.Body.String(), first.Body.String())
+	}
+	if first.Header().Get(redeliveredHeader) != "" || second.Header().Get(redeliveredHeader) != "true" {
+		t.Errorf("Only the redelivery should be marked as one")
+	}
+	if got := srv.report.Counts(time.Now().Add(-time.Minute))["redelivered"]; got == nil || got.Executed != 1 {
+		t.Errorf("Command should only run once; got %+v", got)
+	}
+
+	var m pm.Metric
+	if err := srv.redeliveryCounter.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Wrong number of redeliveries; got %v, want 1", got)
+	}
+}
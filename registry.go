@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// How often heartbeats are sent to the registry, when not configured otherwise
+	defaultHeartbeatInterval = Duration(time.Minute)
+	// How long to wait for the registry to respond to a heartbeat
+	heartbeatTimeout = 10 * time.Second
+)
+
+// version of the executor, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// RegistryConfig configures self-registration with a central registry of executors
+type RegistryConfig struct {
+	// URL that heartbeats are POSTed to. Executors don't register themselves if this isn't set.
+	URL string `yaml:"url"`
+	// How often to send heartbeats. Defaults to a minute.
+	Interval Duration `yaml:"interval"`
+	// Name that the executor registers as. Defaults to the namespace and name of its pod, separated by a slash,
+	// if it knows which pod it runs in, or otherwise the hostname.
+	Name string `yaml:"name"`
+	// Optional bearer token sent with heartbeats
+	Token string `yaml:"token"`
+}
+
+// Heartbeat describes an executor and what it's doing.
+// It's the body of requests sent to the registry.
+type Heartbeat struct {
+	Name         string    `json:"name"`
+	Pod          *Identity `json:"pod,omitempty"`
+	Version      string    `json:"version"`
+	ConfigHash   string    `json:"config_hash"`
+	ListenAddr   string    `json:"listen_address"`
+	Commands     int       `json:"commands"`
+	Running      int       `json:"running"`
+	Fingerprints int       `json:"fingerprints"`
+	Queued       int       `json:"queued"`
+	Time         time.Time `json:"time"`
+}
+
+// Hash returns a short hash identifying the config, so that a registry can tell which executors share a config
+func (c *Config) Hash() string {
+	h := sha256.New()
+	// Maps are encoded with sorted keys, so the encoding is stable for a given config
+	data, err := json.Marshal(c)
+	if err == nil {
+		_, _ = h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// heartbeat describes the executor's current state
+func (s *Server) heartbeat() Heartbeat {
+	name := s.config.Registry.Name
+	if name == "" && s.identity.Pod != "" && s.identity.Namespace != "" {
+		// Pods in different namespaces can share a name
+		name = s.identity.Namespace + "/" + s.identity.Pod
+	}
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	s.runningMu.Lock()
+	fingerprints := len(s.running)
+	s.runningMu.Unlock()
+
+	queued := 0
+	s.queuesMu.Lock()
+	for _, q := range s.queues {
+		queued += len(q.jobs)
+	}
+	s.queuesMu.Unlock()
+
+	hb := Heartbeat{
+		Name:         name,
+		Version:      version,
+		ConfigHash:   s.config.Hash(),
+		ListenAddr:   s.config.ListenAddr,
+		Commands:     len(s.config.Commands),
+		Running:      s.runningCount(),
+		Fingerprints: fingerprints,
+		Queued:       queued,
+		Time:         time.Now(),
+	}
+	if !s.identity.Empty() {
+		id := s.identity
+		hb.Pod = &id
+	}
+	return hb
+}
+
+// sendHeartbeat registers the executor with the registry, reporting its current state
+func (s *Server) sendHeartbeat() error {
+	data, err := json.Marshal(s.heartbeat())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.config.Registry.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.Registry.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.Registry.Token)
+	}
+
+	client := &http.Client{Timeout: heartbeatTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response from registry: %s", resp.Status)
+	}
+	return nil
+}
+
+// heartbeatEvery sends heartbeats to the registry periodically, starting right away.
+// It is meant to be called as a goroutine.
+func (s *Server) heartbeatEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.sendHeartbeat(); err != nil {
+			logger.Error("Failed to send heartbeat to registry", Fields{"url": s.config.Registry.URL, "error": err})
+		} else {
+			logger.Debug("Sent heartbeat to registry", Fields{"url": s.config.Registry.URL})
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfig_Hash(t *testing.T) {
+	t.Parallel()
+	a := &Config{Commands: []*Command{{Cmd: "echo"}}}
+	b := &Config{Commands: []*Command{{Cmd: "echo"}}}
+	c := &Config{Commands: []*Command{{Cmd: "true"}}}
+
+	if a.Hash() != b.Hash() {
+		t.Errorf("Identical configs should have the same hash; got %s and %s", a.Hash(), b.Hash())
+	}
+	if a.Hash() == c.Hash() {
+		t.Errorf("Different configs should have different hashes; got %s for both", a.Hash())
+	}
+}
+
+func TestServer_sendHeartbeat(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		ok     bool
+	}{
+		{name: "accepted", status: http.StatusNoContent, ok: true},
+		{name: "rejected", status: http.StatusForbidden, ok: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var got Heartbeat
+			var auth string
+			registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				auth = req.Header.Get("Authorization")
+				if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+					t.Errorf("Failed to decode heartbeat: %v", err)
+				}
+				w.WriteHeader(tc.status)
+			}))
+			defer registry.Close()
+
+			srv, err := genServer()
+			if err != nil {
+				t.Fatal("Failed to generate server")
+			}
+			srv.config.Registry = RegistryConfig{URL: registry.URL, Name: "executor-1", Token: "s3cr3t"}
+			srv.startExecution("boop")
+			defer srv.finishExecution("boop")
+
+			err = srv.sendHeartbeat()
+			if ok := err == nil; ok != tc.ok {
+				t.Errorf("Wrong result sending heartbeat; got %v, want ok %v", err, tc.ok)
+			}
+			if auth != "Bearer s3cr3t" {
+				t.Errorf("Wrong Authorization header; got %q", auth)
+			}
+			if got.Name != "executor-1" || got.Version != version || got.ConfigHash != srv.config.Hash() {
+				t.Errorf("Wrong executor identity in heartbeat; got %+v", got)
+			}
+			if got.Commands != len(srv.config.Commands) || got.Fingerprints != 1 {
+				t.Errorf("Wrong counts in heartbeat; got %+v", got)
+			}
+		})
+	}
+}
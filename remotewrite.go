@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// How often execution samples are written, if there are any
+	remoteWriteInterval = 15 * time.Second
+	// How long to wait for the remote-write endpoint to accept samples
+	remoteWriteTimeout = 10 * time.Second
+	// The most samples held for writing; executions finishing while this many are waiting aren't written
+	maxPendingSamples = 10000
+	// Name of the series that executions are written as
+	remoteWriteSeries = "am_executor_execution_duration_seconds"
+
+	// Values for the result label of written executions
+	RemoteWriteResultOk        = "ok"
+	RemoteWriteResultFail      = "fail"
+	RemoteWriteResultSignalled = "signalled"
+)
+
+// RemoteWriteConfig configures writing a sample for each execution to a Prometheus remote-write endpoint,
+// for environments that collect metrics through remote-write rather than by scraping.
+type RemoteWriteConfig struct {
+	// URL of the remote-write endpoint, such as http://prometheus:9090/api/v1/write.
+	// Executions aren't written if this isn't set.
+	URL string `yaml:"url"`
+	// Labels added to every written series, such as to tell executors apart
+	Labels map[string]string `yaml:"labels"`
+	// Headers sent along with written samples, such as for authenticating with a hosted endpoint
+	Headers map[string]string `yaml:"headers"`
+}
+
+// RemoteWriter writes samples describing finished executions to a remote-write endpoint in batches.
+// Methods of a nil RemoteWriter do nothing, so that callers don't need to check whether remote-write is enabled.
+type RemoteWriter struct {
+	config  RemoteWriteConfig
+	client  *http.Client
+	pending []remoteWriteSample
+	sync.Mutex
+}
+
+// remoteWriteSample is a finished execution, written as a sample of its duration
+type remoteWriteSample struct {
+	labels map[string]string
+	value  float64
+	time   time.Time
+}
+
+// NewRemoteWriter returns a writer for the configured endpoint, or nil if no endpoint is configured
+func NewRemoteWriter(config RemoteWriteConfig) *RemoteWriter {
+	if config.URL == "" {
+		return nil
+	}
+	return &RemoteWriter{config: config, client: &http.Client{Timeout: remoteWriteTimeout}}
+}
+
+// Record queues a sample for an execution of the command that finished with the given result,
+// dropping it if too many samples are waiting already.
+func (w *RemoteWriter) Record(command string, result Result, exitCode string, duration time.Duration) {
+	if w == nil {
+		return
+	}
+
+	outcome := RemoteWriteResultFail
+	if result.Signalled() {
+		outcome = RemoteWriteResultSignalled
+	} else if result.Has(CmdOk) {
+		outcome = RemoteWriteResultOk
+	}
+	labels := make(map[string]string, len(w.config.Labels)+4)
+	for k, v := range w.config.Labels {
+		labels[k] = v
+	}
+	labels["__name__"] = remoteWriteSeries
+	labels["command"] = command
+	labels["result"] = outcome
+	labels["exit_code"] = exitCode
+
+	w.Lock()
+	defer w.Unlock()
+	if len(w.pending) >= maxPendingSamples {
+		logger.Debug("Dropping execution sample, because too many are waiting to be written", Fields{"command": command})
+		return
+	}
+	w.pending = append(w.pending, remoteWriteSample{labels: labels, value: duration.Seconds(), time: time.Now()})
+}
+
+// Flush writes the samples that are waiting, logging any failure to do so
+func (w *RemoteWriter) Flush() {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	samples := w.pending
+	w.pending = nil
+	w.Unlock()
+	if len(samples) == 0 {
+		return
+	}
+
+	if err := w.write(samples); err != nil {
+		logger.Warn("Failed to write execution samples", Fields{"url": w.config.URL, "samples": len(samples), "error": err})
+	}
+}
+
+// writeEvery writes waiting samples periodically.
+// It is meant to be called as a goroutine.
+func (w *RemoteWriter) writeEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.Flush()
+	}
+}
+
+// write sends samples to the remote-write endpoint
+func (w *RemoteWriter) write(samples []remoteWriteSample) error {
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(snappyEncode(encodeWriteRequest(samples))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "prometheus-am-executor/"+version)
+	for k, v := range w.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response from remote-write endpoint: %s", resp.Status)
+	}
+	return nil
+}
+
+// encodeWriteRequest returns samples encoded as a remote-write WriteRequest protobuf message,
+// with a time series per sample. Labels of each series are sorted by name, as receivers expect.
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []remoteWriteSample) []byte {
+	var req []byte
+	for _, sample := range samples {
+		names := make([]string, 0, len(sample.labels))
+		for name := range sample.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = protoBytes(label, 1, []byte(name))
+			label = protoBytes(label, 2, []byte(sample.labels[name]))
+			series = protoBytes(series, 1, label)
+		}
+		var s []byte
+		s = protoFixed64(s, 1, math.Float64bits(sample.value))
+		s = protoVarint(s, 2, uint64(sample.time.UnixNano()/int64(time.Millisecond)))
+		series = protoBytes(series, 2, s)
+		req = protoBytes(req, 1, series)
+	}
+	return req
+}
+
+// protoVarint appends a varint protobuf field
+func protoVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3)
+	return appendUvarint(b, v)
+}
+
+// protoFixed64 appends a 64-bit protobuf field, such as a double
+func protoFixed64(b []byte, field int, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	b = appendUvarint(b, uint64(field)<<3|1)
+	return append(b, buf[:]...)
+}
+
+// protoBytes appends a length-delimited protobuf field, such as a string or an embedded message
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendUvarint appends v in the varint encoding that protobuf and snappy share
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// snappyEncode returns data in the snappy block format that remote-write requires.
+// Data is stored as literals rather than compressed, which every snappy decoder accepts;
+// execution samples are small and infrequent enough that compressing them isn't worth a dependency.
+func snappyEncode(data []byte) []byte {
+	b := appendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > 1<<16 {
+			chunk = chunk[:1<<16]
+		}
+		data = data[len(chunk):]
+
+		// Literal lengths are stored minus one; up to 59 fit in the tag byte, and longer ones follow it
+		n := len(chunk) - 1
+		switch {
+		case n < 60:
+			b = append(b, byte(n<<2))
+		case n < 1<<8:
+			b = append(b, 60<<2, byte(n))
+		default:
+			b = append(b, 61<<2, byte(n), byte(n>>8))
+		}
+		b = append(b, chunk...)
+	}
+	return b
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// snappyDecode decodes snappy blocks made up of literals, as snappyEncode writes them
+func snappyDecode(t *testing.T, b []byte) []byte {
+	length, n := binary.Uvarint(b)
+	b = b[n:]
+	var data []byte
+	for len(b) > 0 {
+		tag := b[0]
+		if tag&3 != 0 {
+			t.Fatalf("Unexpected non-literal snappy element %x", tag)
+		}
+		size := int(tag >> 2)
+		b = b[1:]
+		switch size {
+		case 60:
+			size = int(b[0])
+			b = b[1:]
+		case 61:
+			size = int(b[0]) | int(b[1])<<8
+			b = b[2:]
+		}
+		data = append(data, b[:size+1]...)
+		b = b[size+1:]
+	}
+	if uint64(len(data)) != length {
+		t.Fatalf("Wrong decoded length; got %d, want %d", len(data), length)
+	}
+	return data
+}
+
+// protoFields decodes the top-level fields of a protobuf message, keyed by field number.
+// Varint and 64-bit fields are decoded as uint64 values, and length-delimited ones as byte slices.
+func protoFields(t *testing.T, b []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[field] = append(fields[field], v)
+		case 1:
+			fields[field] = append(fields[field], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			b = b[n:]
+			fields[field] = append(fields[field], b[:size])
+			b = b[size:]
+		default:
+			t.Fatalf("Unexpected protobuf wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func Test_snappyEncode(t *testing.T) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "short", size: 10},
+		{name: "one_byte_length", size: 200},
+		{name: "two_byte_length", size: 5000},
+		{name: "several_chunks", size: 1<<17 + 3},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			data := bytes.Repeat([]byte("amx"), tc.size)[:tc.size]
+			if got := snappyDecode(t, snappyEncode(data)); !bytes.Equal(got, data) {
+				t.Errorf("Data changed by encoding; got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestRemoteWriter_Flush(t *testing.T) {
+	t.Parallel()
+	var body []byte
+	var header http.Header
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		body, _ = ioutil.ReadAll(req.Body)
+	}))
+	defer endpoint.Close()
+
+	writer := NewRemoteWriter(RemoteWriteConfig{URL: endpoint.URL, Labels: map[string]string{"executor": "executor-1"}})
+	writer.Record("restart", CmdFail, "2", 1500*time.Millisecond)
+	writer.Flush()
+
+	if header.Get("Content-Encoding") != "snappy" || header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("Wrong encoding headers; got %v", header)
+	}
+	series := protoFields(t, snappyDecode(t, body))[1]
+	if len(series) != 1 {
+		t.Fatalf("Wrong number of series; got %d, want 1", len(series))
+	}
+	ts := protoFields(t, series[0].([]byte))
+
+	labels := make(map[string]string)
+	var names []string
+	for _, l := range ts[1] {
+		label := protoFields(t, l.([]byte))
+		name := string(label[1][0].([]byte))
+		names = append(names, name)
+		labels[name] = string(label[2][0].([]byte))
+	}
+	want := map[string]string{"__name__": remoteWriteSeries, "command": "restart", "executor": "executor-1", "exit_code": "2", "result": RemoteWriteResultFail}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("Wrong labels; got %v, want %v", labels, want)
+	}
+	if !reflect.DeepEqual(names, []string{"__name__", "command", "executor", "exit_code", "result"}) {
+		t.Errorf("Labels should be sorted by name; got %v", names)
+	}
+
+	sample := protoFields(t, ts[2][0].([]byte))
+	if got := math.Float64frombits(sample[1][0].(uint64)); got != 1.5 {
+		t.Errorf("Wrong sample value; got %v, want %v", got, 1.5)
+	}
+	if got := time.Since(time.Unix(0, int64(sample[2][0].(uint64))*int64(time.Millisecond))); got < 0 || got > time.Minute {
+		t.Errorf("Sample should be timestamped when it was recorded; got %s ago", got)
+	}
+
+	// Samples are only written once
+	body = nil
+	writer.Flush()
+	if body != nil {
+		t.Errorf("Expected nothing to be written without new samples")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// How far back the signal-to-noise report can look
+	reportRetention = 7 * 24 * time.Hour
+	// How far back the signal-to-noise report looks, when the window isn't given
+	defaultReportWindow = 24 * time.Hour
+	// Events are counted in buckets of this size, so that memory use doesn't grow with the number of alerts
+	reportBucketSize = time.Minute
+)
+
+// ReportCounts counts what happened to the alert messages that a command was considered for
+type ReportCounts struct {
+	// Alert messages that the command was considered for
+	Received int `json:"received"`
+	// Alert messages whose labels and annotations the command matched
+	Matched int `json:"matched"`
+	// Alert messages that the command matched, but was skipped for, by reason
+	Skipped map[string]int `json:"skipped"`
+	// Executions of the command that finished, including scheduled runs
+	Executed  int `json:"executed"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// add adds other's counts to c
+func (c *ReportCounts) add(other *ReportCounts) {
+	c.Received += other.Received
+	c.Matched += other.Matched
+	c.Executed += other.Executed
+	c.Succeeded += other.Succeeded
+	c.Failed += other.Failed
+	for reason, n := range other.Skipped {
+		c.Skipped[reason] += n
+	}
+}
+
+// ReportEntry summarises what happened to a command's alert messages over a report's window
+type ReportEntry struct {
+	Command string `json:"command"`
+	ReportCounts
+	// Fraction of executions that succeeded; zero if the command didn't run
+	SuccessRatio float64 `json:"success_ratio"`
+}
+
+// SignalReport is the signal-to-noise report for each configured command, as returned by the /api/report endpoint
+type SignalReport struct {
+	Since    time.Time     `json:"since"`
+	Until    time.Time     `json:"until"`
+	Commands []ReportEntry `json:"commands"`
+}
+
+// Report counts what happens to the alert messages that each command is considered for, over time,
+// so that operators can judge whether each automation is earning its keep.
+// Counts are kept in buckets of a minute, and dropped once they're older than reportRetention.
+type Report struct {
+	buckets map[int64]map[string]*ReportCounts
+	sync.Mutex
+}
+
+// NewReport returns a Report instance, with nothing counted
+func NewReport() *Report {
+	return &Report{buckets: make(map[int64]map[string]*ReportCounts)}
+}
+
+// record updates the counts of a command, in the bucket for the given time
+func (r *Report) record(command string, at time.Time, update func(c *ReportCounts)) {
+	r.Lock()
+	defer r.Unlock()
+	key := at.Truncate(reportBucketSize).Unix()
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = make(map[string]*ReportCounts)
+		r.buckets[key] = bucket
+		r.prune(at)
+	}
+	c, ok := bucket[command]
+	if !ok {
+		c = &ReportCounts{Skipped: make(map[string]int)}
+		bucket[command] = c
+	}
+	update(c)
+}
+
+// prune drops buckets that are older than the report's retention
+func (r *Report) prune(now time.Time) {
+	oldest := now.Add(-reportRetention).Unix()
+	for key := range r.buckets {
+		if key < oldest {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// Received counts an alert message that a command was considered for, and whether the command matched it
+func (r *Report) Received(command string, matched bool) {
+	r.record(command, time.Now(), func(c *ReportCounts) {
+		c.Received++
+		if matched {
+			c.Matched++
+		}
+	})
+}
+
+// Skipped counts a command being skipped for an alert message that it matched
+func (r *Report) Skipped(command string, reason CmdRunReason) {
+	r.record(command, time.Now(), func(c *ReportCounts) {
+		c.Skipped[reason.Label()]++
+	})
+}
+
+// Executed counts a finished execution of a command, and whether it succeeded or failed
+func (r *Report) Executed(command string, result Result) {
+	r.record(command, time.Now(), func(c *ReportCounts) {
+		c.Executed++
+		if result.Has(CmdOk) {
+			c.Succeeded++
+		}
+		if result.Has(CmdFail) {
+			c.Failed++
+		}
+	})
+}
+
+// Counts returns what happened to each command's alert messages since the given time, keyed by command
+func (r *Report) Counts(since time.Time) map[string]*ReportCounts {
+	r.Lock()
+	defer r.Unlock()
+	first := since.Truncate(reportBucketSize).Unix()
+	counts := make(map[string]*ReportCounts)
+	for key, bucket := range r.buckets {
+		if key < first {
+			continue
+		}
+		for command, c := range bucket {
+			total, ok := counts[command]
+			if !ok {
+				total = &ReportCounts{Skipped: make(map[string]int)}
+				counts[command] = total
+			}
+			total.add(c)
+		}
+	}
+	return counts
+}
+
+// parseReportWindow returns how far back the report should look, from the window query parameter
+func parseReportWindow(v string) (time.Duration, error) {
+	if v == "" {
+		return defaultReportWindow, nil
+	}
+	window, err := time.ParseDuration(v)
+	if err != nil || window <= 0 || window > reportRetention {
+		return 0, fmt.Errorf("Invalid window parameter %q, expected a positive duration of at most %s", v, reportRetention)
+	}
+	return window, nil
+}
+
+// handleReport responds to GET requests with the signal-to-noise report of each configured command over a window,
+// such as ?window=1h, in the order commands are configured. The window defaults to a day, and can be up to a week.
+func (s *Server) handleReport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	window, err := parseReportWindow(req.URL.Query().Get("window"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until := time.Now()
+	report := SignalReport{Since: until.Add(-window), Until: until, Commands: []ReportEntry{}}
+	counts := s.report.Counts(report.Since)
+	seen := make(map[string]bool)
+	for _, cmd := range s.config.AllCommands() {
+		label := cmd.MetricLabel()
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		e := ReportEntry{Command: label, ReportCounts: ReportCounts{Skipped: make(map[string]int)}}
+		if c, ok := counts[label]; ok {
+			e.ReportCounts = *c
+		}
+		if e.Executed > 0 {
+			e.SuccessRatio = float64(e.Succeeded) / float64(e.Executed)
+		}
+		report.Commands = append(report.Commands, e)
+	}
+	writeJSON(w, report)
+}
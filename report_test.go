@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReport_Counts(t *testing.T) {
+	t.Parallel()
+	r := NewReport()
+	r.Received("restart", true)
+	r.Received("restart", true)
+	r.Received("restart", false)
+	r.Skipped("restart", CmdRunMaintenance)
+	r.Executed("restart", CmdOk)
+	r.Received("echo", false)
+	// Counts older than the report's retention are dropped once a newer bucket is added
+	r.record("restart", time.Now().Add(-2*reportRetention), func(c *ReportCounts) { c.Received++ })
+	r.record("restart", time.Now().Add(-time.Hour), func(c *ReportCounts) { c.Executed++; c.Failed++ })
+
+	counts := r.Counts(time.Now().Add(-time.Minute))
+	c := counts["restart"]
+	if c == nil {
+		t.Fatalf("Expected counts for restart; got %v", counts)
+	}
+	if c.Received != 3 || c.Matched != 2 || c.Skipped[CmdRunMaintenance.Label()] != 1 || c.Executed != 1 || c.Succeeded != 1 || c.Failed != 0 {
+		t.Errorf("Wrong counts for the last minute; got %+v", c)
+	}
+	if got := counts["echo"]; got == nil || got.Received != 1 || got.Matched != 0 {
+		t.Errorf("Wrong counts for unmatched command; got %+v", got)
+	}
+
+	c = r.Counts(time.Now().Add(-2 * time.Hour))["restart"]
+	if c.Executed != 2 || c.Failed != 1 {
+		t.Errorf("Wrong counts for the last two hours; got %+v", c)
+	}
+	c = r.Counts(time.Now().Add(-3 * reportRetention))["restart"]
+	if c.Received != 3 {
+		t.Errorf("Counts older than the retention should be dropped; got %+v", c)
+	}
+}
+
+func Test_parseReportWindow(t *testing.T) {
+	t.Parallel()
+	cases := map[string]time.Duration{"": defaultReportWindow, "1h": time.Hour, "0s": 0, "-1h": 0, "banana": 0, "1000h": 0}
+	for v, want := range cases {
+		got, err := parseReportWindow(v)
+		if (err == nil) != (want > 0) || got != want {
+			t.Errorf("Wrong window for %q; got %s, %v, want %s", v, got, err, want)
+		}
+	}
+}
+
+func TestServer_handleReport(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	restart := &Command{Cmd: "false", Name: "restart"}
+	srv.config.Commands = []*Command{restart, {Cmd: "echo"}}
+	srv.report.Received("restart", true)
+
+	out := make(chan CommandResult)
+	go srv.instrument("", nil, restart, nil, nil, out, nil)
+	for range out {
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleReport(w, httptest.NewRequest(http.MethodGet, "/api/report?window=1h", nil))
+	var got SignalReport
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if got.Until.Sub(got.Since) != time.Hour {
+		t.Errorf("Wrong window; got %s to %s", got.Since, got.Until)
+	}
+	if len(got.Commands) != 2 {
+		t.Fatalf("Wrong number of commands; got %d, want 2", len(got.Commands))
+	}
+	e := got.Commands[0]
+	if e.Command != "restart" || e.Received != 1 || e.Matched != 1 || e.Executed != 1 || e.Failed != 1 || e.SuccessRatio != 0 {
+		t.Errorf("Wrong entry for command that ran; got %+v", e)
+	}
+	if e := got.Commands[1]; e.Command != "echo" || e.Received != 0 || e.Executed != 0 {
+		t.Errorf("Wrong entry for command that didn't run; got %+v", e)
+	}
+
+	w = httptest.NewRecorder()
+	srv.handleReport(w, httptest.NewRequest(http.MethodGet, "/api/report?window=banana", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for invalid window; got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// resolveRequest represents the body of a request to resolve alerts at runtime
+type resolveRequest struct {
+	// Alerts with these fingerprints are resolved
+	Fingerprints []string `json:"fingerprints"`
+	// Alerts that commands are running for are resolved if their labels match all of these
+	Labels map[string]string `json:"labels"`
+}
+
+// resolveResponse represents the body of a response to a request to resolve alerts at runtime
+type resolveResponse struct {
+	// Fingerprints of the alerts that were resolved
+	Fingerprints []string `json:"fingerprints"`
+	// Executions that were running for the resolved alerts, which are signalled the same way as for a resolved webhook
+	Processes []Process `json:"processes"`
+}
+
+// matchesAll returns true if all of the given labels have the same value in other
+func matchesAll(labels map[string]string, other map[string]string) bool {
+	for k, v := range labels {
+		if o, ok := other[k]; !ok || o != v {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvedAlerts returns a resolved alert for each fingerprint that the request names, or whose running executions
+// match the request's labels, along with those executions. The alerts have the labels of their executions, so that
+// commands with match_labels find them.
+func (s *Server) resolvedAlerts(rr resolveRequest, now time.Time) (template.Alerts, []Process) {
+	named := make(map[string]bool, len(rr.Fingerprints))
+	for _, f := range rr.Fingerprints {
+		if f != "" {
+			named[f] = true
+		}
+	}
+
+	labels := make(map[string]map[string]string)
+	procs := []Process{}
+	for _, proc := range s.processes.List() {
+		if proc.Fingerprint == "" {
+			continue
+		}
+		if named[proc.Fingerprint] || len(rr.Labels) > 0 && matchesAll(rr.Labels, proc.Labels) {
+			labels[proc.Fingerprint] = proc.Labels
+			procs = append(procs, proc)
+		}
+	}
+	for f := range named {
+		if _, ok := labels[f]; !ok {
+			labels[f] = nil
+		}
+	}
+
+	alerts := make(template.Alerts, 0, len(labels))
+	for f, l := range labels {
+		alerts = append(alerts, template.Alert{Status: "resolved", Labels: template.KV(l), Fingerprint: f, EndsAt: now})
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Fingerprint < alerts[j].Fingerprint
+	})
+	return alerts, procs
+}
+
+// handleResolve resolves alerts by fingerprint or labels for POST requests, as if alertmanager had sent a resolved
+// notification for each of them, so that operators can stop the remediations running for alerts they've taken over.
+// Commands run for resolved alerts aren't started; only running commands are signalled.
+func (s *Server) handleResolve(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	var rr resolveRequest
+	if err := json.Unmarshal(data, &rr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rr.Fingerprints) == 0 && len(rr.Labels) == 0 {
+		http.Error(w, "Missing fingerprints or labels of alerts to resolve", http.StatusBadRequest)
+		return
+	}
+
+	alerts, procs := s.resolvedAlerts(rr, time.Now())
+	resp := resolveResponse{Fingerprints: make([]string, 0, len(alerts)), Processes: procs}
+	for _, alert := range alerts {
+		// Each alert is resolved on its own, since commands only consider the first alert of a message they match
+		s.amResolved(&template.Data{Status: "resolved", Alerts: template.Alerts{alert}, CommonLabels: alert.Labels})
+		resp.Fingerprints = append(resp.Fingerprints, alert.Fingerprint)
+	}
+	logger.Decision("Alerts resolved at runtime", Fields{"fingerprints": resp.Fingerprints, "labels": rr.Labels, "executions": len(procs), "remote_addr": req.RemoteAddr})
+	writeJSON(w, resp)
+}
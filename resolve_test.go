@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_resolvedAlerts(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.processes.Add(Process{Execution: 1, Command: "drain", Fingerprint: "a", Labels: map[string]string{"alertname": "DiskFull", "host": "db1"}})
+	srv.processes.Add(Process{Execution: 2, Command: "drain", Fingerprint: "b", Labels: map[string]string{"alertname": "DiskFull", "host": "db2"}})
+	srv.processes.Add(Process{Execution: 3, Command: "restart", Fingerprint: "c", Labels: map[string]string{"alertname": "Down"}})
+	srv.processes.Add(Process{Execution: 4, Command: "cleanup"})
+
+	now := time.Now()
+	cases := []struct {
+		name  string
+		rr    resolveRequest
+		want  []string
+		procs int
+	}{
+		{name: "labels", rr: resolveRequest{Labels: map[string]string{"alertname": "DiskFull"}}, want: []string{"a", "b"}, procs: 2},
+		{name: "fingerprints", rr: resolveRequest{Fingerprints: []string{"c", "gone"}}, want: []string{"c", "gone"}, procs: 1},
+		{name: "both", rr: resolveRequest{Fingerprints: []string{"c"}, Labels: map[string]string{"host": "db1"}}, want: []string{"a", "c"}, procs: 2},
+		{name: "no_match", rr: resolveRequest{Labels: map[string]string{"alertname": "Other"}}, want: []string{}, procs: 0},
+	}
+
+	for _, tc := range cases {
+		alerts, procs := srv.resolvedAlerts(tc.rr, now)
+		var got []string
+		for _, a := range alerts {
+			got = append(got, a.Fingerprint)
+			if a.Status != "resolved" || !a.EndsAt.Equal(now) {
+				t.Errorf("Wrong alert for %s; got %+v", tc.name, a)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") || len(procs) != tc.procs {
+			t.Errorf("Wrong alerts resolved for %s; got %v and %d executions, want %v and %d", tc.name, got, len(procs), tc.want, tc.procs)
+		}
+	}
+}
+
+func TestServer_handleResolve(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "sleep", Args: []string{"5"}, MatchLabels: map[string]string{"alertname": "Disk"}}
+	srv.config.Commands = []*Command{cmd}
+
+	alert := &template.Alert{Labels: template.KV{"alertname": "Disk"}, Fingerprint: "boop"}
+	out := make(chan CommandResult)
+	go srv.instrument("boop", alert, cmd, cmd.Args, nil, out, nil)
+	for i := 0; i < 50; i++ {
+		if procs := srv.processes.List(); len(procs) == 1 && procs[0].PID != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleResolve(w, httptest.NewRequest(http.MethodPost, "/api/resolve", strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for request without fingerprints or labels; got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	srv.handleResolve(w, httptest.NewRequest(http.MethodPost, "/api/resolve", strings.NewReader(`{"labels":{"alertname":"Disk"}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status; got %d, want %d", w.Code, http.StatusOK)
+	}
+	var got resolveResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(got.Fingerprints) != 1 || got.Fingerprints[0] != "boop" || len(got.Processes) != 1 {
+		t.Errorf("Wrong alerts resolved; got %+v", got)
+	}
+
+	// Resolving the alert signals the command, as a resolved webhook would
+	signalled := false
+	for r := range out {
+		if r.Kind.Has(CmdSigOk) {
+			signalled = true
+		}
+	}
+	if !signalled {
+		t.Error("Command wasn't signalled")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const (
+	// Outcomes of the commands listed in detailed webhook responses
+	OutcomeFinished  = "finished"
+	OutcomeSkipped   = "skipped"
+	OutcomePending   = "pending"
+	OutcomeSignalled = "signalled"
+)
+
+// CommandOutcome describes what happened to a command that matched an alert message
+type CommandOutcome struct {
+	Command string `json:"command"`
+	// One of finished, skipped, pending (waiting for approval) or signalled
+	Outcome string `json:"outcome"`
+	// Why the command was skipped
+	Reason   string   `json:"reason,omitempty"`
+	Result   string   `json:"result,omitempty"`
+	ExitCode string   `json:"exit_code,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// WebhookResponse is the body of responses to webhook requests, when detailed_response is set
+type WebhookResponse struct {
+	Commands []CommandOutcome `json:"commands"`
+	// Errors that failed the request, as they would have been reported in a plain text response
+	Errors []string `json:"errors,omitempty"`
+	// True if commands were still running when the response was sent, because they ran longer than sync_timeout.
+	// Only the commands that had finished or were skipped by then are listed.
+	Running bool `json:"running,omitempty"`
+}
+
+// outcomeCollector gathers the progress of handling an alert message, to respond with once it's done
+type outcomeCollector struct {
+	outcomes []CommandOutcome
+	sync.Mutex
+}
+
+// record notes what happened to a command, from a progress event.
+// Commands that started aren't noted until they finish.
+func (c *outcomeCollector) record(ev ProgressEvent) {
+	o := CommandOutcome{Command: ev.Command, Reason: ev.Reason, Result: ev.Result, ExitCode: ev.ExitCode, Errors: ev.Errors}
+	switch ev.Event {
+	case ProgressFinished:
+		o.Outcome = OutcomeFinished
+	case ProgressSkipped:
+		o.Outcome = OutcomeSkipped
+	case ProgressPending:
+		o.Outcome = OutcomePending
+	case ProgressSignalled:
+		o.Outcome = OutcomeSignalled
+	default:
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.outcomes = append(c.outcomes, o)
+}
+
+// response returns the body to respond with, once handling the alert message returned the given errors
+func (c *outcomeCollector) response(errors []error) WebhookResponse {
+	c.Lock()
+	defer c.Unlock()
+	resp := WebhookResponse{Commands: append([]CommandOutcome{}, c.outcomes...)}
+	for _, err := range errors {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+	return resp
+}
+
+// running returns the body to respond with, when commands are still running after sync_timeout
+func (c *outcomeCollector) running() WebhookResponse {
+	resp := c.response(nil)
+	resp.Running = true
+	return resp
+}
+
+// writeWebhookResponse responds to a webhook request with the detailed response.
+// The status is 500 if there were errors, as it is for plain text responses, so that alertmanager retries,
+// or 202 if commands are still running.
+func writeWebhookResponse(w http.ResponseWriter, resp WebhookResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Errors) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	} else if resp.Running {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	if _, err := w.Write(data); err != nil {
+		logger.Error("Failed to write response", Fields{"error": err})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOutcomeCollector(t *testing.T) {
+	t.Parallel()
+	c := &outcomeCollector{}
+	c.record(ProgressEvent{Event: ProgressStarted, Command: "false"})
+	c.record(ProgressEvent{Event: ProgressSkipped, Command: "true", Reason: CmdRunFingerOver.Label()})
+	c.record(ProgressEvent{Event: ProgressFinished, Command: "false", Result: CmdFail.String(), ExitCode: "1"})
+	c.record(ProgressEvent{Event: ProgressDone})
+
+	resp := c.response([]error{errors.New("boom")})
+	want := []CommandOutcome{
+		{Command: "true", Outcome: OutcomeSkipped, Reason: CmdRunFingerOver.Label()},
+		{Command: "false", Outcome: OutcomeFinished, Result: CmdFail.String(), ExitCode: "1"},
+	}
+	if len(resp.Commands) != len(want) {
+		t.Fatalf("Wrong outcomes; got %+v, want %+v", resp.Commands, want)
+	}
+	for i, o := range resp.Commands {
+		if o.Command != want[i].Command || o.Outcome != want[i].Outcome || o.Reason != want[i].Reason || o.Result != want[i].Result || o.ExitCode != want[i].ExitCode {
+			t.Errorf("Wrong outcome %d; got %+v, want %+v", i, o, want[i])
+		}
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0] != "boom" {
+		t.Errorf("Wrong errors; got %v, want [boom]", resp.Errors)
+	}
+}
+
+func TestServer_handleWebhookDetailed(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.DetailedResponse = true
+	srv.config.Commands = []*Command{
+		{Cmd: "true"},
+		{Cmd: "true", MatchLabels: map[string]string{"job": "fixed"}},
+	}
+
+	trigger, err := json.Marshal(&amDataFinger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.handleWebhook(w, httptest.NewRequest("POST", "/", bytes.NewReader(trigger)))
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wrong response from handleWebhook; got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Wrong content type; got %s, want application/json", ct)
+	}
+	var got WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(got.Commands) != 1 || len(got.Errors) != 0 {
+		t.Fatalf("Wrong response; got %+v, want one command and no errors", got)
+	}
+	if o := got.Commands[0]; o.Command != "true" || o.Outcome != OutcomeFinished || o.Result != CmdOk.String() || o.ExitCode != "0" {
+		t.Errorf("Wrong outcome; got %+v", o)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+)
+
+// rollback runs the rollback commands of steps that succeeded before a later step in their chain of follow-ups failed.
+// Rollbacks run one at a time, most recent step first, so that each undoes its step on top of the steps before it.
+//
+// Rollbacks run to completion, even if the alert resolves in the meantime, and regardless of the limits on their
+// commands, since the steps they undo already ran. A failed rollback doesn't stop the ones after it.
+// Errors from rollbacks that should be notified about are returned.
+func (s *Server) rollback(amMsg *template.Data, completed []*Command, progress progressFunc, parent *Span) []error {
+	var errs []error
+	for i := len(completed) - 1; i >= 0; i-- {
+		cmd, ok := completed[i].RollbackCommand()
+		if !ok {
+			continue
+		}
+
+		data := cmd.FilterData(amMsg)
+		args, err := cmd.ExpandArgs(data, s.location)
+		var cmdEnv []string
+		if err == nil {
+			cmdEnv, err = cmd.ExpandEnv(data, s.location)
+		}
+		errLabel := ErrLabelTemplate
+		var env []string
+		if err == nil {
+			env, err = s.alertEnv(data)
+			errLabel = ErrLabelEnv
+			env = append(env, cmdEnv...)
+		}
+		if err != nil {
+			logger.Error("Not executing rollback command", Fields{"command": cmd, "error": err})
+			s.errCounter.WithLabelValues(errLabel, cmd.MetricLabel()).Inc()
+			if cmd.ShouldNotify() {
+				errs = append(errs, err)
+			}
+			progress.send(ProgressEvent{Event: ProgressFinished, Command: cmd.String(), Result: CmdFail.String(), Errors: []string{err.Error()}})
+			continue
+		}
+
+		s.decision(cmd, "Rolling back command", Fields{"command": completed[i], "rollback": cmd})
+		span := parent.Child("rollback", Fields{"command": completed[i].String()})
+		out := make(chan CommandResult)
+		// Rollbacks aren't queued behind their step's executions, so they start right away
+		go s.instrument("", nil, cmd, args, env, out, span)
+		progress.send(ProgressEvent{Event: ProgressStarted, Command: cmd.String()})
+
+		var resultState Result
+		var runErr error
+		for result := range out {
+			resultState = resultState | result.Kind
+			if result.Kind.Has(CmdFail) {
+				runErr = result.Err
+			}
+			if result.Kind.Has(CmdFail) && result.Err != nil && cmd.ShouldNotify() {
+				errs = append(errs, result.Err)
+			}
+		}
+		s.decision(cmd, "Rollback command finished", Fields{"command": completed[i], "rollback": cmd, "result": resultState})
+		progress.send(ProgressEvent{Event: ProgressFinished, Command: cmd.String(), Result: resultState.String()})
+		span.End(runErr)
+	}
+	return errs
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServer_rollback(t *testing.T) {
+	cases := []struct {
+		name     string
+		last     string
+		failover []string
+		want     []string
+		errors   int
+	}{
+		// Steps that succeeded are rolled back in reverse order after the last one fails
+		{name: "failed", last: "false", want: []string{"drain", "switch", "undo switch", "undo drain"}, errors: 1},
+		{name: "succeeded", last: "true", want: []string{"drain", "switch"}},
+		// A failure that's handled by a follow-up doesn't end the chain
+		{name: "failure_handled", last: "false", failover: []string{"page"}, want: []string{"drain", "switch", "page"}, errors: 1},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "am-executor-rollback")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.RemoveAll(dir)
+			}()
+			log := filepath.Join(dir, "steps.log")
+			record := func(step string) []string {
+				return []string{"-c", "echo '" + step + "' >> " + log}
+			}
+
+			commands := []*Command{
+				{Name: "drain", Cmd: "sh", Args: record("drain"), RollbackCmd: "sh", RollbackArgs: record("undo drain"), OnSuccessRun: []string{"switch"}},
+				{Name: "switch", Cmd: "sh", Args: record("switch"), RollbackCmd: "sh", RollbackArgs: record("undo switch"), OnSuccessRun: []string{"restart"}},
+				{Name: "restart", Cmd: tc.last, OnFailureRun: tc.failover},
+			}
+			for _, name := range tc.failover {
+				commands = append(commands, &Command{Name: name, Cmd: "sh", Args: record(name)})
+			}
+			srv := NewServer(&Config{Commands: commands})
+			amMsg := &template.Data{Status: "firing", Alerts: template.Alerts{{Status: "firing", Fingerprint: "abc"}}}
+			errs := srv.runCommands(amMsg, "", nil, nil)
+			if len(errs) != tc.errors {
+				t.Errorf("Wrong number of errors; got %v, want %d", errs, tc.errors)
+			}
+
+			data, err := ioutil.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Split(strings.TrimSpace(string(data)), "\n"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Wrong steps run; got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
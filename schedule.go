@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"time"
+)
+
+// Schedule runs a command on a cron schedule, independently of alerts, such as for housekeeping.
+// The command is configured like the commands run for alerts, apart from the settings about matching alerts.
+type Schedule struct {
+	// When to run the command, as a cron expression such as "0 3 * * *", in the configured timezone
+	Cron    string `yaml:"cron"`
+	Command `yaml:",inline"`
+}
+
+// checkSchedules returns an error if scheduled commands have cron expressions or settings that can't be used,
+// or names that are already used by other commands.
+func checkSchedules(c *Config) error {
+	names := make(map[string]bool)
+	for _, cmd := range c.Commands {
+		if cmd.Name != "" {
+			names[cmd.Name] = true
+		}
+	}
+
+	for i, sched := range c.Schedules {
+		cmd := &sched.Command
+		if _, err := ParseCron(sched.Cron); err != nil {
+			return fmt.Errorf("Invalid cron specified for schedule %q at index %d: %w", cmd, i, err)
+		}
+		if cmd.Cmd == "" {
+			return fmt.Errorf("Invalid cmd specified for schedule at index %d: cmd isn't set", i)
+		}
+		if _, err := cmd.ParseArgTemplates(time.UTC); err != nil {
+			return fmt.Errorf("Invalid args specified for schedule %q at index %d: %w", cmd, i, err)
+		}
+		if _, err := cmd.ParseEnvTemplates(time.UTC); err != nil {
+			return fmt.Errorf("Invalid env specified for schedule %q at index %d: %w", cmd, i, err)
+		}
+		if cmd.KillAfter < 0 {
+			return fmt.Errorf("Invalid kill_after specified for schedule %q at index %d: %s is negative", cmd, i, cmd.KillAfter)
+		}
+		if cmd.Timeout < 0 {
+			return fmt.Errorf("Invalid timeout specified for schedule %q at index %d: %s is negative", cmd, i, cmd.Timeout)
+		}
+		if cmd.RetryBackoff < 0 {
+			return fmt.Errorf("Invalid retry_backoff specified for schedule %q at index %d: %s is negative", cmd, i, cmd.RetryBackoff)
+		}
+		if cmd.Jitter < 0 {
+			return fmt.Errorf("Invalid jitter specified for schedule %q at index %d: %s is negative", cmd, i, cmd.Jitter)
+		}
+		for _, m := range cmd.Metrics {
+			if err := m.Validate(); err != nil {
+				return fmt.Errorf("Invalid metrics specified for schedule %q at index %d: %w", cmd, i, err)
+			}
+		}
+		if cmd.SLO != nil {
+			if err := cmd.SLO.Validate(); err != nil {
+				return fmt.Errorf("Invalid slo specified for schedule %q at index %d: %w", cmd, i, err)
+			}
+		}
+		if cmd.RequiresApproval() {
+			return fmt.Errorf("Invalid approval_required specified for schedule %q at index %d: scheduled commands can't wait for approval", cmd, i)
+		}
+		if cmd.IsDestructive() || cmd.Guard != nil {
+			return fmt.Errorf("Invalid destructive specified for schedule %q at index %d: scheduled commands have no alert to guard them", cmd, i)
+		}
+		if cmd.Name != "" {
+			if names[cmd.Name] {
+				return fmt.Errorf("Invalid name specified for schedule %q at index %d: %q is already used", cmd, i, cmd.Name)
+			}
+			names[cmd.Name] = true
+		}
+	}
+	return nil
+}
+
+// scheduleEvery runs a scheduled command each time its cron expression matches, until the executor stops.
+// Runs don't overlap; a run that's due while the previous one is still going is skipped.
+// It is meant to be called as a goroutine.
+func (s *Server) scheduleEvery(sched *Schedule) {
+	// Expressions are validated when the config is read
+	cron, err := ParseCron(sched.Cron)
+	if err != nil {
+		logger.Error("Not scheduling command", Fields{"command": &sched.Command, "cron": sched.Cron, "error": err})
+		return
+	}
+
+	for {
+		next := cron.Next(time.Now().In(s.location))
+		if next.IsZero() {
+			logger.Warn("Not scheduling command, because its cron expression never matches", Fields{"command": &sched.Command, "cron": sched.Cron})
+			return
+		}
+		s.debug(&sched.Command, "Scheduled command", Fields{"command": &sched.Command, "cron": sched.Cron, "next": next})
+		time.Sleep(time.Until(next))
+		s.runScheduled(sched)
+	}
+}
+
+// runScheduled runs a scheduled command, and waits for it to finish.
+// It's instrumented like commands run for alerts, but isn't run for a particular alert,
+// so it has no alert data in its environment and can't be signalled.
+func (s *Server) runScheduled(sched *Schedule) {
+	cmd := &sched.Command
+	if cmd.Name != "" && s.disabled.Disabled(cmd.Name) {
+		s.skip(cmd, CmdRunDisabled, "")
+		return
+	}
+
+	span := s.tracer.Start("schedule", Fields{"command": cmd.String(), "cron": sched.Cron})
+	args, err := cmd.ExpandArgs(&template.Data{}, s.location)
+	var env []string
+	if err == nil {
+		env, err = cmd.ExpandEnv(&template.Data{}, s.location)
+	}
+	if err != nil {
+		logger.Error("Not executing scheduled command", Fields{"command": cmd, "error": err})
+		s.errCounter.WithLabelValues(ErrLabelTemplate, cmd.MetricLabel()).Inc()
+		span.End(err)
+		return
+	}
+
+	s.decision(cmd, "Executing scheduled command", Fields{"command": cmd, "cron": sched.Cron})
+	out := make(chan CommandResult)
+	if !s.dispatch("", nil, cmd, args, append([]string{"AMX_SCHEDULE=" + sched.Cron}, env...), out, span) {
+		s.skip(cmd, CmdRunQueueFull, "")
+	}
+	var runErr error
+	for result := range out {
+		if result.Kind.Has(CmdFail) {
+			runErr = result.Err
+		}
+	}
+	if runErr != nil {
+		logger.Error("Scheduled command failed", Fields{"command": cmd, "cron": sched.Cron, "error": runErr})
+	}
+	span.End(runErr)
+}
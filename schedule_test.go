@@ -0,0 +1,75 @@
+package main
+
+import (
+	pm "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+func TestCheckSchedules(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			config: Config{Schedules: []*Schedule{{Cron: "@hourly", Command: Command{Cmd: "/usr/local/bin/cleanup", Name: "cleanup"}}}},
+		},
+		{
+			name:    "bad_cron",
+			config:  Config{Schedules: []*Schedule{{Cron: "every day", Command: Command{Cmd: "/usr/local/bin/cleanup"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "no_cmd",
+			config:  Config{Schedules: []*Schedule{{Cron: "@hourly"}}},
+			wantErr: true,
+		},
+		{
+			name: "name_used_by_command",
+			config: Config{
+				Commands:  []*Command{{Cmd: "/usr/local/bin/restart-service", Name: "cleanup"}},
+				Schedules: []*Schedule{{Cron: "@hourly", Command: Command{Cmd: "/usr/local/bin/cleanup", Name: "cleanup"}}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkSchedules(&tc.config)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Unexpected error result; got %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_runScheduled(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	sched := &Schedule{Cron: "@daily", Command: Command{Cmd: "true", Name: "cleanup"}}
+	srv.config.Schedules = []*Schedule{sched}
+
+	srv.runScheduled(sched)
+	var m pm.Metric
+	if err := srv.exitCounter.WithLabelValues("cleanup", "0").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if v := m.GetCounter().GetValue(); v != 1 {
+		t.Errorf("Wrong number of scheduled executions; got %v, want 1", v)
+	}
+
+	srv.disabled.Disable("cleanup")
+	srv.runScheduled(sched)
+	if err := srv.exitCounter.WithLabelValues("cleanup", "0").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if v := m.GetCounter().GetValue(); v != 1 {
+		t.Errorf("Disabled scheduled command ran; got %v executions, want 1", v)
+	}
+}
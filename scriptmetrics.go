@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Environment variable telling a command where to write its custom metric updates
+	metricsFileEnv = "AMX_METRICS_FILE"
+	// Prefix for the subsystem of custom metrics, so they can't collide with our own metrics
+	customMetricSubsystem = "script"
+
+	MetricTypeCounter = "counter"
+	MetricTypeGauge   = "gauge"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	customMetricLabels = []string{"command"}
+)
+
+// CustomMetric declares a metric that a command can update, by writing lines like
+// "metric <name> <value>" to the file named by the AMX_METRICS_FILE environment variable.
+// Counters are increased by the value, while gauges are set to it.
+type CustomMetric struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	Help string `yaml:"help"`
+}
+
+// Validate returns an error if the metric can't be exposed
+func (m CustomMetric) Validate() error {
+	if !metricNameRe.MatchString(m.Name) {
+		return fmt.Errorf("Invalid metric name %q", m.Name)
+	}
+	if m.Type != MetricTypeCounter && m.Type != MetricTypeGauge {
+		return fmt.Errorf("Invalid type %q for metric %s, expected %s or %s", m.Type, m.Name, MetricTypeCounter, MetricTypeGauge)
+	}
+	return nil
+}
+
+// parseMetricLine parses a custom metric update line like "metric <name> <value>"
+func parseMetricLine(line string) (string, float64, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != "metric" {
+		return "", 0, fmt.Errorf("Malformed metric line %q, expected \"metric <name> <value>\"", line)
+	}
+
+	v, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid value for metric %s: %w", fields[1], err)
+	}
+	return fields[1], v, nil
+}
+
+// registerCustomMetrics creates and registers the metrics declared by commands.
+// Commands declaring a metric with the same name share it, with the command being used as a label.
+func (s *Server) registerCustomMetrics() error {
+	for _, cmd := range s.config.AllCommands() {
+		for _, m := range cmd.Metrics {
+			if err := m.Validate(); err != nil {
+				return fmt.Errorf("Command %s: %w", cmd, err)
+			}
+
+			help := m.Help
+			if help == "" {
+				help = "Custom metric reported by commands."
+			}
+			switch m.Type {
+			case MetricTypeCounter:
+				if _, ok := s.customGauges[m.Name]; ok {
+					return fmt.Errorf("Command %s declares metric %s as a counter, but it's declared as a gauge elsewhere", cmd, m.Name)
+				}
+				if _, ok := s.customCounters[m.Name]; ok {
+					continue
+				}
+				cv := prometheus.NewCounterVec(prometheus.CounterOpts{
+					Namespace: metricNamespace,
+					Subsystem: customMetricSubsystem,
+					Name:      m.Name,
+					Help:      help,
+				}, customMetricLabels)
+				if err := s.registry.Register(cv); err != nil {
+					return err
+				}
+				s.customCounters[m.Name] = cv
+			case MetricTypeGauge:
+				if _, ok := s.customCounters[m.Name]; ok {
+					return fmt.Errorf("Command %s declares metric %s as a gauge, but it's declared as a counter elsewhere", cmd, m.Name)
+				}
+				if _, ok := s.customGauges[m.Name]; ok {
+					continue
+				}
+				gv := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+					Namespace: metricNamespace,
+					Subsystem: customMetricSubsystem,
+					Name:      m.Name,
+					Help:      help,
+				}, customMetricLabels)
+				if err := s.registry.Register(gv); err != nil {
+					return err
+				}
+				s.customGauges[m.Name] = gv
+			}
+		}
+	}
+
+	return nil
+}
+
+// recordCustomMetrics applies the custom metric updates a command wrote.
+// Updates to metrics that the command didn't declare are ignored.
+func (s *Server) recordCustomMetrics(cmd *Command, r io.Reader) {
+	declared := make(map[string]string, len(cmd.Metrics))
+	for _, m := range cmd.Metrics {
+		declared[m.Name] = m.Type
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		name, v, err := parseMetricLine(line)
+		if err != nil {
+			logger.Warn("Ignoring metric update from command", Fields{"command": cmd, "error": err})
+			continue
+		}
+
+		switch declared[name] {
+		case MetricTypeCounter:
+			if v < 0 {
+				logger.Warn("Ignoring negative update of counter from command", Fields{"command": cmd, "metric": name})
+				continue
+			}
+			s.customCounters[name].WithLabelValues(cmd.String()).Add(v)
+		case MetricTypeGauge:
+			s.customGauges[name].WithLabelValues(cmd.String()).Set(v)
+		default:
+			logger.Warn("Ignoring update of undeclared metric from command", Fields{"command": cmd, "metric": name})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Error("Failed to read metric updates from command", Fields{"command": cmd, "error": err})
+	}
+}
+
+// metricsFile creates a file for a command to write its custom metric updates to,
+// returning the file and the environment variable that tells the command where it is.
+// Returns a nil file if the command doesn't declare any metrics.
+func (s *Server) metricsFile(cmd *Command) (*os.File, string, error) {
+	if len(cmd.Metrics) == 0 {
+		return nil, "", nil
+	}
+
+	f, err := s.tempFiles.Create("am-executor-metrics-*")
+	if err != nil {
+		return nil, "", err
+	}
+	return f, metricsFileEnv + "=" + f.Name(), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	pm "github.com/prometheus/client_model/go"
+	"strings"
+	"testing"
+)
+
+func TestCustomMetric_Validate(t *testing.T) {
+	cases := []struct {
+		name   string
+		metric CustomMetric
+		err    bool
+	}{
+		{
+			name:   "counter",
+			metric: CustomMetric{Name: "hosts_rebooted", Type: MetricTypeCounter},
+		},
+		{
+			name:   "gauge",
+			metric: CustomMetric{Name: "disk_free_bytes", Type: MetricTypeGauge},
+		},
+		{
+			name:   "bad_name",
+			metric: CustomMetric{Name: "hosts-rebooted", Type: MetricTypeCounter},
+			err:    true,
+		},
+		{
+			name:   "no_name",
+			metric: CustomMetric{Type: MetricTypeCounter},
+			err:    true,
+		},
+		{
+			name:   "bad_type",
+			metric: CustomMetric{Name: "hosts_rebooted", Type: "histogram"},
+			err:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := tc.metric.Validate()
+			if tc.err && err == nil {
+				t.Errorf("Expected error validating metric %+v", tc.metric)
+			} else if !tc.err && err != nil {
+				t.Errorf("Unexpected error validating metric %+v: %v", tc.metric, err)
+			}
+		})
+	}
+}
+
+func Test_parseMetricLine(t *testing.T) {
+	cases := []struct {
+		line  string
+		name  string
+		value float64
+		err   bool
+	}{
+		{line: "metric hosts_rebooted 1", name: "hosts_rebooted", value: 1},
+		{line: "metric  disk_free_bytes\t1.5e9", name: "disk_free_bytes", value: 1.5e9},
+		{line: "hosts_rebooted 1", err: true},
+		{line: "metric hosts_rebooted", err: true},
+		{line: "metric hosts_rebooted one", err: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.line, func(t *testing.T) {
+			t.Parallel()
+			name, value, err := parseMetricLine(tc.line)
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected error parsing %q", tc.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %q: %v", tc.line, err)
+			}
+			if name != tc.name || value != tc.value {
+				t.Errorf("Wrong metric parsed from %q; got %s=%f, want %s=%f", tc.line, name, value, tc.name, tc.value)
+			}
+		})
+	}
+}
+
+func TestServer_registerCustomMetrics(t *testing.T) {
+	t.Parallel()
+	c := Config{
+		Commands: []*Command{
+			{Cmd: "echo", Metrics: []CustomMetric{{Name: "hosts_rebooted", Type: MetricTypeCounter}}},
+			{Cmd: "true", Metrics: []CustomMetric{{Name: "hosts_rebooted", Type: MetricTypeGauge}}},
+		},
+	}
+	s := NewServer(&c)
+	if err := s.registerCustomMetrics(); err == nil {
+		t.Errorf("Expected error registering a metric declared with conflicting types")
+	}
+}
+
+func TestServer_recordCustomMetrics(t *testing.T) {
+	t.Parallel()
+	cmd := &Command{
+		Cmd: "echo",
+		Metrics: []CustomMetric{
+			{Name: "hosts_rebooted", Type: MetricTypeCounter},
+			{Name: "disk_free_bytes", Type: MetricTypeGauge},
+		},
+	}
+	s := NewServer(&Config{Commands: []*Command{cmd}})
+	if err := s.registerCustomMetrics(); err != nil {
+		t.Fatalf("Failed to register custom metrics: %v", err)
+	}
+
+	updates := strings.Join([]string{
+		"metric hosts_rebooted 2",
+		"metric hosts_rebooted -1",
+		"metric hosts_rebooted 1",
+		"metric disk_free_bytes 100",
+		"metric disk_free_bytes 42",
+		"metric undeclared 1",
+		"garbage",
+	}, "\n")
+	s.recordCustomMetrics(cmd, strings.NewReader(updates))
+
+	got, err := getCounterValue(s.customCounters["hosts_rebooted"], cmd.String())
+	if err != nil {
+		t.Fatalf("Failed to read counter: %v", err)
+	}
+	if got != 3 {
+		t.Errorf("Wrong counter value; got %f, want %f", got, 3.0)
+	}
+
+	var m pm.Metric
+	if err := s.customGauges["disk_free_bytes"].WithLabelValues(cmd.String()).Write(&m); err != nil {
+		t.Fatalf("Failed to read gauge: %v", err)
+	}
+	if got := m.Gauge.GetValue(); got != 42 {
+		t.Errorf("Wrong gauge value; got %f, want %f", got, 42.0)
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/imgix/prometheus-am-executor/chanmap"
 	"github.com/imgix/prometheus-am-executor/countermap"
@@ -10,12 +10,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	pm "github.com/prometheus/client_model/go"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
+	texttemplate "text/template"
 	"time"
 )
 
@@ -26,6 +25,21 @@ const (
 	CmdRunNoFinger
 	CmdRunFingerUnder
 	CmdRunFingerOver
+	CmdRunMaintenance
+	CmdRunQueueFull
+	CmdRunResolved
+	CmdRunDisabled
+	CmdRunExpired
+	CmdRunTombstoned
+	CmdRunGuarded
+	CmdRunDebounced
+	CmdRunCooldown
+	CmdRunRateLimited
+	CmdRunDomainLimit
+	CmdRunCanary
+	CmdRunSelfResolved
+	CmdRunSuppressed
+	CmdRunVetoed
 )
 
 const (
@@ -35,8 +49,20 @@ const (
 	ErrLabelRead       = "read"
 	ErrLabelUnmarshall = "unmarshal"
 	ErrLabelStart      = "start"
+	ErrLabelSpool      = "spool"
+	ErrLabelTemplate   = "template"
+	ErrLabelAuth       = "auth"
+	ErrLabelState      = "state"
+	ErrLabelForbidden  = "forbidden"
+	ErrLabelEnv        = "env"
+	ErrLabelHistory    = "history"
+	ErrLabelSD         = "sd"
+	ErrLabelHook       = "hook"
 	SigLabelOk         = "ok"
 	SigLabelFail       = "fail"
+	SigLabelKill       = "kill"
+	// Value of the command label for errors that aren't about a particular command
+	CmdLabelNone = ""
 )
 
 var (
@@ -46,6 +72,21 @@ var (
 		CmdRunNoFinger:     "No fingerprint found for command",
 		CmdRunFingerUnder:  "Command count for fingerprint is under limit",
 		CmdRunFingerOver:   "Command count for fingerprint is over limit",
+		CmdRunMaintenance:  "Alert matches an active maintenance window",
+		CmdRunQueueFull:    "Command queue is full",
+		CmdRunResolved:     "Alert resolved while command was queued",
+		CmdRunDisabled:     "Command was disabled at runtime",
+		CmdRunExpired:      "Command waited in its queue for longer than max_queue_age",
+		CmdRunTombstoned:   "Alert already resolved, and the firing notification arrived late",
+		CmdRunGuarded:      "Alert doesn't meet the conditions of the destructive command's guard",
+		CmdRunDebounced:    "Command already ran for the alert within its debounce window",
+		CmdRunCooldown:     "Command finished running for the alert within its cooldown period",
+		CmdRunRateLimited:  "Command already ran as often as max_per_minute or max_per_hour allow",
+		CmdRunDomainLimit:  "Too many executions are running in the alert's failure domain",
+		CmdRunCanary:       "Alert isn't in the command's canary_percent",
+		CmdRunSelfResolved: "Alert resolved within the command's wait_for grace period",
+		CmdRunSuppressed:   "Command succeeded for the alert within its suppress_after_success window",
+		CmdRunVetoed:       "Command was left out by the decision hook",
 	}
 
 	// These labels are meant to be applied to prometheus metrics
@@ -55,6 +96,21 @@ var (
 		CmdRunNoFinger:     "nofinger",
 		CmdRunFingerUnder:  "fingerunder",
 		CmdRunFingerOver:   "fingerover",
+		CmdRunMaintenance:  "maintenance",
+		CmdRunQueueFull:    "queuefull",
+		CmdRunResolved:     "resolved",
+		CmdRunDisabled:     "disabled",
+		CmdRunExpired:      "expired",
+		CmdRunTombstoned:   "tombstoned",
+		CmdRunGuarded:      "guarded",
+		CmdRunDebounced:    "debounced",
+		CmdRunCooldown:     "cooldown",
+		CmdRunRateLimited:  "ratelimited",
+		CmdRunDomainLimit:  "domainlimit",
+		CmdRunCanary:       "canary",
+		CmdRunSelfResolved: "selfresolved",
+		CmdRunSuppressed:   "suppressed",
+		CmdRunVetoed:       "vetoed",
 	}
 
 	procDurationOpts = prometheus.HistogramOpts{
@@ -65,6 +121,35 @@ var (
 		Buckets:   []float64{1, 10, 60, 600, 900, 1800},
 	}
 
+	procExitOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "process",
+		Name:      "exit_total",
+		Help:      "Total number of processes that exited, by exit code; -1 if they were terminated by a signal.",
+	}
+
+	remediationOpts = prometheus.HistogramOpts{
+		Namespace: metricNamespace,
+		Subsystem: "remediation",
+		Name:      "seconds",
+		Help:      "Time from alerts starting to commands run for them completing successfully.",
+		Buckets:   []float64{60, 300, 900, 1800, 3600, 7200, 21600, 86400},
+	}
+
+	lastSuccessOpts = prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: "last_success",
+		Name:      "timestamp_seconds",
+		Help:      "Unix time that commands last exited successfully.",
+	}
+
+	lastFailureOpts = prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: "last_failure",
+		Name:      "timestamp_seconds",
+		Help:      "Unix time that commands last failed.",
+	}
+
 	procCurrentOpts = prometheus.GaugeOpts{
 		Namespace: metricNamespace,
 		Subsystem: "processes",
@@ -93,15 +178,95 @@ var (
 		Help:      "Total number of commands that were skipped instead of run for matching alerts.",
 	}
 
-	errCountLabels  = []string{"stage"}
-	sigCountLabels  = []string{"result"}
-	skipCountLabels = []string{"reason"}
+	retryCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "retries",
+		Name:      "total",
+		Help:      "Total number of times failed commands were retried.",
+	}
+
+	reconcileCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "fingerprint_corrections",
+		Name:      "total",
+		Help:      "Total number of per-fingerprint running command counts that were corrected by reconciliation.",
+	}
+
+	truncatedCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "output_truncated",
+		Name:      "total",
+		Help:      "Total number of executions whose output was truncated for exceeding max_output_bytes.",
+	}
+
+	queueDepthOpts = prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: "queue",
+		Name:      "depth",
+		Help:      "Current number of commands waiting in a queue for a worker.",
+	}
+
+	queueCapacityOpts = prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: "queue",
+		Name:      "capacity",
+		Help:      "Most executions of commands with limited concurrency that can be running or waiting in their queue at once.",
+	}
+
+	queueExpiredOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "queue",
+		Name:      "expired_total",
+		Help:      "Total number of queued executions dropped for waiting longer than max_queue_age.",
+	}
+
+	saturationOpts = prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: "queue",
+		Name:      "saturated_seconds",
+		Help:      "How long the longest-full command queue has had no room for more commands; zero if all queues have room.",
+	}
+
+	queueWaitOpts = prometheus.HistogramOpts{
+		Namespace: metricNamespace,
+		Subsystem: "queue",
+		Name:      "wait_seconds",
+		Help:      "Time commands waited in a queue before a worker started them.",
+		Buckets:   []float64{0.1, 1, 10, 60, 600, 1800},
+	}
+
+	payloadCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook_payloads",
+		Name:      "total",
+		Help:      "Total number of webhook payloads received, by alertmanager payload schema version.",
+	}
+
+	procLabels      = []string{"command"}
+	procExitLabels  = []string{"command", "exit_code"}
+	errCountLabels  = []string{"stage", "command"}
+	sigCountLabels  = []string{"result", "command"}
+	skipCountLabels = []string{"reason", "command"}
+	payloadLabels   = []string{"version"}
+
+	cmdSkipCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "command_skipped",
+		Name:      "total",
+		Help:      "Total number of times commands with notify_on_skip were skipped instead of run for matching alerts.",
+	}
+	cmdSkipCountLabels = []string{"command", "reason"}
 )
 
 type CmdRunReason int
 
 type Server struct {
 	config *Config
+	// Cancelled when the executor shuts down, which running commands' contexts are derived from
+	ctx  context.Context
+	stop context.CancelFunc
+	// Location that times are given to commands in
+	location *time.Location
 	// A mapping of an alarm fingerprint to a channel that can be used to
 	// trigger action on all executing commands matching that fingerprint.
 	// In our case, we want the ability to signal a running process if the matching channel is closed.
@@ -110,21 +275,155 @@ type Server struct {
 	// A mapping of an alarm fingerprint to the number of commands being executed for it.
 	// This is compared to the Command.Max value to determine if a command should execute.
 	fingerCount *countermap.Counter
+	// The number of commands actually running for each alarm fingerprint, which fingerCount is reconciled against.
+	running   map[string]int
+	runningMu sync.Mutex
+	// Track number of fingerprint counts corrected by reconciliation.
+	driftCounter prometheus.Counter
+	// Track number of executions whose output was truncated.
+	truncations prometheus.Counter
+	// Maintenance windows requested at runtime; matching alerts are skipped.
+	maintenance *Maintenance
+	// Executions that are running, which are listed by the /processes endpoint.
+	processes *Processes
+	// The most recent executions, which are listed by the /history endpoint; nil if they aren't kept.
+	history *History
+	// Finished executions persisted across restarts, which the /history endpoint lists instead when it isn't nil.
+	historyDB *HistoryDB
+	// Channels closed to stop running executions on request, keyed by execution ID.
+	killers *chanmap.ChannelMap
+	// Alert fingerprints that resolved recently; late firing notifications for them are skipped.
+	tombstones *Tombstones
+	// When commands with a debounce window last ran for alerts; repeated notifications within the window are skipped.
+	debouncer *Debouncer
+	// When commands with a cooldown last finished running for alerts; executions within the cooldown are skipped,
+	// even if the alert resolved and fired again in the meantime.
+	cooldowns *Debouncer
+	// When commands with suppress_after_success last succeeded for alerts; firing notifications within the window
+	// are skipped.
+	successes *Debouncer
+	// Track the firing notifications skipped because of suppress_after_success
+	suppressions *prometheus.CounterVec
+	// How often commands with max_per_minute or max_per_hour can still run
+	rateLimiter *RateLimiter
+	// How many executions are running in each failure domain, such as a rack, across all commands
+	domains *FailureDomains
+	// Commands disabled at runtime; they're skipped for matching alerts.
+	disabled *DisabledCommands
+	// How each command's latest execution finished, which the /api/commands endpoint lists.
+	lastResults *LastResults
+	// Counts what happens to the alert messages each command is considered for, for the signal-to-noise report.
+	report *Report
+	// Until when destructive commands were approved at runtime; they're skipped for matching alerts otherwise.
+	approvals *Approvals
+	// Executions of commands with approval_required that are waiting to be approved or rejected.
+	pending *PendingApprovals
+	// Script that decides which matching commands run for an alert message, and in what order; nil if there's none
+	decisionHook *DecisionHook
+	// Client addresses that can send webhook requests
+	allowlist *IPAllowlist
+	// How many webhook requests can still be handled under webhook_rate_limit; nil if there's no limit
+	requestLimiter *RequestLimiter
+	// Track the webhook requests that were throttled by webhook_rate_limit
+	throttledCounter prometheus.Counter
+	// Webhook requests that redeliveries are answered with the result of; nil if redeliveries aren't detected
+	redeliveries *Redeliveries
+	// Track the webhook requests that were answered as redeliveries
+	redeliveryCounter prometheus.Counter
+	// Track the webhook requests that were responded to before their commands finished, and those that then failed
+	syncTimeouts prometheus.Counter
+	lateFailures prometheus.Counter
+	// Track alerts dropped from alert messages for repeating the fingerprint of an earlier alert
+	duplicateAlerts prometheus.Counter
+	// Uploads execution records to object storage; nil if they aren't archived
+	archiver *Archiver
+	// Track whether execution records were archived
+	archiveCounter *prometheus.CounterVec
+	// Signs links to admin API actions, and verifies requests made through them; nil if links aren't configured
+	links *LinkSigner
+	// Files in the system's temporary directory that are still in use, which the janitor leaves alone
+	tempFiles *TempFiles
+	// Track the files that the janitor removed, and their size
+	janitorFiles *prometheus.CounterVec
+	janitorBytes *prometheus.CounterVec
+	// How many collect and instrument goroutines are running
+	live *liveCounts
+	// Gauges of goroutines and channels, which the watchdog warns about when they keep growing
+	health []healthGauge
+	// File that runtime state is saved to whenever it changes; it isn't saved if this is empty.
+	stateFile string
+	// Held while the runtime state is saved, so that saves running at the same time can't write an older state last.
+	stateMu sync.Mutex
+	// Attempts at running commands for alerts that haven't resolved, which later runs are told about.
+	attempts *Attempts
+	// Webhook payloads that haven't finished being processed, so they can be replayed after a restart.
+	// Payloads aren't persisted if this is nil.
+	spool payloadSpool
+	// Backend that the history, spool and runtime state are kept in when they aren't configured separately; nil if
+	// storage isn't configured.
+	store Store
+	// The Kubernetes pod that the executor runs in, which is empty when it isn't known.
+	identity Identity
 	// An instance of metrics registry.
 	// We use this instead of the default, because the default only allows one instance of metrics to be registered.
 	registry        *prometheus.Registry
-	processDuration prometheus.Histogram
-	processCurrent  prometheus.Gauge
+	processDuration *prometheus.HistogramVec
+	processCurrent  *prometheus.GaugeVec
 	errCounter      *prometheus.CounterVec
+	// Track number of processes that exited, per command and exit code.
+	exitCounter *prometheus.CounterVec
+	// Track time from alerts starting to their commands succeeding, per command.
+	remediation *prometheus.HistogramVec
+	// Track when each command last succeeded and failed.
+	lastSuccess *prometheus.GaugeVec
+	lastFailure *prometheus.GaugeVec
 	// Track number of active processes signalled due to a 'resolved' message being received from alertmanager.
 	sigCounter *prometheus.CounterVec
 	// Track number of commands skipped instead of run.
 	skipCounter *prometheus.CounterVec
+	// Track number of times commands that notify about skips were skipped, per command.
+	cmdSkipCounter *prometheus.CounterVec
+	// Track number of times failed commands were retried.
+	retryCounter prometheus.Counter
+	// Track number of webhook payloads received per schema version.
+	payloadCounter *prometheus.CounterVec
+	// Queues for commands with limited concurrency, created when first needed.
+	queues     map[*Command]*commandQueue
+	queuesMu   sync.Mutex
+	queueDepth *prometheus.GaugeVec
+	queueWait  prometheus.Histogram
+	saturation prometheus.GaugeFunc
+	// Track number of queued executions dropped for waiting too long, per command.
+	queueExpired *prometheus.CounterVec
+	// Track how many executions each command with limited concurrency can have running or queued.
+	queueCapacity *prometheus.GaugeVec
+	// Track the objectives declared by commands with an slo setting, and how their executions measured up.
+	sloSuccessTarget  *prometheus.GaugeVec
+	sloDurationTarget *prometheus.GaugeVec
+	sloExecutions     *prometheus.CounterVec
+	// Track whether attempts of commands with a timeout exited before their deadline.
+	deadlineCounter *prometheus.CounterVec
+	// Track whether the guards of destructive commands allowed them to run.
+	guardCounter *prometheus.CounterVec
+	// Track the decisions on executions of commands that wait for approval.
+	approvalCounter *prometheus.CounterVec
+	// Metrics declared by commands in the config, keyed by metric name.
+	customCounters map[string]*prometheus.CounterVec
+	customGauges   map[string]*prometheus.GaugeVec
+	// The number of executions started, used to attribute command output to an execution.
+	executions uint64
+	// Records spans of webhook handling and executions; nil if tracing isn't configured.
+	tracer *Tracer
+	// Writes samples of finished executions to a remote-write endpoint; nil if remote-write isn't configured.
+	remoteWriter *RemoteWriter
+	// Template for a line logged about each finished execution; nil if none is configured.
+	summary *texttemplate.Template
 }
 
 // amDataToEnv converts prometheus alert manager template data into key=value strings,
 // which are meant to be set as environment variables of commands called by this program..
-func amDataToEnv(td *template.Data) []string {
+// Times are also given in RFC3339 format, in the given location.
+func amDataToEnv(td *template.Data, loc *time.Location) []string {
 	env := []string{
 		"AMX_RECEIVER=" + td.Receiver,
 		"AMX_STATUS=" + td.Status,
@@ -147,6 +446,8 @@ func amDataToEnv(td *template.Data) []string {
 			key+"_STATUS"+"="+alert.Status,
 			key+"_START"+"="+timeToStr(alert.StartsAt),
 			key+"_END"+"="+timeToStr(alert.EndsAt),
+			key+"_START_RFC3339"+"="+timeToRFC3339(alert.StartsAt, loc),
+			key+"_END_RFC3339"+"="+timeToRFC3339(alert.EndsAt, loc),
 			key+"_URL"+"="+alert.GeneratorURL,
 			key+"_FINGERPRINT"+"="+alert.Fingerprint,
 		)
@@ -174,6 +475,15 @@ func concatErrors(errors ...error) error {
 	return fmt.Errorf(strings.Join(s, "\n"))
 }
 
+// timeToRFC3339 converts the Time struct into an RFC3339 string in the given location.
+// Returns an empty string for the zero time, which alertmanager uses for alerts that haven't ended.
+func timeToRFC3339(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
 // timeToStr converts the Time struct into a string representing its Unix epoch.
 func timeToStr(t time.Time) string {
 	if t.IsZero() {
@@ -185,7 +495,7 @@ func timeToStr(t time.Time) string {
 // handleError responds to an HTTP request with an error message and logs it
 func handleError(w http.ResponseWriter, err error) {
 	http.Error(w, err.Error(), http.StatusInternalServerError)
-	log.Println(err)
+	logger.Error("Failed to handle request", Fields{"error": err})
 }
 
 // handleHealth is meant to respond to health checks for this program
@@ -196,6 +506,25 @@ func handleHealth(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// handleReady responds to readiness checks, failing when a command queue has been full for longer than the saturation threshold,
+// so that load balancers can send alerts to another instance.
+func (s *Server) handleReady(w http.ResponseWriter, req *http.Request) {
+	threshold := time.Duration(s.config.SaturationThreshold)
+	if threshold <= 0 {
+		threshold = time.Duration(defaultSaturationThreshold)
+	}
+
+	if d := s.saturatedFor(); d > threshold {
+		http.Error(w, fmt.Sprintf("Command queues have been full for %s.", d.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+
+	_, err := fmt.Fprint(w, "Ready to execute commands.\n")
+	if err != nil {
+		handleError(w, err)
+	}
+}
+
 // Label returns a prometheus-compatible label for a reason why a command could or couldn't run
 func (r CmdRunReason) Label() string {
 	return CmdRunLabel[r]
@@ -206,26 +535,54 @@ func (r CmdRunReason) String() string {
 	return CmdRunDesc[r]
 }
 
-// amFiring handles a triggered alert message from alertmanager
-func (s *Server) amFiring(amMsg *template.Data) []error {
+// commandsFor returns the commands to run for alert messages with the given status that were received on the given
+// route, including the resolve-time hooks of commands when alerts resolve.
+// Messages received on the default webhook path have an empty route, and only run commands without one.
+func (s *Server) commandsFor(status string, route string) []*Command {
+	var commands []*Command
+	for _, cmd := range s.config.Commands {
+		if s.config.IsFollowUp(cmd) {
+			// This command only runs after another command finishes
+			continue
+		}
+		if cmd.Route != route {
+			continue
+		}
+		if cmd.RunsOn(status) {
+			commands = append(commands, cmd)
+		}
+		if status == "resolved" {
+			if hook, ok := cmd.ResolvedCommand(); ok {
+				commands = append(commands, hook)
+			}
+		}
+	}
+	return commands
+}
+
+// runCommands runs the commands configured for the status of an alert message from alertmanager, and the route it was
+// received on, and waits for them to return. Progress is reported as commands start, finish, are signalled or skipped.
+func (s *Server) runCommands(amMsg *template.Data, route string, progress progressFunc, parent *Span) []error {
 	var wg, collectWg sync.WaitGroup
-	var env = amDataToEnv(amMsg)
+	span := parent.Child("run_commands", Fields{"alert.status": amMsg.Status, "route": route})
 
 	// Execute our commands, and wait for them to return
 	type future struct {
-		cmd *Command
-		out chan CommandResult
+		cmd     *Command
+		version string
+		out     chan CommandResult
+		// When the alert the command is remediating started; zero if it isn't remediating a firing alert
+		startsAt time.Time
+		// Steps that succeeded before the command in its chain of follow-ups, which are rolled back if it fails
+		completed []*Command
+		// Stops counting the execution in its alert's failure domains
+		release func()
 	}
 
 	// Aggregate error messages into a single channel
 	var errors = make(chan error)
 	var allErrors = make([]error, 0)
 	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		collectWg.Wait()
-		close(errors)
-	}()
 	go func() {
 		defer wg.Done()
 		for err := range errors {
@@ -233,53 +590,208 @@ func (s *Server) amFiring(amMsg *template.Data) []error {
 		}
 	}()
 
+	// Commands are started by the loop below, and as follow-ups once other commands finish
+	var start func(cmd *Command, completed []*Command)
+
 	// collect error messages returned by running the command
 	var collect = func(f future) {
 		defer collectWg.Done()
+		defer begin(&s.live.collect)()
 		var resultState Result
+		var exitCode string
 		for result := range f.out {
 			resultState = resultState | result.Kind
+			if code, ok := result.ExitCode(); ok {
+				exitCode = strconv.Itoa(code)
+			}
+			if result.Kind.Has(CmdSigOk) {
+				progress.send(ProgressEvent{Event: ProgressSignalled, Command: f.cmd.String()})
+			}
 			// We don't consider errors from CmdSigOk or CmdSigFail states, as
 			// conditions that should be passed back to the caller.
 			if result.Kind.Has(CmdFail) && result.Err != nil && f.cmd.ShouldNotify() {
 				errors <- result.Err
 			}
 		}
-		if s.config.Verbose {
-			log.Printf("Command: %s, result: %s", f.cmd.String(), resultState)
+		// Follow-ups can run in the same failure domain once this execution is done
+		f.release()
+		s.decision(f.cmd, "Command finished", Fields{"command": f.cmd, "version": f.version, "result": resultState})
+		if resultState.Has(CmdOk) && !f.startsAt.IsZero() {
+			s.remediation.WithLabelValues(f.cmd.MetricLabel()).Observe(time.Since(f.startsAt).Seconds())
+		}
+		if resultState != 0 {
+			progress.send(ProgressEvent{Event: ProgressFinished, Command: f.cmd.String(), Result: resultState.String(), ExitCode: exitCode})
+		}
+		followUps := f.cmd.FollowUps(resultState)
+		if resultState.Has(CmdFail) && !resultState.Signalled() && len(followUps) == 0 {
+			// The chain ends with this failure, so undo the steps that succeeded before it
+			for _, err := range s.rollback(amMsg, f.completed, progress, span) {
+				errors <- err
+			}
+		}
+		completed := f.completed
+		if resultState.Has(CmdOk) {
+			completed = append(completed[:len(completed):len(completed)], f.cmd)
+		}
+		for _, name := range followUps {
+			next, ok := s.config.CommandNamed(name)
+			if !ok {
+				logger.Warn("Not running follow-up, because no command has its name", Fields{"command": f.cmd, "follow_up": name})
+				continue
+			}
+			s.decision(f.cmd, "Running follow-up command", Fields{"command": f.cmd, "follow_up": next, "result": resultState})
+			start(next, completed)
 		}
 	}
 
-	for _, cmd := range s.config.Commands {
+	// Commands the decision hook left out; they're skipped, including as follow-ups of other commands
+	var vetoed map[*Command]bool
+
+	// Errors expanding arguments are collected separately, since they happen before commands are dispatched
+	var expandErrors []error
+	var expandMu sync.Mutex
+	start = func(cmd *Command, completed []*Command) {
+		alert, _ := cmd.Alert(amMsg)
+		fingerprint := alert.Fingerprint
 		ok, reason := s.CanRun(cmd, amMsg)
+		if ok && vetoed[cmd] {
+			ok, reason = false, CmdRunVetoed
+		}
+		if ok && !s.rateLimiter.Allow(cmd, time.Now()) {
+			// Rate limits are checked last, so that executions skipped for other reasons don't use them up
+			ok, reason = false, CmdRunRateLimited
+		} else if ok && (cmd.MaxPerMinute > 0 || cmd.MaxPerHour > 0) {
+			// The execution used up some of the command's rate limits, which a restart shouldn't reset
+			s.saveState()
+		}
+		s.report.Received(cmd.MetricLabel(), ok || reason != CmdRunNoLabelMatch)
 		if !ok {
 			// This is not a command we should run for this alert.
-			if s.config.Verbose {
-				log.Printf("Skipping command due to '%s': %s", reason, cmd)
+			if reason == CmdRunSuppressed {
+				s.suppressions.WithLabelValues(cmd.MetricLabel()).Inc()
 			}
-			s.skipCounter.WithLabelValues(reason.Label()).Inc()
-			continue
+			s.skip(cmd, reason, fingerprint)
+			if reason != CmdRunNoLabelMatch {
+				span.Child("skip", Fields{"command": cmd.String(), "alert.fingerprint": fingerprint, "reason": reason.Label()}).End(nil)
+				progress.send(ProgressEvent{Event: ProgressSkipped, Command: cmd.String(), Reason: reason.Label()})
+			}
+			return
+		}
+		data, err := cmd.TransformData(cmd.FilterData(amMsg), s.location)
+		var args, cmdEnv []string
+		if err == nil {
+			args, err = cmd.ExpandArgs(data, s.location)
+		}
+		if err == nil {
+			cmdEnv, err = cmd.ExpandEnv(data, s.location)
+		}
+		errLabel := ErrLabelTemplate
+		var env []string
+		if err == nil {
+			env, err = s.alertEnv(data)
+			errLabel = ErrLabelEnv
+			// The command's own variables come last, so that they're the ones used if names clash
+			env = append(env, cmdEnv...)
+		}
+		if err != nil {
+			logger.Error("Not executing command", Fields{"command": cmd, "fingerprint": fingerprint, "error": err})
+			s.errCounter.WithLabelValues(errLabel, cmd.MetricLabel()).Inc()
+			if cmd.ShouldNotify() {
+				expandMu.Lock()
+				expandErrors = append(expandErrors, err)
+				expandMu.Unlock()
+			}
+			progress.send(ProgressEvent{Event: ProgressFinished, Command: cmd.String(), Result: CmdFail.String(), Errors: []string{err.Error()}})
+			if errs := s.rollback(amMsg, completed, progress, span); len(errs) > 0 {
+				expandMu.Lock()
+				expandErrors = append(expandErrors, errs...)
+				expandMu.Unlock()
+			}
+			return
+		}
+
+		startsAt := alert.StartsAt
+		firing := &alert
+		if amMsg.Status == "resolved" {
+			// Commands run for a resolved alert have nothing left to be signalled by, so they always run to completion.
+			// They're cleaning up after the alert rather than remediating it, so they aren't timed.
+			fingerprint = ""
+			startsAt = time.Time{}
+			firing = nil
 		}
-		if s.config.Verbose {
-			log.Println("Executing:", cmd)
+		if cmd.RequiresApproval() {
+			// The command runs in the background if it's approved, so it isn't collected with the others
+			s.requestApproval(cmd, fingerprint, firing, args, env)
+			progress.send(ProgressEvent{Event: ProgressPending, Command: cmd.String()})
+			return
 		}
 
-		fingerprint, _ := cmd.Fingerprint(amMsg)
+		release := func() {}
+		if firing != nil {
+			// Only executions remediating firing alerts count towards failure domain limits
+			var ok bool
+			if release, ok = s.domains.Acquire(alert.Labels); !ok {
+				s.removePayloadFile(env)
+				s.skip(cmd, CmdRunDomainLimit, fingerprint)
+				progress.send(ProgressEvent{Event: ProgressSkipped, Command: cmd.String(), Reason: CmdRunDomainLimit.Label()})
+				return
+			}
+		}
+
+		version := cmd.Version()
+		s.decision(cmd, "Executing command", Fields{"command": cmd, "fingerprint": fingerprint, "version": version})
 		out := make(chan CommandResult)
+		if s.dispatch(fingerprint, firing, cmd, args, env, out, span) {
+			if fingerprint != "" && cmd.Debounce > 0 {
+				s.debouncer.Record(cmd.MetricLabel(), fingerprint, time.Duration(cmd.Debounce))
+			}
+			progress.send(ProgressEvent{Event: ProgressStarted, Command: cmd.String()})
+		} else {
+			s.skip(cmd, CmdRunQueueFull, fingerprint)
+			progress.send(ProgressEvent{Event: ProgressSkipped, Command: cmd.String(), Reason: CmdRunQueueFull.Label()})
+		}
+		// Results are collected after the started event is sent, so that it can't be preceded by the finished event.
+		// Commands wait to send their results until they're collected.
+		// Follow-ups are started while their predecessor's collection is still counted, so the count can't reach zero early.
 		collectWg.Add(1)
-		go collect(future{cmd: cmd, out: out})
-		// s.instrument() runs the command and updates related metrics
-		go s.instrument(fingerprint, cmd, env, out)
+		go collect(future{cmd: cmd, version: version, out: out, startsAt: startsAt, completed: completed, release: release})
 	}
 
+	commands := s.commandsFor(amMsg.Status, route)
+	if s.decisionHook != nil {
+		commands, vetoed = s.decide(amMsg, commands)
+	}
+	for _, cmd := range commands {
+		start(cmd, nil)
+	}
+
+	// Stop aggregating errors once all results are collected.
+	// This starts after all commands are dispatched, so that it can't finish before collection starts.
+	go func() {
+		defer wg.Done()
+		collectWg.Wait()
+		close(errors)
+	}()
+
 	// Wait for instrumentation, error collection to finish
 	wg.Wait()
 
-	return allErrors
+	errs := append(expandErrors, allErrors...)
+	if len(errs) > 0 {
+		span.End(concatErrors(errs...))
+	} else {
+		span.End(nil)
+	}
+	return errs
 }
 
 // amResolved handles a resolved alert message from alertmanager
 func (s *Server) amResolved(amMsg *template.Data) {
+	if s.config.Faults.DropResolveSignals {
+		logger.Debug("Injecting fault: dropping signals for resolved alert", nil)
+		return
+	}
+
 	for _, cmd := range s.config.Commands {
 		fingerprint, ok := cmd.Fingerprint(amMsg)
 		if !ok || fingerprint == "" {
@@ -288,124 +800,525 @@ func (s *Server) amResolved(amMsg *template.Data) {
 		}
 
 		s.tellFingers.Close(fingerprint)
+		s.attempts.Forget(cmd.MetricLabel(), fingerprint)
+		s.debouncer.Forget(cmd.MetricLabel(), fingerprint)
+		if n := s.pending.Resolve(fingerprint); n > 0 {
+			logger.Decision("Dropped executions waiting for approval, because their alert resolved", Fields{"command": cmd, "fingerprint": fingerprint, "count": n})
+		}
+	}
+
+	// Remember the resolved alerts for a while, in case firing notifications for them arrive late
+	if ttl := time.Duration(s.config.ResolveTombstone); ttl > 0 {
+		for _, alert := range amMsg.Alerts {
+			if alert.Fingerprint != "" && alert.Status == "resolved" {
+				s.tombstones.Add(alert.Fingerprint, alert.EndsAt, ttl)
+			}
+		}
 	}
 }
 
 // handleWebhook is meant to respond to webhook requests from prometheus alertmanager.
 // It unpacks the alert, and dispatches it to the matching programs through environment variables.
+// Requests to a path that commands declare as their route only run those commands; others run commands without a route.
 //
 // If a command fails, an HTTP 500 response is returned to alertmanager.
 // Note that alertmanager may treat non HTTP 200 responses as 'failure to notify', and may re-dispatch the alert to us.
 func (s *Server) handleWebhook(w http.ResponseWriter, req *http.Request) {
-	if s.config.Verbose {
-		log.Println("Webhook triggered from remote address:port", req.RemoteAddr)
+	route := s.config.RouteOf(req.URL.Path)
+	logger.Debug("Webhook triggered", Fields{"remote_addr": req.RemoteAddr, "route": route})
+	if !s.allowlist.Allows(req) {
+		logger.Decision("Rejected request from address that isn't allowed", Fields{"remote_addr": req.RemoteAddr, "forwarded_for": req.Header.Get("X-Forwarded-For")})
+		s.errCounter.WithLabelValues(ErrLabelForbidden, CmdLabelNone).Inc()
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if ok, wait := s.requestLimiter.Allow(time.Now()); !ok {
+		// Alertmanager sends notifications for alerts that are still firing again, so throttled alerts aren't lost
+		logger.Decision("Throttled request that exceeded webhook_rate_limit", Fields{"remote_addr": req.RemoteAddr, "retry_after": wait})
+		s.throttledCounter.Inc()
+		w.Header().Set("Retry-After", retryAfter(wait))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
 	}
+	// Callers that are themselves traced can have their trace continue through here
+	span := s.tracer.StartRemote("webhook", req.Header.Get("traceparent"), Fields{"http.client_ip": req.RemoteAddr})
+	var spanErr error
+	// Set once handling the alert message is left to the background, which then ends the span and finishes the payload
+	var late bool
+	defer func() {
+		if !late {
+			span.End(spanErr)
+		}
+	}()
+
 	data, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		handleError(w, err)
-		s.errCounter.WithLabelValues(ErrLabelRead).Inc()
+		s.errCounter.WithLabelValues(ErrLabelRead, CmdLabelNone).Inc()
+		spanErr = err
 		return
 	}
 
-	if s.config.Verbose {
-		log.Println("Body:", string(data))
+	var spoolID string
+	if s.spool != nil {
+		// Keep the payload on disk until we're done with it, so that it can be replayed if we're interrupted
+		id, err := s.spool.AddRoute(route, data)
+		if err != nil {
+			handleError(w, err)
+			s.errCounter.WithLabelValues(ErrLabelSpool, CmdLabelNone).Inc()
+			spanErr = err
+			return
+		}
+		spoolID = id
+		defer func() {
+			if !late {
+				s.finishSpooled(id)
+			}
+		}()
 	}
-	var amMsg = &template.Data{}
-	if err := json.Unmarshal(data, amMsg); err != nil {
+
+	logger.Debug("Webhook body", Fields{"body": string(data)})
+	amMsg, err := s.decodePayload(data)
+	if err != nil {
 		handleError(w, err)
-		s.errCounter.WithLabelValues(ErrLabelUnmarshall).Inc()
+		s.errCounter.WithLabelValues(ErrLabelUnmarshall, CmdLabelNone).Inc()
+		spanErr = err
+		return
+	}
+	logger.Debug("Webhook alert message", Fields{"message": fmt.Sprintf("%#v", amMsg)})
+	span.SetAttr("alert.status", amMsg.Status)
+	span.SetAttr("alert.receiver", amMsg.Receiver)
+	span.SetAttr("alert.count", len(amMsg.Alerts))
+
+	// Requests that alertmanager sent again get the result of the first, rather than running commands twice.
+	// Streamed progress can't be replayed, so those requests are always handled.
+	if key := s.redeliveries.Key(req, data, amMsg); key != "" && !wantsProgress(req) {
+		d, original := s.redeliveries.Begin(key, time.Now())
+		if !original {
+			s.replayDelivery(w, req, d)
+			return
+		}
+		rw := newRecordingWriter(w)
+		defer s.redeliveries.Finish(d, rw)
+		w = rw
+	}
+
+	// Callers that accept newline-delimited JSON get progress as it happens, instead of waiting for all commands
+	var progress progressFunc
+	var pw *progressWriter
+	var outcomes *outcomeCollector
+	if wantsProgress(req) {
+		pw = newProgressWriter(w)
+		progress = pw.write
+	} else if s.config.DetailedResponse {
+		outcomes = &outcomeCollector{}
+		progress = outcomes.record
+	}
+
+	var errors []error
+	if timeout := time.Duration(s.config.SyncTimeout); timeout > 0 && pw == nil {
+		// Alertmanager gives up on slow requests, and retries them, so slow commands are left to finish in the background
+		errors, late = s.handleMessageWithin(timeout, amMsg, route, progress, span, func(errors []error) {
+			if len(errors) > 0 {
+				span.End(concatErrors(errors...))
+			} else {
+				span.End(nil)
+			}
+			if spoolID != "" {
+				s.finishSpooled(spoolID)
+			}
+		})
+	} else {
+		errors = s.handleMessage(amMsg, route, progress, span)
+	}
+	if late {
+		if outcomes != nil {
+			writeWebhookResponse(w, outcomes.running())
+		} else {
+			writeAccepted(w)
+		}
+		return
+	}
+	if len(errors) > 0 {
+		spanErr = concatErrors(errors...)
+	}
+	if pw != nil {
+		pw.done(errors)
 		return
 	}
-	if s.config.Verbose {
-		log.Printf("Got: %#v", amMsg)
+	if outcomes != nil {
+		writeWebhookResponse(w, outcomes.response(errors))
+		return
 	}
+	if len(errors) > 0 {
+		handleError(w, concatErrors(errors...))
+	}
+}
 
+// handleMessage dispatches an alert message from alertmanager based on its status,
+// returning any errors that should be reported back to alertmanager.
+// Only commands with the route the message was received on are run; the route is empty for the default webhook path.
+// Progress is reported to the progress function, which may be nil.
+// Spans of the commands run are recorded as children of the given span, which may also be nil.
+func (s *Server) handleMessage(amMsg *template.Data, route string, progress progressFunc, span *Span) []error {
 	var errors []error
+	// Scripts shouldn't act twice on the same alert
+	amMsg, dropped := dedupeAlerts(amMsg)
+	if dropped > 0 {
+		s.duplicateAlerts.Add(float64(dropped))
+		logger.Decision("Dropped alerts with the same fingerprint as an earlier alert in the message", Fields{"dropped": dropped})
+	}
 	switch amMsg.Status {
 	case "firing":
-		errors = s.amFiring(amMsg)
+		errors = s.runCommands(amMsg, route, progress, span)
 	case "resolved":
 		// When an alert is resolved, we will attempt to signal any active commands
 		// that were dispatched on behalf of it, by matching commands against fingerprints
 		// used to run them.
 		s.amResolved(amMsg)
+		// Then run any commands meant to clean up after a resolved alert
+		errors = s.runCommands(amMsg, route, progress, span)
 	default:
 		errors = append(errors, fmt.Errorf("Unknown alertmanager message status: %s", amMsg.Status))
 	}
 
-	if len(errors) > 0 {
-		handleError(w, concatErrors(errors...))
-	}
+	return errors
 }
 
-// initMetrics initializes prometheus metrics
+// initMetrics initializes prometheus metrics, so that series for each configured command are exported
+// before anything happens to them.
 func (s *Server) initMetrics() error {
-	var pd, pc pm.Metric
-	err := s.processDuration.Write(&pd)
-	if err != nil {
-		return err
+	_ = s.errCounter.WithLabelValues(ErrLabelRead, CmdLabelNone)
+	_ = s.errCounter.WithLabelValues(ErrLabelUnmarshall, CmdLabelNone)
+	_ = s.errCounter.WithLabelValues(ErrLabelSpool, CmdLabelNone)
+	_ = s.errCounter.WithLabelValues(ErrLabelAuth, CmdLabelNone)
+	_ = s.errCounter.WithLabelValues(ErrLabelState, CmdLabelNone)
+	_ = s.errCounter.WithLabelValues(ErrLabelForbidden, CmdLabelNone)
+	if s.historyDB != nil {
+		_ = s.errCounter.WithLabelValues(ErrLabelHistory, CmdLabelNone)
 	}
-
-	err = s.processCurrent.Write(&pc)
-	if err != nil {
-		return err
+	if len(s.config.labelSDMatchers()) > 0 {
+		_ = s.errCounter.WithLabelValues(ErrLabelSD, CmdLabelNone)
+	}
+	if s.decisionHook != nil {
+		_ = s.errCounter.WithLabelValues(ErrLabelHook, CmdLabelNone)
 	}
+	_ = s.payloadCounter.WithLabelValues(strconv.Itoa(payloadVersionCurrent))
+	_ = s.payloadCounter.WithLabelValues(PayloadLabelNone)
+	_ = s.payloadCounter.WithLabelValues(PayloadLabelAPIv2)
 
-	_ = s.errCounter.WithLabelValues(ErrLabelRead)
-	_ = s.errCounter.WithLabelValues(ErrLabelUnmarshall)
-	_ = s.errCounter.WithLabelValues(ErrLabelStart)
-	_ = s.sigCounter.WithLabelValues(ErrLabelStart)
-	_ = s.sigCounter.WithLabelValues(SigLabelOk)
-	_ = s.sigCounter.WithLabelValues(SigLabelFail)
-	_ = s.skipCounter.WithLabelValues(CmdRunNoLabelMatch.Label())
-	_ = s.skipCounter.WithLabelValues(CmdRunFingerOver.Label())
+	for _, cmd := range s.config.AllCommands() {
+		label := cmd.MetricLabel()
+		_ = s.processDuration.WithLabelValues(label)
+		_ = s.processCurrent.WithLabelValues(label)
+		_ = s.exitCounter.WithLabelValues(label, "0")
+		_ = s.remediation.WithLabelValues(label)
+		_ = s.errCounter.WithLabelValues(ErrLabelStart, label)
+		_ = s.errCounter.WithLabelValues(ErrLabelTemplate, label)
+		if s.config.MaxEnvSize > 0 {
+			_ = s.errCounter.WithLabelValues(ErrLabelEnv, label)
+		}
+		_ = s.sigCounter.WithLabelValues(ErrLabelStart, label)
+		_ = s.sigCounter.WithLabelValues(SigLabelOk, label)
+		_ = s.sigCounter.WithLabelValues(SigLabelFail, label)
+		_ = s.sigCounter.WithLabelValues(SigLabelKill, label)
+		_ = s.skipCounter.WithLabelValues(CmdRunNoLabelMatch.Label(), label)
+		_ = s.skipCounter.WithLabelValues(CmdRunFingerOver.Label(), label)
+		_ = s.skipCounter.WithLabelValues(CmdRunMaintenance.Label(), label)
+		_ = s.skipCounter.WithLabelValues(CmdRunQueueFull.Label(), label)
+		_ = s.skipCounter.WithLabelValues(CmdRunDisabled.Label(), label)
+		if s.config.ResolveTombstone > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunTombstoned.Label(), label)
+		}
+		if cmd.Concurrency > 0 {
+			_ = s.queueDepth.WithLabelValues(label)
+			s.queueCapacity.WithLabelValues(label).Set(float64(cmd.QueueCapacity()))
+		}
+		if cmd.MaxQueueAge > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunExpired.Label(), label)
+			_ = s.queueExpired.WithLabelValues(label)
+		}
+		if cmd.Debounce > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunDebounced.Label(), label)
+		}
+		if cmd.Cooldown > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunCooldown.Label(), label)
+		}
+		if cmd.SuppressAfterSuccess > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunSuppressed.Label(), label)
+			_ = s.suppressions.WithLabelValues(label)
+		}
+		if cmd.MaxPerMinute > 0 || cmd.MaxPerHour > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunRateLimited.Label(), label)
+		}
+		if s.decisionHook != nil {
+			_ = s.skipCounter.WithLabelValues(CmdRunVetoed.Label(), label)
+		}
+		if len(s.config.FailureDomains) > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunDomainLimit.Label(), label)
+		}
+		if cmd.CanaryPercent > 0 && cmd.CanaryPercent < 100 {
+			_ = s.skipCounter.WithLabelValues(CmdRunCanary.Label(), label)
+		}
+		if cmd.WaitFor > 0 {
+			_ = s.skipCounter.WithLabelValues(CmdRunSelfResolved.Label(), label)
+		}
+		if s.archiver != nil {
+			_ = s.archiveCounter.WithLabelValues(label, ArchiveLabelOk)
+			_ = s.archiveCounter.WithLabelValues(label, ArchiveLabelFail)
+		}
+		if cmd.RequiresApproval() {
+			for _, decision := range []string{ApprovalLabelApproved, ApprovalLabelRejected, ApprovalLabelExpired, ApprovalLabelResolved} {
+				_ = s.approvalCounter.WithLabelValues(label, decision)
+			}
+		}
+		if cmd.IsDestructive() {
+			_ = s.skipCounter.WithLabelValues(CmdRunGuarded.Label(), label)
+			_ = s.guardCounter.WithLabelValues(label, GuardLabelAllowed)
+			_ = s.guardCounter.WithLabelValues(label, GuardLabelBlocked)
+		}
+	}
+	s.initSLOMetrics()
+	s.initDeadlineMetrics()
 
 	return nil
 }
 
+// collectMetrics returns the current value of each series of a collector
+func collectMetrics(c prometheus.Collector) []*pm.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var metrics []*pm.Metric
+	for m := range ch {
+		var pb pm.Metric
+		if err := m.Write(&pb); err == nil {
+			metrics = append(metrics, &pb)
+		}
+	}
+	return metrics
+}
+
+// runningCount returns the number of commands running, across all commands
+func (s *Server) runningCount() int {
+	var total float64
+	for _, m := range collectMetrics(s.processCurrent) {
+		total += m.GetGauge().GetValue()
+	}
+	return int(total)
+}
+
 // instrument a command.
 // It is meant to be called as a goroutine with context provided by handleWebhook.
+// The alert is the firing alert the command is remediating, if any, which its annotations are made for.
 //
 // The prometheus structs use sync/atomic in methods like Dec and Observe,
 // so they're safe to call concurrently from goroutines.
-func (s *Server) instrument(fingerprint string, cmd *Command, env []string, out chan<- CommandResult) {
-	s.processCurrent.Inc()
-	defer s.processCurrent.Dec()
+func (s *Server) instrument(fingerprint string, alert *template.Alert, cmd *Command, args []string, env []string, out chan<- CommandResult, parent *Span) {
+	// The caller's results are only closed once we're done here, so that follow-up commands it starts
+	// see this execution's fingerprint count released.
+	finished := make(chan struct{})
+	defer close(finished)
+	defer begin(&s.live.instrument)()
+	// A file that the alert message was written to isn't needed once the command exits
+	defer s.removePayloadFile(env)
+	label := cmd.MetricLabel()
+	// The version is taken before the command runs, in case its file is edited while it does
+	version := cmd.Version()
+	span := parent.Child("execute", Fields{"command": cmd.String(), "alert.fingerprint": fingerprint})
+	if span != nil {
+		// Commands can add their own spans to the trace
+		env = append(env[:len(env):len(env)], "TRACEPARENT="+span.TraceParent())
+	}
+	// Commands can act on the executor's own pod or node
+	env = append(env[:len(env):len(env)], s.identity.Env()...)
+	s.processCurrent.WithLabelValues(label).Inc()
+	defer s.processCurrent.WithLabelValues(label).Dec()
 	var quit chan struct{}
 	if len(fingerprint) > 0 {
 		// The goroutine running the command will listen to this channel
 		// to determine if it should exit early.
 		quit = s.tellFingers.Add(fingerprint)
 		// This value is used to determine if new commands matching this fingerprint should start.
-		s.fingerCount.Inc(fingerprint)
-		defer s.fingerCount.Dec(fingerprint)
-	} else if s.config.Verbose {
-		log.Println("Command has no fingerprint, so it won't quit early if alert is resolved first:", cmd)
+		s.startExecution(fingerprint)
+		defer s.finishExecution(fingerprint)
+	} else {
+		s.debug(cmd, "Command has no fingerprint, so it won't quit early if alert is resolved first", Fields{"command": cmd})
 	}
 
 	done := make(chan struct{})
 	cmdOut := make(chan CommandResult)
+	var elapsed time.Duration
+	var output *lineLogger
+	var proc Process
 	// Intercept responses from commands, so that we can update metrics we're interested in
 	go func() {
 		defer close(out)
+		var result Result
+		var runErr error
+		var attempt Attempt
+		var exitCode string
 		for r := range cmdOut {
+			result = result | r.Kind
+			if r.Attempt > attempt.Number {
+				attempt = Attempt{Number: r.Attempt}
+			}
+			if r.Kind.Has(CmdOk | CmdFail | CmdRetry) {
+				// Retried attempts failed, even though their result isn't reported as such
+				attempt.Result = r.Kind & CmdOk
+				if attempt.Result == 0 {
+					attempt.Result = CmdFail
+				}
+			}
+			if r.Kind.Has(CmdFail) {
+				runErr = r.Err
+			}
+			if code, ok := r.ExitCode(); ok {
+				exitCode = strconv.Itoa(code)
+				s.exitCounter.WithLabelValues(label, exitCode).Inc()
+				span.SetAttr("process.exit_code", code)
+			}
+			if r.Kind.Has(CmdOk) {
+				s.lastSuccess.WithLabelValues(label).SetToCurrentTime()
+			}
+			if r.Kind.Has(CmdFail) {
+				s.lastFailure.WithLabelValues(label).SetToCurrentTime()
+			}
 			if r.Kind.Has(CmdFail) && r.Err != nil && cmd.ShouldNotify() {
-				s.errCounter.WithLabelValues(ErrLabelStart).Inc()
+				s.errCounter.WithLabelValues(ErrLabelStart, label).Inc()
 			}
 			if r.Kind.Has(CmdSigOk) {
-				s.sigCounter.WithLabelValues(SigLabelOk).Inc()
+				s.sigCounter.WithLabelValues(SigLabelOk, label).Inc()
+				s.decision(cmd, "Signalled command, because its alert resolved", Fields{"command": cmd, "fingerprint": fingerprint, "signal": cmd.ResolvedSig})
 			}
 			if r.Kind.Has(CmdSigFail) {
-				s.sigCounter.WithLabelValues(SigLabelFail).Inc()
+				s.sigCounter.WithLabelValues(SigLabelFail, label).Inc()
+				s.decision(cmd, "Failed to signal command", Fields{"command": cmd, "fingerprint": fingerprint, "error": r.Err})
+			}
+			if r.Kind.Has(CmdKill) {
+				s.sigCounter.WithLabelValues(SigLabelKill, label).Inc()
+				s.decision(cmd, "Killed command, which was still running after being signalled", Fields{"command": cmd, "fingerprint": fingerprint, "kill_after": cmd.KillAfter})
+			}
+			if r.TimedOut {
+				s.decision(cmd, "Killed command, which was still running at its deadline", Fields{"command": cmd, "fingerprint": fingerprint, "timeout": cmd.Timeout})
+			}
+			s.observeDeadline(cmd, r)
+			if r.Kind.Has(CmdRetry) {
+				s.retryCounter.Inc()
+				s.decision(cmd, "Retrying command after failure", Fields{"command": cmd, "fingerprint": fingerprint, "error": r.Err})
 			}
 			out <- r
 		}
+		if len(fingerprint) > 0 && attempt.Number > 0 {
+			s.attempts.Record(label, fingerprint, attempt)
+		}
+		if len(fingerprint) > 0 && cmd.Cooldown > 0 {
+			// Unlike debounce, the cooldown starts once the execution finished, and isn't reset when the alert resolves
+			s.cooldowns.Record(label, fingerprint, time.Duration(cmd.Cooldown))
+			s.saveState()
+		}
+		s.recordSuccess(cmd, fingerprint, result)
+		span.SetAttr("result", result)
+		span.End(runErr)
+		<-finished
+		// The execution's duration is only known once instrumentation is finished
+		s.remoteWriter.Record(label, result, exitCode, elapsed)
+		s.observeSLO(cmd, result, elapsed)
+		summary := ExecutionSummary{Command: cmd.String(), Fingerprint: fingerprint, Result: result.String(), Duration: elapsed, ExitCode: exitCode}
+		s.logSummary(summary)
+		tail := output.Tail()
+		entry := HistoryEntry{
+			Execution:   proc.Execution,
+			Command:     summary.Command,
+			Version:     version,
+			Fingerprint: fingerprint,
+			Started:     proc.Started,
+			Finished:    proc.Started.Add(elapsed),
+			Result:      summary.Result,
+			ExitCode:    exitCode,
+			Output:      lastLines(tail, historyOutputLines),
+		}
+		s.history.Add(entry)
+		s.persistHistory(entry)
+		s.lastResults.Set(cmd, LastResult{Execution: entry.Execution, Finished: entry.Finished, Result: entry.Result, ExitCode: exitCode})
+		s.report.Executed(label, result)
+		if s.archiver != nil {
+			go s.archive(cmd, ArchiveRecord{HistoryEntry: entry, Name: label, Labels: proc.Labels, Output: lastLines(tail, s.archiver.config.Lines())})
+		}
+		if cmd.Annotate != nil && alert != nil {
+			summary.Output = lastLines(tail, cmd.Annotate.Lines())
+			go s.annotate(cmd.Annotate, *alert, summary)
+		}
 	}()
 
+	// Give the command somewhere to write updates to its custom metrics
+	mf, mfEnv, err := s.metricsFile(cmd)
+	if err != nil {
+		logger.Error("Failed to create metrics file for command", Fields{"command": cmd, "error": err})
+	} else if mf != nil {
+		env = append(env[:len(env):len(env)], mfEnv)
+		defer func() {
+			_ = mf.Close()
+			s.tempFiles.Remove(mf.Name())
+		}()
+	}
+
+	s.delayStart(cmd)
 	start := time.Now()
-	cmd.Run(cmdOut, quit, done, env...)
+	run := *cmd
+	run.Args = args
+	if sig, ok := envValue(env, resolvedSignalEnv); ok {
+		// The command's resolved_signal is a template, which was expanded with its arguments
+		run.ResolvedSig = sig
+	}
+	if len(fingerprint) > 0 {
+		run.previous = s.attempts.Last(label, fingerprint)
+	}
+	run = run.WithInterpreter(s.config.Interpreters)
+	fields := s.outputFields(cmd, fingerprint)
+	output = newLineLogger(logger, fields, cmd.MaxOutputBytes)
+	tail := 0
+	if s.history != nil {
+		tail = historyOutputLines
+	}
+	if cmd.Annotate != nil && cmd.Annotate.Lines() > tail {
+		tail = cmd.Annotate.Lines()
+	}
+	if s.archiver != nil && s.archiver.config.Lines() > tail {
+		tail = s.archiver.config.Lines()
+	}
+	output.KeepTail(tail)
+	// Track the execution while it's running, so that it can be listed
+	execution, _ := fields["execution"].(uint64)
+	ctx, cancel := s.executionContext(execution, cmd, quit)
+	defer cancel()
+	proc = Process{Execution: execution, Command: cmd.String(), Fingerprint: fingerprint, Started: start}
+	if alert != nil {
+		proc.Labels = alert.Labels
+	}
+	s.processes.Add(proc)
+	run.started = func(pid int) {
+		s.processes.SetPID(execution, pid)
+	}
+	run.Run(ctx, cmdOut, done, output, env...)
 	<-done
-	s.processDuration.Observe(time.Since(start).Seconds())
+	s.processes.Remove(execution)
+	s.killers.Close(executionKey(execution))
+	output.Flush()
+	if output.Truncated() {
+		s.truncations.Inc()
+	}
+	elapsed = time.Since(start)
+	s.processDuration.WithLabelValues(label).Observe(elapsed.Seconds())
+	fields["duration"] = elapsed.Seconds()
+	s.debug(cmd, "Command exited", fields)
+
+	if mf != nil {
+		s.recordCustomMetrics(cmd, mf)
+	}
 }
 
 // CanRun returns true if the Command is allowed to run based on its fingerprint and settings
@@ -414,6 +1327,46 @@ func (s *Server) CanRun(cmd *Command, amMsg *template.Data) (bool, CmdRunReason)
 		return false, CmdRunNoLabelMatch
 	}
 
+	if cmd.Name != "" && s.disabled.Disabled(cmd.Name) {
+		return false, CmdRunDisabled
+	}
+
+	if s.maintenance.Matches(amMsg) {
+		return false, CmdRunMaintenance
+	}
+
+	if amMsg.Status == "firing" {
+		if alert, ok := cmd.Alert(amMsg); ok && alert.Fingerprint != "" && s.tombstones.Suppressed(alert.Fingerprint, alert.StartsAt) {
+			return false, CmdRunTombstoned
+		}
+	}
+
+	if !cmd.InCanary(amMsg) {
+		return false, CmdRunCanary
+	}
+
+	if amMsg.Status == "firing" && cmd.Debounce > 0 {
+		if fingerprint, ok := cmd.Fingerprint(amMsg); ok && fingerprint != "" && s.debouncer.Debounced(cmd.MetricLabel(), fingerprint) {
+			return false, CmdRunDebounced
+		}
+	}
+
+	if amMsg.Status == "firing" && cmd.Cooldown > 0 {
+		if fingerprint, ok := cmd.Fingerprint(amMsg); ok && fingerprint != "" && s.cooldowns.Debounced(cmd.MetricLabel(), fingerprint) {
+			return false, CmdRunCooldown
+		}
+	}
+
+	if amMsg.Status == "firing" && cmd.SuppressAfterSuccess > 0 {
+		if fingerprint, ok := cmd.Fingerprint(amMsg); ok && fingerprint != "" && s.successes.Debounced(cmd.MetricLabel(), fingerprint) {
+			return false, CmdRunSuppressed
+		}
+	}
+
+	if cmd.IsDestructive() && !s.guardAllows(cmd, amMsg) {
+		return false, CmdRunGuarded
+	}
+
 	if cmd.Max <= 0 {
 		return true, CmdRunNoMax
 	}
@@ -434,32 +1387,162 @@ func (s *Server) CanRun(cmd *Command, amMsg *template.Data) (bool, CmdRunReason)
 // Start runs a golang http server with the given routes.
 // Returns
 // * a reference to the HTTP server (so that we can gracefully shut it down)
-// a channel that will contain the error result of the ListenAndServe call
+// a channel that will contain the error result of listening and serving
 func (s *Server) Start() (*http.Server, chan error) {
 	s.registry.MustRegister(s.processDuration)
 	s.registry.MustRegister(s.processCurrent)
+	s.registry.MustRegister(s.exitCounter)
+	s.registry.MustRegister(s.remediation)
+	s.registry.MustRegister(s.lastSuccess)
+	s.registry.MustRegister(s.lastFailure)
 	s.registry.MustRegister(s.errCounter)
 	s.registry.MustRegister(s.sigCounter)
 	s.registry.MustRegister(s.skipCounter)
+	s.registry.MustRegister(s.cmdSkipCounter)
+	s.registry.MustRegister(s.retryCounter)
+	s.registry.MustRegister(s.throttledCounter)
+	s.registry.MustRegister(s.redeliveryCounter)
+	s.registry.MustRegister(s.syncTimeouts)
+	s.registry.MustRegister(s.lateFailures)
+	s.registry.MustRegister(s.duplicateAlerts)
+	s.registry.MustRegister(s.archiveCounter)
+	s.registry.MustRegister(s.janitorFiles)
+	s.registry.MustRegister(s.janitorBytes)
+	s.registry.MustRegister(s.payloadCounter)
+	s.registry.MustRegister(s.driftCounter)
+	s.registry.MustRegister(s.truncations)
+	s.registry.MustRegister(s.queueDepth)
+	s.registry.MustRegister(s.queueCapacity)
+	s.registry.MustRegister(s.queueWait)
+	s.registry.MustRegister(s.queueExpired)
+	s.registry.MustRegister(s.saturation)
+	s.registry.MustRegister(s.sloSuccessTarget)
+	s.registry.MustRegister(s.sloDurationTarget)
+	s.registry.MustRegister(s.sloExecutions)
+	s.registry.MustRegister(s.deadlineCounter)
+	s.registry.MustRegister(s.suppressions)
+	s.registry.MustRegister(s.guardCounter)
+	s.registry.MustRegister(s.approvalCounter)
+	s.registerHealthGauges()
+	s.registerIdentity()
+
+	err := s.registerCustomMetrics()
+	if err != nil {
+		panic(err)
+	}
 
 	// Initialize metrics
-	err := s.initMetrics()
+	err = s.initMetrics()
 	if err != nil {
 		panic(err)
 	}
 
+	auth, err := NewAuthenticator(s.config.Auth)
+	if err != nil {
+		panic(err)
+	}
+
+	if s.config.Faults.Enabled() {
+		logger.Warn("Injecting faults, which shouldn't be done in production", Fields{"faults": fmt.Sprintf("%+v", s.config.Faults)})
+	}
+
+	// Replay payloads left over from a previous run.
+	// They're collected before we start listening, so that they can't be confused with new payloads.
+	go s.replay(s.pendingSpooled())
+
+	// Repair fingerprint counts that drift from the commands actually running, so that Command.Max keeps being enforced correctly
+	interval := time.Duration(s.config.ReconcileInterval)
+	if interval <= 0 {
+		interval = time.Duration(defaultReconcileInterval)
+	}
+	go s.reconcileEvery(interval)
+
+	// Warn about goroutines and channels that keep piling up, which leaks look like
+	go s.watchEvery(watchdogInterval)
+
+	// Export spans to the collector as they finish
+	if s.tracer != nil {
+		go s.tracer.exportEvery(traceExportInterval)
+	}
+
+	// Write samples of finished executions for environments that collect metrics through remote-write
+	if s.remoteWriter != nil {
+		go s.remoteWriter.writeEvery(remoteWriteInterval)
+	}
+
+	// Let a central registry know we're here, so that fleets of executors can be inventoried
+	if s.config.Registry.URL != "" {
+		interval := time.Duration(s.config.Registry.Interval)
+		if interval <= 0 {
+			interval = time.Duration(defaultHeartbeatInterval)
+		}
+		go s.heartbeatEvery(interval)
+	}
+
+	// Push metrics for executors that prometheus can't scrape
+	if s.config.Pushgateway.URL != "" {
+		interval := time.Duration(s.config.Pushgateway.Interval)
+		if interval <= 0 {
+			interval = time.Duration(defaultPushInterval)
+		}
+		go s.pushEvery(interval)
+	}
+
+	// Forget executions once they're older than the history's retention period
+	if s.historyDB != nil {
+		go s.pruneHistoryEvery(historyPruneInterval)
+	}
+
+	// Remove old files from the directories we write to, so that they don't fill the disk
+	if s.config.Janitor.Enabled() {
+		interval := time.Duration(s.config.Janitor.Interval)
+		if interval <= 0 {
+			interval = time.Duration(defaultJanitorInterval)
+		}
+		go s.cleanUpEvery(interval)
+	}
+
+	// Pick up changes to the targets that commands match labels against
+	for _, m := range s.config.labelSDMatchers() {
+		go s.refreshLabelSD(m)
+	}
+
+	// Run housekeeping commands, independently of alerts
+	for _, sched := range s.config.Schedules {
+		go s.scheduleEvery(sched)
+	}
+
 	// We use our own instance of ServeMux instead of DefaultServeMux,
 	// to keep handler registration separate between server instances.
 	mux := http.NewServeMux()
-	srv := &http.Server{Addr: s.config.ListenAddr, Handler: mux}
-	mux.HandleFunc("/", s.handleWebhook)
+	srv := &http.Server{Addr: s.config.ListenAddr, Handler: mux, TLSConfig: auth.TLSConfig()}
+	// Keep slow clients from holding connections open indefinitely
+	s.config.HTTPServer.apply(srv)
+	// Health checks and metrics stay unauthenticated, so that they keep working for load balancers and prometheus
+	mux.HandleFunc("/", s.requireAuth(auth, s.handleWebhook))
+	for _, route := range s.config.Routes() {
+		mux.HandleFunc(route, s.requireAuth(auth, s.handleWebhook))
+	}
 	mux.HandleFunc("/_health", handleHealth)
-	mux.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{
+	mux.HandleFunc("/_ready", s.handleReady)
+	mux.HandleFunc("/_maintenance", s.requireAuth(auth, s.handleMaintenance))
+	mux.HandleFunc("/_state", s.requireAuth(auth, s.handleState))
+	mux.HandleFunc("/api/commands", s.requireAuth(auth, s.handleCatalog))
+	mux.HandleFunc(commandsAPIPath, s.requireAuth(auth, s.handleCommandToggle))
+	mux.HandleFunc("/processes", s.requireAuth(auth, s.handleProcesses))
+	mux.HandleFunc(processesAPIPath, s.requireAuth(auth, s.handleProcessKill))
+	mux.HandleFunc("/history", s.requireAuth(auth, s.handleHistory))
+	mux.HandleFunc("/api/report", s.requireAuth(auth, s.handleReport))
+	mux.HandleFunc("/api/approvals", s.requireAuth(auth, s.handleApprovals))
+	mux.HandleFunc(approvalsAPIPath, s.requireAuth(auth, s.handleApprovalDecision))
+	mux.HandleFunc("/api/resolve", s.requireAuth(auth, s.handleResolve))
+	mux.HandleFunc(linksAPIPath, s.requireAuth(auth, s.handleLinks))
+	mux.Handle("/metrics", s.failMetricWrites(promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{
 		// Prometheus can use the same logger we are, when printing errors about serving metrics
-		ErrorLog: log.New(os.Stderr, "", log.LstdFlags),
+		ErrorLog: logger,
 		// Include metric handler errors in metrics output
 		Registry: s.registry,
-	}))
+	})))
 
 	// Start http server in a goroutine, so that it doesn't block other activities
 	var httpSrvResult = make(chan error, 1)
@@ -469,17 +1552,28 @@ func (s *Server) Start() (*http.Server, chan error) {
 		for i, e := range s.config.Commands {
 			commands[i] = e.String()
 		}
-		log.Println("Listening on", s.config.ListenAddr, "with commands", strings.Join(commands, ", "))
+		network, addr, err := s.config.ListenAddress()
+		if err != nil {
+			httpSrvResult <- err
+			return
+		}
+		mode, err := s.config.SocketMode()
+		if err != nil {
+			httpSrvResult <- err
+			return
+		}
+		ln, err := listen(network, addr, mode)
+		if err != nil {
+			httpSrvResult <- err
+			return
+		}
+		logger.Info("Listening", Fields{"address": addr, "network": network, "commands": strings.Join(commands, ", ")})
 		if (s.config.TLSCrt != "") && (s.config.TLSKey != "") {
-			if s.config.Verbose {
-				log.Println("HTTPS on")
-			}
-			httpSrvResult <- srv.ListenAndServeTLS(s.config.TLSCrt, s.config.TLSKey)
+			logger.Debug("HTTPS on", nil)
+			httpSrvResult <- srv.ServeTLS(ln, s.config.TLSCrt, s.config.TLSKey)
 		} else {
-			if s.config.Verbose {
-				log.Println("HTTPS off")
-			}
-			httpSrvResult <- srv.ListenAndServe()
+			logger.Debug("HTTPS off", nil)
+			httpSrvResult <- srv.Serve(ln)
 		}
 	}()
 
@@ -488,17 +1582,84 @@ func (s *Server) Start() (*http.Server, chan error) {
 
 // NewServer returns a new server instance
 func NewServer(config *Config) *Server {
+	ctx, stop := context.WithCancel(context.Background())
 	s := Server{
-		config:          config,
-		tellFingers:     chanmap.NewChannelMap(),
-		fingerCount:     countermap.NewCounter(),
-		registry:        prometheus.NewPedanticRegistry(),
-		processDuration: prometheus.NewHistogram(procDurationOpts),
-		processCurrent:  prometheus.NewGauge(procCurrentOpts),
-		errCounter:      prometheus.NewCounterVec(errCountOpts, errCountLabels),
-		sigCounter:      prometheus.NewCounterVec(sigCountOpts, sigCountLabels),
-		skipCounter:     prometheus.NewCounterVec(skipCountOpts, skipCountLabels),
+		config:            config,
+		ctx:               ctx,
+		stop:              stop,
+		location:          config.Location(),
+		tellFingers:       chanmap.NewChannelMap(),
+		fingerCount:       countermap.NewCounter(),
+		running:           make(map[string]int),
+		maintenance:       NewMaintenance(),
+		disabled:          NewDisabledCommands(),
+		lastResults:       NewLastResults(),
+		report:            NewReport(),
+		approvals:         NewApprovals(),
+		pending:           NewPendingApprovals(),
+		processes:         NewProcesses(),
+		killers:           chanmap.NewChannelMap(),
+		history:           NewHistory(config.HistorySize),
+		tombstones:        NewTombstones(),
+		debouncer:         NewDebouncer(),
+		cooldowns:         NewDebouncer(),
+		successes:         NewDebouncer(),
+		suppressions:      prometheus.NewCounterVec(suppressionCountOpts, procLabels),
+		rateLimiter:       NewRateLimiter(),
+		tempFiles:         NewTempFiles(),
+		domains:           NewFailureDomains(config.FailureDomains),
+		attempts:          NewAttempts(),
+		registry:          prometheus.NewPedanticRegistry(),
+		processDuration:   prometheus.NewHistogramVec(procDurationOpts, procLabels),
+		processCurrent:    prometheus.NewGaugeVec(procCurrentOpts, procLabels),
+		exitCounter:       prometheus.NewCounterVec(procExitOpts, procExitLabels),
+		remediation:       prometheus.NewHistogramVec(remediationOpts, procLabels),
+		lastSuccess:       prometheus.NewGaugeVec(lastSuccessOpts, procLabels),
+		lastFailure:       prometheus.NewGaugeVec(lastFailureOpts, procLabels),
+		errCounter:        prometheus.NewCounterVec(errCountOpts, errCountLabels),
+		sigCounter:        prometheus.NewCounterVec(sigCountOpts, sigCountLabels),
+		skipCounter:       prometheus.NewCounterVec(skipCountOpts, skipCountLabels),
+		cmdSkipCounter:    prometheus.NewCounterVec(cmdSkipCountOpts, cmdSkipCountLabels),
+		retryCounter:      prometheus.NewCounter(retryCountOpts),
+		payloadCounter:    prometheus.NewCounterVec(payloadCountOpts, payloadLabels),
+		driftCounter:      prometheus.NewCounter(reconcileCountOpts),
+		truncations:       prometheus.NewCounter(truncatedCountOpts),
+		queues:            make(map[*Command]*commandQueue),
+		queueDepth:        prometheus.NewGaugeVec(queueDepthOpts, procLabels),
+		queueCapacity:     prometheus.NewGaugeVec(queueCapacityOpts, procLabels),
+		queueWait:         prometheus.NewHistogram(queueWaitOpts),
+		queueExpired:      prometheus.NewCounterVec(queueExpiredOpts, procLabels),
+		sloSuccessTarget:  prometheus.NewGaugeVec(sloSuccessTargetOpts, procLabels),
+		sloDurationTarget: prometheus.NewGaugeVec(sloDurationTargetOpts, procLabels),
+		sloExecutions:     prometheus.NewCounterVec(sloExecutionsOpts, sloLabels),
+		deadlineCounter:   prometheus.NewCounterVec(deadlineCountOpts, deadlineLabels),
+		guardCounter:      prometheus.NewCounterVec(guardCountOpts, guardLabels),
+		approvalCounter:   prometheus.NewCounterVec(approvalCountOpts, approvalLabels),
+		customCounters:    make(map[string]*prometheus.CounterVec),
+		customGauges:      make(map[string]*prometheus.GaugeVec),
+		tracer:            NewTracer(config.Tracing),
+		remoteWriter:      NewRemoteWriter(config.RemoteWrite),
+		requestLimiter:    NewRequestLimiter(config.WebhookRateLimit, config.WebhookBurst),
+		throttledCounter:  prometheus.NewCounter(throttledCountOpts),
+		redeliveries:      NewRedeliveries(config.RedeliveryWindow, config.RedeliveryHeader),
+		redeliveryCounter: prometheus.NewCounter(redeliveryCountOpts),
+		syncTimeouts:      prometheus.NewCounter(syncTimeoutCountOpts),
+		lateFailures:      prometheus.NewCounter(lateFailureCountOpts),
+		duplicateAlerts:   prometheus.NewCounter(duplicateAlertCountOpts),
+		archiveCounter:    prometheus.NewCounterVec(archiveCountOpts, archiveLabels),
+		janitorFiles:      prometheus.NewCounterVec(janitorFilesOpts, janitorLabels),
+		janitorBytes:      prometheus.NewCounterVec(janitorBytesOpts, janitorLabels),
+		live:              new(liveCounts),
 	}
+	s.saturation = prometheus.NewGaugeFunc(saturationOpts, func() float64 {
+		return s.saturatedFor().Seconds()
+	})
+	s.health = s.healthGauges()
+	// The template, allowlist, archive and signed links are validated when the config is read
+	s.summary, _ = config.ParseSummaryTemplate()
+	s.allowlist, _ = NewIPAllowlist(config.AllowedCIDRs, config.TrustedProxies)
+	s.archiver, _ = NewArchiver(config.Archive)
+	s.links, _ = NewLinkSigner(config.SignedLinks)
 
 	return &s
 }
@@ -64,6 +64,8 @@ var (
 	amDataToEnvMap = map[*template.Data][]string{
 		&amData: {
 			"AMX_ALERT_1_END=0",
+			"AMX_ALERT_1_START_RFC3339=2016-04-07T16:08:52Z",
+			"AMX_ALERT_1_END_RFC3339=",
 			"AMX_ALERT_1_LABEL_alertname=InstanceDown",
 			"AMX_ALERT_1_LABEL_instance=localhost:1234",
 			"AMX_ALERT_1_LABEL_job=broken",
@@ -74,6 +76,8 @@ var (
 			"AMX_ALERT_1_FINGERPRINT=",
 
 			"AMX_ALERT_2_END=0",
+			"AMX_ALERT_2_START_RFC3339=2016-04-07T16:08:52Z",
+			"AMX_ALERT_2_END_RFC3339=",
 			"AMX_ALERT_2_LABEL_alertname=InstanceDown",
 			"AMX_ALERT_2_LABEL_instance=localhost:5678",
 			"AMX_ALERT_2_LABEL_job=broken",
@@ -161,14 +165,18 @@ func genServer() (*Server, error) {
 	return s, err
 }
 
-// getCounterValue returns a metric's value
+// getCounterValue returns the total of a counter's series with the given value of their label other than command,
+// across all commands. Series of counters that are only labelled by command are matched by the command instead.
 func getCounterValue(cv *prometheus.CounterVec, label string) (float64, error) {
-	var m = &pm.Metric{}
-	err := cv.WithLabelValues(label).Write(m)
-	if err != nil {
-		return -1, err
+	var total float64
+	for _, m := range collectMetrics(cv) {
+		for _, l := range m.GetLabel() {
+			if (l.GetName() != "command" || len(m.GetLabel()) == 1) && l.GetValue() == label {
+				total += m.GetCounter().GetValue()
+			}
+		}
 	}
-	return m.Counter.GetValue(), nil
+	return total, nil
 }
 
 // RandLoopAddr returns an available loopback address and TCP port
@@ -230,7 +238,7 @@ func WaitForGetSuccess(url string) (*http.Response, error) {
 func Test_amDataToEnv(t *testing.T) {
 	t.Parallel()
 	for td, expectedEnv := range amDataToEnvMap {
-		env := amDataToEnv(td)
+		env := amDataToEnv(td, time.UTC)
 		sort.Strings(env)
 		sort.Strings(expectedEnv)
 
@@ -240,6 +248,17 @@ func Test_amDataToEnv(t *testing.T) {
 	}
 }
 
+func Test_timeToRFC3339(t *testing.T) {
+	t.Parallel()
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	if got, want := timeToRFC3339(time.Unix(1460045332, 0), loc), "2016-04-07T11:08:52-05:00"; got != want {
+		t.Errorf("Wrong RFC3339 time; got %s, want %s", got, want)
+	}
+	if got := timeToRFC3339(time.Time{}, loc); got != "" {
+		t.Errorf("Zero time should be empty; got %s", got)
+	}
+}
+
 func Test_handleHealth(t *testing.T) {
 	t.Parallel()
 	req := httptest.NewRequest("GET", "/_health", nil)
@@ -260,12 +279,59 @@ func Test_handleHealth(t *testing.T) {
 	}
 }
 
+func TestServer_handleReady(t *testing.T) {
+	cases := []struct {
+		name       string
+		fullSince  time.Time
+		statusCode int
+	}{
+		{
+			name:       "room",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "full_briefly",
+			fullSince:  time.Now(),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "saturated",
+			fullSince:  time.Now().Add(-time.Hour),
+			statusCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv, err := genServer()
+			if err != nil {
+				t.Fatal("Failed to generate server")
+			}
+			srv.config.SaturationThreshold = Duration(time.Minute)
+			cmd := srv.config.Commands[0]
+			srv.queues[cmd] = &commandQueue{cmd: cmd, jobs: make(chan queueJob, 1), pending: 1, fullSince: tc.fullSince}
+
+			req := httptest.NewRequest("GET", "/_ready", nil)
+			w := httptest.NewRecorder()
+			srv.handleReady(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.statusCode {
+				t.Errorf("Wrong response from handleReady; got %d, want %d", resp.StatusCode, tc.statusCode)
+			}
+		})
+	}
+}
+
 func Test_handleMetrics(t *testing.T) {
 	t.Parallel()
 	srv, err := genServer()
 	if err != nil {
 		t.Fatal("Failed to generate server")
 	}
+	// Queue metrics are only exposed for commands with limited concurrency
+	srv.config.Commands = append(srv.config.Commands, &Command{Cmd: "sleep", Concurrency: 1})
 	httpSrv, _ := srv.Start()
 	defer func() {
 		_ = stopServer(httpSrv)
@@ -291,6 +357,14 @@ func Test_handleMetrics(t *testing.T) {
 			metricNamespace,
 			procCurrentOpts.Subsystem,
 			procCurrentOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			procExitOpts.Subsystem,
+			procExitOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			remediationOpts.Subsystem,
+			remediationOpts.Name}, sep): false,
 		strings.Join([]string{
 			metricNamespace,
 			errCountOpts.Subsystem,
@@ -303,6 +377,30 @@ func Test_handleMetrics(t *testing.T) {
 			metricNamespace,
 			skipCountOpts.Subsystem,
 			skipCountOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			retryCountOpts.Subsystem,
+			retryCountOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			reconcileCountOpts.Subsystem,
+			reconcileCountOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			queueDepthOpts.Subsystem,
+			queueDepthOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			queueCapacityOpts.Subsystem,
+			queueCapacityOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			queueWaitOpts.Subsystem,
+			queueWaitOpts.Name}, sep): false,
+		strings.Join([]string{
+			metricNamespace,
+			saturationOpts.Subsystem,
+			saturationOpts.Name}, sep): false,
 	}
 
 	scanner := bufio.NewScanner(resp.Body)
@@ -362,6 +460,7 @@ func Test_handleWebhook(t *testing.T) {
 		errors         int
 		signalled      int
 		skipped        int
+		retried        int
 		stillRunningOk bool
 	}{
 		// The httptest.NewRequest() call sends a request to handleWebhook
@@ -383,6 +482,54 @@ func Test_handleWebhook(t *testing.T) {
 			statusCode: http.StatusInternalServerError,
 			errors:     2,
 		},
+		// Only the command with the route the alert was posted to runs
+		{
+			name: "route",
+			commands: []*Command{
+				{Cmd: "false", Route: "/hooks/disk"},
+				{Cmd: "true", Route: "/hooks/restart"},
+				{Cmd: "false"},
+			},
+			reqs:       []*http.Request{httptest.NewRequest("GET", "/hooks/restart", bytes.NewReader(trigger))},
+			statusCode: http.StatusOK,
+			errors:     0,
+		},
+		// Commands with a route don't run for alerts posted to other paths
+		{
+			name:       "route_default",
+			commands:   []*Command{{Cmd: "false", Route: "/hooks/disk"}, {Cmd: "true"}},
+			reqs:       []*http.Request{httptest.NewRequest("GET", "/", bytes.NewReader(trigger))},
+			statusCode: http.StatusOK,
+			errors:     0,
+		},
+		// Expect no error, because the template in the argument is expanded from the alert's labels
+		{
+			name:       "arg_template",
+			commands:   []*Command{{Cmd: "test", Args: []string{"{{ .CommonLabels.instance }}", "=", "localhost:5678"}}},
+			reqs:       []*http.Request{httptest.NewRequest("GET", "/", bytes.NewReader(trigger))},
+			statusCode: http.StatusOK,
+			errors:     0,
+		},
+		// Expect the command not to run, because the template refers to a missing label
+		{
+			name:           "arg_template_missing",
+			commands:       []*Command{{Cmd: "true", Args: []string{"{{ .CommonLabels.banana }}"}}},
+			reqs:           []*http.Request{httptest.NewRequest("GET", "/", bytes.NewReader(trigger))},
+			statusCode:     http.StatusInternalServerError,
+			errors:         0,
+			stillRunningOk: true,
+		},
+		// We'll expect 1 error after the failing command is retried twice
+		{
+			name: "retries",
+			commands: []*Command{
+				{Cmd: "false", Retries: 2, RetryBackoff: Duration(time.Millisecond)},
+			},
+			reqs:       []*http.Request{httptest.NewRequest("GET", "/", bytes.NewReader(trigger))},
+			statusCode: http.StatusInternalServerError,
+			errors:     1,
+			retried:    2,
+		},
 		// We'll expect 0 errors due to NotifyOnFailure being False
 		{
 			name: "no_error_notify",
@@ -419,6 +566,61 @@ func Test_handleWebhook(t *testing.T) {
 			signalled:      0,
 			stillRunningOk: true,
 		},
+		// Expect 1 error from the command run when the alert resolves
+		{
+			name:     "when_resolved",
+			commands: []*Command{{Cmd: "false", When: WhenResolved}},
+			reqs: []*http.Request{
+				httptest.NewRequest("GET", "/", bytes.NewReader(trigger)),
+				httptest.NewRequest("GET", "/", bytes.NewReader(resolve)),
+			},
+			statusCode: http.StatusInternalServerError,
+			errors:     1,
+		},
+		// Expect the firing command to be signalled, and 1 error from the resolve-time hook
+		{
+			name:     "resolved_cmd",
+			commands: []*Command{{Cmd: "sleep", Args: []string{"4s"}, ResolvedCmd: "false"}},
+			reqs: []*http.Request{
+				httptest.NewRequest("GET", "/", bytes.NewReader(trigger)),
+				httptest.NewRequest("GET", "/", bytes.NewReader(resolve)),
+			},
+			statusCode: http.StatusInternalServerError,
+			errors:     1,
+			signalled:  1,
+		},
+		// Expect no error, because commands only run for firing alerts by default
+		{
+			name:           "when_firing",
+			commands:       []*Command{{Cmd: "false"}},
+			reqs:           []*http.Request{httptest.NewRequest("GET", "/", bytes.NewReader(resolve))},
+			statusCode:     http.StatusOK,
+			errors:         0,
+			stillRunningOk: true,
+		},
+		// Expect 2 errors, from the failing command and the follow-up it escalates to, which runs a final follow-up
+		{
+			name: "follow_up_failure",
+			commands: []*Command{
+				{Cmd: "false", Name: "cheap", OnFailureRun: []string{"heavy"}},
+				{Cmd: "false", Name: "heavy", OnFailureRun: []string{"page"}},
+				{Cmd: "true", Name: "page"},
+			},
+			reqs:       []*http.Request{httptest.NewRequest("GET", "/", bytes.NewReader(trigger))},
+			statusCode: http.StatusInternalServerError,
+			errors:     2,
+		},
+		// Expect no error, because follow-ups only run for the result they're declared for
+		{
+			name: "follow_up_success",
+			commands: []*Command{
+				{Cmd: "true", Name: "cheap", OnFailureRun: []string{"heavy"}},
+				{Cmd: "false", Name: "heavy"},
+			},
+			reqs:       []*http.Request{httptest.NewRequest("GET", "/", bytes.NewReader(trigger))},
+			statusCode: http.StatusOK,
+			errors:     0,
+		},
 
 		// Expect 0 skipped due to no Max
 		{
@@ -453,6 +655,23 @@ func Test_handleWebhook(t *testing.T) {
 			skipped:        2,
 			stillRunningOk: true,
 		},
+		// Expect 1 skipped due to the command's queue being full
+		{
+			name: "queue_full",
+			commands: []*Command{
+				{Cmd: "sleep", Args: []string{"4s"}, Concurrency: 1, QueueSize: 1},
+			},
+			reqs: []*http.Request{
+				httptest.NewRequest("GET", "/", bytes.NewReader(trigger)),
+				httptest.NewRequest("GET", "/", bytes.NewReader(trigger)),
+				httptest.NewRequest("GET", "/", bytes.NewReader(trigger)),
+			},
+			statusCode:     http.StatusOK,
+			errors:         0,
+			signalled:      0,
+			skipped:        1,
+			stillRunningOk: true,
+		},
 	}
 
 	for _, tc := range cases {
@@ -497,25 +716,18 @@ func Test_handleWebhook(t *testing.T) {
 			}
 
 			// Check the process duration metric
-			var pdMetric pm.Metric
-			err = srv.processDuration.Write(&pdMetric)
-			if err != nil {
-				t.Fatalf("Failed to retrieve processDuration metric from handleWebhook: %v", err)
+			var durationCount uint64
+			for _, m := range collectMetrics(srv.processDuration) {
+				durationCount += m.GetHistogram().GetSampleCount()
 			}
-			durationCount := pdMetric.GetHistogram().GetSampleCount()
 			if !tc.stillRunningOk && durationCount == 0 {
 				t.Errorf("handleWebhook didn't observe processDuration metric samples")
 			}
 
 			// Check the process count metric
-			var pcMetric pm.Metric
-			err = srv.processCurrent.Write(&pcMetric)
-			if err != nil {
-				t.Fatalf("Failed to retrieve processCurrent metric from handleWebhook: %v", err)
-			}
-			current := pcMetric.GetGauge().GetValue()
+			current := srv.runningCount()
 			if !tc.stillRunningOk && current > 0 {
-				t.Errorf("handleWebhook metric says process is still running; got %f, want %d", current, 0)
+				t.Errorf("handleWebhook metric says process is still running; got %d, want %d", current, 0)
 			}
 
 			// Check the error metrics
@@ -534,6 +746,16 @@ func Test_handleWebhook(t *testing.T) {
 				t.Errorf("Wrong signalled count for %q; got %f, want %d", "ok", count, tc.signalled)
 			}
 
+			// Check retried metrics
+			var rMetric pm.Metric
+			err = srv.retryCounter.Write(&rMetric)
+			if err != nil {
+				t.Fatalf("Failed to retrieve retryCounter metric from handleWebhook: %v", err)
+			}
+			if retried := rMetric.GetCounter().GetValue(); retried != float64(tc.retried) {
+				t.Errorf("Wrong retried count; got %f, want %d", retried, tc.retried)
+			}
+
 			// Check skipped metrics
 			skipLabels := make([]string, 0)
 			for _, v := range CmdRunLabel {
@@ -555,6 +777,107 @@ func Test_handleWebhook(t *testing.T) {
 	}
 }
 
+func TestServer_instrumentExitCode(t *testing.T) {
+	t.Parallel()
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	cmd := &Command{Cmd: "sh", Name: "exit_three"}
+	out := make(chan CommandResult)
+	go srv.instrument("", nil, cmd, []string{"-c", "exit 3"}, nil, out, nil)
+	for range out {
+	}
+
+	var m pm.Metric
+	if err := srv.exitCounter.WithLabelValues("exit_three", "3").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Wrong exit count for exit code 3; got %f, want %d", got, 1)
+	}
+}
+
+func TestServer_instrumentLastResult(t *testing.T) {
+	cases := []struct {
+		name    string
+		cmd     string
+		success bool
+		failure bool
+	}{
+		{name: "success", cmd: "true", success: true},
+		{name: "failure", cmd: "false", failure: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv, err := genServer()
+			if err != nil {
+				t.Fatal("Failed to generate server")
+			}
+
+			before := float64(time.Now().Unix())
+			out := make(chan CommandResult)
+			go srv.instrument("", nil, &Command{Cmd: tc.cmd}, nil, nil, out, nil)
+			for range out {
+			}
+
+			for gauge, want := range map[*prometheus.GaugeVec]bool{srv.lastSuccess: tc.success, srv.lastFailure: tc.failure} {
+				var m pm.Metric
+				if err := gauge.WithLabelValues(tc.cmd).Write(&m); err != nil {
+					t.Fatal(err)
+				}
+				if got := m.GetGauge().GetValue() >= before; got != want {
+					t.Errorf("Wrong timestamp %f for %s, compared to start at %f; set %v, want %v", m.GetGauge().GetValue(), tc.cmd, before, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_remediation(t *testing.T) {
+	cases := []struct {
+		name    string
+		cmd     string
+		status  string
+		samples uint64
+	}{
+		{name: "success", cmd: "true", status: "firing", samples: 1},
+		{name: "failure", cmd: "false", status: "firing", samples: 0},
+		{name: "resolved", cmd: "true", status: "resolved", samples: 0},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			notify := false
+			cmd := &Command{Cmd: tc.cmd, Name: "fix", NotifyOnFailure: &notify, When: WhenBoth}
+			srv := NewServer(&Config{Commands: []*Command{cmd}})
+			startsAt := time.Now().Add(-time.Minute)
+			amMsg := &template.Data{
+				Status: tc.status,
+				Alerts: template.Alerts{{Status: tc.status, StartsAt: startsAt, Fingerprint: "abc"}},
+			}
+			_ = srv.runCommands(amMsg, "", nil, nil)
+
+			var m pm.Metric
+			if err := srv.remediation.WithLabelValues("fix").(prometheus.Histogram).Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.GetHistogram().GetSampleCount(); got != tc.samples {
+				t.Fatalf("Wrong number of remediation samples; got %d, want %d", got, tc.samples)
+			}
+			if tc.samples > 0 && m.GetHistogram().GetSampleSum() < time.Minute.Seconds() {
+				t.Errorf("Remediation time should be at least since the alert started; got %fs", m.GetHistogram().GetSampleSum())
+			}
+		})
+	}
+}
+
 func TestServer_CanRun(t *testing.T) {
 	t.Parallel()
 	srv, err := genServer()
@@ -569,6 +892,12 @@ func TestServer_CanRun(t *testing.T) {
 	var pass = func() {}
 	var boop10 = func() { srv.fingerCount.IncBy("boop", 10) }
 	var reset = func() { srv.fingerCount.Reset("boop") }
+	var maintain = func() {
+		srv.maintenance.Add(MaintenanceWindow{Labels: map[string]string{"job": "broken"}, Expires: time.Now().Add(time.Hour)})
+	}
+	var endMaintenance = func() { srv.maintenance = NewMaintenance() }
+	var disable = func() { srv.disabled.Disable("restart") }
+	var enable = func() { srv.disabled.Enable("restart") }
 	cases := []struct {
 		name    string
 		command Command
@@ -672,6 +1001,31 @@ func TestServer_CanRun(t *testing.T) {
 			before: boop10,
 			after:  reset,
 		},
+		// Can't run if alert matches a maintenance window
+		{
+			name: "maintenance",
+			command: Command{
+				Cmd: "echo",
+				MatchLabels: map[string]string{
+					"job": "broken",
+				},
+			},
+			data:   &amData,
+			ok:     false,
+			reason: CmdRunMaintenance,
+			before: maintain,
+			after:  endMaintenance,
+		},
+		// Can't run if the command was disabled at runtime
+		{
+			name:    "disabled",
+			command: Command{Cmd: "echo", Name: "restart"},
+			data:    &amData,
+			ok:      false,
+			reason:  CmdRunDisabled,
+			before:  disable,
+			after:   enable,
+		},
 	}
 
 	for _, tc := range cases {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+var (
+	// Signals that resolved_signal can name, whether or not the platform has them, so that configs stay valid
+	// across platforms. Signals the platform doesn't have are emulated.
+	signalNames = []string{
+		"SIGABRT", "SIGALRM", "SIGBUS", "SIGCHLD", "SIGCONT", "SIGFPE", "SIGHUP", "SIGILL", "SIGINT", "SIGIO", "SIGIOT",
+		"SIGKILL", "SIGPIPE", "SIGPROF", "SIGQUIT", "SIGSEGV", "SIGSTOP", "SIGSYS", "SIGTERM", "SIGTRAP", "SIGTSTP",
+		"SIGTTIN", "SIGTTOU", "SIGURG", "SIGUSR1", "SIGUSR2", "SIGVTALRM", "SIGWINCH", "SIGXCPU", "SIGXFSZ",
+	}
+)
+
+// signaller sends signals to the processes of commands, in the ways that the platform allows
+type signaller interface {
+	// Lookup returns the signal with the given upper case name or number, and false if the platform doesn't have it
+	Lookup(name string) (os.Signal, bool)
+	// Signal sends sig to the process, or to every process in the group that it leads if group is set
+	Signal(p *os.Process, sig os.Signal, group bool) error
+	// Isolate makes the command start in its own process group, if the platform has them
+	Isolate(cmd *exec.Cmd)
+}
+
+// emulatedSignal stands in for a signal that the platform doesn't have.
+// Sending it kills the process instead, as cancelling a command's context would, so that commands still stop when
+// their alert resolves.
+type emulatedSignal string
+
+func (s emulatedSignal) String() string {
+	return string(s) + " (emulated)"
+}
+
+// Signal makes emulatedSignal an os.Signal
+func (s emulatedSignal) Signal() {}
+
+// knownSignal returns true if name is one of signalNames
+func knownSignal(name string) bool {
+	for _, n := range signalNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// kills returns true if sending the signal kills the process outright, leaving nothing to escalate to
+func kills(sig os.Signal) bool {
+	_, emulated := sig.(emulatedSignal)
+	return emulated || sig == os.Kill
+}
+
+// killSignaller is the signaller for platforms without POSIX signals and process groups, such as Windows.
+// os.Kill is the only signal it has; every other signal is emulated by killing the process.
+// Process groups aren't supported, so only the command's own process is killed.
+type killSignaller struct{}
+
+func (killSignaller) Lookup(name string) (os.Signal, bool) {
+	if name == "SIGKILL" || name == "9" {
+		return os.Kill, true
+	}
+	return nil, false
+}
+
+func (killSignaller) Signal(p *os.Process, sig os.Signal, group bool) error {
+	return p.Kill()
+}
+
+func (killSignaller) Isolate(cmd *exec.Cmd) {}
@@ -0,0 +1,8 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+// The platform's way of signalling the processes of commands.
+// Platforms without POSIX signals, such as Windows, emulate them by killing processes.
+var platformSignals signaller = killSignaller{}
@@ -0,0 +1,82 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+var (
+	// The platform's way of signalling the processes of commands
+	platformSignals signaller = posixSignaller{}
+
+	signals = map[string]syscall.Signal{
+		"SIGABRT":   syscall.SIGABRT,
+		"SIGALRM":   syscall.SIGALRM,
+		"SIGBUS":    syscall.SIGBUS,
+		"SIGCHLD":   syscall.SIGCHLD,
+		"SIGCONT":   syscall.SIGCONT,
+		"SIGFPE":    syscall.SIGFPE,
+		"SIGHUP":    syscall.SIGHUP,
+		"SIGILL":    syscall.SIGILL,
+		"SIGINT":    syscall.SIGINT,
+		"SIGIO":     syscall.SIGIO,
+		"SIGIOT":    syscall.SIGIOT,
+		"SIGKILL":   syscall.SIGKILL,
+		"SIGPIPE":   syscall.SIGPIPE,
+		"SIGPROF":   syscall.SIGPROF,
+		"SIGQUIT":   syscall.SIGQUIT,
+		"SIGSEGV":   syscall.SIGSEGV,
+		"SIGSTOP":   syscall.SIGSTOP,
+		"SIGSYS":    syscall.SIGSYS,
+		"SIGTERM":   syscall.SIGTERM,
+		"SIGTRAP":   syscall.SIGTRAP,
+		"SIGTSTP":   syscall.SIGTSTP,
+		"SIGTTIN":   syscall.SIGTTIN,
+		"SIGTTOU":   syscall.SIGTTOU,
+		"SIGURG":    syscall.SIGURG,
+		"SIGUSR1":   syscall.SIGUSR1,
+		"SIGUSR2":   syscall.SIGUSR2,
+		"SIGVTALRM": syscall.SIGVTALRM,
+		"SIGWINCH":  syscall.SIGWINCH,
+		"SIGXCPU":   syscall.SIGXCPU,
+		"SIGXFSZ":   syscall.SIGXFSZ,
+	}
+)
+
+// posixSignaller is the signaller for platforms with POSIX signals and process groups
+type posixSignaller struct{}
+
+func (posixSignaller) Lookup(name string) (os.Signal, bool) {
+	if IsDigit(name) {
+		n, err := strconv.Atoi(name)
+		return syscall.Signal(n), err == nil
+	}
+	sig, ok := signals[name]
+	return sig, ok
+}
+
+func (posixSignaller) Signal(p *os.Process, sig os.Signal, group bool) error {
+	if _, ok := sig.(emulatedSignal); ok {
+		sig = os.Kill
+	}
+	if !group {
+		return p.Signal(sig)
+	}
+
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("Can't send %s to a process group", sig)
+	}
+	// A negative pid addresses every process in the group led by the command
+	return syscall.Kill(-p.Pid, s)
+}
+
+func (posixSignaller) Isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestKillSignaller(t *testing.T) {
+	t.Parallel()
+	var s killSignaller
+	if sig, ok := s.Lookup("SIGKILL"); !ok || sig != os.Kill {
+		t.Errorf("Wrong lookup of SIGKILL; got %v, %v", sig, ok)
+	}
+	if sig, ok := s.Lookup("SIGUSR2"); ok {
+		t.Errorf("SIGUSR2 should be emulated; got %v", sig)
+	}
+
+	// Signals that are emulated kill the process, as SIGKILL would
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start process: %v", err)
+	}
+	if err := s.Signal(cmd.Process, emulatedSignal("SIGUSR2"), true); err != nil {
+		t.Fatalf("Failed to signal process: %v", err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Error("Process should have been killed")
+	}
+}
+
+func Test_kills(t *testing.T) {
+	t.Parallel()
+	cases := map[os.Signal]bool{os.Kill: true, emulatedSignal("SIGTERM"): true, os.Interrupt: false}
+	for sig, want := range cases {
+		if got := kills(sig); got != want {
+			t.Errorf("Wrong result for %s; got %v, want %v", sig, got, want)
+		}
+	}
+}
+
+func Test_knownSignal(t *testing.T) {
+	t.Parallel()
+	for _, name := range signalNames {
+		if !knownSignal(name) {
+			t.Errorf("%s should be known", name)
+		}
+		if _, ok := platformSignals.Lookup(name); !ok {
+			t.Logf("%s is emulated on this platform", name)
+		}
+	}
+	if knownSignal("SIGBANANA") {
+		t.Error("SIGBANANA shouldn't be known")
+	}
+}
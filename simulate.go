@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kvFlag collects repeated name=value flags, such as -label instance=db1
+type kvFlag map[string]string
+
+// String returns the pairs in the flag's format, sorted by name
+func (kv kvFlag) String() string {
+	pairs := make([]string, 0, len(kv))
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set adds a name=value pair
+func (kv kvFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	kv[parts[0]] = parts[1]
+	return nil
+}
+
+// simulatePayload returns an alertmanager message about a single alert with the given labels and annotations
+func simulatePayload(status string, labels, annotations map[string]string) *template.Data {
+	alert := template.Alert{
+		Status:      status,
+		Labels:      template.KV(labels),
+		Annotations: template.KV(annotations),
+		StartsAt:    time.Now(),
+	}
+	if status == "resolved" {
+		alert.EndsAt = time.Now()
+	}
+	groupLabels := template.KV{}
+	if name, ok := labels["alertname"]; ok {
+		groupLabels["alertname"] = name
+	}
+	amMsg := &template.Data{
+		Receiver:          "am-executor",
+		Status:            status,
+		Alerts:            template.Alerts{alert},
+		GroupLabels:       groupLabels,
+		CommonLabels:      alert.Labels,
+		CommonAnnotations: alert.Annotations,
+	}
+	// Fill in the fingerprint that alertmanager would have sent
+	adaptPayload(amMsg)
+	return amMsg
+}
+
+// runSimulate sends an alert described by flags to a running executor, or runs the commands a config file has for it
+// in-process, so that the wiring of commands can be tested without writing webhook payloads by hand.
+func runSimulate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.SetOutput(w)
+	labels, annotations := kvFlag{}, kvFlag{}
+	fs.Var(labels, "label", "Label of the alert, as name=value; can be repeated")
+	fs.Var(annotations, "annotation", "Annotation of the alert, as name=value; can be repeated")
+	status := fs.String("status", "firing", "Status of the alert, firing or resolved")
+	target := fs.String("u", "http://localhost:8080/", "URL of the executor webhook endpoint")
+	configFile := fs.String("f", "", "YAML config file whose commands are run for the alert in-process, instead of sending it to an executor")
+	route := fs.String("route", "", "Route of the commands to run in-process; when sending to an executor, the route is part of the URL instead")
+	timeout := fs.Duration("t", 30*time.Second, "Timeout for the webhook request")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *status != "firing" && *status != "resolved" {
+		return fmt.Errorf("Status must be firing or resolved, got %q", *status)
+	}
+	if len(labels) == 0 {
+		return fmt.Errorf("At least one -label is needed to describe the alert")
+	}
+
+	amMsg := simulatePayload(*status, labels, annotations)
+	if *configFile != "" {
+		return simulateInProcess(*configFile, *route, amMsg, w)
+	}
+	return simulateRemote(*target, *timeout, amMsg, w)
+}
+
+// simulateRemote sends the alert to the executor webhook at the given URL, and writes its response to w
+func simulateRemote(target string, timeout time.Duration, amMsg *template.Data, w io.Writer) error {
+	data, err := json.Marshal(amMsg)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if body = bytes.TrimSpace(body); len(body) > 0 {
+		_, _ = fmt.Fprintf(w, "%s: %s\n", resp.Status, body)
+	} else {
+		_, _ = fmt.Fprintln(w, resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Executor responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// simulateInProcess runs the commands that the config file has for the alert, waiting for them to finish.
+// Their output is logged as usual. Nothing else is running, so limits like max start out with nothing counted against
+// them.
+func simulateInProcess(configFile string, route string, amMsg *template.Data, w io.Writer) error {
+	c, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(c); err != nil {
+		return err
+	}
+
+	s := NewServer(mergeConfigs(c))
+	defer s.fingerCount.Stop()
+	if errors := s.handleMessage(amMsg, route, nil, nil); len(errors) > 0 {
+		return concatErrors(errors...)
+	}
+	_, _ = fmt.Fprintf(w, "Finished running commands for the %s alert\n", amMsg.Status)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runSimulate_remote(t *testing.T) {
+	t.Parallel()
+	var received template.Data
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	err := runSimulate([]string{"-u", srv.URL + "/", "-label", "alertname=Down", "-label", "instance=db1=primary", "-annotation", "summary=Down", "-status", "resolved"}, &out)
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	if len(received.Alerts) != 1 {
+		t.Fatalf("Wrong number of alerts sent; got %d, want 1", len(received.Alerts))
+	}
+	a := received.Alerts[0]
+	if received.Status != "resolved" || a.Labels["instance"] != "db1=primary" || a.Annotations["summary"] != "Down" || a.Fingerprint == "" || a.EndsAt.IsZero() {
+		t.Errorf("Wrong alert sent; got %+v", a)
+	}
+	if received.GroupLabels["alertname"] != "Down" {
+		t.Errorf("Wrong group labels sent; got %v", received.GroupLabels)
+	}
+
+	for _, args := range [][]string{
+		{"-u", srv.URL + "/"},
+		{"-u", srv.URL + "/", "-label", "alertname=Down", "-status", "pending"},
+		{"-u", srv.URL + "/", "-label", "alertname"},
+	} {
+		if err := runSimulate(args, &out); err == nil {
+			t.Errorf("Expected an error for args %v", args)
+		}
+	}
+}
+
+func Test_runSimulate_inProcess(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor-simulate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	marker := filepath.Join(dir, "ran")
+	config := filepath.Join(dir, "config.yml")
+	yml := `commands:
+  - cmd: sh
+    args: ["-c", "echo $AMX_LABEL_instance > ` + marker + `"]
+    match_labels:
+      alertname: Down
+`
+	if err := ioutil.WriteFile(config, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = runSimulate([]string{"-f", config, "-label", "alertname=Down", "-label", "instance=db1"}, &out)
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("Expected command to run: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "db1" {
+		t.Errorf("Wrong label passed to command; got %q, want %q", got, "db1")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// How long to wait for a skip notification webhook to respond
+	skipWebhookTimeout = 10 * time.Second
+)
+
+// SkipNotification describes a command that was skipped instead of run for an alert.
+// It's the body of requests sent to a command's skip_webhook.
+type SkipNotification struct {
+	Command     string    `json:"command"`
+	Reason      string    `json:"reason"`
+	Description string    `json:"description"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// skip records that a command was skipped instead of run for an alert.
+// Commands with notify_on_skip set are also reported with a warning, a per-command metric and their skip_webhook.
+func (s *Server) skip(cmd *Command, reason CmdRunReason, fingerprint string) {
+	s.skipCounter.WithLabelValues(reason.Label(), cmd.MetricLabel()).Inc()
+	if reason != CmdRunNoLabelMatch {
+		s.report.Skipped(cmd.MetricLabel(), reason)
+	}
+
+	// Commands not matching an alert are skipped all the time, so that isn't worth notifying about
+	if !cmd.ShouldNotifySkip() || reason == CmdRunNoLabelMatch {
+		s.decision(cmd, "Skipping command", Fields{"command": cmd, "fingerprint": fingerprint, "reason": reason})
+		return
+	}
+
+	logger.Warn("Skipping command", Fields{"command": cmd, "fingerprint": fingerprint, "reason": reason})
+	s.cmdSkipCounter.WithLabelValues(cmd.String(), reason.Label()).Inc()
+	if cmd.SkipWebhook != "" {
+		go s.notifySkip(cmd.SkipWebhook, SkipNotification{
+			Command:     cmd.String(),
+			Reason:      reason.Label(),
+			Description: reason.String(),
+			Fingerprint: fingerprint,
+			Time:        time.Now(),
+		})
+	}
+}
+
+// notifySkip sends a skip notification to a webhook.
+// It is meant to be called as a goroutine, so that slow webhooks don't hold up alert processing.
+func (s *Server) notifySkip(url string, n SkipNotification) {
+	data, err := json.Marshal(n)
+	if err != nil {
+		logger.Error("Failed to encode skip notification", Fields{"command": n.Command, "error": err})
+		return
+	}
+
+	client := &http.Client{Timeout: skipWebhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logger.Error("Failed to send skip notification", Fields{"command": n.Command, "error": err})
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Error("Unexpected response to skip notification", Fields{"command": n.Command, "status": resp.Status})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	pm "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_skip(t *testing.T) {
+	t.Parallel()
+	notifications := make(chan SkipNotification, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var n SkipNotification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			t.Errorf("Failed to decode skip notification: %v", err)
+		}
+		notifications <- n
+	}))
+	defer hook.Close()
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	notify := true
+	quiet := &Command{Cmd: "echo", Name: "quiet"}
+	loud := &Command{Cmd: "echo", Args: []string{"loud"}, NotifyOnSkip: &notify, SkipWebhook: hook.URL}
+
+	srv.skip(quiet, CmdRunFingerOver, "boop")
+	srv.skip(loud, CmdRunNoLabelMatch, "boop")
+	srv.skip(loud, CmdRunFingerOver, "boop")
+
+	select {
+	case n := <-notifications:
+		if n.Command != loud.String() || n.Reason != CmdRunFingerOver.Label() || n.Fingerprint != "boop" {
+			t.Errorf("Wrong skip notification; got %+v", n)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("Timed-out waiting for skip notification")
+	}
+
+	skipped, err := getCounterValue(srv.skipCounter, CmdRunFingerOver.Label())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 2 {
+		t.Errorf("Wrong skip count; got %f, want %d", skipped, 2)
+	}
+
+	var q pm.Metric
+	if err := srv.skipCounter.WithLabelValues(CmdRunFingerOver.Label(), "quiet").Write(&q); err != nil {
+		t.Fatal(err)
+	}
+	if quietSkipped := q.GetCounter().GetValue(); quietSkipped != 1 {
+		t.Errorf("Wrong skip count for command labelled %q; got %f, want %d", "quiet", quietSkipped, 1)
+	}
+
+	var m pm.Metric
+	if err := srv.cmdSkipCounter.WithLabelValues(loud.String(), CmdRunFingerOver.Label()).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if cmdSkipped := m.GetCounter().GetValue(); cmdSkipped != 1 {
+		t.Errorf("Wrong per-command skip count; got %f, want %d", cmdSkipped, 1)
+	}
+
+	select {
+	case n := <-notifications:
+		t.Errorf("Unexpected skip notification; got %+v", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const (
+	// Outcomes that executions are counted with, against their command's SLO
+	SLOLabelGood   = "good"
+	SLOLabelFailed = "failed"
+	SLOLabelSlow   = "slow"
+)
+
+var (
+	sloSuccessTargetOpts = prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: "slo",
+		Name:      "target_success_ratio",
+		Help:      "Fraction of executions of commands that are meant to be good, as declared by their slo setting.",
+	}
+
+	sloDurationTargetOpts = prometheus.GaugeOpts{
+		Namespace: metricNamespace,
+		Subsystem: "slo",
+		Name:      "target_duration_seconds",
+		Help:      "Longest that successful executions of commands can take and still be good, as declared by their slo setting.",
+	}
+
+	sloExecutionsOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "slo",
+		Name:      "executions_total",
+		Help:      "Total number of executions of commands with an slo setting, by whether they were good, failed or slow.",
+	}
+	sloLabels = []string{"command", "outcome"}
+)
+
+// SLOConfig declares a service level objective for a command, so that the automation layer itself can be alerted on
+// when it burns through its error budget.
+type SLOConfig struct {
+	// Fraction of executions that are meant to be good, such as 0.99.
+	// Defaults to 1, meaning every execution is meant to be good.
+	SuccessRate float64 `yaml:"success_rate"`
+	// Executions that succeed, but take longer than this, aren't good.
+	// A zero value means any successful execution is good, however long it takes.
+	Duration Duration `yaml:"duration"`
+}
+
+// Validate returns an error if the objective's settings can't be used
+func (o *SLOConfig) Validate() error {
+	if o.SuccessRate < 0 || o.SuccessRate > 1 {
+		return fmt.Errorf("success_rate must be between 0 and 1, got %g", o.SuccessRate)
+	}
+	if o.Duration < 0 {
+		return fmt.Errorf("duration must not be negative, got %s", o.Duration)
+	}
+	return nil
+}
+
+// Target returns the fraction of executions that are meant to be good
+func (o *SLOConfig) Target() float64 {
+	if o.SuccessRate == 0 {
+		return 1
+	}
+	return o.SuccessRate
+}
+
+// Outcome returns the label that an execution with the given result and duration is counted with.
+// Returns false for executions that didn't succeed or fail, such as ones that were signalled because their alert
+// resolved, since they don't count towards the objective.
+func (o *SLOConfig) Outcome(result Result, elapsed time.Duration) (string, bool) {
+	switch {
+	case result.Has(CmdFail):
+		return SLOLabelFailed, true
+	case !result.Has(CmdOk):
+		return "", false
+	case o.Duration > 0 && elapsed > time.Duration(o.Duration):
+		return SLOLabelSlow, true
+	default:
+		return SLOLabelGood, true
+	}
+}
+
+// initSLOMetrics exports the targets of commands with an slo setting, and their executions before they first run
+func (s *Server) initSLOMetrics() {
+	for _, cmd := range s.config.AllCommands() {
+		if cmd.SLO == nil {
+			continue
+		}
+		label := cmd.MetricLabel()
+		s.sloSuccessTarget.WithLabelValues(label).Set(cmd.SLO.Target())
+		if cmd.SLO.Duration > 0 {
+			s.sloDurationTarget.WithLabelValues(label).Set(time.Duration(cmd.SLO.Duration).Seconds())
+		}
+		for _, outcome := range []string{SLOLabelGood, SLOLabelFailed, SLOLabelSlow} {
+			_ = s.sloExecutions.WithLabelValues(label, outcome)
+		}
+	}
+}
+
+// observeSLO counts a finished execution against its command's objective, if it has one
+func (s *Server) observeSLO(cmd *Command, result Result, elapsed time.Duration) {
+	if cmd.SLO == nil {
+		return
+	}
+	if outcome, ok := cmd.SLO.Outcome(result, elapsed); ok {
+		s.sloExecutions.WithLabelValues(cmd.MetricLabel(), outcome).Inc()
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	pm "github.com/prometheus/client_model/go"
+	"testing"
+	"time"
+)
+
+func TestSLOConfig_Outcome(t *testing.T) {
+	t.Parallel()
+	slo := &SLOConfig{SuccessRate: 0.99, Duration: Duration(time.Minute)}
+	cases := []struct {
+		name    string
+		result  Result
+		elapsed time.Duration
+		want    string
+		counted bool
+	}{
+		{name: "good", result: CmdOk, elapsed: time.Second, want: SLOLabelGood, counted: true},
+		{name: "retried", result: CmdRetry | CmdOk, elapsed: time.Second, want: SLOLabelGood, counted: true},
+		{name: "slow", result: CmdOk, elapsed: 2 * time.Minute, want: SLOLabelSlow, counted: true},
+		{name: "failed", result: CmdFail, elapsed: 2 * time.Minute, want: SLOLabelFailed, counted: true},
+		{name: "signalled", result: CmdSigOk},
+	}
+	for _, tc := range cases {
+		got, counted := slo.Outcome(tc.result, tc.elapsed)
+		if got != tc.want || counted != tc.counted {
+			t.Errorf("%s: wrong outcome; got %q (counted %v), want %q (counted %v)", tc.name, got, counted, tc.want, tc.counted)
+		}
+	}
+
+	if got, _ := (&SLOConfig{}).Outcome(CmdOk, time.Hour); got != SLOLabelGood {
+		t.Errorf("Expected slow executions to be good without a duration, got %q", got)
+	}
+}
+
+func TestSLOConfig_Validate(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		slo     SLOConfig
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "valid", slo: SLOConfig{SuccessRate: 0.95, Duration: Duration(time.Minute)}},
+		{name: "rate_over_one", slo: SLOConfig{SuccessRate: 1.5}, wantErr: true},
+		{name: "negative_duration", slo: SLOConfig{Duration: Duration(-time.Minute)}, wantErr: true},
+	}
+	for _, tc := range cases {
+		if err := tc.slo.Validate(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
+
+func TestServer_observeSLO(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	cmd := &Command{Cmd: "false", SLO: &SLOConfig{SuccessRate: 0.9}}
+	srv.config.Commands = append(srv.config.Commands, cmd)
+	srv.initSLOMetrics()
+
+	var m pm.Metric
+	if err := srv.sloSuccessTarget.WithLabelValues(cmd.MetricLabel()).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetGauge().GetValue() != 0.9 {
+		t.Errorf("Wrong success target; got %f, want 0.9", m.GetGauge().GetValue())
+	}
+
+	out := make(chan CommandResult)
+	go srv.instrument("", nil, cmd, nil, nil, out, nil)
+	for range out {
+	}
+	if err := srv.sloExecutions.WithLabelValues(cmd.MetricLabel(), SLOLabelFailed).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetCounter().GetValue() != 1 {
+		t.Errorf("Wrong number of failed executions; got %f, want 1", m.GetCounter().GetValue())
+	}
+}
@@ -0,0 +1,139 @@
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// File name suffix of spooled entries
+	entrySuffix = ".json"
+	// File name prefix of entries that are still being written
+	tmpPrefix = ".tmp-"
+	// Separates the route of an entry from the rest of its ID
+	routeSeparator = "~"
+)
+
+// Entry represents a payload that was added to the spool, but hasn't been marked as done yet
+type Entry struct {
+	ID   string
+	Data []byte
+	// Path that the payload was received on; empty if it wasn't added with one
+	Route string
+}
+
+// Spool persists payloads to a directory until they're marked as done,
+// so that payloads which weren't finished being processed can be replayed after a restart.
+// Each entry is stored in its own file, named so that entries sort in the order they were added.
+type Spool struct {
+	dir string
+	seq uint64
+}
+
+// Add durably stores a payload, returning the ID used to mark it as done
+func (s *Spool) Add(data []byte) (string, error) {
+	return s.AddRoute("", data)
+}
+
+// AddRoute durably stores a payload along with the path it was received on, returning the ID used to mark it as done.
+// The route is escaped into the entry's file name, so that it's stored atomically with the payload.
+func (s *Spool) AddRoute(route string, data []byte) (string, error) {
+	seq := atomic.AddUint64(&s.seq, 1)
+	id := fmt.Sprintf("%020d-%010d", time.Now().UnixNano(), seq)
+	if route != "" {
+		id += routeSeparator + url.PathEscape(route)
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, tmpPrefix)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		// Clean up if we didn't get to rename the file
+		_ = os.Remove(tmp.Name())
+	}()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		// Make sure the payload is on disk before it's visible as an entry
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmp.Name(), s.path(id))
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Done removes an entry from the spool
+func (s *Spool) Done(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Pending returns the entries that haven't been marked as done, in the order they were added
+func (s *Spool) Pending() ([]Entry, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, tmpPrefix) || !strings.HasSuffix(name, entrySuffix) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, entrySuffix))
+	}
+	sort.Strings(ids)
+
+	entries := make([]Entry, 0, len(ids))
+	for _, id := range ids {
+		data, err := ioutil.ReadFile(s.path(id))
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{ID: id, Data: data}
+		if i := strings.Index(id, routeSeparator); i >= 0 {
+			e.Route, err = url.PathUnescape(id[i+len(routeSeparator):])
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// path returns the file path of an entry
+func (s *Spool) path(id string) string {
+	return filepath.Join(s.dir, id+entrySuffix)
+}
+
+// NewSpool returns a Spool instance storing entries in the given directory, creating it if necessary
+func NewSpool(dir string) (*Spool, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Spool{dir: dir}, nil
+}
@@ -0,0 +1,140 @@
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempSpool returns a Spool in a new temporary directory, and a function to remove it
+func tempSpool(t *testing.T) (*Spool, func()) {
+	dir, err := ioutil.TempDir("", "am-executor_spool-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSpool(filepath.Join(dir, "spool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestSpool_Add(t *testing.T) {
+	t.Parallel()
+	s, cleanup := tempSpool(t)
+	defer cleanup()
+
+	payloads := []string{"banana", "tomato", "lemon"}
+	for _, p := range payloads {
+		if _, err := s.Add([]byte(p)); err != nil {
+			t.Fatalf("Failed to add %s to spool: %v", p, err)
+		}
+	}
+
+	entries, err := s.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(payloads) {
+		t.Fatalf("Wrong number of pending entries; got %d, want %d", len(entries), len(payloads))
+	}
+	// Entries are returned in the order they were added
+	for i, e := range entries {
+		if string(e.Data) != payloads[i] {
+			t.Errorf("Wrong data for entry %d; got %s, want %s", i, e.Data, payloads[i])
+		}
+	}
+}
+
+func TestSpool_AddRoute(t *testing.T) {
+	t.Parallel()
+	s, cleanup := tempSpool(t)
+	defer cleanup()
+
+	if _, err := s.AddRoute("/hooks/disk", []byte("banana")); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.Add([]byte("tomato"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Wrong number of pending entries; got %d, want %d", len(entries), 2)
+	}
+	if got, want := entries[0].Route, "/hooks/disk"; got != want {
+		t.Errorf("Wrong route for entry added with one; got %q, want %q", got, want)
+	}
+	if got := entries[1].Route; got != "" {
+		t.Errorf("Wrong route for entry added without one; got %q, want none", got)
+	}
+
+	if err := s.Done(entries[0].ID); err != nil {
+		t.Errorf("Failed to mark routed entry as done: %v", err)
+	}
+	entries, err = s.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != id {
+		t.Errorf("Wrong pending entries; got %v", entries)
+	}
+}
+
+func TestSpool_Done(t *testing.T) {
+	t.Parallel()
+	s, cleanup := tempSpool(t)
+	defer cleanup()
+
+	done, err := s.Add([]byte("banana"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Add([]byte("tomato"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Done(done); err != nil {
+		t.Errorf("Failed to mark entry as done: %v", err)
+	}
+	// Marking an entry as done more than once is fine
+	if err := s.Done(done); err != nil {
+		t.Errorf("Failed to mark entry as done again: %v", err)
+	}
+
+	entries, err := s.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || string(entries[0].Data) != "tomato" {
+		t.Errorf("Wrong pending entries; got %v", entries)
+	}
+}
+
+func TestSpool_Pending(t *testing.T) {
+	t.Parallel()
+	s, cleanup := tempSpool(t)
+	defer cleanup()
+
+	// Partially written entries and unrelated files are ignored
+	for _, name := range []string{tmpPrefix + "123", "README"} {
+		if err := ioutil.WriteFile(filepath.Join(s.dir, name), []byte("banana"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := s.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Wrong number of pending entries; got %d, want %d", len(entries), 0)
+	}
+}
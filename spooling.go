@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/imgix/prometheus-am-executor/spool"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Bucket that payloads are kept in when they're spooled to storage, keyed by their ID
+	spoolBucket = "spool"
+)
+
+// payloadSpool persists webhook payloads until they're marked as done.
+// It's a spool.Spool when spool_dir is set, or a storeSpool when they're kept in storage instead.
+type payloadSpool interface {
+	AddRoute(route string, data []byte) (string, error)
+	Done(id string) error
+	Pending() ([]spool.Entry, error)
+}
+
+// storeSpool persists payloads to a store until they're marked as done.
+// IDs sort in the order payloads were added, like those of spool.Spool.
+type storeSpool struct {
+	store Store
+	seq   uint64
+}
+
+// storedPayload is a payload as it's kept in a store
+type storedPayload struct {
+	Route string `json:"route,omitempty"`
+	Data  []byte `json:"data"`
+}
+
+// newStoreSpool returns a storeSpool keeping payloads in the given store
+func newStoreSpool(store Store) *storeSpool {
+	return &storeSpool{store: store}
+}
+
+func (s *storeSpool) AddRoute(route string, data []byte) (string, error) {
+	id := fmt.Sprintf("%020d-%010d", time.Now().UnixNano(), atomic.AddUint64(&s.seq, 1))
+	value, err := json.Marshal(storedPayload{Route: route, Data: data})
+	if err != nil {
+		return "", err
+	}
+	return id, s.store.Put(spoolBucket, []byte(id), value)
+}
+
+func (s *storeSpool) Done(id string) error {
+	return s.store.Delete(spoolBucket, []byte(id))
+}
+
+func (s *storeSpool) Pending() ([]spool.Entry, error) {
+	entries := make([]spool.Entry, 0)
+	var decodeErr error
+	err := s.store.Scan(spoolBucket, nil, nil, false, func(k []byte, v []byte) bool {
+		var p storedPayload
+		if decodeErr = json.Unmarshal(v, &p); decodeErr != nil {
+			return false
+		}
+		entries = append(entries, spool.Entry{ID: string(k), Data: p.Data, Route: p.Route})
+		return true
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	return entries, err
+}
+
+// OpenSpool persists webhook payloads in the given directory until they have been processed,
+// so that they can be replayed if the server is interrupted.
+func (s *Server) OpenSpool(dir string) error {
+	sp, err := spool.NewSpool(dir)
+	if err != nil {
+		return err
+	}
+	s.spool = sp
+	return nil
+}
+
+// finishSpooled removes a processed payload from the spool
+func (s *Server) finishSpooled(id string) {
+	err := s.spool.Done(id)
+	if err != nil {
+		logger.Error("Failed to remove processed payload from spool", Fields{"id": id, "error": err})
+		s.errCounter.WithLabelValues(ErrLabelSpool, CmdLabelNone).Inc()
+	}
+}
+
+// pendingSpooled returns the payloads in the spool that weren't processed
+func (s *Server) pendingSpooled() []spool.Entry {
+	if s.spool == nil {
+		return nil
+	}
+
+	entries, err := s.spool.Pending()
+	if err != nil {
+		logger.Error("Failed to read unprocessed payloads from spool", Fields{"error": err})
+		s.errCounter.WithLabelValues(ErrLabelSpool, CmdLabelNone).Inc()
+		return nil
+	}
+	return entries
+}
+
+// replay processes payloads that weren't processed before the server was last interrupted, in the order they arrived.
+// Errors from running commands are logged, since there's no longer a caller to report them to.
+func (s *Server) replay(entries []spool.Entry) {
+	for _, e := range entries {
+		logger.Info("Replaying unprocessed payload from spool", Fields{"id": e.ID, "route": e.Route})
+		span := s.tracer.Start("replay", Fields{"spool.id": e.ID})
+		if amMsg, err := s.decodePayload(e.Data); err != nil {
+			logger.Error("Failed to unmarshal spooled payload", Fields{"id": e.ID, "error": err})
+			s.errCounter.WithLabelValues(ErrLabelUnmarshall, CmdLabelNone).Inc()
+			span.End(err)
+		} else if errors := s.handleMessage(amMsg, e.Route, nil, span); len(errors) > 0 {
+			logger.Error("Errors while replaying spooled payload", Fields{"id": e.ID, "error": concatErrors(errors...)})
+			span.End(concatErrors(errors...))
+		} else {
+			span.End(nil)
+		}
+		s.finishSpooled(e.ID)
+	}
+}
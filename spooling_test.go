@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestServer_replay(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor_replay-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	if err := srv.OpenSpool(dir); err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+
+	// Leave a payload behind, as if we were interrupted while processing it
+	trigger, err := json.Marshal(&amDataFinger)
+	if err != nil {
+		t.Fatal("Failed to encode amDataFinger as JSON")
+	}
+	if _, err := srv.spool.AddRoute("", trigger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.spool.AddRoute("", []byte("banana")); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := srv.pendingSpooled()
+	if len(pending) != 2 {
+		t.Fatalf("Wrong number of pending payloads; got %d, want %d", len(pending), 2)
+	}
+	srv.replay(pending)
+
+	if pending := srv.pendingSpooled(); len(pending) != 0 {
+		t.Errorf("Replayed payloads are still pending; got %d", len(pending))
+	}
+	count, err := getCounterValue(srv.errCounter, ErrLabelUnmarshall)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Wrong error count for %q; got %f, want %d", ErrLabelUnmarshall, count, 1)
+	}
+}
+
+func TestServer_handleWebhookSpooled(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor_spooled-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	if err := srv.OpenSpool(dir); err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+
+	trigger, err := json.Marshal(&amDataFinger)
+	if err != nil {
+		t.Fatal("Failed to encode amDataFinger as JSON")
+	}
+	w := httptest.NewRecorder()
+	srv.handleWebhook(w, httptest.NewRequest("GET", "/", bytes.NewReader(trigger)))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Errorf("Wrong response from handleWebhook; got %d, want %d", w.Result().StatusCode, http.StatusOK)
+	}
+
+	// Processed payloads are removed from the spool
+	if pending := srv.pendingSpooled(); len(pending) != 0 {
+		t.Errorf("Processed payloads are still pending; got %d", len(pending))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+var (
+	// Bucket and key that runtime state is kept under, when it's kept in storage rather than a state file
+	stateBucket = "state"
+	stateKey    = []byte("runtime")
+)
+
+// State represents the runtime state of the server that isn't part of its configuration.
+// It can be exported from one instance and imported into another, such as during blue/green deployments.
+type State struct {
+	Maintenance []MaintenanceWindow `json:"maintenance"`
+	// Names of the commands disabled at runtime
+	DisabledCommands []string `json:"disabled_commands"`
+	// Alerts that commands with a cooldown are skipped for, until when
+	Cooldowns []DebounceEntry `json:"cooldowns"`
+	// What's left of the rate limits of commands with max_per_minute or max_per_hour
+	RateBudgets []RateBudget `json:"rate_budgets"`
+}
+
+// State returns a snapshot of the server's runtime state
+func (s *Server) State() State {
+	return State{
+		Maintenance:      s.maintenance.Windows(),
+		DisabledCommands: s.disabled.Names(),
+		Cooldowns:        s.cooldowns.Entries(),
+		RateBudgets:      s.rateLimiter.Budgets(),
+	}
+}
+
+// RestoreState replaces the server's runtime state with the given state
+func (s *Server) RestoreState(st State) {
+	s.maintenance.Set(st.Maintenance)
+	s.disabled.Set(st.DisabledCommands)
+	s.cooldowns.Set(st.Cooldowns)
+	s.rateLimiter.Set(st.RateBudgets)
+}
+
+// LoadState restores the runtime state saved in the given file, and saves the state there whenever it changes,
+// so that maintenance windows, disabled commands, cooldowns and rate limits survive restarts.
+// A missing file is treated as empty state, since it's created once the state first changes.
+func (s *Server) LoadState(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var st State
+		if err := json.Unmarshal(data, &st); err != nil {
+			return err
+		}
+		s.RestoreState(st)
+		logger.Info("Restored runtime state", Fields{"state_file": path, "maintenance_windows": len(st.Maintenance), "disabled_commands": len(st.DisabledCommands), "cooldowns": len(st.Cooldowns), "rate_budgets": len(st.RateBudgets)})
+	}
+	s.stateFile = path
+	return nil
+}
+
+// loadStoredState restores the runtime state kept in storage, and keeps it there whenever it changes
+func (s *Server) loadStoredState() error {
+	data, err := s.store.Get(stateBucket, stateKey)
+	if err != nil || data == nil {
+		return err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	s.RestoreState(st)
+	logger.Info("Restored runtime state from storage", Fields{"maintenance_windows": len(st.Maintenance), "disabled_commands": len(st.DisabledCommands), "cooldowns": len(st.Cooldowns), "rate_budgets": len(st.RateBudgets)})
+	return nil
+}
+
+// saveState writes the runtime state to the state file, if there is one, or else to storage, if it's configured.
+// The state file is replaced atomically, so that a crash while saving can't leave a truncated state file behind.
+// Saves are serialized, with the state taken once the previous save is written, so the newest state is written last.
+func (s *Server) saveState() {
+	if s.stateFile == "" && s.store == nil {
+		return
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	data, err := json.Marshal(s.State())
+	if err == nil {
+		if s.stateFile != "" {
+			err = writeFileAtomic(s.stateFile, data)
+		} else {
+			err = s.store.Put(stateBucket, stateKey, data)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to save runtime state", Fields{"state_file": s.stateFile, "error": err})
+		s.errCounter.WithLabelValues(ErrLabelState, CmdLabelNone).Inc()
+	}
+}
+
+// handleState exports the runtime state as JSON for GET requests, and imports it for PUT requests.
+// Imported state replaces the current state, rather than being merged with it.
+func (s *Server) handleState(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, s.State())
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+
+		var st State
+		if err := json.Unmarshal(data, &st); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.RestoreState(st)
+		s.saveState()
+		logger.Debug("Imported runtime state", Fields{"maintenance_windows": len(st.Maintenance), "disabled_commands": len(st.DisabledCommands), "cooldowns": len(st.Cooldowns), "rate_budgets": len(st.RateBudgets)})
+		writeJSON(w, s.State())
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
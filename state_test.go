@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_handleState(t *testing.T) {
+	t.Parallel()
+	src, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	dst, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+
+	src.maintenance.Add(MaintenanceWindow{Labels: map[string]string{"job": "broken"}, Expires: time.Now().Add(time.Hour)})
+	src.maintenance.Add(MaintenanceWindow{Labels: map[string]string{"job": "fixed"}, Expires: time.Now().Add(-time.Second)})
+	dst.maintenance.Add(MaintenanceWindow{Labels: map[string]string{"job": "other"}, Expires: time.Now().Add(time.Hour)})
+	src.disabled.Disable("restart")
+	dst.disabled.Disable("reboot")
+	src.cooldowns.Record("restart", "boop", time.Hour)
+	limited := &Command{Cmd: "reboot", MaxPerMinute: 1}
+	src.rateLimiter.Allow(limited, time.Now())
+	dst.cooldowns.Record("reboot", "beep", time.Hour)
+
+	// Export the state from one server
+	w := httptest.NewRecorder()
+	src.handleState(w, httptest.NewRequest("GET", "/_state", nil))
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Wrong response when exporting state; got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var exported State
+	if err := json.NewDecoder(resp.Body).Decode(&exported); err != nil {
+		t.Fatalf("Failed to decode exported state: %v", err)
+	}
+	if len(exported.Maintenance) != 1 {
+		t.Errorf("Wrong number of exported maintenance windows; got %d, want %d", len(exported.Maintenance), 1)
+	}
+
+	// Import it into another
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	dst.handleState(w, httptest.NewRequest("PUT", "/_state", bytes.NewReader(data)))
+	resp = w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Wrong response when importing state; got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	windows := dst.maintenance.Windows()
+	if len(windows) != 1 || windows[0].Labels["job"] != "broken" {
+		t.Errorf("Imported state didn't replace maintenance windows; got %v", windows)
+	}
+	if names := dst.disabled.Names(); len(names) != 1 || names[0] != "restart" {
+		t.Errorf("Imported state didn't replace disabled commands; got %v", names)
+	}
+	if !dst.cooldowns.Debounced("restart", "boop") || dst.cooldowns.Debounced("reboot", "beep") {
+		t.Errorf("Imported state didn't replace cooldowns; got %v", dst.cooldowns.Entries())
+	}
+	if dst.rateLimiter.Allow(limited, time.Now()) {
+		t.Errorf("Imported state didn't carry over rate limits; got %v", dst.rateLimiter.Budgets())
+	}
+
+	// Malformed state is rejected
+	w = httptest.NewRecorder()
+	dst.handleState(w, httptest.NewRequest("PUT", "/_state", bytes.NewReader([]byte("banana"))))
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong response when importing bad state; got %d, want %d", w.Result().StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestServer_LoadState(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "state.json")
+
+	// A missing state file is empty state
+	cooldown := &Command{Cmd: "true", Name: "cooldown", Cooldown: Duration(time.Hour)}
+	src := NewServer(&Config{Commands: []*Command{{Cmd: "restart-service", Name: "restart"}, cooldown}})
+	if err := src.LoadState(path); err != nil {
+		t.Fatalf("Failed to load missing state file: %v", err)
+	}
+
+	// Changes made through the API are saved
+	w := httptest.NewRecorder()
+	src.handleCommandToggle(w, httptest.NewRequest("POST", "/api/commands/restart/disable", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong response disabling command; got %d, want %d", w.Code, http.StatusOK)
+	}
+	w = httptest.NewRecorder()
+	src.handleMaintenance(w, httptest.NewRequest("POST", "/_maintenance", bytes.NewBufferString(`{"labels":{"job":"broken"},"duration":"1h"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong response starting maintenance window; got %d, want %d", w.Code, http.StatusOK)
+	}
+	out := make(chan CommandResult)
+	go src.instrument("boop", nil, cooldown, nil, nil, out, nil)
+	for range out {
+	}
+
+	// and restored by the next server using the file
+	dst := NewServer(&Config{})
+	if err := dst.LoadState(path); err != nil {
+		t.Fatalf("Failed to load state file: %v", err)
+	}
+	if !dst.disabled.Disabled("restart") {
+		t.Errorf("Disabled command wasn't restored; got %v", dst.disabled.Names())
+	}
+	if windows := dst.maintenance.Windows(); len(windows) != 1 || windows[0].Labels["job"] != "broken" {
+		t.Errorf("Maintenance window wasn't restored; got %+v", windows)
+	}
+	if !dst.cooldowns.Debounced(cooldown.MetricLabel(), "boop") {
+		t.Errorf("Cooldown wasn't restored; got %+v", dst.cooldowns.Entries())
+	}
+
+	// Only the state file is left behind, without temporary files
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the state file to be left; got %d files", len(files))
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewServer(&Config{}).LoadState(path); err == nil {
+		t.Errorf("Expected an error loading a corrupt state file")
+	}
+}
+
+func TestServer_saveState_concurrent(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	path := filepath.Join(dir, "state.json")
+
+	src := NewServer(&Config{})
+	if err := src.LoadState(path); err != nil {
+		t.Fatal(err)
+	}
+	// Each change is saved at the same time as others, and the state saved last has to include all of them
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			src.disabled.Disable(name)
+			src.saveState()
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	dst := NewServer(&Config{})
+	if err := dst.LoadState(path); err != nil {
+		t.Fatal(err)
+	}
+	if names := dst.disabled.Names(); len(names) != 20 {
+		t.Errorf("Saved state is missing changes; got %d disabled commands, want 20", len(names))
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Storage backends that records can be kept in
+	StorageMemory = "memory"
+	StorageFile   = "file"
+	StorageBolt   = "bolt"
+)
+
+// Store keeps records in named buckets, ordered by their keys.
+// It's what the execution history, unprocessed webhook payloads and runtime state are kept in when storage is
+// configured, so that they share one backend.
+type Store interface {
+	// Put stores a record, replacing any record with the same key
+	Put(bucket string, key []byte, value []byte) error
+	// Get returns the value of a record, or nil if there isn't one
+	Get(bucket string, key []byte) ([]byte, error)
+	// Delete removes the records with the given keys, ignoring ones that don't exist
+	Delete(bucket string, keys ...[]byte) error
+	// Scan calls fn for each record whose key is at least from and less than to, in the order of their keys, or in
+	// reverse order if reverse is true. A nil from or to leaves the range open at that end.
+	// Scanning stops when fn returns false. The key and value are only valid until fn returns.
+	Scan(bucket string, from []byte, to []byte, reverse bool, fn func(key []byte, value []byte) bool) error
+	// Close releases the store's resources
+	Close() error
+}
+
+// StorageConfig configures the backend that the execution history, unprocessed webhook payloads and runtime state
+// are kept in, for each of them that doesn't have its own history_db, spool_dir or state_file
+type StorageConfig struct {
+	// Backend to keep records in: memory, which doesn't survive restarts; file, which rewrites a single JSON file
+	// on every change and suits small deployments; or bolt, an embedded database file.
+	Backend string `yaml:"backend"`
+	// File that the file and bolt backends keep records in
+	Path string `yaml:"path"`
+}
+
+// Validate returns an error if the storage settings can't be used
+func (c StorageConfig) Validate() error {
+	switch strings.ToLower(c.Backend) {
+	case "":
+		if c.Path != "" {
+			return fmt.Errorf("backend isn't set")
+		}
+	case StorageMemory:
+		if c.Path != "" {
+			return fmt.Errorf("the %s backend doesn't use a path", StorageMemory)
+		}
+	case StorageFile, StorageBolt:
+		if c.Path == "" {
+			return fmt.Errorf("the %s backend requires a path", strings.ToLower(c.Backend))
+		}
+	default:
+		return fmt.Errorf("unknown backend %q, expected one of %s, %s or %s", c.Backend, StorageMemory, StorageFile, StorageBolt)
+	}
+	return nil
+}
+
+// NewStore opens the configured storage backend, or returns nil if there isn't one
+func NewStore(c StorageConfig) (Store, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(c.Backend) {
+	case StorageMemory:
+		return NewMemoryStore(), nil
+	case StorageFile:
+		return NewFileStore(c.Path)
+	case StorageBolt:
+		return NewBoltStore(c.Path)
+	}
+	return nil, nil
+}
+
+// MemoryStore keeps records in memory, so they're lost when the server restarts
+type MemoryStore struct {
+	// Values of records, keyed by bucket and then by their key
+	buckets map[string]map[string][]byte
+	sync.RWMutex
+}
+
+// NewMemoryStore returns a MemoryStore instance, with no records
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (m *MemoryStore) Put(bucket string, key []byte, value []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	b, ok := m.buckets[bucket]
+	if !ok {
+		b = make(map[string][]byte)
+		m.buckets[bucket] = b
+	}
+	b[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *MemoryStore) Get(bucket string, key []byte) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	v, ok := m.buckets[bucket][string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (m *MemoryStore) Delete(bucket string, keys ...[]byte) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, key := range keys {
+		delete(m.buckets[bucket], string(key))
+	}
+	return nil
+}
+
+func (m *MemoryStore) Scan(bucket string, from []byte, to []byte, reverse bool, fn func(key []byte, value []byte) bool) error {
+	m.RLock()
+	defer m.RUnlock()
+	b := m.buckets[bucket]
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		if (from == nil || k >= string(from)) && (to == nil || k < string(to)) {
+			keys = append(keys, k)
+		}
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+	for _, k := range keys {
+		if !fn([]byte(k), b[k]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore keeps records in memory, and writes them all to a JSON file whenever they change.
+// Rewriting the whole file is simple and easy to inspect, but only suits small numbers of records.
+type FileStore struct {
+	*MemoryStore
+	path string
+	// Held while records are changed and written, so that writes of the file are in the order of the changes
+	mu sync.Mutex
+}
+
+// NewFileStore returns a FileStore keeping records in the file at the given path, loading any records already there.
+// A missing file is treated as having no records, since it's created once records first change.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are hex encoded, since they're bytes rather than text
+	var buckets map[string]map[string][]byte
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for bucket, records := range buckets {
+		f.buckets[bucket] = make(map[string][]byte, len(records))
+		for k, v := range records {
+			key, err := hex.DecodeString(k)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: invalid key %q in bucket %q", path, k, bucket)
+			}
+			f.buckets[bucket][string(key)] = v
+		}
+	}
+	return f, nil
+}
+
+func (f *FileStore) Put(bucket string, key []byte, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_ = f.MemoryStore.Put(bucket, key, value)
+	return f.write()
+}
+
+func (f *FileStore) Delete(bucket string, keys ...[]byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_ = f.MemoryStore.Delete(bucket, keys...)
+	return f.write()
+}
+
+// write writes all records to the file
+func (f *FileStore) write() error {
+	f.RLock()
+	buckets := make(map[string]map[string][]byte, len(f.buckets))
+	for bucket, records := range f.buckets {
+		buckets[bucket] = make(map[string][]byte, len(records))
+		for k, v := range records {
+			buckets[bucket][hex.EncodeToString([]byte(k))] = v
+		}
+	}
+	data, err := json.Marshal(buckets)
+	f.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file that then replaces the file at the given path,
+// so that a crash while writing can't leave a truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// BoltStore keeps records in a bolt database file, with a bolt bucket for each bucket of records
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens the bolt database at the given path, creating it if it doesn't exist
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: historyDBLockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Put(bucket string, key []byte, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bb, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return bb.Put(key, value)
+	})
+}
+
+func (b *BoltStore) Get(bucket string, key []byte) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if bb := tx.Bucket([]byte(bucket)); bb != nil {
+			if v := bb.Get(key); v != nil {
+				value = append([]byte(nil), v...)
+			}
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (b *BoltStore) Delete(bucket string, keys ...[]byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bb := tx.Bucket([]byte(bucket))
+		if bb == nil {
+			return nil
+		}
+		for _, key := range keys {
+			if err := bb.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) Scan(bucket string, from []byte, to []byte, reverse bool, fn func(key []byte, value []byte) bool) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		bb := tx.Bucket([]byte(bucket))
+		if bb == nil {
+			return nil
+		}
+		c := bb.Cursor()
+		if !reverse {
+			k, v := c.First()
+			if from != nil {
+				k, v = c.Seek(from)
+			}
+			for ; k != nil && (to == nil || bytes.Compare(k, to) < 0); k, v = c.Next() {
+				if !fn(k, v) {
+					break
+				}
+			}
+			return nil
+		}
+
+		k, v := c.Last()
+		if to != nil {
+			// Start from the last record before the end of the range
+			if k, v = c.Seek(to); k == nil {
+				k, v = c.Last()
+			}
+			for k != nil && bytes.Compare(k, to) >= 0 {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil && (from == nil || bytes.Compare(k, from) >= 0); k, v = c.Prev() {
+			if !fn(k, v) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// OpenStorage keeps the execution history, unprocessed webhook payloads and runtime state in the configured storage
+// backend, for each of them that doesn't have its own history_db, spool_dir or state_file.
+// It's meant to be called after those are opened.
+func (s *Server) OpenStorage(c StorageConfig) error {
+	store, err := NewStore(c)
+	if err != nil || store == nil {
+		return err
+	}
+	s.store = store
+	if s.historyDB == nil {
+		s.historyDB = newHistoryDB(store, time.Duration(s.config.HistoryRetention))
+	}
+	if s.spool == nil {
+		s.spool = newStoreSpool(store)
+	}
+	if s.stateFile == "" {
+		return s.loadStoredState()
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStorageConfig_Validate(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name    string
+		storage StorageConfig
+		ok      bool
+	}{
+		{name: "unset", storage: StorageConfig{}, ok: true},
+		{name: "memory", storage: StorageConfig{Backend: "memory"}, ok: true},
+		{name: "bolt", storage: StorageConfig{Backend: "Bolt", Path: "/var/lib/am-executor/store.db"}, ok: true},
+		{name: "no_backend", storage: StorageConfig{Path: "/var/lib/am-executor/store.db"}, ok: false},
+		{name: "no_path", storage: StorageConfig{Backend: "file"}, ok: false},
+		{name: "memory_path", storage: StorageConfig{Backend: "memory", Path: "/tmp/store.json"}, ok: false},
+		{name: "unknown", storage: StorageConfig{Backend: "sqlite", Path: "/tmp/store.sqlite"}, ok: false},
+	}
+
+	for _, tc := range cases {
+		if err := tc.storage.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Wrong validation result for %s; got %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+// tempStores returns a store of each backend, the last two in a new temporary directory, and a function to close
+// and remove them
+func tempStores(t *testing.T) (map[string]Store, func()) {
+	dir, err := ioutil.TempDir("", "am-executor_store-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Store{StorageMemory: NewMemoryStore()}
+	if stores[StorageFile], err = NewFileStore(filepath.Join(dir, "store.json")); err != nil {
+		t.Fatal(err)
+	}
+	if stores[StorageBolt], err = NewBoltStore(filepath.Join(dir, "store.db")); err != nil {
+		t.Fatal(err)
+	}
+	return stores, func() {
+		for _, store := range stores {
+			_ = store.Close()
+		}
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// scanKeys returns the keys of the records a scan of the store visits
+func scanKeys(t *testing.T, store Store, from string, to string, reverse bool) []string {
+	t.Helper()
+	var fromKey, toKey []byte
+	if from != "" {
+		fromKey = []byte(from)
+	}
+	if to != "" {
+		toKey = []byte(to)
+	}
+	keys := make([]string, 0)
+	err := store.Scan("fruit", fromKey, toKey, reverse, func(k []byte, _ []byte) bool {
+		keys = append(keys, string(k))
+		return len(keys) < 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	stores, cleanup := tempStores(t)
+	defer cleanup()
+
+	for backend, store := range stores {
+		for _, k := range []string{"d", "b", "a", "c", "e"} {
+			if err := store.Put("fruit", []byte(k), []byte("value-"+k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if v, err := store.Get("fruit", []byte("c")); err != nil || string(v) != "value-c" {
+			t.Errorf("Wrong value from %s store; got %q, %v, want %q", backend, v, err, "value-c")
+		}
+		if v, err := store.Get("vegetables", []byte("c")); err != nil || v != nil {
+			t.Errorf("Wrong value from %s store for a missing bucket; got %q, %v, want nil", backend, v, err)
+		}
+
+		// Scans stop after three records
+		cases := []struct {
+			name     string
+			from, to string
+			reverse  bool
+			want     []string
+		}{
+			{name: "forward", want: []string{"a", "b", "c"}},
+			{name: "reverse", reverse: true, want: []string{"e", "d", "c"}},
+			{name: "range", from: "b", to: "d", want: []string{"b", "c"}},
+			{name: "reverse_range", from: "b", to: "d", reverse: true, want: []string{"c", "b"}},
+			{name: "reverse_past_end", to: "z", reverse: true, want: []string{"e", "d", "c"}},
+		}
+		for _, tc := range cases {
+			if got := scanKeys(t, store, tc.from, tc.to, tc.reverse); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Wrong %s scan of %s store; got %v, want %v", tc.name, backend, got, tc.want)
+			}
+		}
+
+		if err := store.Delete("fruit", []byte("a"), []byte("b"), []byte("nope")); err != nil {
+			t.Fatal(err)
+		}
+		if got := scanKeys(t, store, "", "", false); !reflect.DeepEqual(got, []string{"c", "d", "e"}) {
+			t.Errorf("Wrong records in %s store after deleting; got %v", backend, got)
+		}
+	}
+}
+
+func TestFileStore_reopen(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor_store-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	f, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Put("fruit", []byte{0, 1}, []byte("banana")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := f.Get("fruit", []byte{0, 1}); err != nil || string(v) != "banana" {
+		t.Errorf("Wrong value after reopening file store; got %q, %v, want %q", v, err, "banana")
+	}
+}
+
+func TestServer_OpenStorage(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "am-executor_store-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := StorageConfig{Backend: StorageFile, Path: filepath.Join(dir, "store.json")}
+
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.OpenStorage(storage); err != nil {
+		t.Fatal(err)
+	}
+	srv.persistHistory(HistoryEntry{Execution: 1, Command: "echo", Started: time.Now()})
+	if _, err := srv.spool.AddRoute("/restart", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	srv.disabled.Set([]string{"restart"})
+	srv.saveState()
+
+	// Another server using the same storage picks up where the first one left off
+	srv, err = genServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.OpenStorage(storage); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := srv.historyDB.Query(HistoryQuery{}); err != nil || len(entries) != 1 {
+		t.Errorf("Wrong history from storage; got %v, %v, want 1 execution", entries, err)
+	}
+	if pending := srv.pendingSpooled(); len(pending) != 1 || pending[0].Route != "/restart" {
+		t.Errorf("Wrong spooled payloads from storage; got %v, want 1 for /restart", pending)
+	}
+	if names := srv.disabled.Names(); !reflect.DeepEqual(names, []string{"restart"}) {
+		t.Errorf("Wrong disabled commands from storage; got %v, want [restart]", names)
+	}
+}
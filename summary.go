@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// ExecutionSummary describes a finished execution, as given to the summary_template
+type ExecutionSummary struct {
+	// The command that ran, with its arguments
+	Command string
+	// Fingerprint of the alert the command ran for; empty if it didn't run for a particular alert
+	Fingerprint string
+	// Result of the execution, such as Ok, Fail, or SigOk|Fail for a command that failed after being signalled
+	Result string
+	// How long the execution took
+	Duration time.Duration
+	// Exit code of the command's last attempt; empty if it didn't exit, such as when it couldn't be started
+	ExitCode string
+	// The last lines of the command's output, if they're kept for an annotation
+	Output []string
+}
+
+// ParseSummaryTemplate returns the template that a summary of each execution is logged with,
+// or nil if no summary_template is configured.
+func (c *Config) ParseSummaryTemplate() (*texttemplate.Template, error) {
+	if c.SummaryTemplate == "" {
+		return nil, nil
+	}
+	return texttemplate.New("summary").Option("missingkey=error").Parse(c.SummaryTemplate)
+}
+
+// Render returns the summary as formatted by the template, on one line.
+// Newlines are replaced with spaces, so that each summary stays on one line for log pipelines to parse.
+func (e ExecutionSummary) Render(t *texttemplate.Template) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, e); err != nil {
+		return "", err
+	}
+	return strings.NewReplacer("\r\n", " ", "\n", " ").Replace(b.String()), nil
+}
+
+// logSummary logs a one-line summary of a finished execution at info level, if a summary template is configured
+func (s *Server) logSummary(summary ExecutionSummary) {
+	if s.summary == nil {
+		return
+	}
+
+	line, err := summary.Render(s.summary)
+	if err != nil {
+		logger.Warn("Failed to render execution summary", Fields{"command": summary.Command, "error": err})
+		return
+	}
+	logger.Info(line, nil)
+}
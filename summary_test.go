@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExecutionSummary_Render(t *testing.T) {
+	summary := ExecutionSummary{Command: "/usr/local/bin/restart-service", Fingerprint: "abc", Result: "Fail", Duration: 1500 * time.Millisecond, ExitCode: "2"}
+	cases := []struct {
+		name     string
+		template string
+		line     string
+		err      bool
+	}{
+		{
+			name:     "fields",
+			template: `cmd={{.Command}} fp={{.Fingerprint}} result={{.Result}} duration={{.Duration.Seconds}} exit={{.ExitCode}}`,
+			line:     "cmd=/usr/local/bin/restart-service fp=abc result=Fail duration=1.5 exit=2",
+		},
+		{
+			name:     "multi_line",
+			template: "{{.Result}}\n{{.Duration}}",
+			line:     "Fail 1.5s",
+		},
+		{
+			name:     "unknown_field",
+			template: "{{.Host}}",
+			err:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tmpl, err := (&Config{SummaryTemplate: tc.template}).ParseSummaryTemplate()
+			if err != nil {
+				t.Fatalf("Failed to parse template %q: %v", tc.template, err)
+			}
+			line, err := summary.Render(tmpl)
+			if tc.err {
+				if err == nil {
+					t.Errorf("Expected an error rendering %q", tc.template)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to render %q: %v", tc.template, err)
+			}
+			if line != tc.line {
+				t.Errorf("Wrong summary; got %q, want %q", line, tc.line)
+			}
+		})
+	}
+}
+
+func TestConfig_ParseSummaryTemplate(t *testing.T) {
+	t.Parallel()
+	if tmpl, err := (&Config{}).ParseSummaryTemplate(); tmpl != nil || err != nil {
+		t.Errorf("Expected no template when none is configured; got %v, %v", tmpl, err)
+	}
+	if _, err := (&Config{SummaryTemplate: "{{.Command"}).ParseSummaryTemplate(); err == nil {
+		t.Errorf("Expected an error parsing an invalid template")
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var (
+	suppressionCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "command",
+		Name:      "suppressed_total",
+		Help:      "Total number of firing notifications that commands were skipped for, because they succeeded for the alert within their suppress_after_success window.",
+	}
+)
+
+// recordSuccess starts a command's suppress_after_success window for an alert, if the execution for it succeeded
+func (s *Server) recordSuccess(cmd *Command, fingerprint string, result Result) {
+	if fingerprint == "" || cmd.SuppressAfterSuccess <= 0 || !result.Has(CmdOk) {
+		return
+	}
+	s.successes.Record(cmd.MetricLabel(), fingerprint, time.Duration(cmd.SuppressAfterSuccess))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"time"
+)
+
+var (
+	syncTimeoutCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "sync_timeouts_total",
+		Help:      "Total number of webhook requests that were responded to before their commands finished, because they ran longer than sync_timeout.",
+	}
+	lateFailureCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "late_failures_total",
+		Help:      "Total number of webhook requests whose commands failed after the request was responded to, because of sync_timeout.",
+	}
+)
+
+// handleMessageWithin handles an alert message, waiting up to timeout for it to be handled.
+// If it isn't handled in time, late is true, and done is called with the errors from handling it once it is.
+// Otherwise, the errors are returned, and done isn't called.
+func (s *Server) handleMessageWithin(timeout time.Duration, amMsg *template.Data, route string, progress progressFunc, span *Span, done func(errors []error)) (errors []error, late bool) {
+	result := make(chan []error, 1)
+	go func() {
+		result <- s.handleMessage(amMsg, route, progress, span)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case errors = <-result:
+		return errors, false
+	case <-t.C:
+	}
+
+	s.syncTimeouts.Inc()
+	logger.Decision("Responding before commands finished, because they ran longer than sync_timeout", Fields{"sync_timeout": Duration(timeout)})
+	go func() {
+		errors := <-result
+		if len(errors) > 0 {
+			// There's no caller left to report them to, so they're only logged and counted
+			logger.Error("Commands failed after the webhook request was responded to", Fields{"error": concatErrors(errors...)})
+			s.lateFailures.Inc()
+		}
+		done(errors)
+	}()
+	return nil, true
+}
+
+// writeAccepted responds to a webhook request whose commands are still running in the background
+func writeAccepted(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	if _, err := w.Write([]byte("Commands are still running\n")); err != nil {
+		logger.Error("Failed to write response", Fields{"error": err})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	pm "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_handleWebhook_syncTimeout(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.SyncTimeout = Duration(50 * time.Millisecond)
+	srv.config.Commands = []*Command{{Cmd: "sh", Args: []string{"-c", "sleep 0.5; false"}}}
+
+	trigger, err := json.Marshal(&amDataFinger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	began := time.Now()
+	srv.handleWebhook(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(trigger)))
+	if elapsed := time.Since(began); elapsed >= 500*time.Millisecond {
+		t.Errorf("Response should be sent at the sync_timeout; took %s", elapsed)
+	}
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Wrong status for commands still running; got %d, want %d", w.Code, http.StatusAccepted)
+	}
+
+	counter := func(c interface{ Write(*pm.Metric) error }) float64 {
+		var m pm.Metric
+		if err := c.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	if got := counter(srv.syncTimeouts); got != 1 {
+		t.Errorf("Wrong number of sync timeouts; got %v, want 1", got)
+	}
+	// The command fails in the background once it's done
+	deadline := time.Now().Add(5 * time.Second)
+	for counter(srv.lateFailures) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Late failure wasn't counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Commands that finish in time are responded to as usual
+	srv.config.Commands = []*Command{{Cmd: "false"}}
+	w = httptest.NewRecorder()
+	srv.handleWebhook(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(trigger)))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status for command that failed in time; got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
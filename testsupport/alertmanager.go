@@ -0,0 +1,171 @@
+// Package testsupport helps write integration tests against a running prometheus-am-executor.
+// Alertmanager sends it webhook notifications the way alertmanager does, and Recorder provides a command for it to run
+// that records how it was run, so that tests can check which commands ran with which environment, and which signals
+// they were sent.
+package testsupport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// Version of alertmanager's webhook payload schema that notifications are sent in
+	payloadVersion = "4"
+	// How long to wait for the executor to respond to a notification, when the client isn't set
+	notifyTimeout = 30 * time.Second
+)
+
+// message is the body of a webhook notification, as alertmanager sends it
+type message struct {
+	*template.Data
+	Version  string `json:"version"`
+	GroupKey string `json:"groupKey"`
+}
+
+// Alertmanager sends webhook notifications about alerts to an executor
+type Alertmanager struct {
+	// URL of the executor's webhook endpoint
+	URL string
+	// Receiver that notifications are sent for, and alertmanager's own URL
+	Receiver    string
+	ExternalURL string
+	// Headers added to each notification, such as for authentication
+	Header http.Header
+	// Client that notifications are sent with
+	Client *http.Client
+}
+
+// NewAlertmanager returns an Alertmanager that notifies the executor webhook at the given URL
+func NewAlertmanager(url string) *Alertmanager {
+	return &Alertmanager{
+		URL:         url,
+		Receiver:    "am-executor",
+		ExternalURL: "http://alertmanager.test:9093",
+		Header:      make(http.Header),
+		Client:      &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+// Alert returns a firing alert with the given labels, which started now.
+// Its fingerprint is derived from its labels, so alerts with the same labels have the same fingerprint.
+func Alert(labels map[string]string) template.Alert {
+	return template.Alert{
+		Status:      "firing",
+		Labels:      template.KV(labels),
+		Annotations: template.KV{},
+		StartsAt:    time.Now(),
+		Fingerprint: Fingerprint(labels),
+	}
+}
+
+// Fingerprint returns a fingerprint identifying alerts with the given labels
+func Fingerprint(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := fnv.New64a()
+	for _, name := range names {
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write([]byte{255})
+		_, _ = h.Write([]byte(labels[name]))
+		_, _ = h.Write([]byte{255})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// Fire sends a notification that the alerts are firing.
+// Returns an error if it can't be sent, or the executor doesn't respond with a 2xx status, as when a command fails.
+func (am *Alertmanager) Fire(alerts ...template.Alert) error {
+	for i := range alerts {
+		alerts[i].Status = "firing"
+	}
+	return am.Notify("firing", alerts...)
+}
+
+// Resolve sends a notification that the alerts resolved, ending now unless they already have an end time
+func (am *Alertmanager) Resolve(alerts ...template.Alert) error {
+	for i := range alerts {
+		alerts[i].Status = "resolved"
+		if alerts[i].EndsAt.IsZero() {
+			alerts[i].EndsAt = time.Now()
+		}
+	}
+	return am.Notify("resolved", alerts...)
+}
+
+// Notify sends a notification about a group of alerts with the given status, as alertmanager groups them:
+// their common labels and annotations are the ones that all of them share.
+func (am *Alertmanager) Notify(status string, alerts ...template.Alert) error {
+	data := &template.Data{
+		Receiver:          am.Receiver,
+		Status:            status,
+		Alerts:            alerts,
+		GroupLabels:       template.KV{},
+		CommonLabels:      common(alerts, func(a template.Alert) template.KV { return a.Labels }),
+		CommonAnnotations: common(alerts, func(a template.Alert) template.KV { return a.Annotations }),
+		ExternalURL:       am.ExternalURL,
+	}
+	if name, ok := data.CommonLabels["alertname"]; ok {
+		data.GroupLabels["alertname"] = name
+	}
+	body, err := json.Marshal(message{Data: data, Version: payloadVersion, GroupKey: fmt.Sprintf("{}:%v", data.GroupLabels)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, am.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range am.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := am.Client
+	if client == nil {
+		client = &http.Client{Timeout: notifyTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response to %s notification: %s: %s", status, resp.Status, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
+
+// common returns the key/value pairs that all the alerts have in common
+func common(alerts []template.Alert, kv func(template.Alert) template.KV) template.KV {
+	shared := template.KV{}
+	if len(alerts) == 0 {
+		return shared
+	}
+	for k, v := range kv(alerts[0]) {
+		shared[k] = v
+	}
+	for _, a := range alerts[1:] {
+		other := kv(a)
+		for k, v := range shared {
+			if other[k] != v {
+				delete(shared, k)
+			}
+		}
+	}
+	return shared
+}
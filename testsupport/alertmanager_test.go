@@ -0,0 +1,69 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"github.com/prometheus/alertmanager/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+	a := Fingerprint(map[string]string{"alertname": "Disk", "instance": "a"})
+	b := Fingerprint(map[string]string{"instance": "a", "alertname": "Disk"})
+	c := Fingerprint(map[string]string{"alertname": "Disk", "instance": "b"})
+	if a != b {
+		t.Errorf("Expected same labels to have the same fingerprint, got %s and %s", a, b)
+	}
+	if a == c {
+		t.Errorf("Expected different labels to have different fingerprints, both got %s", a)
+	}
+}
+
+func TestAlertmanager_Fire(t *testing.T) {
+	t.Parallel()
+	var received message
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(req.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	am := NewAlertmanager(ts.URL)
+	a := Alert(map[string]string{"alertname": "Disk", "instance": "a"})
+	b := Alert(map[string]string{"alertname": "Disk", "instance": "b"})
+	if err := am.Fire(a, b); err == nil {
+		t.Fatal("Expected an error for an unauthorized notification")
+	}
+
+	am.Header.Set("Authorization", "Bearer secret")
+	if err := am.Fire(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if received.Version != payloadVersion || received.Status != "firing" || len(received.Alerts) != 2 {
+		t.Fatalf("Unexpected notification received: %+v", received)
+	}
+	if expected := (template.KV{"alertname": "Disk"}); !reflect.DeepEqual(received.CommonLabels, expected) {
+		t.Errorf("Expected common labels %v, got %v", expected, received.CommonLabels)
+	}
+	if expected := (template.KV{"alertname": "Disk"}); !reflect.DeepEqual(received.GroupLabels, expected) {
+		t.Errorf("Expected group labels %v, got %v", expected, received.GroupLabels)
+	}
+	if received.Alerts[0].Fingerprint != a.Fingerprint {
+		t.Errorf("Expected fingerprint %s, got %s", a.Fingerprint, received.Alerts[0].Fingerprint)
+	}
+
+	if err := am.Resolve(a); err != nil {
+		t.Fatal(err)
+	}
+	if received.Status != "resolved" || received.Alerts[0].Status != "resolved" || received.Alerts[0].EndsAt.IsZero() {
+		t.Errorf("Expected a resolved alert with an end time, got %+v", received.Alerts[0])
+	}
+}
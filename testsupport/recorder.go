@@ -0,0 +1,268 @@
+package testsupport
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// Name of the script that a Recorder writes into its directory
+	recorderScript = "record.sh"
+	// Default for how long Expect methods wait for what they expect to happen
+	defaultExpectTimeout = 5 * time.Second
+	// How often Expect methods check for what they expect
+	expectInterval = 10 * time.Millisecond
+)
+
+// recorderTemplate is a shell script that records its arguments and environment to files named after its PID,
+// records signals it receives, and keeps running for as long as its first argument says, in seconds.
+// It exits with the status given by its second argument, or 0.
+// TERM, INT and HUP make it exit after recording them, as a command would; USR1 and USR2 are only recorded.
+const recorderTemplate = `#!/bin/sh
+out=%s/$$
+printf '%%s\n' "$@" > "$out.args"
+env > "$out.env.tmp" && mv "$out.env.tmp" "$out.env"
+record() { echo "$1" >> "$out.signals"; }
+trap 'record TERM; kill "$child" 2>/dev/null; exit 143' TERM
+trap 'record INT; kill "$child" 2>/dev/null; exit 130' INT
+trap 'record HUP; kill "$child" 2>/dev/null; exit 129' HUP
+trap 'record USR1' USR1
+trap 'record USR2' USR2
+sleep "${1:-0}" &
+child=$!
+while kill -0 "$child" 2>/dev/null; do
+  wait "$child"
+done
+exit "${2:-0}"
+`
+
+// Execution describes one run of a Recorder's script
+type Execution struct {
+	PID  int
+	Args []string
+	Env  map[string]string
+	// Names of the signals that the script received, such as TERM, in the order it received them
+	Signals []string
+}
+
+// Recorder provides a script for an executor to run in place of a command, which records how it was run.
+// Configure a command with Recorder.Path as its cmd, optionally with how many seconds it should keep running for and
+// its exit status as args, then use the Expect methods to check how it was run.
+type Recorder struct {
+	// Directory that the script and its records are kept in
+	Dir string
+	// Path to the script
+	Path string
+	// How long Expect methods wait for what they expect to happen
+	Timeout time.Duration
+}
+
+// NewRecorder writes a recording script into the given directory, such as one from ioutil.TempDir,
+// and returns a Recorder for it.
+func NewRecorder(dir string) (*Recorder, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, recorderScript)
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf(recorderTemplate, shellQuote(dir))), 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{Dir: dir, Path: path, Timeout: defaultExpectTimeout}, nil
+}
+
+// Executions returns the script's runs so far, ordered by PID.
+// Runs are included once they've recorded their environment.
+func (r *Recorder) Executions() ([]Execution, error) {
+	paths, err := filepath.Glob(filepath.Join(r.Dir, "*.env"))
+	if err != nil {
+		return nil, err
+	}
+	executions := make([]Execution, 0, len(paths))
+	for _, path := range paths {
+		base := strings.TrimSuffix(path, ".env")
+		pid, err := strconv.Atoi(filepath.Base(base))
+		if err != nil {
+			continue
+		}
+		e := Execution{PID: pid}
+		if e.Env, err = readEnv(path); err != nil {
+			return nil, err
+		}
+		if e.Args, err = readLines(base + ".args"); err != nil {
+			return nil, err
+		}
+		if e.Signals, err = readLines(base + ".signals"); err != nil {
+			return nil, err
+		}
+		executions = append(executions, e)
+	}
+	sort.Slice(executions, func(i, j int) bool { return executions[i].PID < executions[j].PID })
+	return executions, nil
+}
+
+// Find returns the runs so far whose environment has all of the given variables, with the given values
+func (r *Recorder) Find(env map[string]string) ([]Execution, error) {
+	executions, err := r.Executions()
+	if err != nil {
+		return nil, err
+	}
+	var found []Execution
+	for _, e := range executions {
+		if e.hasEnv(env) {
+			found = append(found, e)
+		}
+	}
+	return found, nil
+}
+
+// ExpectExecuted waits for the script to run with all of the given environment variables, and returns that run.
+// The test fails if it doesn't run that way before the timeout.
+func (r *Recorder) ExpectExecuted(t testing.TB, env map[string]string) Execution {
+	t.Helper()
+	var found []Execution
+	ok := r.poll(t, func() bool {
+		found = r.find(t, env)
+		return len(found) > 0
+	})
+	if !ok {
+		t.Fatalf("Expected command to be executed with env %v, within %s; executions were %v", env, r.timeout(), r.executions(t))
+	}
+	return found[0]
+}
+
+// ExpectNotExecuted waits until the timeout, and fails the test if the script ran with all of the given environment
+// variables in that time.
+func (r *Recorder) ExpectNotExecuted(t testing.TB, env map[string]string) {
+	t.Helper()
+	if r.poll(t, func() bool { return len(r.find(t, env)) > 0 }) {
+		t.Fatalf("Expected command not to be executed with env %v; executions were %v", env, r.find(t, env))
+	}
+}
+
+// ExpectSignal waits for a run of the script with all of the given environment variables to receive the named
+// signal, such as TERM. The test fails if none of them does before the timeout.
+func (r *Recorder) ExpectSignal(t testing.TB, env map[string]string, signal string) Execution {
+	t.Helper()
+	signal = strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+	var signalled Execution
+	ok := r.poll(t, func() bool {
+		for _, e := range r.find(t, env) {
+			for _, s := range e.Signals {
+				if s == signal {
+					signalled = e
+					return true
+				}
+			}
+		}
+		return false
+	})
+	if !ok {
+		t.Fatalf("Expected command executed with env %v to receive signal %s, within %s; executions were %v", env, signal, r.timeout(), r.find(t, env))
+	}
+	return signalled
+}
+
+// poll calls check until it returns true, or the timeout passes.
+// Returns whether check returned true.
+func (r *Recorder) poll(t testing.TB, check func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(r.timeout())
+	for {
+		if check() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(expectInterval)
+	}
+}
+
+// find returns the runs with the given environment, failing the test if the records can't be read
+func (r *Recorder) find(t testing.TB, env map[string]string) []Execution {
+	t.Helper()
+	found, err := r.Find(env)
+	if err != nil {
+		t.Fatalf("Can't read executions from %s: %v", r.Dir, err)
+	}
+	return found
+}
+
+// executions returns all the runs, failing the test if the records can't be read
+func (r *Recorder) executions(t testing.TB) []Execution {
+	t.Helper()
+	return r.find(t, nil)
+}
+
+// timeout returns how long Expect methods wait for
+func (r *Recorder) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return defaultExpectTimeout
+	}
+	return r.Timeout
+}
+
+// hasEnv returns true if the run's environment has all of the given variables, with the given values
+func (e Execution) hasEnv(env map[string]string) bool {
+	for k, v := range env {
+		if actual, ok := e.Env[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// shellQuote quotes a string as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// readEnv parses the output of env.
+// Lines without an = are continuations of multi-line values.
+func readEnv(path string) (map[string]string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string, len(lines))
+	var last string
+	for _, line := range lines {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			last = parts[0]
+			env[last] = parts[1]
+		} else if last != "" {
+			env[last] += "\n" + line
+		}
+	}
+	return env, nil
+}
+
+// readLines returns the lines in a file, or none if it doesn't exist
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,77 @@
+package testsupport
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tempRecorder returns a Recorder in a new temporary directory, and a function to remove it
+func tempRecorder(t *testing.T) (*Recorder, func()) {
+	dir, err := ioutil.TempDir("", "am-executor_recorder-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestRecorder_ExpectExecuted(t *testing.T) {
+	t.Parallel()
+	r, cleanup := tempRecorder(t)
+	defer cleanup()
+	r.Timeout = 200 * time.Millisecond
+
+	cmd := exec.Command(r.Path, "0", "3")
+	cmd.Env = append(os.Environ(), "AMX_STATUS=firing", "MULTI=one\ntwo")
+	if err := cmd.Run(); err == nil {
+		t.Error("Expected script to exit with the given status")
+	}
+
+	e := r.ExpectExecuted(t, map[string]string{"AMX_STATUS": "firing"})
+	if e.PID != cmd.Process.Pid {
+		t.Errorf("Expected PID %d, got %d", cmd.Process.Pid, e.PID)
+	}
+	if expected := []string{"0", "3"}; !reflect.DeepEqual(e.Args, expected) {
+		t.Errorf("Expected args %v, got %v", expected, e.Args)
+	}
+	if e.Env["MULTI"] != "one\ntwo" {
+		t.Errorf("Expected multi-line env value to be kept, got %q", e.Env["MULTI"])
+	}
+	r.ExpectNotExecuted(t, map[string]string{"AMX_STATUS": "resolved"})
+}
+
+func TestRecorder_ExpectSignal(t *testing.T) {
+	t.Parallel()
+	r, cleanup := tempRecorder(t)
+	defer cleanup()
+
+	cmd := exec.Command(r.Path, "30")
+	cmd.Env = append(os.Environ(), "AMX_ALERT_1_LABEL_instance=a")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"AMX_ALERT_1_LABEL_instance": "a"}
+	r.ExpectExecuted(t, env)
+
+	if err := cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	r.ExpectSignal(t, env, "SIGUSR1")
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	e := r.ExpectSignal(t, env, "term")
+	if expected := []string{"USR1", "TERM"}; !reflect.DeepEqual(e.Signals, expected) {
+		t.Errorf("Expected signals %v, got %v", expected, e.Signals)
+	}
+	_ = cmd.Wait()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	throttledCountOpts = prometheus.CounterOpts{
+		Namespace: metricNamespace,
+		Subsystem: "webhook",
+		Name:      "throttled_total",
+		Help:      "Total number of webhook requests that were answered with 429 Too Many Requests, because they exceeded webhook_rate_limit.",
+	}
+)
+
+// RequestLimiter limits how many webhook requests the server handles, across all callers
+type RequestLimiter struct {
+	bucket *tokenBucket
+	sync.Mutex
+}
+
+// NewRequestLimiter returns a RequestLimiter that allows rate requests a second, in bursts of up to burst requests.
+// The burst defaults to the rate, rounded up. Returns nil, which allows every request, if rate isn't positive.
+func NewRequestLimiter(rate float64, burst int) *RequestLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	period := time.Duration(float64(burst) / rate * float64(time.Second))
+	return &RequestLimiter{bucket: newTokenBucket(burst, period, time.Now())}
+}
+
+// Allow returns true if a request can be handled at the given time, taking a token for it.
+// Otherwise, it returns how long it will be until a request can be handled.
+func (l *RequestLimiter) Allow(now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	b := l.bucket
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * float64(b.period) / b.capacity)
+}
+
+// retryAfter returns the value of a Retry-After header for a wait, in whole seconds of at least one
+func retryAfter(wait time.Duration) string {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	pm "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiter_Allow(t *testing.T) {
+	t.Parallel()
+	if ok, _ := (*RequestLimiter)(nil).Allow(time.Now()); !ok {
+		t.Error("A nil limiter should allow every request")
+	}
+	if l := NewRequestLimiter(0, 5); l != nil {
+		t.Error("A limiter without a rate shouldn't be created")
+	}
+
+	l := NewRequestLimiter(2, 0)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(now); !ok {
+			t.Fatalf("Request %d of the burst should be allowed", i)
+		}
+	}
+	ok, wait := l.Allow(now)
+	if ok || wait <= 0 || wait > 500*time.Millisecond {
+		t.Errorf("Request beyond the burst should wait for up to half a second; got %v, %s", ok, wait)
+	}
+	if ok, _ := l.Allow(now.Add(wait)); !ok {
+		t.Error("Request should be allowed once the wait has passed")
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	t.Parallel()
+	cases := map[time.Duration]string{0: "1", 200 * time.Millisecond: "1", time.Second: "1", 1500 * time.Millisecond: "2"}
+	for wait, want := range cases {
+		if got := retryAfter(wait); got != want {
+			t.Errorf("Wrong Retry-After for %s; got %s, want %s", wait, got, want)
+		}
+	}
+}
+
+func TestServer_handleWebhook_throttled(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.requestLimiter = NewRequestLimiter(0.1, 1)
+
+	codes := make([]int, 0, 2)
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		srv.handleWebhook(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"status":"firing","alerts":[]}`)))
+		codes = append(codes, w.Code)
+	}
+	if codes[0] == http.StatusTooManyRequests || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("Only the second request should be throttled; got %v", codes)
+	}
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Wrong Retry-After header; got %q, want %q", got, "10")
+	}
+
+	var m pm.Metric
+	if err := srv.throttledCounter.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Wrong number of throttled requests; got %v, want 1", got)
+	}
+}
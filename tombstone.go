@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// tombstone records that an alert fingerprint resolved
+type tombstone struct {
+	// When the alert ended, according to the resolved notification
+	endsAt time.Time
+	// When the tombstone stops suppressing firing notifications
+	expires time.Time
+}
+
+// Tombstones remembers alert fingerprints that resolved recently, so that firing notifications for them
+// that were delivered out of order, after the resolved notification, can be suppressed.
+type Tombstones struct {
+	entries map[string]tombstone
+	sync.Mutex
+}
+
+// NewTombstones returns a Tombstones instance
+func NewTombstones() *Tombstones {
+	return &Tombstones{entries: make(map[string]tombstone)}
+}
+
+// Add records that the alert with the fingerprint resolved at endsAt, suppressing it until ttl has passed.
+// Expired tombstones are removed, so they don't accumulate over long uptimes.
+func (t *Tombstones) Add(fingerprint string, endsAt time.Time, ttl time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	for fp, ts := range t.entries {
+		if !now.Before(ts.expires) {
+			delete(t.entries, fp)
+		}
+	}
+	t.entries[fingerprint] = tombstone{endsAt: endsAt, expires: now.Add(ttl)}
+}
+
+// Suppressed returns true if a firing notification for the fingerprint, for an alert that started at startsAt,
+// is a late delivery for an alert that has already resolved.
+// Alerts that started after the resolved one ended are firing again, so they aren't suppressed.
+func (t *Tombstones) Suppressed(fingerprint string, startsAt time.Time) bool {
+	t.Lock()
+	defer t.Unlock()
+	ts, ok := t.entries[fingerprint]
+	if !ok || !time.Now().Before(ts.expires) {
+		return false
+	}
+	return ts.endsAt.IsZero() || startsAt.IsZero() || !startsAt.After(ts.endsAt)
+}
+
+// Len returns the number of tombstones, including expired ones that haven't been removed yet
+func (t *Tombstones) Len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.entries)
+}
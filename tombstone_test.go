@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"testing"
+	"time"
+)
+
+func TestTombstones_Suppressed(t *testing.T) {
+	endsAt := time.Now()
+	cases := []struct {
+		name        string
+		fingerprint string
+		startsAt    time.Time
+		ttl         time.Duration
+		want        bool
+	}{
+		{name: "late_firing", fingerprint: "boop", startsAt: endsAt.Add(-time.Minute), ttl: time.Minute, want: true},
+		{name: "no_start", fingerprint: "boop", ttl: time.Minute, want: true},
+		{name: "firing_again", fingerprint: "boop", startsAt: endsAt.Add(time.Second), ttl: time.Minute},
+		{name: "other_fingerprint", fingerprint: "beep", startsAt: endsAt.Add(-time.Minute), ttl: time.Minute},
+		{name: "expired", fingerprint: "boop", startsAt: endsAt.Add(-time.Minute), ttl: -time.Second},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ts := NewTombstones()
+			ts.Add("boop", endsAt, tc.ttl)
+			if got := ts.Suppressed(tc.fingerprint, tc.startsAt); got != tc.want {
+				t.Errorf("Wrong suppression; got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTombstones_Add_prunes(t *testing.T) {
+	t.Parallel()
+	ts := NewTombstones()
+	ts.Add("boop", time.Now(), -time.Second)
+	ts.Add("beep", time.Now(), time.Minute)
+	if n := ts.Len(); n != 1 {
+		t.Errorf("Expired tombstone wasn't removed; got %d tombstones, want 1", n)
+	}
+}
+
+func TestServer_amResolved_tombstone(t *testing.T) {
+	srv, err := genServer()
+	if err != nil {
+		t.Fatal("Failed to generate server")
+	}
+	srv.config.ResolveTombstone = Duration(time.Minute)
+	cmd := srv.config.Commands[0]
+
+	startsAt := time.Now().Add(-time.Hour)
+	resolved := &template.Data{
+		Status: "resolved",
+		Alerts: template.Alerts{{Status: "resolved", Fingerprint: "boop", StartsAt: startsAt, EndsAt: time.Now()}},
+	}
+	srv.amResolved(resolved)
+
+	late := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Fingerprint: "boop", StartsAt: startsAt}},
+	}
+	if ok, reason := srv.CanRun(cmd, late); ok || reason != CmdRunTombstoned {
+		t.Errorf("Late firing notification wasn't suppressed; got %v, %s", ok, reason.Label())
+	}
+
+	again := &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{{Status: "firing", Fingerprint: "boop", StartsAt: time.Now().Add(time.Second)}},
+	}
+	if ok, reason := srv.CanRun(cmd, again); !ok {
+		t.Errorf("Alert firing again was suppressed; got %s", reason.Label())
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often finished spans are exported, if there are any
+	traceExportInterval = 5 * time.Second
+	// How long to wait for the collector to accept spans
+	traceExportTimeout = 10 * time.Second
+	// The most finished spans held for export; spans finishing while this many are waiting are dropped
+	maxPendingSpans = 2048
+	// Name that spans are reported under, when not configured otherwise
+	defaultTraceServiceName = "prometheus-am-executor"
+
+	// Span kinds and status codes, as defined by OTLP
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanStatusOk     = 1
+	spanStatusError  = 2
+)
+
+// TracingConfig configures exporting traces of webhook handling and command execution to an OpenTelemetry collector
+type TracingConfig struct {
+	// URL of an OTLP/HTTP traces endpoint, such as http://localhost:4318/v1/traces.
+	// Traces aren't recorded if this isn't set.
+	Endpoint string `yaml:"endpoint"`
+	// Name of the service that spans are reported under. Defaults to prometheus-am-executor.
+	ServiceName string `yaml:"service_name"`
+	// Headers sent along with exported spans, such as for authenticating with a hosted collector
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Tracer records spans, and exports them in batches to an OpenTelemetry collector using OTLP/HTTP's JSON encoding.
+// Methods of a nil Tracer do nothing, so that callers don't need to check whether tracing is enabled.
+type Tracer struct {
+	config  TracingConfig
+	client  *http.Client
+	pending []*Span
+	sync.Mutex
+}
+
+// Span is an operation that's part of a trace, such as handling a webhook or running a command.
+// Methods of a nil Span do nothing.
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    Fields
+	err      error
+	sync.Mutex
+}
+
+// NewTracer returns a tracer exporting spans to the configured endpoint, or nil if no endpoint is configured
+func NewTracer(config TracingConfig) *Tracer {
+	if config.Endpoint == "" {
+		return nil
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultTraceServiceName
+	}
+	return &Tracer{config: config, client: &http.Client{Timeout: traceExportTimeout}}
+}
+
+// Start starts a span at the root of a new trace
+func (t *Tracer) Start(name string, attrs Fields) *Span {
+	return t.StartRemote(name, "", attrs)
+}
+
+// StartRemote starts a span continuing the trace described by a W3C traceparent header,
+// so that an executor called by a traced system shows up in that system's traces.
+// A new trace is started if the header is empty or invalid.
+func (t *Tracer) StartRemote(name string, traceparent string, attrs Fields) *Span {
+	if t == nil {
+		return nil
+	}
+
+	sp := t.newSpan(name, spanKindServer, attrs)
+	if traceID, parentID, ok := parseTraceParent(traceparent); ok {
+		sp.traceID = traceID
+		sp.parentID = parentID
+	} else {
+		_, _ = rand.Read(sp.traceID[:])
+	}
+	return sp
+}
+
+// Child starts a span for an operation that's part of this span's operation
+func (sp *Span) Child(name string, attrs Fields) *Span {
+	if sp == nil {
+		return nil
+	}
+
+	child := sp.tracer.newSpan(name, spanKindInternal, attrs)
+	child.traceID = sp.traceID
+	child.parentID = sp.spanID
+	return child
+}
+
+// newSpan returns a started span with a new ID
+func (t *Tracer) newSpan(name string, kind int, attrs Fields) *Span {
+	sp := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: make(Fields, len(attrs))}
+	_, _ = rand.Read(sp.spanID[:])
+	for k, v := range attrs {
+		sp.attrs[k] = v
+	}
+	return sp
+}
+
+// SetAttr sets an attribute describing the span's operation
+func (sp *Span) SetAttr(key string, value interface{}) {
+	if sp == nil {
+		return
+	}
+	sp.Lock()
+	defer sp.Unlock()
+	sp.attrs[key] = value
+}
+
+// End finishes the span, marking it as failed if err isn't nil, and queues it for export.
+// Spans that are already finished aren't changed.
+func (sp *Span) End(err error) {
+	if sp == nil {
+		return
+	}
+	sp.Lock()
+	if !sp.end.IsZero() {
+		sp.Unlock()
+		return
+	}
+	sp.end = time.Now()
+	sp.err = err
+	sp.Unlock()
+
+	sp.tracer.finish(sp)
+}
+
+// TraceParent returns a W3C traceparent header value identifying the span,
+// which commands can use to add their own spans to the trace.
+func (sp *Span) TraceParent() string {
+	if sp == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sp.traceID[:]), hex.EncodeToString(sp.spanID[:]))
+}
+
+// parseTraceParent returns the trace and parent span IDs of a W3C traceparent header value
+func parseTraceParent(header string) (traceID [16]byte, parentID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == [16]byte{} {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID == [8]byte{} {
+		return traceID, parentID, false
+	}
+	return traceID, parentID, true
+}
+
+// finish queues a finished span for export, dropping it if too many spans are waiting already
+func (t *Tracer) finish(sp *Span) {
+	t.Lock()
+	defer t.Unlock()
+	if len(t.pending) >= maxPendingSpans {
+		logger.Debug("Dropping span, because too many are waiting to be exported", Fields{"span": sp.name})
+		return
+	}
+	t.pending = append(t.pending, sp)
+}
+
+// Flush exports the spans that have finished, logging any failure to do so
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := t.export(spans); err != nil {
+		logger.Warn("Failed to export spans", Fields{"endpoint": t.config.Endpoint, "spans": len(spans), "error": err})
+	}
+}
+
+// exportEvery exports finished spans periodically.
+// It is meant to be called as a goroutine.
+func (t *Tracer) exportEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		t.Flush()
+	}
+}
+
+// export sends spans to the collector
+func (t *Tracer) export(spans []*Span) error {
+	data, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", t.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response from collector: %s", resp.Status)
+	}
+	return nil
+}
+
+// The parts of an OTLP/HTTP trace export request that the executor uses
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// request returns an export request for spans
+func (t *Tracer) request(spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, sp := range spans {
+		encoded[i] = sp.otlp()
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(Fields{"service.name": t.config.ServiceName, "service.version": version})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: defaultTraceServiceName, Version: version}, Spans: encoded}},
+	}}}
+}
+
+// otlp returns the span as it's encoded in export requests
+func (sp *Span) otlp() otlpSpan {
+	sp.Lock()
+	defer sp.Unlock()
+	encoded := otlpSpan{
+		TraceID:    hex.EncodeToString(sp.traceID[:]),
+		SpanID:     hex.EncodeToString(sp.spanID[:]),
+		Name:       sp.name,
+		Kind:       sp.kind,
+		Start:      strconv.FormatInt(sp.start.UnixNano(), 10),
+		End:        strconv.FormatInt(sp.end.UnixNano(), 10),
+		Attributes: otlpAttributes(sp.attrs),
+		Status:     otlpStatus{Code: spanStatusOk},
+	}
+	if sp.parentID != [8]byte{} {
+		encoded.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+	}
+	if sp.err != nil {
+		encoded.Status = otlpStatus{Code: spanStatusError, Message: sp.err.Error()}
+	}
+	return encoded
+}
+
+// otlpAttributes returns fields as OTLP attributes, sorted by name.
+// Values that aren't numbers or booleans are encoded as strings.
+func otlpAttributes(fields Fields) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(fields))
+	for k, v := range fields {
+		var value map[string]interface{}
+		switch val := fieldValue(v).(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": val}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(val)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+		case uint64:
+			value = map[string]interface{}{"intValue": strconv.FormatUint(val, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": val}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+		}
+		attrs = append(attrs, otlpAttribute{Key: k, Value: value})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
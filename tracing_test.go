@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parseTraceParent(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		trace  string
+		parent string
+		ok     bool
+	}{
+		{name: "valid", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", trace: "4bf92f3577b34da6a3ce929d0e0e4736", parent: "00f067aa0ba902b7", ok: true},
+		{name: "empty", header: "", ok: false},
+		{name: "invalid_version", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: false},
+		{name: "zero_trace", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", ok: false},
+		{name: "short_parent", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", ok: false},
+		{name: "not_hex", header: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", ok: false},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			trace, parent, ok := parseTraceParent(tc.header)
+			if ok != tc.ok {
+				t.Fatalf("Wrong result parsing %q; got %v, want %v", tc.header, ok, tc.ok)
+			}
+			if !ok {
+				return
+			}
+			if got := hex.EncodeToString(trace[:]); got != tc.trace {
+				t.Errorf("Wrong trace ID; got %s, want %s", got, tc.trace)
+			}
+			if got := hex.EncodeToString(parent[:]); got != tc.parent {
+				t.Errorf("Wrong parent span ID; got %s, want %s", got, tc.parent)
+			}
+		})
+	}
+}
+
+func TestNewTracer(t *testing.T) {
+	t.Parallel()
+	if tracer := NewTracer(TracingConfig{}); tracer != nil {
+		t.Errorf("Tracer shouldn't be created without an endpoint")
+	}
+
+	// Spans of a nil tracer do nothing, rather than panicking
+	var tracer *Tracer
+	span := tracer.Start("webhook", nil)
+	span.Child("execute", nil).End(nil)
+	span.SetAttr("key", "value")
+	span.End(nil)
+	tracer.Flush()
+}
+
+func TestServer_handleWebhookTracing(t *testing.T) {
+	t.Parallel()
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var r otlpRequest
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			t.Errorf("Failed to decode export request: %v", err)
+		}
+		requests <- r
+	}))
+	defer collector.Close()
+
+	// The command only succeeds if it's given the trace context
+	cmd := &Command{Cmd: "sh", Args: []string{"-c", "test -n \"$TRACEPARENT\""}}
+	srv := NewServer(&Config{Commands: []*Command{cmd}, Tracing: TracingConfig{Endpoint: collector.URL, ServiceName: "executor"}})
+
+	payload := `{"version":"4","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"HighLoad"},"fingerprint":"boop"}]}`
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString(payload))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	srv.handleWebhook(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong response from handleWebhook; got %d, want %d", w.Code, http.StatusOK)
+	}
+	srv.tracer.Flush()
+
+	r := <-requests
+	if len(r.ResourceSpans) != 1 || len(r.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Wrong structure of export request: %+v", r)
+	}
+	var service string
+	for _, attr := range r.ResourceSpans[0].Resource.Attributes {
+		if attr.Key == "service.name" {
+			service, _ = attr.Value["stringValue"].(string)
+		}
+	}
+	if service != "executor" {
+		t.Errorf("Wrong service name; got %q, want %q", service, "executor")
+	}
+
+	spans := make(map[string]otlpSpan)
+	for _, sp := range r.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[sp.Name] = sp
+		if sp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Span %s isn't part of the caller's trace; got trace %s", sp.Name, sp.TraceID)
+		}
+	}
+	for child, parent := range map[string]string{"run_commands": "webhook", "execute": "run_commands"} {
+		if spans[child].ParentSpanID == "" || spans[child].ParentSpanID != spans[parent].SpanID {
+			t.Errorf("Span %s should be a child of %s; got spans %+v", child, parent, spans)
+		}
+	}
+	if got := spans["webhook"].ParentSpanID; got != "00f067aa0ba902b7" {
+		t.Errorf("Webhook span should be a child of the caller's span; got parent %q", got)
+	}
+
+	execute := spans["execute"]
+	if execute.Status.Code != spanStatusOk {
+		t.Errorf("Execution span should succeed; got status %+v", execute.Status)
+	}
+	attrs := make(map[string]map[string]interface{})
+	for _, attr := range execute.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	if got := attrs["alert.fingerprint"]["stringValue"]; got != "boop" {
+		t.Errorf("Wrong fingerprint attribute; got %v, want %v", got, "boop")
+	}
+	if got := attrs["command"]["stringValue"]; got != cmd.String() {
+		t.Errorf("Wrong command attribute; got %v, want %v", got, cmd.String())
+	}
+	if got := attrs["process.exit_code"]["intValue"]; got != "0" {
+		t.Errorf("Wrong exit code attribute; got %v, want %v", got, "0")
+	}
+}
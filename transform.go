@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/alertmanager/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// ParseTransform parses the command's transform as a Go template, or returns nil if it doesn't have one.
+// Besides "local", as in arguments, transforms can use these functions:
+//   - json: encodes a value as JSON
+//   - matching: returns the alerts of a list that match the command's labels and annotations
+//   - withAlerts: returns a copy of an alert message with its alerts replaced, and its status, common labels and
+//     common annotations worked out again from them, as in {{ json (withAlerts . (matching .Alerts)) }}
+func (c Command) ParseTransform(loc *time.Location) (*texttemplate.Template, error) {
+	if c.Transform == "" {
+		return nil, nil
+	}
+
+	funcs := texttemplate.FuncMap{
+		"local": func(t time.Time) time.Time { return t.In(loc) },
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"matching": func(alerts template.Alerts) template.Alerts {
+			matched := make(template.Alerts, 0, len(alerts))
+			for _, alert := range alerts {
+				if c.matchesLabels(alert.Labels) && c.matchesAnnotations(alert.Annotations) {
+					matched = append(matched, alert)
+				}
+			}
+			return matched
+		},
+		"withAlerts": withAlerts,
+	}
+	return texttemplate.New("transform").Funcs(funcs).Option("missingkey=error").Parse(c.Transform)
+}
+
+// withAlerts returns a copy of the alert message with the given alerts, and the status, common labels and common
+// annotations that alertmanager would have sent with them. Group labels are kept, since alerts are grouped by them.
+func withAlerts(msg *template.Data, alerts template.Alerts) *template.Data {
+	data := *msg
+	data.Alerts = alerts
+	data.Status = "resolved"
+	data.CommonLabels = template.KV{}
+	data.CommonAnnotations = template.KV{}
+	for i, alert := range alerts {
+		if alert.Status == "firing" {
+			data.Status = "firing"
+		}
+		if i == 0 {
+			for k, v := range alert.Labels {
+				data.CommonLabels[k] = v
+			}
+			for k, v := range alert.Annotations {
+				data.CommonAnnotations[k] = v
+			}
+			continue
+		}
+		keepCommon(data.CommonLabels, alert.Labels)
+		keepCommon(data.CommonAnnotations, alert.Annotations)
+	}
+	return &data
+}
+
+// TransformData returns the alert message as rewritten by the command's transform, which has to output the JSON of an
+// alertmanager webhook payload. The message is returned as it is if the command has no transform.
+func (c Command) TransformData(msg *template.Data, loc *time.Location) (*template.Data, error) {
+	t, err := c.ParseTransform(loc)
+	if err != nil || t == nil {
+		return msg, err
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, msg); err != nil {
+		return nil, fmt.Errorf("Failed to transform payload: %w", err)
+	}
+	var transformed template.Data
+	if err := json.Unmarshal([]byte(b.String()), &transformed); err != nil {
+		return nil, fmt.Errorf("Transform didn't output a valid payload: %w", err)
+	}
+	return &transformed, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/prometheus/alertmanager/template"
+	"testing"
+	"time"
+)
+
+func TestCommand_TransformData(t *testing.T) {
+	t.Parallel()
+	msg := &template.Data{
+		Status:      "firing",
+		GroupLabels: template.KV{"alertname": "Down"},
+		Alerts: template.Alerts{
+			{Status: "firing", Labels: template.KV{"alertname": "Down", "service": "api", "instance": "a"}, Fingerprint: "a"},
+			{Status: "firing", Labels: template.KV{"alertname": "Down", "service": "db", "instance": "b"}, Fingerprint: "b"},
+			{Status: "resolved", Labels: template.KV{"alertname": "Down", "service": "api", "instance": "c"}, Fingerprint: "c"},
+		},
+		CommonLabels: template.KV{"alertname": "Down"},
+	}
+
+	cases := []struct {
+		name         string
+		transform    string
+		wantErr      bool
+		fingerprints []string
+		common       template.KV
+		status       string
+	}{
+		{name: "none", transform: "", fingerprints: []string{"a", "b", "c"}, common: template.KV{"alertname": "Down"}, status: "firing"},
+		{name: "matching", transform: `{{ json (withAlerts . (matching .Alerts)) }}`, fingerprints: []string{"a", "c"}, common: template.KV{"alertname": "Down", "service": "api"}, status: "firing"},
+		{name: "matching_resolved", transform: `{{ json (withAlerts . (matching .Alerts.Resolved)) }}`, fingerprints: []string{"c"}, common: template.KV{"alertname": "Down", "service": "api", "instance": "c"}, status: "resolved"},
+		{name: "literal", transform: `{"status": "firing", "commonLabels": {"custom": "yes"}}`, common: template.KV{"custom": "yes"}, status: "firing"},
+		{name: "invalid_json", transform: `{{ .Status }}`, wantErr: true},
+		{name: "missing_key", transform: `{{ .CommonLabels.nope }}`, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		cmd := Command{Cmd: "echo", MatchLabels: map[string]string{"service": "api"}, Transform: tc.transform}
+		got, err := cmd.TransformData(msg, time.UTC)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Wrong error for %s; got %v, want error=%v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		var fingerprints []string
+		for _, a := range got.Alerts {
+			fingerprints = append(fingerprints, a.Fingerprint)
+		}
+		if len(fingerprints) != len(tc.fingerprints) {
+			t.Errorf("Wrong alerts for %s; got %v, want %v", tc.name, fingerprints, tc.fingerprints)
+		}
+		for i := range fingerprints {
+			if i < len(tc.fingerprints) && fingerprints[i] != tc.fingerprints[i] {
+				t.Errorf("Wrong alerts for %s; got %v, want %v", tc.name, fingerprints, tc.fingerprints)
+				break
+			}
+		}
+		if len(got.CommonLabels) != len(tc.common) {
+			t.Errorf("Wrong common labels for %s; got %v, want %v", tc.name, got.CommonLabels, tc.common)
+		}
+		for k, v := range tc.common {
+			if got.CommonLabels[k] != v {
+				t.Errorf("Wrong common labels for %s; got %v, want %v", tc.name, got.CommonLabels, tc.common)
+				break
+			}
+		}
+		if got.Status != tc.status {
+			t.Errorf("Wrong status for %s; got %s, want %s", tc.name, got.Status, tc.status)
+		}
+	}
+
+	if _, err := (Command{Cmd: "echo", Transform: "{{ json ."}).ParseTransform(time.UTC); err == nil {
+		t.Error("Expected an error parsing an invalid transform")
+	}
+}
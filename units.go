@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Multipliers for the units accepted by ParseByteSize, keyed by lower-case unit name
+	byteUnits = map[string]ByteSize{
+		"":    1,
+		"b":   1,
+		"kb":  1000,
+		"mb":  1000 * 1000,
+		"gb":  1000 * 1000 * 1000,
+		"tb":  1000 * 1000 * 1000 * 1000,
+		"kib": 1 << 10,
+		"mib": 1 << 20,
+		"gib": 1 << 30,
+		"tib": 1 << 40,
+	}
+)
+
+// Duration is a time.Duration that is read from configuration as a duration string, such as "2m30s".
+// Unlike time.Duration, plain integers aren't accepted, since their unit would be ambiguous.
+type Duration time.Duration
+
+// ByteSize is an amount of bytes that is read from configuration as a size string, such as "5MiB" or "512KB".
+// Plain integers are interpreted as bytes.
+type ByteSize int64
+
+// String returns a string representation of the duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalYAML encodes the duration as a duration string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// UnmarshalYAML decodes the duration from a duration string
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("Invalid duration %q, expected a value like \"2m30s\": %w", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ParseByteSize parses a size string made of a number and an optional unit, such as "5MiB".
+// Units are case-insensitive; KB, MB, GB and TB are powers of 1000, while KiB, MiB, GiB and TiB are powers of 1024.
+func ParseByteSize(s string) (ByteSize, error) {
+	trimmed := strings.TrimSpace(s)
+	i := strings.IndexFunc(trimmed, func(r rune) bool {
+		return !(r >= '0' && r <= '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(trimmed)
+	}
+
+	number, unit := trimmed[:i], strings.ToLower(strings.TrimSpace(trimmed[i:]))
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("Unknown unit %q in size %q", trimmed[i:], s)
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number in size %q", s)
+	}
+
+	return ByteSize(n * float64(multiplier)), nil
+}
+
+// String returns a string representation of the size, using the largest binary unit that represents it exactly
+func (b ByteSize) String() string {
+	for _, unit := range []string{"TiB", "GiB", "MiB", "KiB"} {
+		m := byteUnits[strings.ToLower(unit)]
+		if b != 0 && b%m == 0 {
+			return strconv.FormatInt(int64(b/m), 10) + unit
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+// MarshalYAML encodes the size as a size string
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return b.String(), nil
+}
+
+// UnmarshalYAML decodes the size from a size string
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	v, err := ParseByteSize(s)
+	if err != nil {
+		return fmt.Errorf("Invalid size, expected a value like \"5MiB\": %w", err)
+	}
+	*b = v
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+	"testing"
+	"time"
+)
+
+func TestDuration_UnmarshalYAML(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    Duration
+		wantErr bool
+	}{
+		{
+			name: "duration",
+			in:   "2m30s",
+			want: Duration(2*time.Minute + 30*time.Second),
+		},
+		{
+			name: "zero",
+			in:   "0",
+			want: 0,
+		},
+		{
+			name:    "no_unit",
+			in:      "30",
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			in:      "banana",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var got struct {
+				D Duration `yaml:"d"`
+			}
+			err := yaml.Unmarshal([]byte("d: "+tc.in), &got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unexpected error result; got %v, want error %v", err, tc.wantErr)
+			}
+			if got.D != tc.want {
+				t.Errorf("Wrong duration; got %s, want %s", got.D, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    ByteSize
+		wantErr bool
+	}{
+		{
+			name: "bytes",
+			in:   "512",
+			want: 512,
+		},
+		{
+			name: "bytes_unit",
+			in:   "512B",
+			want: 512,
+		},
+		{
+			name: "binary",
+			in:   "5MiB",
+			want: 5 * 1024 * 1024,
+		},
+		{
+			name: "decimal",
+			in:   "5MB",
+			want: 5 * 1000 * 1000,
+		},
+		{
+			name: "lower_case",
+			in:   "2kib",
+			want: 2048,
+		},
+		{
+			name: "space",
+			in:   "1.5 GiB",
+			want: 1536 * 1024 * 1024,
+		},
+		{
+			name:    "unknown_unit",
+			in:      "5 bananas",
+			wantErr: true,
+		},
+		{
+			name:    "negative",
+			in:      "-5MiB",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			in:      "",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc // Capture range variable, for use in anonymous function
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseByteSize(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Unexpected error result; got %v, want error %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("Wrong size; got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestByteSize_String(t *testing.T) {
+	cases := map[ByteSize]string{
+		0:                  "0B",
+		100:                "100B",
+		2048:               "2KiB",
+		5 * 1024 * 1024:    "5MiB",
+		5*1024*1024 + 1:    "5242881B",
+		3 * (1 << 40):      "3TiB",
+		1536 * 1024 * 1024: "1536MiB",
+	}
+
+	for size, want := range cases {
+		if size.String() != want {
+			t.Errorf("Wrong string for size %d; got %s, want %s", int64(size), size, want)
+		}
+	}
+}